@@ -1,34 +1,46 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/localrivet/datasaver/internal/audit"
 	"github.com/localrivet/datasaver/internal/backup"
 	"github.com/localrivet/datasaver/internal/config"
 	"github.com/localrivet/datasaver/internal/mcp"
 	"github.com/localrivet/datasaver/internal/mcp/oauth"
+	"github.com/localrivet/datasaver/internal/mcp/tools"
 	"github.com/localrivet/datasaver/internal/metrics"
 	"github.com/localrivet/datasaver/internal/notify"
+	"github.com/localrivet/datasaver/internal/progress"
 	"github.com/localrivet/datasaver/internal/restore"
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/pkg/postgres"
 	"github.com/spf13/cobra"
 )
 
 var (
 	version   = "0.1.0"
 	cfgFile   string
+	output    string
 	logger    *slog.Logger
 	cfg       *config.Config
 	store     storage.Backend
-	notifier  *notify.Notifier
+	notifier  notify.Sender
 )
 
 func main() {
@@ -45,6 +57,17 @@ func main() {
 				return nil
 			}
 
+			switch output {
+			case "text":
+			case "json":
+				// Keep stdout for the JSON document.
+				logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+					Level: slog.LevelInfo,
+				}))
+			default:
+				return fmt.Errorf("invalid --output %q: must be text or json", output)
+			}
+
 			var err error
 			cfg, err = config.Load(cfgFile)
 			if err != nil {
@@ -61,6 +84,10 @@ func main() {
 					AccessKey: cfg.Storage.S3.AccessKey,
 					SecretKey: cfg.Storage.S3.SecretKey,
 					UseSSL:    cfg.Storage.S3.UseSSL,
+
+					MaxAttempts:    cfg.Storage.S3.MaxAttempts,
+					ObjectLockDays: cfg.Storage.S3.ObjectLockDays,
+					ObjectLockMode: cfg.Storage.S3.ObjectLockMode,
 				}
 			}
 
@@ -68,28 +95,71 @@ func main() {
 			if err != nil {
 				return fmt.Errorf("failed to create storage backend: %w", err)
 			}
+			if len(cfg.Storage.Secondaries) > 0 {
+				mirror := storage.NewMirror(store, logger)
+				for _, spec := range cfg.Storage.Secondaries {
+					secondary, err := openBackendSpec(spec)
+					if err != nil {
+						return fmt.Errorf("failed to create secondary storage %s: %w", spec, err)
+					}
+					mirror.AddSecondary(spec, secondary)
+				}
+				store = mirror
+			}
 
-			notifier = notify.NewNotifier(cfg.Monitoring.WebhookURL, logger)
+			notifier = newNotifier()
 
 			return nil
 		},
 	}
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file path")
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "output format for backup, list, history, health, verify and diff: text or json")
 
 	rootCmd.AddCommand(daemonCmd())
 	rootCmd.AddCommand(backupCmd())
 	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(historyCmd())
 	rootCmd.AddCommand(restoreCmd())
 	rootCmd.AddCommand(cleanupCmd())
+	rootCmd.AddCommand(gcCmd())
+	rootCmd.AddCommand(migrateCmd())
 	rootCmd.AddCommand(healthCmd())
 	rootCmd.AddCommand(verifyCmd())
+	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(diffCmd())
+	rootCmd.AddCommand(checkCmd())
+	rootCmd.AddCommand(selfTestCmd())
+	rootCmd.AddCommand(walPushCmd())
+	rootCmd.AddCommand(walFetchCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
+// newNotifier combines the webhook and email notifiers that are configured.
+// It returns nil when neither is.
+func newNotifier() notify.Sender {
+	var senders []notify.Sender
+	if webhook := notify.NewNotifier(cfg.Monitoring.WebhookURL, logger); webhook != nil {
+		webhook.SetFormat(cfg.Monitoring.WebhookFormat)
+		senders = append(senders, webhook)
+	}
+	if email := cfg.Monitoring.Email; email.Enabled() {
+		senders = append(senders, notify.NewEmailNotifier(notify.EmailConfig{
+			Host:     email.Host,
+			Port:     email.Port,
+			Username: email.Username,
+			Password: email.Password,
+			From:     email.From,
+			To:       email.To,
+			Timeout:  cfg.EmailTimeout(),
+		}, logger))
+	}
+	return notify.Multi(senders...)
+}
+
 func daemonCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "daemon",
@@ -100,8 +170,47 @@ func daemonCmd() *cobra.Command {
 
 			m := metrics.New("datasaver")
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
-			scheduler := backup.NewScheduler(engine, cfg.Schedule, logger)
+			auditLog, err := audit.New(cfg.Monitoring.AuditLog, logger)
+			if err != nil {
+				return err
+			}
+			defer auditLog.Close()
+
+			engine := backup.NewEngine(cfg, store, notifier, m, logger)
+			scheduler := backup.NewScheduler(engine, cfg.Schedule.Backup, logger)
+			scheduler.SetSchedules(cfg.BackupSchedules())
+			scheduler.SetCatchUp(cfg.Backup.CatchUpMissed)
+			scheduler.SetAutoCleanup(cfg.Retention.AutoCleanup)
+			scheduler.SetKeepGoing(cfg.Backup.KeepGoing)
+			scheduler.SetJitter(cfg.ScheduleJitterRange())
+			// Always attach the drill so a reload can schedule it later.
+			scheduler.SetRestoreDrill(cfg.Schedule.VerifyRestore, backup.NewRestoreDrill(engine, m, logger))
+
+			storageCheck := &storageProbe{engine: engine}
+			if !cfg.Storage.SkipStartupCheck() {
+				if err := storageCheck.Run(ctx); err != nil {
+					logger.Error("storage check failed", "backend", cfg.Storage.Backend, "error", err)
+					if cfg.Storage.FailOnStartupCheck() {
+						return fmt.Errorf("storage check failed: %w", err)
+					}
+				} else {
+					logger.Info("storage check passed", "backend", cfg.Storage.Backend)
+				}
+			}
+
+			if ttl := cfg.InstanceLockTTL(); ttl > 0 {
+				lock, err := engine.AcquireInstanceLock(ctx, ttl)
+				switch {
+				case errors.Is(err, backup.ErrInstanceLocked):
+					return err
+				case err != nil && cfg.Storage.FailOnStartupCheck():
+					return err
+				case err != nil:
+					logger.Warn("running without the instance lock", "error", err)
+				default:
+					defer lock.Release(context.Background())
+				}
+			}
 
 			if err := scheduler.Start(ctx); err != nil {
 				return fmt.Errorf("failed to start scheduler: %w", err)
@@ -110,6 +219,8 @@ func daemonCmd() *cobra.Command {
 			mux := http.NewServeMux()
 			mux.Handle("/metrics", metrics.Handler())
 			mux.HandleFunc("/health", healthHandler(scheduler))
+			mux.HandleFunc("/health/live", liveHandler)
+			mux.HandleFunc("/health/ready", readyHandler(scheduler, cfg.AlertDuration(), storageCheck))
 
 			// Build base URL for OAuth discovery
 			baseURL := fmt.Sprintf("http://localhost:%d", cfg.Monitoring.HealthPort)
@@ -122,7 +233,7 @@ func daemonCmd() *cobra.Command {
 			oauthHandler.RegisterRoutes(mux)
 
 			// Add MCP endpoint if API key is configured
-			mcpHandler := mcp.NewHandler(cfg, store, notifier, logger, baseURL)
+			mcpHandler := mcp.NewHandler(cfg, store, notifier, m, logger, auditLog, baseURL)
 			if mcpHandler.Enabled() {
 				mux.Handle("/mcp", mcpHandler)
 				logger.Info("MCP endpoint enabled", "path", "/mcp")
@@ -154,9 +265,21 @@ func daemonCmd() *cobra.Command {
 
 			go alertMonitor(ctx, scheduler, cfg, m)
 
+			if cfg.Backup.WALArchiveDir != "" {
+				shipper := backup.NewWALShipper(engine, cfg.Backup.WALArchiveDir, cfg.WALPollInterval(), logger)
+				go shipper.Run(ctx)
+			}
+
+			applied := *cfg
+
 			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			<-sigCh
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+			for sig := range sigCh {
+				if sig != syscall.SIGHUP {
+					break
+				}
+				reloadConfig(ctx, scheduler, &applied)
+			}
 
 			logger.Info("shutting down")
 
@@ -174,118 +297,624 @@ func daemonCmd() *cobra.Command {
 }
 
 func backupCmd() *cobra.Command {
-	return &cobra.Command{
+	var labelArgs []string
+	var showProgress bool
+	var compressInDB bool
+	var keepGoing bool
+
+	cmd := &cobra.Command{
 		Use:   "backup",
 		Short: "Perform immediate backup",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
-
-			result, err := engine.Run(ctx)
+			labels, err := postgres.ParseLabels(labelArgs)
 			if err != nil {
 				return err
 			}
 
-			fmt.Printf("Backup completed successfully\n")
-			fmt.Printf("  ID: %s\n", result.ID)
-			fmt.Printf("  Size: %s\n", formatBytes(result.Size))
-			fmt.Printf("  Compressed: %s\n", formatBytes(result.CompressedSize))
-			fmt.Printf("  Duration: %s\n", result.Duration.Round(time.Millisecond))
+			if showProgress {
+				ctx = progress.WithFunc(ctx, printProgress)
+			}
 
-			return nil
+			if compressInDB {
+				if cfg.IsSQLite() {
+					return fmt.Errorf("--compress-in-db is only supported for PostgreSQL")
+				}
+				cfg.Backup.CompressInDB = true
+			}
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			if keepGoing {
+				cfg.Backup.KeepGoing = true
+			}
+
+			results, err := engine.RunAll(ctx, labels)
+			if len(results) <= 1 && err != nil {
+				return err
+			}
+			if backup.IsPartial(err) && cfg.Backup.KeepGoing {
+				// The failures are printed with the results below.
+				err = nil
+			}
+
+			if output == "json" {
+				if jsonErr := printJSON(tools.NewBackupNowOutput(results, labels)); jsonErr != nil {
+					return jsonErr
+				}
+				return err
+			}
+
+			for _, result := range results {
+				if len(results) > 1 {
+					fmt.Printf("%s: ", result.Database)
+				}
+				if result.Error != nil {
+					fmt.Printf("Backup failed: %v\n", result.Error)
+					continue
+				}
+				fmt.Printf("Backup completed successfully\n")
+				fmt.Printf("  ID: %s\n", result.ID)
+				fmt.Printf("  Size: %s\n", formatBytes(result.Size))
+				fmt.Printf("  Compressed: %s (%.2fx)\n", formatBytes(result.CompressedSize), result.CompressionRatio)
+				fmt.Printf("  Duration: %s\n", result.Duration.Round(time.Millisecond))
+				fmt.Printf("  Throughput: %s/s\n", formatBytes(int64(result.ThroughputBytesPerSec)))
+			}
+
+			return err
 		},
 	}
+
+	cmd.Flags().StringArrayVar(&labelArgs, "label", nil, "label to record on the backup as key=value (repeatable)")
+	cmd.Flags().BoolVar(&showProgress, "progress", false, "print bytes processed and throughput to stderr while running")
+	cmd.Flags().BoolVar(&keepGoing, "keep-going", false, "exit 0 when some of several databases were backed up, even if others failed")
+	cmd.Flags().BoolVar(&compressInDB, "compress-in-db", false, "have pg_dump compress the dump (-Z) instead of compressing it afterwards")
+
+	return cmd
 }
 
 func listCmd() *cobra.Command {
-	return &cobra.Command{
+	var labelArgs []string
+	var since, until string
+
+	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List available backups",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
+			labels, err := postgres.ParseLabels(labelArgs)
+			if err != nil {
+				return err
+			}
+			period, err := postgres.ParseTimeRange(since, until, time.Now())
+			if err != nil {
+				return err
+			}
 
-			backups, err := engine.ListBackups(ctx)
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			all, err := engine.ListBackups(ctx)
 			if err != nil {
 				return err
 			}
 
-			if len(backups) == 0 {
-				fmt.Println("No backups found")
-				return nil
+			var backups []*postgres.BackupMetadata
+			for _, b := range all {
+				if b.HasLabels(labels) && period.Contains(b.Timestamp) {
+					backups = append(backups, b)
+				}
 			}
 
 			sort.Slice(backups, func(i, j int) bool {
 				return backups[i].Timestamp.After(backups[j].Timestamp)
 			})
 
-			fmt.Printf("%-26s %-20s %-12s %-8s\n", "ID", "DATE", "SIZE", "TYPE")
+			if output == "json" {
+				items := make([]tools.BackupItem, len(backups))
+				for i, b := range backups {
+					items[i] = *tools.ToBackupItem(b)
+				}
+				return printJSON(tools.ListBackupsOutput{Count: len(items), Backups: items})
+			}
+
+			if len(backups) == 0 {
+				fmt.Println("No backups found")
+				return nil
+			}
+
+			fmt.Printf("%-26s %-20s %-12s %-8s %s\n", "ID", "DATE", "SIZE", "TYPE", "LABELS")
 			for _, b := range backups {
-				fmt.Printf("%-26s %-20s %-12s %-8s\n",
+				fmt.Printf("%-26s %-20s %-12s %-8s %s\n",
 					b.ID,
 					b.Timestamp.Format("2006-01-02 15:04"),
 					formatBytes(b.Backup.CompressedSize),
 					b.Type,
+					formatLabels(b.Labels),
 				)
 			}
 
 			return nil
 		},
 	}
+
+	cmd.Flags().StringArrayVar(&labelArgs, "label", nil, "only list backups with this key=value label (repeatable)")
+	addTimeRangeFlags(cmd, &since, &until)
+
+	return cmd
+}
+
+// addTimeRangeFlags adds the --since and --until filters of list and
+// history.
+func addTimeRangeFlags(cmd *cobra.Command, since, until *string) {
+	cmd.Flags().StringVar(since, "since", "", "only backups taken at or after this RFC 3339 time, date or age such as 7d")
+	cmd.Flags().StringVar(until, "until", "", "only backups taken before this RFC 3339 time or age, or by the end of this date")
+}
+
+// historyItem is a backup in the history, and whether it is still stored.
+type historyItem struct {
+	tools.BackupItem
+	Stored bool `json:"stored"`
+}
+
+func historyCmd() *cobra.Command {
+	var since, until string
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List every backup ever taken, including deleted ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			period, err := postgres.ParseTimeRange(since, until, time.Now())
+			if err != nil {
+				return err
+			}
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			all, err := engine.History(ctx)
+			if err != nil {
+				return err
+			}
+			var history []*postgres.BackupMetadata
+			for _, b := range all {
+				if period.Contains(b.Timestamp) {
+					history = append(history, b)
+				}
+			}
+			current, err := engine.ListBackups(ctx)
+			if err != nil {
+				return err
+			}
+			stored := make(map[string]bool, len(current))
+			for _, b := range current {
+				stored[b.ID] = true
+			}
+
+			// Newest first, as list shows them.
+			items := make([]historyItem, len(history))
+			for i, b := range history {
+				items[len(history)-1-i] = historyItem{BackupItem: *tools.ToBackupItem(b), Stored: stored[b.ID]}
+			}
+
+			if output == "json" {
+				return printJSON(items)
+			}
+
+			if len(items) == 0 {
+				fmt.Println("No backup history")
+				return nil
+			}
+
+			fmt.Printf("%-26s %-20s %-20s %-12s %-8s %s\n", "ID", "DATE", "DATABASE", "SIZE", "TYPE", "STATUS")
+			for i, item := range items {
+				b := history[len(history)-1-i]
+				status := "stored"
+				if !item.Stored {
+					status = "deleted"
+				}
+				fmt.Printf("%-26s %-20s %-20s %-12s %-8s %s\n",
+					b.ID,
+					b.Timestamp.Format("2006-01-02 15:04"),
+					b.Database.Name,
+					formatBytes(b.Backup.CompressedSize),
+					b.Type,
+					status,
+				)
+			}
+
+			return nil
+		},
+	}
+
+	addTimeRangeFlags(cmd, &since, &until)
+
+	return cmd
 }
 
 func restoreCmd() *cobra.Command {
 	var targetDB string
 	var dryRun bool
+	var targetDir string
+	var targetTime string
+	var pointInTime string
+	var dropCreate bool
+	var force bool
+	var assumeYes bool
+	var showProgress bool
+	var targetHost string
+	var targetPort int
+	var targetUser string
+	var targetPassword string
+	var latest bool
+	var backupType string
+	var table string
+	var extractTo string
+	var extractSQL bool
 
 	cmd := &cobra.Command{
-		Use:   "restore <backup-id>",
+		Use:   "restore [backup-id]",
 		Short: "Restore from backup",
-		Args:  cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if latest && len(args) > 0 {
+				return fmt.Errorf("--latest takes no backup ID")
+			}
+			if pointInTime != "" {
+				if latest || targetTime != "" {
+					return fmt.Errorf("--point-in-time picks the base backup itself; drop --latest and --target-time")
+				}
+				if len(args) > 0 {
+					return fmt.Errorf("--point-in-time takes no backup ID; use --target-time with one")
+				}
+				return nil
+			}
+			if !latest && len(args) != 1 {
+				return fmt.Errorf("requires a backup ID, --latest or --point-in-time")
+			}
+			if backupType != "" && !latest {
+				return fmt.Errorf("--type only applies with --latest")
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			restoreEngine := restore.NewEngine(cfg, store, logger)
+			if pointInTime != "" {
+				targetTime = pointInTime
+			}
+			var recoverTo time.Time
+			if targetTime != "" {
+				var err error
+				recoverTo, err = time.Parse(time.RFC3339, targetTime)
+				if err != nil {
+					return fmt.Errorf("invalid target time %q: must be RFC 3339, e.g. 2024-01-15T14:30:00Z", targetTime)
+				}
+			}
+
+			var backupID string
+			if pointInTime != "" {
+				base, err := backup.NewEngine(cfg, store, nil, nil, logger).BaseBackupBefore(ctx, recoverTo)
+				if err != nil {
+					return err
+				}
+				backupID = base.ID
+				fmt.Printf("Base backup: %s (%s)\n", base.ID, base.Timestamp.Format("2006-01-02 15:04:05"))
+			} else if latest {
+				meta, err := backup.NewEngine(cfg, store, nil, nil, logger).Latest(ctx, backupType)
+				if err != nil {
+					return err
+				}
+				backupID = meta.ID
+				fmt.Printf("Latest backup: %s (%s)\n", meta.ID, meta.Timestamp.Format("2006-01-02 15:04:05"))
+			} else {
+				backupID = args[0]
+			}
+
+			if targetPassword == "" {
+				targetPassword = os.Getenv("DATASAVER_TARGET_PASSWORD")
+			}
+
+			// Dry runs, extracts and data directory restores leave every
+			// database alone; a SQLite restore without --target-db fails
+			// in the engine before it touches anything.
+			touchesDB := !dryRun && extractTo == "" && targetDir == "" && (targetDB != "" || !cfg.IsSQLite())
+			if touchesDB && !assumeYes {
+				target := targetDB
+				if target == "" {
+					meta, err := backup.NewEngine(cfg, store, nil, nil, logger).GetBackup(ctx, backupID)
+					if err != nil {
+						return err
+					}
+					target = meta.Database.Name
+				}
+				if err := confirmRestore(target, targetHost, dropCreate); err != nil {
+					return err
+				}
+			}
+
+			if showProgress {
+				ctx = progress.WithFunc(ctx, printProgress)
+			}
+
+			auditLog, err := audit.New(cfg.Monitoring.AuditLog, logger)
+			if err != nil {
+				return err
+			}
+			defer auditLog.Close()
+
+			restoreEngine := restore.NewEngine(cfg, store, notifier, nil, logger)
 
 			result, err := restoreEngine.Restore(ctx, restore.RestoreOptions{
-				BackupID: args[0],
-				TargetDB: targetDB,
+				BackupID:       backupID,
+				TargetDB:       targetDB,
+				DryRun:         dryRun,
+				Force:          dropCreate,
+				Overwrite:      force,
+				TargetDir:      targetDir,
+				TargetTime:     recoverTo,
+				RestoreCommand: walFetchCommand(),
+				TargetHost:     targetHost,
+				TargetPort:     targetPort,
+				TargetUser:     targetUser,
+				TargetPassword: targetPassword,
+				Table:          table,
+				ExtractTo:      extractTo,
+				ExtractSQL:     extractSQL,
+			})
+			auditLog.Record(audit.Event{
+				Op:       "restore",
+				Source:   audit.SourceCLI,
+				Actor:    currentUser(),
+				BackupID: backupID,
+				TargetDB: result.TargetDB,
 				DryRun:   dryRun,
+				Err:      err,
 			})
 			if err != nil {
 				return err
 			}
 
-			if dryRun {
+			if extractTo != "" {
+				fmt.Println("Backup extracted - no database was touched")
+				fmt.Printf("  Backup: %s\n", result.BackupID)
+				fmt.Printf("  File: %s\n", result.TargetDB)
+				if result.ChecksumValid {
+					fmt.Println("  Checksum: verified")
+				}
+				if result.ContentValid {
+					fmt.Println("  Content checksum: verified")
+				}
+			} else if dryRun {
 				fmt.Println("Dry run completed - no changes made")
+				fmt.Printf("  Backup: %s\n", result.BackupID)
+				if result.ChecksumValid {
+					fmt.Println("  Checksum: verified")
+				}
+				if result.ContentValid {
+					fmt.Println("  Content checksum: verified")
+				}
+				if targetDir != "" {
+					fmt.Printf("  Files: %d\n", result.Objects)
+					if !result.RecoveryTarget.IsZero() {
+						fmt.Printf("  Recovery target: %s (archived WAL reaches %s)\n", result.RecoveryTarget.Format(time.RFC3339), result.RecoveryEndLSN)
+					}
+				} else {
+					fmt.Printf("  Tables: %d\n", result.Tables)
+					fmt.Printf("  Objects: %d\n", result.Objects)
+				}
+			} else if targetDir != "" {
+				fmt.Printf("Base backup restored\n")
+				fmt.Printf("  Backup: %s\n", result.BackupID)
+				fmt.Printf("  Data directory: %s\n", result.TargetDB)
+				if !result.RecoveryTarget.IsZero() {
+					fmt.Printf("  Recovery target: %s (archived WAL reaches %s)\n", result.RecoveryTarget.Format(time.RFC3339), result.RecoveryEndLSN)
+				}
+				fmt.Println("  Start PostgreSQL on the data directory to replay archived WAL")
 			} else {
 				fmt.Printf("Restore completed successfully\n")
 				fmt.Printf("  Backup: %s\n", result.BackupID)
 				fmt.Printf("  Target database: %s\n", result.TargetDB)
+				if targetHost != "" {
+					fmt.Printf("  Target server: %s\n", targetHost)
+				}
+				if table != "" {
+					fmt.Printf("  Table: %s\n", table)
+				}
+				if result.Suspect {
+					fmt.Println("  Warning: restore is suspect, sentinel table row counts do not match the backup")
+					for _, m := range result.RowCountMismatches {
+						fmt.Printf("    %s: %d rows, expected %d\n", m.Table, m.Actual, m.Expected)
+					}
+					if len(result.RowCountMismatches) == 0 {
+						fmt.Println("    the restored tables could not be counted; see the log")
+					}
+				}
+			}
+
+			switch result.Mode {
+			case "schema":
+				fmt.Println("  Note: schema-only backup, no table data was restored")
+			case "data":
+				fmt.Println("  Note: data-only backup, the schema must already exist")
+			}
+			for _, warning := range result.Warnings {
+				fmt.Printf("  Warning: %s\n", warning)
 			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&targetDB, "target-db", "", "restore to different database")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "test restore without applying")
+	cmd.Flags().StringVar(&targetDB, "target-db", "", "restore to different database; for SQLite backups, the file to restore into (required)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "download and validate the backup without restoring it")
+	cmd.Flags().StringVar(&targetDir, "target-dir", "", "empty data directory to unpack a physical backup into")
+	cmd.Flags().StringVar(&targetTime, "target-time", "", "recover a physical backup to this time (RFC 3339)")
+	cmd.Flags().StringVar(&pointInTime, "point-in-time", "", "recover to this time (RFC 3339) from the latest base backup before it")
+	cmd.Flags().BoolVar(&dropCreate, "drop-create", false, "drop and recreate the target database (delete the file for SQLite) before restoring")
+	cmd.Flags().BoolVar(&force, "force", false, "let a SQLite restore replace an existing --target-db file, keeping it as a timestamped .bak copy, or --extract-only replace an existing file")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "restore without asking to confirm the target database; required when stdin is not a terminal")
+	cmd.Flags().BoolVar(&showProgress, "progress", false, "print bytes processed and throughput to stderr while running")
+	cmd.Flags().BoolVar(&latest, "latest", false, "restore the most recent backup instead of a given ID")
+	cmd.Flags().StringVar(&backupType, "type", "", "with --latest, the most recent backup of this type: daily, weekly, monthly or yearly")
+	cmd.Flags().StringVar(&targetHost, "target-host", "", "restore into this PostgreSQL server instead of the configured one")
+	cmd.Flags().IntVar(&targetPort, "target-port", 0, "port of the target server (default: the configured port)")
+	cmd.Flags().StringVar(&targetUser, "target-user", "", "user for the target server (default: the configured user)")
+	cmd.Flags().StringVar(&targetPassword, "target-password", "", "password for the target server; prefer DATASAVER_TARGET_PASSWORD (default: the configured password)")
+	cmd.Flags().StringVar(&table, "table", "", "restore only this table (name or schema.name) from a PostgreSQL custom or directory dump")
+	cmd.Flags().StringVar(&extractTo, "extract-only", "", "verify, decrypt and decompress the backup into this file instead of restoring it")
+	cmd.Flags().BoolVar(&extractSQL, "sql", false, "with --extract-only, convert a PostgreSQL custom or directory dump to a SQL script with pg_restore -f")
 
 	return cmd
 }
 
-func cleanupCmd() *cobra.Command {
+// confirmRestore asks the user to type the name of the database a restore
+// writes into, so a restore aimed at the wrong database, such as
+// production, is caught before anything is overwritten. Without a terminal
+// to ask on it fails, and --yes must be passed instead.
+func confirmRestore(database, host string, dropCreate bool) error {
+	target := database
+	if host != "" {
+		target += " on " + host
+	}
+	if !stdinIsTerminal() {
+		return fmt.Errorf("restore into %s needs confirmation, but stdin is not a terminal; pass --yes to restore without it", target)
+	}
+
+	action := "This will restore into database " + target
+	if dropCreate {
+		action = "This will drop database " + target + " and everything in it, then restore into it"
+	}
+	fmt.Printf("%s.\nType the database name to confirm: ", action)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != database {
+		return fmt.Errorf("restore aborted: %q does not match the database name", strings.TrimSpace(answer))
+	}
+	return nil
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal rather
+// than a pipe, a file or /dev/null.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func walPushCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "wal-push <path>",
+		Short: "Archive a WAL file (use as archive_command = 'datasaver wal-push %p')",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+			return engine.ArchiveWAL(context.Background(), args[0])
+		},
+	}
+}
+
+func walFetchCmd() *cobra.Command {
 	return &cobra.Command{
+		Use:   "wal-fetch <name> <dest>",
+		Short: "Fetch an archived WAL file (used as restore_command during recovery)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			restoreEngine := restore.NewEngine(cfg, store, notifier, nil, logger)
+			err := restoreEngine.FetchWAL(context.Background(), args[0], args[1])
+			if errors.Is(err, restore.ErrWALNotFound) {
+				// Expected at the end of the archive; PostgreSQL only needs
+				// the non-zero exit.
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+			}
+			return err
+		},
+	}
+}
+
+// walFetchCommand returns the restore_command for physical restores: this
+// binary and config file running wal-fetch.
+func walFetchCommand() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return restore.DefaultRestoreCommand
+	}
+	command := shellQuote(exe)
+	if cfgFile != "" {
+		if abs, err := filepath.Abs(cfgFile); err == nil {
+			command += " -c " + shellQuote(abs)
+		}
+	}
+	return command + " wal-fetch %f %p"
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func cleanupCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
 		Use:   "cleanup",
 		Short: "Clean up old backups manually",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			if dryRun {
+				decisions, err := engine.PreviewCleanup(ctx)
+				if err != nil {
+					return err
+				}
+
+				if len(decisions) == 0 {
+					fmt.Println("No backups found")
+					return nil
+				}
+
+				var deleteCount int
+				var reclaimed int64
+				fmt.Printf("%-26s %-20s %-8s %-7s %-12s %s\n", "ID", "DATE", "TYPE", "ACTION", "SIZE", "REASON")
+				for _, d := range decisions {
+					action := "keep"
+					if !d.Keep {
+						action = "delete"
+						deleteCount++
+						reclaimed += d.Metadata.Backup.CompressedSize
+					}
+					fmt.Printf("%-26s %-20s %-8s %-7s %-12s %s\n",
+						d.Metadata.ID,
+						d.Metadata.Timestamp.Format("2006-01-02 15:04"),
+						d.Type,
+						action,
+						formatBytes(d.Metadata.Backup.CompressedSize),
+						d.Reason,
+					)
+				}
+
+				fmt.Printf("\nDry run: %d backups would be deleted, reclaiming %s\n", deleteCount, formatBytes(reclaimed))
+				return nil
+			}
+
+			auditLog, err := audit.New(cfg.Monitoring.AuditLog, logger)
+			if err != nil {
+				return err
+			}
+			defer auditLog.Close()
 
 			count, err := engine.Cleanup(ctx)
+			auditLog.Record(audit.Event{
+				Op:      "cleanup",
+				Source:  audit.SourceCLI,
+				Actor:   currentUser(),
+				Deleted: count,
+				Err:     err,
+			})
 			if err != nil {
 				return err
 			}
@@ -294,6 +923,169 @@ func cleanupCmd() *cobra.Command {
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would be deleted without deleting")
+
+	return cmd
+}
+
+// currentUser names the OS user running the CLI for the audit log.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func gcCmd() *cobra.Command {
+	var dryRun bool
+	var minAge time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete backup files without metadata and metadata without files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			result, err := engine.GC(ctx, minAge, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if len(result.OrphanedFiles) == 0 && len(result.DanglingMetadata) == 0 {
+				fmt.Println("No orphaned files or dangling metadata found")
+				return nil
+			}
+
+			var orphanedBytes int64
+			for _, f := range result.OrphanedFiles {
+				fmt.Printf("orphaned file     %s (%s)\n", f.Path, formatBytes(f.Size))
+				orphanedBytes += f.Size
+			}
+			for _, p := range result.DanglingMetadata {
+				fmt.Printf("dangling metadata %s\n", p)
+			}
+
+			if dryRun {
+				fmt.Printf("\nDry run: %d files would be deleted, reclaiming %s\n",
+					len(result.OrphanedFiles)+len(result.DanglingMetadata), formatBytes(orphanedBytes))
+				return nil
+			}
+
+			fmt.Printf("\nGC completed: %d files deleted, %s reclaimed\n", result.Deleted, formatBytes(result.ReclaimedBytes))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would be deleted without deleting")
+	cmd.Flags().DurationVar(&minAge, "min-age", 24*time.Hour, "ignore files modified more recently than this, which may belong to a backup in progress")
+
+	return cmd
+}
+
+func migrateCmd() *cobra.Command {
+	var from string
+	var to string
+	var opts backup.MigrateOptions
+
+	cmd := &cobra.Command{
+		Use:   "migrate --from <backend> --to <backend>",
+		Short: "Copy all backups from one storage backend to another",
+		Long: `Copy every object from one storage backend to another, verifying each copy.
+
+A backend is local:<path> or s3:<bucket>; S3 endpoint, region and credentials
+come from the storage.s3 configuration. A copy that fails with a transient
+error is retried with backoff; objects that still fail are reported and the
+command exits non-zero. Objects already at the destination with the same size
+and checksum are skipped, so rerunning the same command resumes an
+interrupted migration.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			src, err := openBackendSpec(from)
+			if err != nil {
+				return fmt.Errorf("invalid --from: %w", err)
+			}
+			dst, err := openBackendSpec(to)
+			if err != nil {
+				return fmt.Errorf("invalid --to: %w", err)
+			}
+
+			engine := backup.NewEngine(cfg, src, nil, nil, logger)
+
+			result, err := engine.Migrate(ctx, dst, opts)
+			if result == nil {
+				return err
+			}
+
+			for _, f := range result.Copied {
+				fmt.Printf("copy %s (%s)\n", f.Path, formatBytes(f.Size))
+			}
+			failed := make([]string, 0, len(result.Failed))
+			for p := range result.Failed {
+				failed = append(failed, p)
+			}
+			sort.Strings(failed)
+			for _, p := range failed {
+				fmt.Printf("FAILED %s: %v\n", p, result.Failed[p])
+			}
+
+			if opts.DryRun {
+				fmt.Printf("\nDry run: %d objects would be copied (%s), %d already present\n",
+					len(result.Copied), formatBytes(result.Bytes), result.Skipped)
+			} else {
+				fmt.Printf("\nMigration completed: %d objects copied (%s, %d after a retry), %d already present, %d failed\n",
+					len(result.Copied), formatBytes(result.Bytes), result.Retried, result.Skipped, len(result.Failed))
+			}
+			if err != nil {
+				cmd.SilenceUsage = true
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "source backend: local:<path> or s3:<bucket>")
+	cmd.Flags().StringVar(&to, "to", "", "destination backend: local:<path> or s3:<bucket>")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "show what would be copied without copying")
+	cmd.Flags().IntVar(&opts.MaxAttempts, "max-attempts", 0, "tries per object before it counts as failed (default 3)")
+	cmd.Flags().DurationVar(&opts.RetryWait, "retry-wait", 0, "wait before the first retry, doubling each time (default 1s)")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+// openBackendSpec opens a backend given as local:<path> or s3:<bucket>. S3
+// backends use the configured S3 settings with the bucket replaced.
+func openBackendSpec(spec string) (storage.Backend, error) {
+	kind, location, ok := strings.Cut(spec, ":")
+	if !ok || location == "" {
+		return nil, fmt.Errorf("%q must be local:<path> or s3:<bucket>", spec)
+	}
+
+	factory := storage.NewFactory()
+	switch kind {
+	case "local":
+		return factory.Create("local", location, nil)
+	case "s3":
+		s3 := cfg.Storage.S3
+		return factory.Create("s3", "", &storage.S3Config{
+			Bucket:    location,
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			UseSSL:    s3.UseSSL,
+
+			MaxAttempts:    s3.MaxAttempts,
+			ObjectLockDays: s3.ObjectLockDays,
+			ObjectLockMode: s3.ObjectLockMode,
+		})
+	default:
+		return nil, fmt.Errorf("unknown backend %q in %q, want local or s3", kind, spec)
+	}
 }
 
 func healthCmd() *cobra.Command {
@@ -303,7 +1095,7 @@ func healthCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
 
 			backups, err := engine.ListBackups(ctx)
 			if err != nil {
@@ -326,45 +1118,272 @@ func healthCmd() *cobra.Command {
 			} else if time.Since(lastBackup) > cfg.AlertDuration() {
 				status = "warning: backup overdue"
 			}
+			schedules := tools.NamedSchedules(backup.UpcomingRuns(cfg.BackupSchedules(), time.Now()))
+
+			if output == "json" {
+				out := tools.BackupStatusOutput{
+					Status:       status,
+					TotalBackups: len(backups),
+					StorageBytes: totalSize,
+					StorageCap:   engine.StorageCap(),
+					Schedules:    schedules,
+				}
+				if !lastBackup.IsZero() {
+					out.LastBackup = lastBackup.Format(time.RFC3339)
+				}
+				return printJSON(out)
+			}
 
 			fmt.Printf("Status: %s\n", status)
 			if !lastBackup.IsZero() {
 				fmt.Printf("Last backup: %s\n", lastBackup.Format("2006-01-02 15:04:05"))
 			}
 			fmt.Printf("Total backups: %d\n", len(backups))
-			fmt.Printf("Storage used: %s\n", formatBytes(totalSize))
+			printStorageUsed(totalSize, engine.StorageCap())
+			for _, sched := range schedules {
+				fmt.Printf("Next %s backup: %s (%s)\n", sched.Name, sched.NextBackup, sched.Schedule)
+			}
 
 			return nil
 		},
 	}
 }
 
-func verifyCmd() *cobra.Command {
+func statsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize backup counts, sizes and retention",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			stats, err := engine.Stats(ctx)
+			if err != nil {
+				return err
+			}
+
+			if stats.Count == 0 {
+				fmt.Println("No backups found")
+				return nil
+			}
+
+			types := make([]string, 0, len(stats.CountByType))
+			for t := range stats.CountByType {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+
+			fmt.Printf("Total backups: %d\n", stats.Count)
+			for _, t := range types {
+				fmt.Printf("  %-8s %-5d %s\n", t+":", stats.CountByType[t], formatBytes(stats.SizeByType[t]))
+			}
+			printStorageUsed(stats.TotalSize, stats.MaxTotalBytes)
+			fmt.Printf("Average size: %s\n", formatBytes(stats.AverageSize))
+			fmt.Printf("Smallest: %s\n", formatBytes(stats.MinSize))
+			fmt.Printf("Largest: %s\n", formatBytes(stats.MaxSize))
+			if stats.CompressionRatio > 0 {
+				fmt.Printf("Compression ratio: %.2fx (%s uncompressed)\n", stats.CompressionRatio, formatBytes(stats.UncompressedSize))
+			}
+			fmt.Printf("Oldest: %s (%s)\n", stats.Oldest.ID, stats.Oldest.Timestamp.Format("2006-01-02 15:04:05"))
+			fmt.Printf("Newest: %s (%s)\n", stats.Newest.ID, stats.Newest.Timestamp.Format("2006-01-02 15:04:05"))
+			fmt.Printf("Projected deletions: %d, reclaiming %s\n", stats.ProjectedDeletions, formatBytes(stats.ReclaimableBytes))
+
+			return nil
+		},
+	}
+}
+
+func diffCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "verify <backup-id>",
+		Use:   "diff <backup-id-a> <backup-id-b>",
+		Short: "Compare the metadata of two backups",
+		Long:  "Compare two backups and print the change in size, compressed size, duration, database version and, where recorded, table row counts from the first backup to the second.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			diff, err := engine.Diff(ctx, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				return printJSON(tools.NewCompareBackupsOutput(diff))
+			}
+
+			fmt.Printf("Before: %s (%s)\n", diff.Before.ID, diff.Before.Timestamp.Format("2006-01-02 15:04:05"))
+			fmt.Printf("After:  %s (%s)\n\n", diff.After.ID, diff.After.Timestamp.Format("2006-01-02 15:04:05"))
+			for _, f := range diff.Fields {
+				if f.Change == "" {
+					fmt.Printf("%-18s %s (unchanged)\n", f.Field+":", f.Before)
+					continue
+				}
+				fmt.Printf("%-18s %s -> %s  %s\n", f.Field+":", f.Before, f.After, f.Change)
+			}
+
+			switch {
+			case diff.TablesCompared == 0:
+				fmt.Println("\nTable row counts: not recorded (set backup.collect_stats)")
+			case len(diff.Tables) == 0:
+				fmt.Printf("\nTable row counts: unchanged in %d table(s)\n", diff.TablesCompared)
+			default:
+				fmt.Printf("\nTable row counts: %d of %d table(s) changed\n", len(diff.Tables), diff.TablesCompared)
+				for _, t := range diff.Tables {
+					fmt.Printf("  %-30s %s -> %s\n", t.Table, formatRowCount(t.Before), formatRowCount(t.After))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// formatRowCount prints a table row count from a backup diff, where -1
+// means the backup has no count for the table.
+func formatRowCount(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+func checkCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Check configuration, database, storage and tools without backing up",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			source := cfgFile
+			if source == "" {
+				source = "environment only"
+			}
+			fmt.Printf("PASS  %-24s %s\n", "config", source)
+
+			var failed int
+			results := engine.Preflight(ctx)
+			for _, r := range results {
+				if r.Passed() {
+					fmt.Printf("PASS  %-24s %s\n", r.Name, r.Detail)
+				} else {
+					failed++
+					fmt.Printf("FAIL  %-24s %v\n", r.Name, r.Err)
+				}
+			}
+
+			if failed > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d of %d checks failed", failed, len(results)+1)
+			}
+
+			fmt.Println("\nAll checks passed")
+			return nil
+		},
+	}
+}
+
+func selfTestCmd() *cobra.Command {
+	var useDatabase bool
+
+	cmd := &cobra.Command{
+		Use:   "self-test",
+		Short: "Back up, verify, restore and delete a throwaway backup",
+		Long: `Run a throwaway backup through the configured storage, compression and
+encryption, verify it, restore it into a scratch target and delete it again.
+
+By default a small built-in SQLite sample database is backed up; --database
+backs up the configured database instead. Existing backups, retention, hooks,
+notifications and metrics are not touched.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			engine := backup.NewEngine(cfg, store, nil, nil, logger)
+
+			var failed int
+			results := engine.SelfTest(ctx, !useDatabase)
+			for _, r := range results {
+				if r.Passed() {
+					fmt.Printf("PASS  %-24s %s\n", r.Name, r.Detail)
+				} else {
+					failed++
+					fmt.Printf("FAIL  %-24s %v\n", r.Name, r.Err)
+				}
+			}
+
+			if failed > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d of %d phases failed", failed, len(results))
+			}
+
+			fmt.Println("\nSelf-test passed")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&useDatabase, "database", false, "Back up the configured database instead of a built-in sample")
+
+	return cmd
+}
+
+func verifyCmd() *cobra.Command {
+	var all bool
+	var deep bool
+
+	cmd := &cobra.Command{
+		Use:   "verify [backup-id]",
 		Short: "Validate backup integrity",
-		Args:  cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all && len(args) > 0 {
+				return fmt.Errorf("--all takes no backup ID")
+			}
+			if !all && len(args) != 1 {
+				return fmt.Errorf("requires a backup ID, or --all")
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
-			engine := backup.NewEngine(cfg, store, notifier, logger)
-			validator := backup.NewValidator(store, logger)
+			engine := backup.NewEngine(cfg, store, notifier, nil, logger)
+
+			if all {
+				return verifyAll(cmd, engine, deep)
+			}
 
 			meta, err := engine.GetBackup(ctx, args[0])
 			if err != nil {
 				return err
 			}
 
-			result, err := validator.Validate(ctx, meta)
+			result, err := engine.Verify(ctx, meta, deep)
 			if err != nil {
 				return err
 			}
 
+			if output == "json" {
+				if err := printJSON(tools.NewVerifyBackupOutput(result)); err != nil {
+					return err
+				}
+				if !result.Valid {
+					cmd.SilenceUsage = true
+					return fmt.Errorf("backup validation failed")
+				}
+				return nil
+			}
+
 			if result.Valid {
 				fmt.Printf("Backup %s is valid\n", args[0])
 				fmt.Printf("  File exists: %v\n", result.FileExists)
 				fmt.Printf("  Size match: %v\n", result.SizeMatch)
 				fmt.Printf("  Checksum OK: %v\n", result.ChecksumOK)
+				fmt.Printf("  Compression OK: %v\n", result.CompressionOK)
+				if deep {
+					fmt.Printf("  Restore OK: %v\n", result.RestoreOK)
+				}
 			} else {
 				fmt.Printf("Backup %s is INVALID\n", args[0])
 				for _, e := range result.Errors {
@@ -376,22 +1395,120 @@ func verifyCmd() *cobra.Command {
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "verify every backup and print a summary")
+	cmd.Flags().BoolVar(&deep, "deep", false, "also restore each backup into a temporary database")
+
+	return cmd
 }
 
+// verifyAll verifies every backup for verify --all and fails if any is
+// invalid.
+func verifyAll(cmd *cobra.Command, engine *backup.Engine, deep bool) error {
+	results, err := engine.VerifyAll(context.Background(), deep)
+	if err != nil {
+		return err
+	}
+	summary := tools.NewVerifyAllBackupsOutput(results)
+
+	if output == "json" {
+		if err := printJSON(summary); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%-26s %-8s %s\n", "ID", "VALID", "ERRORS")
+		for _, r := range results {
+			valid := "yes"
+			if !r.Valid {
+				valid = "NO"
+			}
+			fmt.Printf("%-26s %-8s %s\n", r.BackupID, valid, strings.Join(r.Errors, "; "))
+		}
+		fmt.Printf("\n%d backups: %d passed, %d failed\n", summary.Total, summary.Passed, summary.Failed)
+	}
+
+	if summary.Failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d backups failed verification", summary.Failed, summary.Total)
+	}
+	return nil
+}
+
+// healthResponse is the JSON body of the health endpoints.
+type healthResponse struct {
+	Status       string `json:"status"`
+	Reason       string `json:"reason,omitempty"`
+	LastBackup   string `json:"last_backup,omitempty"`
+	NextBackup   string `json:"next_backup,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	BackupCount  *int   `json:"backup_count,omitempty"`
+	StorageBytes *int64 `json:"storage_bytes,omitempty"`
+
+	Schedules []tools.ScheduleStatus `json:"schedules,omitempty"`
+}
+
+// wantsJSON reports whether the request asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// healthHandler reports the last and next backup, as text by default or as
+// JSON when the client accepts it. It returns 503 while the last backup run
+// failed, and reports degraded with 200 when only some databases failed.
 func healthHandler(scheduler *backup.Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		engine := scheduler.Engine()
 
 		status := "healthy"
+		code := http.StatusOK
 		lastRun := engine.LastRun()
 		lastErr := engine.LastError()
 		nextRun := scheduler.NextRun()
 
-		if lastErr != nil {
+		switch {
+		case backup.IsPartial(lastErr):
+			// Some databases were backed up; the failures were notified.
+			status = "degraded"
+		case lastErr != nil:
 			status = "unhealthy"
-			w.WriteHeader(http.StatusServiceUnavailable)
+			code = http.StatusServiceUnavailable
 		}
 
+		if wantsJSON(r) {
+			resp := healthResponse{Status: status}
+			if backups, err := engine.ListBackups(r.Context()); err == nil {
+				var totalSize int64
+				for _, b := range backups {
+					totalSize += b.Backup.CompressedSize
+					if b.Timestamp.After(lastRun) {
+						lastRun = b.Timestamp
+					}
+				}
+				count := len(backups)
+				resp.BackupCount = &count
+				resp.StorageBytes = &totalSize
+			}
+			if !lastRun.IsZero() {
+				resp.LastBackup = lastRun.Format(time.RFC3339)
+			}
+			if lastErr != nil {
+				resp.LastError = lastErr.Error()
+			}
+			if !nextRun.IsZero() {
+				resp.NextBackup = nextRun.Format(time.RFC3339)
+			}
+			resp.Schedules = tools.NamedSchedules(scheduler.NextRuns())
+			writeHealthJSON(w, code, resp)
+			return
+		}
+
+		w.WriteHeader(code)
 		fmt.Fprintf(w, "status: %s\n", status)
 		if !lastRun.IsZero() {
 			fmt.Fprintf(w, "last_backup: %s\n", lastRun.Format(time.RFC3339))
@@ -402,6 +1519,177 @@ func healthHandler(scheduler *backup.Scheduler) http.HandlerFunc {
 		if !nextRun.IsZero() {
 			fmt.Fprintf(w, "next_backup: %s\n", nextRun.Format(time.RFC3339))
 		}
+		for _, sched := range tools.NamedSchedules(scheduler.NextRuns()) {
+			fmt.Fprintf(w, "next_backup[%s]: %s\n", sched.Name, sched.NextBackup)
+		}
+	}
+}
+
+// liveHandler answers as long as the process is serving requests, for
+// liveness probes.
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeHealthJSON(w, http.StatusOK, healthResponse{Status: "alive"})
+		return
+	}
+	fmt.Fprintln(w, "status: alive")
+}
+
+// lastBackupTime returns when the engine last backed up or, before its first
+// run since startup, the timestamp of the newest stored backup. It is zero
+// when there is neither.
+func lastBackupTime(ctx context.Context, engine *backup.Engine) time.Time {
+	lastRun := engine.LastRun()
+	if lastRun.IsZero() {
+		if backups, err := engine.ListBackups(ctx); err == nil {
+			for _, b := range backups {
+				if b.Timestamp.After(lastRun) {
+					lastRun = b.Timestamp
+				}
+			}
+		}
+	}
+	return lastRun
+}
+
+// storageRecheckInterval is how often readiness probes retry a failed
+// storage check, so fixing credentials without a restart clears it.
+const storageRecheckInterval = time.Minute
+
+// storageCheckTimeout bounds a single storage check.
+const storageCheckTimeout = 30 * time.Second
+
+// storageProbe remembers the result of the daemon's storage check.
+type storageProbe struct {
+	engine *backup.Engine
+
+	mu      sync.Mutex
+	err     error
+	checked time.Time
+}
+
+// Run checks storage and records the result.
+func (p *storageProbe) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, storageCheckTimeout)
+	defer cancel()
+	err := p.engine.CheckStorage(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+	p.checked = time.Now()
+	return err
+}
+
+// Err returns the last check's error, checking again when it failed more
+// than storageRecheckInterval ago. A passed check is not repeated.
+func (p *storageProbe) Err(ctx context.Context) error {
+	p.mu.Lock()
+	err, checked := p.err, p.checked
+	p.mu.Unlock()
+
+	if err != nil && time.Since(checked) > storageRecheckInterval {
+		if err = p.Run(ctx); err == nil {
+			logger.Info("storage check passed", "backend", cfg.Storage.Backend)
+		}
+	}
+	return err
+}
+
+// readyHandler returns 200 while the scheduler is running and a backup
+// succeeded within maxAge, and 503 otherwise, for readiness probes. Before
+// the daemon's first run, the newest backup in storage counts.
+func readyHandler(scheduler *backup.Scheduler, maxAge time.Duration, storage *storageProbe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		engine := scheduler.Engine()
+		storageErr := storage.Err(r.Context())
+
+		lastRun := lastBackupTime(r.Context(), engine)
+
+		resp := healthResponse{Status: "ready"}
+		if !lastRun.IsZero() {
+			resp.LastBackup = lastRun.Format(time.RFC3339)
+		}
+		switch {
+		case !scheduler.IsRunning():
+			resp.Reason = "scheduler not running"
+		case storageErr != nil:
+			resp.Reason = fmt.Sprintf("storage check failed: %v", storageErr)
+		case lastRun.IsZero():
+			resp.Reason = "no successful backup"
+		case time.Since(lastRun) > maxAge:
+			resp.Reason = fmt.Sprintf("last successful backup older than %s", maxAge)
+		}
+
+		code := http.StatusOK
+		if resp.Reason != "" {
+			resp.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+
+		if wantsJSON(r) {
+			writeHealthJSON(w, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "status: %s\n", resp.Status)
+		if resp.Reason != "" {
+			fmt.Fprintf(w, "reason: %s\n", resp.Reason)
+		}
+		if resp.LastBackup != "" {
+			fmt.Fprintf(w, "last_backup: %s\n", resp.LastBackup)
+		}
+	}
+}
+
+// reloadConfig re-reads the config and applies schedule and retention changes
+// to the running daemon. Other changes are only logged: applying them would
+// need new servers, storage or database connections, so they wait for a
+// restart. applied tracks the settings the daemon is actually running with.
+func reloadConfig(ctx context.Context, scheduler *backup.Scheduler, applied *config.Config) {
+	logger.Info("reloading config", "path", cfgFile)
+
+	next, err := config.Load(cfgFile)
+	if err != nil {
+		logger.Error("config reload failed, keeping current config", "error", err)
+		return
+	}
+
+	changed := applied.ChangedSections(next)
+	if len(changed) == 0 {
+		logger.Info("config reloaded, no changes")
+		return
+	}
+
+	for _, section := range changed {
+		switch section {
+		case "schedule":
+			if err := scheduler.Reschedule(ctx, next.BackupSchedules(), next.Schedule.VerifyRestore); err != nil {
+				logger.Error("failed to apply new schedule", "error", err)
+				continue
+			}
+			scheduler.Engine().SetSchedules(next.Schedules)
+			scheduler.SetJitter(next.ScheduleJitterRange())
+			logger.Info("schedule updated",
+				"from", applied.Schedule,
+				"to", next.Schedule,
+				"jitter", next.ScheduleJitter,
+				"next_run", scheduler.NextRun(),
+			)
+			applied.Schedule = next.Schedule
+			applied.Schedules = next.Schedules
+			applied.ScheduleJitter = next.ScheduleJitter
+		case "retention":
+			scheduler.Engine().SetRetention(next.Retention)
+			scheduler.SetAutoCleanup(next.Retention.AutoCleanup)
+			logger.Info("retention policy updated",
+				"from", applied.Retention,
+				"to", next.Retention,
+			)
+			applied.Retention = next.Retention
+		default:
+			logger.Warn("config change requires a restart to take effect", "section", section)
+		}
 	}
 }
 
@@ -409,20 +1697,26 @@ func alertMonitor(ctx context.Context, scheduler *backup.Scheduler, cfg *config.
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
+	// Backup age is refreshed more often than the alert fires, so that
+	// Prometheus sees it cross the threshold within a minute.
+	ageTicker := time.NewTicker(1 * time.Minute)
+	defer ageTicker.Stop()
+	stored := lastBackupTime(ctx, scheduler.Engine())
+	updateBackupAge(scheduler.Engine(), stored, cfg, m)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-ageTicker.C:
+			updateBackupAge(scheduler.Engine(), stored, cfg, m)
 		case <-ticker.C:
 			engine := scheduler.Engine()
 			lastRun := engine.LastRun()
 
-			backups, _ := engine.ListBackups(ctx)
-			var totalSize int64
-			for _, b := range backups {
-				totalSize += b.Backup.CompressedSize
+			if backups, err := engine.ListBackups(ctx); err == nil {
+				m.SetStorageUsed(backup.SizeByType(backups))
 			}
-			m.SetStorageUsed(totalSize)
 
 			if !lastRun.IsZero() && time.Since(lastRun) > cfg.AlertDuration() {
 				if notifier != nil {
@@ -437,6 +1731,54 @@ func alertMonitor(ctx context.Context, scheduler *backup.Scheduler, cfg *config.
 	}
 }
 
+// updateBackupAge sets the backup age and overdue gauges from the engine's
+// last run, or from stored, the newest backup found at startup, before the
+// first run. They are left alone until a backup is known, so a fresh install
+// is not reported overdue.
+func updateBackupAge(engine *backup.Engine, stored time.Time, cfg *config.Config, m *metrics.Metrics) {
+	lastRun := engine.LastRun()
+	if lastRun.IsZero() {
+		lastRun = stored
+	}
+	if lastRun.IsZero() {
+		return
+	}
+	m.SetLastBackupAge(time.Since(lastRun), cfg.AlertDuration())
+}
+
+// printStorageUsed prints the total backup size and, when set, how much of
+// the retention cap it uses.
+func printStorageUsed(total, limit int64) {
+	if limit <= 0 {
+		fmt.Printf("Storage used: %s\n", formatBytes(total))
+		return
+	}
+	fmt.Printf("Storage used: %s of %s cap (%.0f%%)\n", formatBytes(total), formatBytes(limit), float64(total)/float64(limit)*100)
+}
+
+// formatLabels renders labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// printProgress writes one line per progress update to stderr for
+// --progress, leaving stdout to the result, including --output json.
+func printProgress(u progress.Update) {
+	fmt.Fprintln(os.Stderr, u.String())
+}
+
+// printJSON writes v to stdout for --output json.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
 func formatBytes(bytes int64) string {
 	const (
 		KB = 1024
@@ -0,0 +1,90 @@
+// Package audit records who restored or cleaned up backups, and what came
+// of it, for change management.
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Sources of audited operations.
+const (
+	SourceCLI = "cli"
+	SourceMCP = "mcp"
+)
+
+// Event is one restore or cleanup invocation.
+type Event struct {
+	Op     string // restore or cleanup
+	Source string // SourceCLI or SourceMCP
+
+	// Actor is who asked: the OS user for the CLI, or the KeyID of the API
+	// key for MCP.
+	Actor string
+
+	BackupID string
+	TargetDB string
+	DryRun   bool
+	Deleted  int // Backups deleted by a cleanup
+	Err      error
+}
+
+// Logger writes audit events. A nil Logger discards them.
+type Logger struct {
+	logger *slog.Logger
+	file   *os.File
+}
+
+// New returns a Logger appending JSON lines to the file at path, or, when
+// path is empty, writing through logger with audit=true on every entry.
+func New(path string, logger *slog.Logger) (*Logger, error) {
+	if path == "" {
+		return &Logger{logger: logger.With("audit", true)}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Logger{logger: slog.New(slog.NewJSONHandler(f, nil)), file: f}, nil
+}
+
+// Record writes e with the current time and its outcome: success, or
+// failure with the error.
+func (l *Logger) Record(e Event) {
+	if l == nil {
+		return
+	}
+
+	attrs := []any{
+		"op", e.Op,
+		"source", e.Source,
+		"actor", e.Actor,
+		"dry_run", e.DryRun,
+	}
+	if e.BackupID != "" {
+		attrs = append(attrs, "backup_id", e.BackupID)
+	}
+	if e.TargetDB != "" {
+		attrs = append(attrs, "target_db", e.TargetDB)
+	}
+	if e.Op == "cleanup" {
+		attrs = append(attrs, "deleted", e.Deleted)
+	}
+	if e.Err != nil {
+		attrs = append(attrs, "outcome", "failure", "error", e.Err.Error())
+	} else {
+		attrs = append(attrs, "outcome", "success")
+	}
+
+	l.logger.Info("audit", attrs...)
+}
+
+// Close closes the audit log file, if there is one.
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
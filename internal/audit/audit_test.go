@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(path, slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	l.Record(Event{Op: "restore", Source: SourceMCP, Actor: "abc123", BackupID: "backup_20240115_020000", TargetDB: "app"})
+	l.Record(Event{Op: "cleanup", Source: SourceCLI, Actor: "alice", Err: errors.New("storage unavailable")})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2", len(lines))
+	}
+
+	var restore, cleanup map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &restore); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &cleanup); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if restore["time"] == nil || restore["op"] != "restore" || restore["source"] != "mcp" || restore["actor"] != "abc123" ||
+		restore["backup_id"] != "backup_20240115_020000" || restore["target_db"] != "app" || restore["outcome"] != "success" {
+		t.Errorf("restore entry = %v", restore)
+	}
+	if cleanup["outcome"] != "failure" || cleanup["error"] != "storage unavailable" || cleanup["deleted"] != float64(0) {
+		t.Errorf("cleanup entry = %v", cleanup)
+	}
+}
+
+func TestLogger_Fallback(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New("", slog.New(slog.NewTextHandler(&buf, nil)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	l.Record(Event{Op: "restore", Source: SourceCLI, Actor: "alice", DryRun: true})
+	if out := buf.String(); !strings.Contains(out, "audit=true") || !strings.Contains(out, "dry_run=true") {
+		t.Errorf("log = %q, want an audit entry", out)
+	}
+}
+
+func TestLogger_Nil(t *testing.T) {
+	var l *Logger
+	l.Record(Event{Op: "restore"})
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
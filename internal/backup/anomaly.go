@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// minAnomalyHistory is how many comparable earlier backups the anomaly check
+// needs before it trusts their median.
+const minAnomalyHistory = 3
+
+// minAnomalyDuration is the duration below which timing is too noisy to
+// compare: a 2s backup taking 4s is not worth an alert.
+const minAnomalyDuration = 10 * time.Second
+
+// checkAnomalies compares a backup that just completed against the median
+// compressed size and duration of the monitoring.anomaly_window backups
+// before it, and sends a backup.anomaly notification for each that deviates
+// by more than monitoring.anomaly_percent. Only backups of the same database,
+// schedule, mode and kind are compared, so a schema-only schedule does not
+// look like a shrunken full dump. It returns the anomalies found.
+func (e *Engine) checkAnomalies(ctx context.Context, logger *slog.Logger, metadata *postgres.BackupMetadata) []string {
+	window := e.cfg.Monitoring.AnomalyWindow
+	if window <= 0 {
+		return nil
+	}
+
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		logger.Warn("failed to list backups for anomaly check", "error", err)
+		return nil
+	}
+
+	var history []*postgres.BackupMetadata
+	for _, b := range backups {
+		if b.ID == metadata.ID || b.Database != metadata.Database || b.Schedule != metadata.Schedule ||
+			b.BackupMode() != metadata.BackupMode() || b.Backup.Kind != metadata.Backup.Kind {
+			continue
+		}
+		history = append(history, b)
+	}
+	if len(history) < minAnomalyHistory {
+		return nil
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Timestamp.After(history[j].Timestamp)
+	})
+	if len(history) > window {
+		history = history[:window]
+	}
+
+	sizes := make([]float64, len(history))
+	durations := make([]float64, len(history))
+	for i, b := range history {
+		sizes[i] = float64(b.Backup.CompressedSize)
+		durations[i] = b.Backup.DurationSeconds
+	}
+
+	threshold := e.cfg.Monitoring.AnomalyThreshold()
+	medSize, medDuration := median(sizes), median(durations)
+	var anomalies []string
+	if ratio, ok := deviation(float64(metadata.Backup.CompressedSize), medSize, threshold); ok {
+		anomalies = append(anomalies, fmt.Sprintf(
+			"backup %s is %.0f%% of the median size of the last %d backups (%d bytes vs %.0f)",
+			metadata.ID, ratio*100, len(history), metadata.Backup.CompressedSize, medSize))
+	}
+	duration, usual := secondsDuration(metadata.Backup.DurationSeconds), secondsDuration(medDuration)
+	if duration >= minAnomalyDuration || usual >= minAnomalyDuration {
+		if ratio, ok := deviation(metadata.Backup.DurationSeconds, medDuration, threshold); ok {
+			anomalies = append(anomalies, fmt.Sprintf(
+				"backup %s took %.0f%% of the median duration of the last %d backups (%s vs %s)",
+				metadata.ID, ratio*100, len(history), duration, usual))
+		}
+	}
+
+	for _, message := range anomalies {
+		logger.Warn("backup anomaly", "detail", message)
+		if e.notifier != nil {
+			e.notifier.NotifyAnomaly(metadata.ID, message)
+		}
+	}
+	return anomalies
+}
+
+// deviation returns value as a fraction of med, and whether it differs
+// from med by more than threshold. A zero median has nothing to compare to.
+func deviation(value, med, threshold float64) (float64, bool) {
+	if med <= 0 {
+		return 0, false
+	}
+	ratio := value / med
+	return ratio, math.Abs(ratio-1) > threshold
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
@@ -3,19 +3,39 @@ package backup
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/metrics"
+	"github.com/localrivet/datasaver/internal/notify"
+	"github.com/localrivet/datasaver/internal/oplock"
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/internal/transform"
 	"github.com/localrivet/datasaver/pkg/postgres"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Mock storage backend for testing
 type mockStorage struct {
+	mu        sync.Mutex
 	files     map[string][]byte
 	sizeErr   error
 	existsErr error
@@ -33,11 +53,15 @@ func (m *mockStorage) Write(ctx context.Context, path string, reader io.Reader)
 	if err != nil {
 		return err
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.files[path] = data
 	return nil
 }
 
 func (m *mockStorage) Read(ctx context.Context, path string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.readErr != nil {
 		return nil, m.readErr
 	}
@@ -49,13 +73,20 @@ func (m *mockStorage) Read(ctx context.Context, path string) (io.ReadCloser, err
 }
 
 func (m *mockStorage) Delete(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	delete(m.files, path)
 	return nil
 }
 
 func (m *mockStorage) List(ctx context.Context, prefix string) ([]storage.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var files []storage.FileInfo
 	for path := range m.files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
 		files = append(files, storage.FileInfo{
 			Path:         path,
 			Size:         int64(len(m.files[path])),
@@ -66,6 +97,8 @@ func (m *mockStorage) List(ctx context.Context, prefix string) ([]storage.FileIn
 }
 
 func (m *mockStorage) Exists(ctx context.Context, path string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.existsErr != nil {
 		return false, m.existsErr
 	}
@@ -74,6 +107,8 @@ func (m *mockStorage) Exists(ctx context.Context, path string) (bool, error) {
 }
 
 func (m *mockStorage) Size(ctx context.Context, path string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.sizeErr != nil {
 		return 0, m.sizeErr
 	}
@@ -230,6 +265,38 @@ func TestWithRetry_NonRetryableError(t *testing.T) {
 	}
 }
 
+func TestWithRetry_IsPermanent(t *testing.T) {
+	ctx := context.Background()
+	codeErr := errors.New("FATAL 28P01")
+	cfg := RetryConfig{
+		MaxAttempts: 3,
+		InitialWait: 10 * time.Millisecond,
+		MaxWait:     100 * time.Millisecond,
+		Multiplier:  2.0,
+		IsPermanent: func(err error) bool { return errors.Is(err, codeErr) },
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	callCount := 0
+	_, err := WithRetry(ctx, cfg, logger, "test-op", func() (string, error) {
+		callCount++
+		return "", fmt.Errorf("failed to connect: %w", codeErr)
+	})
+	if !errors.Is(err, codeErr) || callCount != 1 {
+		t.Errorf("WithRetry() error = %v after %d calls, want the permanent error after 1", err, callCount)
+	}
+
+	// Errors the classifier does not know still follow the generic rules.
+	callCount = 0
+	_, err = WithRetry(ctx, cfg, logger, "test-op", func() (string, error) {
+		callCount++
+		return "", errors.New("connection refused")
+	})
+	if err == nil || callCount != 3 {
+		t.Errorf("WithRetry() error = %v after %d calls, want 3 attempts", err, callCount)
+	}
+}
+
 func TestWithRetry_ContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // Cancel immediately
@@ -452,7 +519,7 @@ func TestValidator_Validate_SizeMismatch(t *testing.T) {
 
 func TestValidator_Validate_Success(t *testing.T) {
 	store := newMockStorage()
-	content := []byte("backup content")
+	content := gzipBytes(t, []byte("backup content"))
 	store.files["backup-001.dump.gz"] = content
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	v := NewValidator(store, logger)
@@ -480,6 +547,121 @@ func TestValidator_Validate_Success(t *testing.T) {
 	if !result.SizeMatch {
 		t.Error("Validate() SizeMatch = false, want true")
 	}
+	if !result.CompressionOK {
+		t.Error("Validate() CompressionOK = false, want true")
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := transform.Gzip{}.Wrap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestValidator_Validate_CorruptCompression(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	good := gzipBytes(t, bytes.Repeat([]byte("row data\n"), 1000))
+	flipped := append([]byte(nil), good...)
+	flipped[len(flipped)-5] ^= 0xff // inside the CRC-32 trailer
+
+	tests := []struct {
+		name           string
+		file           string
+		content        []byte
+		compressedInDB bool
+		wantOK         bool
+	}{
+		{"valid gzip", "backup-001.dump.gz", good, false, true},
+		{"truncated gzip", "backup-001.dump.gz", good[:len(good)/2], false, false},
+		{"bad CRC", "backup-001.dump.gz", flipped, false, false},
+		{"bad header", "backup-001.dump.gz", []byte("not gzip at all"), false, false},
+		{"uncompressed", "backup-001.dump", []byte("not gzip at all"), false, true},
+		// pg_dump's own compression is left to pg_restore.
+		{"compressed in pg_dump", "backup-001.dump", []byte("PGDMP"), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStorage()
+			store.files[tt.file] = tt.content
+			metadata := &postgres.BackupMetadata{
+				ID:    "backup-001",
+				Files: []string{tt.file, "backup-001.meta.json"},
+				Backup: postgres.BackupInfo{
+					CompressedSize: int64(len(tt.content)),
+					CompressedInDB: tt.compressedInDB,
+				},
+			}
+
+			result, err := NewValidator(store, logger).Validate(context.Background(), metadata)
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if result.CompressionOK != tt.wantOK || result.Valid != tt.wantOK {
+				t.Errorf("Validate() CompressionOK = %v, Valid = %v, want %v (errors %v)",
+					result.CompressionOK, result.Valid, tt.wantOK, result.Errors)
+			}
+		})
+	}
+}
+
+// checksumStorage is a mockStorage that records checksums on write, like
+// S3Storage.
+type checksumStorage struct {
+	*mockStorage
+	sums map[string]string
+}
+
+func (c *checksumStorage) Checksum(ctx context.Context, path string) (string, error) {
+	return c.sums[path], nil
+}
+
+func TestValidator_Validate_StoredChecksum(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	const want = "sha256:aaaa"
+
+	tests := []struct {
+		name   string
+		stored string
+		wantOK bool
+	}{
+		{"match", want, true},
+		{"mismatch", "sha256:bbbb", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &checksumStorage{
+				mockStorage: newMockStorage(),
+				sums:        map[string]string{"backup-001.dump": tt.stored},
+			}
+			store.files["backup-001.dump"] = []byte("backup content")
+			// Either answer must come without downloading the file.
+			store.readErr = errors.New("unexpected download")
+
+			metadata := &postgres.BackupMetadata{
+				ID:    "backup-001",
+				Files: []string{"backup-001.dump", "backup-001.meta.json"},
+				Backup: postgres.BackupInfo{
+					CompressedSize: int64(len("backup content")),
+					Checksum:       want,
+				},
+			}
+
+			result, err := NewValidator(store, logger).Validate(context.Background(), metadata)
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if result.ChecksumOK != tt.wantOK || result.Valid != tt.wantOK {
+				t.Errorf("Validate() ChecksumOK = %v, Valid = %v, want %v (errors %v)",
+					result.ChecksumOK, result.Valid, tt.wantOK, result.Errors)
+			}
+		})
+	}
 }
 
 func TestValidator_Validate_ExistsError(t *testing.T) {
@@ -506,8 +688,8 @@ func TestNewScheduler(t *testing.T) {
 	if s == nil {
 		t.Fatal("NewScheduler() returned nil")
 	}
-	if s.schedule != "0 2 * * *" {
-		t.Errorf("schedule = %v, want 0 2 * * *", s.schedule)
+	if len(s.schedules) != 1 || s.schedules[0].Cron != "0 2 * * *" {
+		t.Errorf("schedules = %v, want [0 2 * * *]", s.schedules)
 	}
 	if s.IsRunning() {
 		t.Error("IsRunning() = true, want false for new scheduler")
@@ -570,10 +752,9 @@ func TestScheduler_Engine(t *testing.T) {
 	}
 }
 
-func TestCompressGzip(t *testing.T) {
+func TestEngine_Encode(t *testing.T) {
 	tmpDir := t.TempDir()
 	srcPath := tmpDir + "/source.txt"
-	dstPath := tmpDir + "/source.txt.gz"
 
 	// Create source file
 	content := []byte("test content for compression")
@@ -581,26 +762,109 @@ func TestCompressGzip(t *testing.T) {
 		t.Fatalf("Failed to create source file: %v", err)
 	}
 
-	// Compress
-	err := compressGzip(srcPath, dstPath)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{Compression: "gzip"}, newMockStorage(), nil, nil, logger)
+
+	encoded, size, err := engine.encode(context.Background(), engine.logger, srcPath, 0, false)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if encoded != srcPath+".gz" {
+		t.Errorf("encode() file = %q, want %q", encoded, srcPath+".gz")
+	}
+	if size == 0 {
+		t.Error("Compressed file is empty")
+	}
+
+	engine = NewEngine(&config.Config{Compression: "none"}, newMockStorage(), nil, nil, logger)
+	encoded, _, err = engine.encode(context.Background(), engine.logger, srcPath, 0, false)
 	if err != nil {
-		t.Fatalf("compressGzip() error = %v", err)
+		t.Fatalf("encode() error = %v", err)
+	}
+	if encoded != srcPath {
+		t.Errorf("encode() with no compression = %q, want the source file", encoded)
 	}
 
-	// Verify compressed file exists and is smaller (for this small file it might not be)
-	info, err := os.Stat(dstPath)
+	// A file pg_dump compressed is stored as it is, only encrypted.
+	key := strings.Repeat("ab", 32)
+	engine = NewEngine(&config.Config{Compression: "gzip", Encryption: config.EncryptionConfig{Key: key}}, newMockStorage(), nil, nil, logger)
+	encoded, _, err = engine.encode(context.Background(), engine.logger, srcPath, 0, true)
 	if err != nil {
-		t.Fatalf("Failed to stat compressed file: %v", err)
+		t.Fatalf("encode() error = %v", err)
 	}
-	if info.Size() == 0 {
-		t.Error("Compressed file is empty")
+	if encoded != srcPath+".enc" {
+		t.Errorf("encode() of a compressed file = %q, want %q", encoded, srcPath+".enc")
 	}
 }
 
-func TestCompressGzip_SourceNotFound(t *testing.T) {
-	err := compressGzip("/nonexistent/file.txt", "/tmp/out.gz")
+func TestEncodeFile_SourceNotFound(t *testing.T) {
+	err := encodeFile(transform.Chain{transform.Gzip{}}, "/nonexistent/file.txt", "/tmp/out.gz", nil)
 	if err == nil {
-		t.Error("compressGzip() should error when source doesn't exist")
+		t.Error("encodeFile() should error when source doesn't exist")
+	}
+}
+
+func TestEngine_Encode_RoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := []byte(strings.Repeat("test content for compression ", 100))
+	key := bytes.Repeat([]byte{0x42}, transform.KeySize)
+
+	tests := []struct {
+		compression string
+		level       int
+		encrypt     bool
+		suffix      string
+	}{
+		{"gzip", 1, false, ".gz"},
+		{"gzip", 9, false, ".gz"},
+		{"zstd", 0, false, ".zst"},
+		{"zstd", 19, false, ".zst"},
+		{"gzip", 0, true, ".gz.enc"},
+		{"none", 0, true, ".enc"},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%d-%v", tt.compression, tt.level, tt.encrypt), func(t *testing.T) {
+			srcPath := filepath.Join(tmpDir, fmt.Sprintf("source-%s-%d-%v", tt.compression, tt.level, tt.encrypt))
+			if err := os.WriteFile(srcPath, content, 0644); err != nil {
+				t.Fatalf("Failed to create source file: %v", err)
+			}
+
+			cfg := &config.Config{Compression: tt.compression, CompressionLevel: tt.level}
+			if tt.encrypt {
+				cfg.Encryption.Key = fmt.Sprintf("%x", key)
+			}
+			engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+			encoded, _, err := engine.encode(context.Background(), engine.logger, srcPath, 0, false)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			if !strings.HasSuffix(encoded, tt.suffix) {
+				t.Fatalf("encode() file = %q, want suffix %q", encoded, tt.suffix)
+			}
+
+			f, err := os.Open(encoded)
+			if err != nil {
+				t.Fatalf("Failed to open encoded file: %v", err)
+			}
+			defer f.Close()
+
+			reader, err := transform.NewRegistry(key).Reader(f, encoded)
+			if err != nil {
+				t.Fatalf("Reader() error = %v", err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("decoded content does not match source")
+			}
+		})
 	}
 }
 
@@ -650,3 +914,3007 @@ func TestEqualFoldSlice(t *testing.T) {
 		})
 	}
 }
+
+// newDrillFixture stores a single SQLite backup with the given SQL content and
+// returns an engine over it plus a channel receiving webhook payloads.
+func newDrillFixture(t *testing.T, content string) (*Engine, chan notify.WebhookPayload) {
+	t.Helper()
+
+	store := newMockStorage()
+	store.files["backup_20260101_020000.sql"] = []byte(content)
+
+	meta := postgres.NewBackupMetadata("backup_20260101_020000", "test.db", "local", "3.45.0")
+	meta.AddFile("backup_20260101_020000.sql")
+	metaJSON, err := meta.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error: %v", err)
+	}
+	store.files["backup_20260101_020000.meta.json"] = metaJSON
+
+	payloads := make(chan notify.WebhookPayload, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.WebhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		payloads <- p
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Database: config.DatabaseConfig{Type: "sqlite"}}
+	engine := NewEngine(cfg, store, notify.NewNotifier(server.URL, logger), nil, logger)
+
+	return engine, payloads
+}
+
+// gatherValue returns the value of a single counter or gauge from reg.
+func gatherValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		m := mf.GetMetric()[0]
+		if m.GetCounter() != nil {
+			return m.GetCounter().GetValue()
+		}
+		return m.GetGauge().GetValue()
+	}
+	t.Fatalf("metric %s not found", name)
+	return 0
+}
+
+func TestRestoreDrill_Success(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("drill_ok")
+
+	engine, payloads := newDrillFixture(t, "CREATE TABLE users (id INTEGER PRIMARY KEY);\n")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	drill := NewRestoreDrill(engine, m, logger)
+
+	if err := drill.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got := gatherValue(t, reg, "drill_ok_verify_restore_total"); got != 1 {
+		t.Errorf("verify_restore_total = %v, want 1", got)
+	}
+	if got := gatherValue(t, reg, "drill_ok_last_verify_restore_success"); got != 1 {
+		t.Errorf("last_verify_restore_success = %v, want 1", got)
+	}
+	if len(payloads) != 0 {
+		t.Errorf("expected no alerts for a successful drill, got %d", len(payloads))
+	}
+}
+
+func TestRestoreDrill_FailureAlertsAndRecordsMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("drill_fail")
+
+	engine, payloads := newDrillFixture(t, "this is not valid SQL;")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	drill := NewRestoreDrill(engine, m, logger)
+
+	if err := drill.Run(context.Background()); err == nil {
+		t.Fatal("Run() error = nil, want error for corrupt backup")
+	}
+
+	if got := gatherValue(t, reg, "drill_fail_verify_restore_failures_total"); got != 1 {
+		t.Errorf("verify_restore_failures_total = %v, want 1", got)
+	}
+	if got := gatherValue(t, reg, "drill_fail_last_verify_restore_success"); got != 0 {
+		t.Errorf("last_verify_restore_success = %v, want 0", got)
+	}
+
+	select {
+	case p := <-payloads:
+		if p.Event != "verify_restore.failed" {
+			t.Errorf("alert event = %s, want verify_restore.failed", p.Event)
+		}
+		if p.BackupID != "backup_20260101_020000" {
+			t.Errorf("alert backup_id = %s, want backup_20260101_020000", p.BackupID)
+		}
+	default:
+		t.Error("expected an alert for a failed drill")
+	}
+}
+
+func TestRestoreDrill_NoBackups(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("drill_empty")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Database: config.DatabaseConfig{Type: "sqlite"}}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+	drill := NewRestoreDrill(engine, m, logger)
+
+	if err := drill.Run(context.Background()); err == nil {
+		t.Fatal("Run() error = nil, want error when there is nothing to verify")
+	}
+	if got := gatherValue(t, reg, "drill_empty_verify_restore_failures_total"); got != 1 {
+		t.Errorf("verify_restore_failures_total = %v, want 1", got)
+	}
+}
+
+func TestScheduler_VerifyRestoreScheduleTriggersDrill(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("drill_sched")
+
+	engine, payloads := newDrillFixture(t, "garbage")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s := NewScheduler(engine, "0 2 * * *", logger)
+	s.SetRestoreDrill("0 4 * * 0", NewRestoreDrill(engine, m, logger))
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	entry := s.cron.Entry(s.drillEntryID)
+	if !entry.Valid() {
+		t.Fatal("restore drill was not registered with cron")
+	}
+	if entry.Next.Weekday() != time.Sunday || entry.Next.Hour() != 4 {
+		t.Errorf("drill next run = %v, want Sunday 04:00", entry.Next)
+	}
+
+	// Backup NextRun must still track the backup job, not the drill.
+	if next := s.NextRun(); next.Hour() != 2 {
+		t.Errorf("NextRun() = %v, want the 02:00 backup", next)
+	}
+
+	entry.Job.Run()
+
+	if got := gatherValue(t, reg, "drill_sched_verify_restore_failures_total"); got != 1 {
+		t.Errorf("verify_restore_failures_total = %v, want 1", got)
+	}
+	if len(payloads) != 1 {
+		t.Errorf("expected 1 alert from the scheduled drill, got %d", len(payloads))
+	}
+}
+
+func TestScheduler_InvalidVerifyRestoreSchedule(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewScheduler(nil, "0 2 * * *", logger)
+	s.SetRestoreDrill("not a cron", NewRestoreDrill(nil, nil, logger))
+
+	if err := s.Start(context.Background()); err == nil {
+		s.Stop()
+		t.Fatal("Start() error = nil, want error for invalid verify_restore schedule")
+	}
+}
+
+func TestScheduler_Reschedule(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewScheduler(nil, "0 2 * * *", logger)
+	s.SetRestoreDrill("", NewRestoreDrill(nil, nil, logger))
+
+	ctx := context.Background()
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	if s.drillEntryID != 0 {
+		t.Fatal("drill scheduled without a verify_restore schedule")
+	}
+
+	if err := s.Reschedule(ctx, []config.ScheduleEntry{{Cron: "30 5 * * *"}}, "0 4 * * 0"); err != nil {
+		t.Fatalf("Reschedule() error = %v", err)
+	}
+
+	if next := s.NextRun(); next.Hour() != 5 || next.Minute() != 30 {
+		t.Errorf("NextRun() = %v, want 05:30", next)
+	}
+	if len(s.cron.Entries()) != 2 {
+		t.Errorf("cron has %d entries, want 2", len(s.cron.Entries()))
+	}
+	if entry := s.cron.Entry(s.drillEntryID); entry.Next.Weekday() != time.Sunday {
+		t.Errorf("drill next run = %v, want Sunday", entry.Next)
+	}
+
+	if err := s.Reschedule(ctx, []config.ScheduleEntry{{Cron: "30 5 * * *"}}, ""); err != nil {
+		t.Fatalf("Reschedule() error = %v", err)
+	}
+	if len(s.cron.Entries()) != 1 {
+		t.Errorf("cron has %d entries after removing the drill, want 1", len(s.cron.Entries()))
+	}
+}
+
+func TestScheduler_MultipleSchedules(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewScheduler(nil, "0 2 * * *", logger)
+	s.SetSchedules([]config.ScheduleEntry{
+		{Name: "nightly", Cron: "0 2 * * *"},
+		{Name: "hourly", Cron: "0 * * * *", Mode: "schema"},
+	})
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	if len(s.cron.Entries()) != 2 {
+		t.Fatalf("cron has %d entries, want 2", len(s.cron.Entries()))
+	}
+
+	runs := s.NextRuns()
+	if len(runs) != 2 || runs[0].Name != "nightly" || runs[1].Name != "hourly" {
+		t.Fatalf("NextRuns() = %+v, want nightly then hourly", runs)
+	}
+	if runs[0].Next.Hour() != 2 || runs[1].Next.Minute() != 0 {
+		t.Errorf("NextRuns() = %+v, want 02:00 and the top of the hour", runs)
+	}
+
+	// The hourly job always comes first, or ties with the nightly one.
+	if next := s.NextRun(); !next.Equal(runs[1].Next) {
+		t.Errorf("NextRun() = %v, want the soonest run %v", next, runs[1].Next)
+	}
+}
+
+func TestScheduler_RescheduleInvalidKeepsCurrent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewScheduler(nil, "0 2 * * *", logger)
+	s.SetRestoreDrill("0 4 * * 0", NewRestoreDrill(nil, nil, logger))
+
+	ctx := context.Background()
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	if err := s.Reschedule(ctx, []config.ScheduleEntry{{Cron: "0 3 * * *"}}, "not a cron"); err == nil {
+		t.Fatal("Reschedule() error = nil, want error for invalid verify_restore schedule")
+	}
+
+	if next := s.NextRun(); next.Hour() != 2 {
+		t.Errorf("NextRun() = %v, want the original 02:00 backup", next)
+	}
+	if len(s.cron.Entries()) != 2 {
+		t.Errorf("cron has %d entries, want the original 2", len(s.cron.Entries()))
+	}
+}
+
+func TestEngine_SetRetention(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	for i, ts := range []time.Time{
+		time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), // Tuesday
+		time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC), // Wednesday
+	} {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = ts
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+	}
+
+	engine := NewEngine(&config.Config{Retention: config.RetentionConfig{Daily: 1}}, store, nil, nil, logger)
+	engine.SetRetention(config.RetentionConfig{Daily: 7})
+
+	decisions, err := engine.PreviewCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PreviewCleanup() error = %v", err)
+	}
+	for _, d := range decisions {
+		if !d.Keep {
+			t.Errorf("backup %s marked for deletion after raising daily retention to 7", d.Metadata.ID)
+		}
+	}
+}
+
+func TestScheduler_MissedRun(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	day := func(d, h, m int) time.Time {
+		return time.Date(2026, time.March, d, h, m, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		lastBackup time.Time
+		unsched    time.Time // an on-demand and a self-test backup taken then
+		now        time.Time
+		wantMissed time.Time
+	}{
+		{
+			name:       "no backups",
+			now:        day(10, 9, 0),
+			wantMissed: day(10, 9, 0),
+		},
+		{
+			name:       "next run still ahead",
+			lastBackup: day(9, 2, 0),
+			now:        day(10, 1, 30),
+		},
+		{
+			name:       "daemon down during window",
+			lastBackup: day(8, 2, 0),
+			now:        day(10, 9, 0),
+			wantMissed: day(9, 2, 0),
+		},
+		{
+			name:       "restart after catch-up does not run again",
+			lastBackup: day(10, 9, 1),
+			now:        day(10, 9, 5),
+		},
+		{
+			name:       "manual and self-test backups do not count",
+			lastBackup: day(8, 2, 0),
+			unsched:    day(10, 8, 0),
+			now:        day(10, 9, 0),
+			wantMissed: day(9, 2, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStorage()
+			if !tt.lastBackup.IsZero() {
+				meta := postgres.NewBackupMetadata("backup-001", "db", "local", "16")
+				meta.Timestamp = tt.lastBackup
+				data, _ := meta.ToJSON()
+				store.files["backup-001.meta.json"] = data
+			}
+			if !tt.unsched.IsZero() {
+				manual := postgres.NewBackupMetadata("backup-002", "db", "local", "16")
+				manual.Timestamp = tt.unsched
+				manual.OnDemand = true
+				selfTest := postgres.NewBackupMetadata(SelfTestPrefix+"backup-003", "db", "local", "16")
+				selfTest.Timestamp = tt.unsched
+				for _, meta := range []*postgres.BackupMetadata{manual, selfTest} {
+					data, _ := meta.ToJSON()
+					store.files[meta.ID+".meta.json"] = data
+				}
+			}
+
+			engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+			s := NewScheduler(engine, "0 2 * * *", logger)
+
+			got, err := s.missedRun(context.Background(), config.ScheduleEntry{Cron: "0 2 * * *"}, tt.now)
+			if err != nil {
+				t.Fatalf("missedRun() error = %v", err)
+			}
+			if !got.Equal(tt.wantMissed) {
+				t.Errorf("missedRun() = %v, want %v", got, tt.wantMissed)
+			}
+		})
+	}
+}
+
+func TestScheduler_MissedRun_ParsesLikeJobs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{}, newMockStorage(), nil, nil, logger)
+	s := NewScheduler(engine, "0 2 * * *", logger)
+
+	for _, expr := range []string{"0 2 * * *", "*/15 * * * 1-5", "@daily", "0 2 * *"} {
+		sched := config.ScheduleEntry{Cron: expr}
+		_, runErr := s.missedRun(context.Background(), sched, time.Now())
+		jobErr := s.Reschedule(context.Background(), []config.ScheduleEntry{sched}, "")
+		if (runErr == nil) != (jobErr == nil) {
+			t.Errorf("%q: missedRun() error = %v, Reschedule() error = %v, want both to agree", expr, runErr, jobErr)
+		}
+	}
+}
+
+func TestScheduler_RunBackupSkipsWhileInProgress(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// A nil engine would panic if runBackup got past the in-progress guard.
+	s := NewScheduler(nil, "0 2 * * *", logger)
+
+	j := &job{schedule: config.ScheduleEntry{Cron: "0 2 * * *"}}
+	j.running.Lock()
+	defer j.running.Unlock()
+
+	s.runBackup(context.Background(), j)
+}
+
+func TestJitterDelay(t *testing.T) {
+	for range 100 {
+		d := jitterDelay(5*time.Minute, 15*time.Minute, 24*time.Hour)
+		if d < 5*time.Minute || d > 15*time.Minute {
+			t.Fatalf("jitterDelay() = %v, want within [5m, 15m]", d)
+		}
+	}
+
+	if d := jitterDelay(0, 0, time.Hour); d != 0 {
+		t.Errorf("jitterDelay() without jitter = %v, want 0", d)
+	}
+
+	// A */10 schedule leaves 10m until the next run, so a 15m jitter must be
+	// capped to keep the run inside its own window.
+	for range 100 {
+		if d := jitterDelay(15*time.Minute, 15*time.Minute, 10*time.Minute); d != 5*time.Minute {
+			t.Fatalf("jitterDelay() = %v, want capped at 5m", d)
+		}
+	}
+}
+
+func TestScheduler_WaitJitterStopsWithScheduler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewScheduler(nil, "0 2 * * *", logger)
+	s.SetJitter(time.Hour, time.Hour)
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	done := make(chan bool)
+	go func() { done <- s.waitJitter(context.Background(), s.jobs[0]) }()
+
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("waitJitter() = true after Stop(), want false")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitJitter() did not return after Stop()")
+	}
+}
+
+func TestEngine_PreviewCleanup_DeletesNothing(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	for i, ts := range []time.Time{
+		time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), // Tuesday
+		time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC), // Wednesday
+	} {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = ts
+		meta.AddFile(id + ".dump")
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+		store.files[id+".dump"] = []byte("dump")
+	}
+
+	cfg := &config.Config{Retention: config.RetentionConfig{Daily: 1}}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	decisions, err := engine.PreviewCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PreviewCleanup() error = %v", err)
+	}
+	if len(decisions) != 2 {
+		t.Fatalf("PreviewCleanup() returned %d decisions, want 2", len(decisions))
+	}
+	if !decisions[0].Keep || decisions[0].Metadata.ID != "backup-001" {
+		t.Errorf("newest backup should be kept, got %+v", decisions[0])
+	}
+	if decisions[1].Keep {
+		t.Error("older backup should be marked for deletion")
+	}
+	if len(store.files) != 4 {
+		t.Errorf("PreviewCleanup() deleted files, %d remain, want 4", len(store.files))
+	}
+}
+
+func TestEngine_Stats(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	backups := []struct {
+		ts             time.Time
+		size           int64
+		compressedSize int64
+	}{
+		{time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), 4000, 1000}, // Tuesday
+		{time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC), 6000, 2000}, // Wednesday
+		{time.Date(2026, 3, 15, 2, 0, 0, 0, time.UTC), 8000, 3000}, // Sunday
+	}
+	for i, b := range backups {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = b.ts
+		meta.Type = "daily"
+		if b.ts.Weekday() == time.Sunday {
+			meta.Type = "weekly"
+		}
+		meta.SetBackupInfo(b.size, b.compressedSize, time.Second, "")
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+	}
+
+	cfg := &config.Config{Retention: config.RetentionConfig{Daily: 1}}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	stats, err := engine.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+
+	if stats.Count != 3 {
+		t.Errorf("Count = %d, want 3", stats.Count)
+	}
+	if stats.CountByType["daily"] != 2 || stats.CountByType["weekly"] != 1 {
+		t.Errorf("CountByType = %v, want daily=2 weekly=1", stats.CountByType)
+	}
+	if stats.SizeByType["daily"] != 3000 || stats.SizeByType["weekly"] != 3000 {
+		t.Errorf("SizeByType = %v, want daily=3000 weekly=3000", stats.SizeByType)
+	}
+	if stats.TotalSize != 6000 || stats.AverageSize != 2000 {
+		t.Errorf("TotalSize = %d, AverageSize = %d, want 6000, 2000", stats.TotalSize, stats.AverageSize)
+	}
+	if stats.MinSize != 1000 || stats.MaxSize != 3000 {
+		t.Errorf("MinSize = %d, MaxSize = %d, want 1000, 3000", stats.MinSize, stats.MaxSize)
+	}
+	if stats.CompressionRatio != 3 {
+		t.Errorf("CompressionRatio = %v, want 3", stats.CompressionRatio)
+	}
+	if stats.Oldest.ID != "backup-000" || stats.Newest.ID != "backup-002" {
+		t.Errorf("Oldest = %s, Newest = %s", stats.Oldest.ID, stats.Newest.ID)
+	}
+	if stats.ProjectedDeletions != 2 || stats.ReclaimableBytes != 3000 {
+		t.Errorf("ProjectedDeletions = %d, ReclaimableBytes = %d, want 2, 3000", stats.ProjectedDeletions, stats.ReclaimableBytes)
+	}
+
+	// A storage cap deletes the oldest backups GFS would keep.
+	engine.SetRetention(config.RetentionConfig{Daily: 7, MaxTotalBytes: 4000})
+	stats, err = engine.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.MaxTotalBytes != 4000 {
+		t.Errorf("MaxTotalBytes = %d, want 4000", stats.MaxTotalBytes)
+	}
+	if stats.ProjectedDeletions != 2 || stats.ReclaimableBytes != 3000 {
+		t.Errorf("with cap: ProjectedDeletions = %d, ReclaimableBytes = %d, want 2, 3000", stats.ProjectedDeletions, stats.ReclaimableBytes)
+	}
+}
+
+func TestEngine_Stats_Empty(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{}, newMockStorage(), nil, nil, logger)
+
+	stats, err := engine.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Count != 0 || stats.Oldest != nil || stats.CompressionRatio != 0 {
+		t.Errorf("Stats() on empty storage = %+v", stats)
+	}
+}
+
+func TestEngine_Run_RecordsFailureMetricWithLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("engine_fail")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:  config.DatabaseConfig{Type: "oracle", Name: "app"},
+		Retention: config.RetentionConfig{Daily: 7},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, m, logger)
+
+	if _, err := engine.Run(context.Background()); err == nil {
+		t.Fatal("Run() error = nil, want error for unsupported database type")
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "engine_fail_backup_failures_total" {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, lp := range mf.GetMetric()[0].GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["database"] != "app" {
+			t.Errorf("database label = %q, want app", labels["database"])
+		}
+		if labels["backup_type"] == "" {
+			t.Error("backup_type label is empty")
+		}
+		return
+	}
+	t.Fatal("backup_failures_total not recorded")
+}
+
+func TestEngine_RunWithLabels(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	result, err := engine.RunWithLabels(context.Background(), map[string]string{"reason": "pre-upgrade"})
+	if err != nil {
+		t.Fatalf("RunWithLabels() error = %v", err)
+	}
+
+	backups, err := engine.ListBackups(context.Background())
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(backups) != 1 || backups[0].ID != result.ID {
+		t.Fatalf("ListBackups() = %d backups, want the labeled one", len(backups))
+	}
+	if backups[0].Labels["reason"] != "pre-upgrade" {
+		t.Errorf("Labels = %v, want reason=pre-upgrade", backups[0].Labels)
+	}
+}
+
+func TestEngine_RunAll_BackupInProgress(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "app.db")},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	release, err := oplock.Acquire("", "backup")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer release()
+
+	results, err := engine.RunAll(context.Background(), nil)
+	if !errors.Is(err, oplock.ErrInProgress) {
+		t.Fatalf("RunAll() error = %v, want ErrInProgress", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("RunAll() = %d results, want none", len(results))
+	}
+	if _, err := engine.Run(context.Background()); !errors.Is(err, oplock.ErrInProgress) {
+		t.Errorf("Run() error = %v, want ErrInProgress", err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("storage has %d files, want none", len(store.files))
+	}
+}
+
+func TestEngine_Run_ContentChecksum(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO users (name) VALUES ('a'), ('b')"); err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var backups []*postgres.BackupMetadata
+	for _, compression := range []string{"gzip", "zstd"} {
+		store := newMockStorage()
+		cfg := &config.Config{
+			Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+			Compression: compression,
+			Retention:   config.RetentionConfig{Daily: 7},
+		}
+		engine := NewEngine(cfg, store, nil, nil, logger)
+		result, err := engine.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run() with %s error = %v", compression, err)
+		}
+		meta, err := engine.GetBackup(context.Background(), result.ID)
+		if err != nil {
+			t.Fatalf("GetBackup() error = %v", err)
+		}
+		if meta.Backup.ContentChecksum == "" || meta.Backup.ContentChecksum == meta.Backup.Checksum {
+			t.Errorf("%s: ContentChecksum = %q, want a checksum distinct from the file's %q", compression, meta.Backup.ContentChecksum, meta.Backup.Checksum)
+		}
+		// A mostly empty SQLite file compresses well.
+		if result.CompressionRatio <= 1 || result.ThroughputBytesPerSec <= 0 {
+			t.Errorf("%s: CompressionRatio = %v, ThroughputBytesPerSec = %v, want both set", compression, result.CompressionRatio, result.ThroughputBytesPerSec)
+		}
+		if meta.Backup.CompressionRatio != result.CompressionRatio {
+			t.Errorf("%s: metadata CompressionRatio = %v, want %v", compression, meta.Backup.CompressionRatio, result.CompressionRatio)
+		}
+
+		validator := NewValidatorWithDBType(store, logger, "sqlite")
+		if err := validator.VerifyRestoreIntegrity(context.Background(), meta); err != nil {
+			t.Errorf("%s: VerifyRestoreIntegrity() error = %v", compression, err)
+		}
+		tampered := *meta
+		tampered.Backup.ContentChecksum = "sha256:0000"
+		if err := validator.VerifyRestoreIntegrity(context.Background(), &tampered); err == nil || !strings.Contains(err.Error(), "content checksum mismatch") {
+			t.Errorf("%s: VerifyRestoreIntegrity() error = %v, want content checksum mismatch", compression, err)
+		}
+		backups = append(backups, meta)
+	}
+
+	if backups[0].Backup.Checksum == backups[1].Backup.Checksum {
+		t.Error("gzip and zstd backups have the same file checksum")
+	}
+	if backups[0].Backup.ContentChecksum != backups[1].Backup.ContentChecksum {
+		t.Errorf("ContentChecksum differs across compression: %s vs %s", backups[0].Backup.ContentChecksum, backups[1].Backup.ContentChecksum)
+	}
+}
+
+func TestEngine_Run_CollectStats(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO users (name) VALUES ('a'), ('b');
+		CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT); INSERT INTO orders DEFAULT VALUES;`); err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, collect := range []bool{false, true} {
+		store := newMockStorage()
+		cfg := &config.Config{
+			Database:  config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+			Retention: config.RetentionConfig{Daily: 7},
+			Backup:    config.BackupConfig{CollectStats: collect, SentinelTables: []string{"users"}},
+		}
+		engine := NewEngine(cfg, store, nil, nil, logger)
+		result, err := engine.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		meta, err := engine.GetBackup(context.Background(), result.ID)
+		if err != nil {
+			t.Fatalf("GetBackup() error = %v", err)
+		}
+
+		if want := map[string]int64{"users": 2}; !reflect.DeepEqual(meta.Backup.TableCounts, want) {
+			t.Errorf("collect_stats %v: TableCounts = %v, want %v", collect, meta.Backup.TableCounts, want)
+		}
+		// sqlite_sequence, created by AUTOINCREMENT, is SQLite's own.
+		var want map[string]int64
+		if collect {
+			want = map[string]int64{"users": 2, "orders": 1}
+		}
+		if !reflect.DeepEqual(meta.Backup.TableStats, want) {
+			t.Errorf("collect_stats %v: TableStats = %v, want %v", collect, meta.Backup.TableStats, want)
+		}
+	}
+}
+
+func TestEngine_RunSchedule(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results, err := engine.RunSchedule(context.Background(), config.ScheduleEntry{
+		Name:        "hourly",
+		Cron:        "0 * * * *",
+		Compression: "gzip",
+	})
+	if err != nil {
+		t.Fatalf("RunSchedule() error = %v", err)
+	}
+	if len(results) != 1 || !strings.HasSuffix(results[0].ID, "_hourly") {
+		t.Fatalf("RunSchedule() results = %+v, want one backup ID ending in _hourly", results)
+	}
+	if engine.LastRun().IsZero() {
+		t.Error("LastRun() is zero after a scheduled run")
+	}
+
+	meta, err := engine.GetBackup(context.Background(), results[0].ID)
+	if err != nil {
+		t.Fatalf("GetBackup() error = %v", err)
+	}
+	if meta.Schedule != "hourly" {
+		t.Errorf("Schedule = %q, want hourly", meta.Schedule)
+	}
+	if meta.Backup.Compression != "gzip" {
+		t.Errorf("Compression = %q, want the schedule's gzip", meta.Backup.Compression)
+	}
+	if _, ok := store.files[results[0].ID+".db.gz"]; !ok {
+		t.Errorf("backup %s.db.gz not stored", results[0].ID)
+	}
+}
+
+func TestEngine_RunAll_OnDemandLimit(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+	add := func(id string, onDemand bool, ts time.Time) {
+		meta := postgres.NewBackupMetadata(id, dbPath, "local", "3")
+		meta.Timestamp = ts
+		meta.OnDemand = onDemand
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+	}
+	now := time.Now().UTC()
+	add("manual-old", true, now.Add(-2*time.Hour))
+	add("manual-1", true, now.Add(-50*time.Minute))
+	add("scheduled", false, now.Add(-40*time.Minute))
+
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "none",
+		Backup:      config.BackupConfig{MaxOnDemandPerHour: 2},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results, err := engine.RunAll(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("RunAll() under the limit error = %v", err)
+	}
+	meta, err := engine.GetBackup(context.Background(), results[0].ID)
+	if err != nil {
+		t.Fatalf("GetBackup() error = %v", err)
+	}
+	if !meta.OnDemand {
+		t.Error("OnDemand = false for a backup taken with RunAll")
+	}
+
+	results, err = engine.RunAll(context.Background(), nil)
+	if !errors.Is(err, ErrRateLimited) || len(results) != 0 {
+		t.Fatalf("RunAll() over the limit = %d results, error %v, want ErrRateLimited", len(results), err)
+	}
+	retryAt := now.Add(10 * time.Minute).Local().Format("2006-01-02T15:04")
+	if !strings.Contains(err.Error(), "try again at "+retryAt) {
+		t.Errorf("RunAll() error = %v, want to try again at %s, when manual-1 is an hour old", err, retryAt)
+	}
+
+	// Scheduled backups are neither limited nor counted.
+	results, err = engine.RunSchedule(context.Background(), config.ScheduleEntry{Name: "hourly", Cron: "0 * * * *"})
+	if err != nil {
+		t.Fatalf("RunSchedule() over the on-demand limit error = %v", err)
+	}
+	if meta, err := engine.GetBackup(context.Background(), results[0].ID); err != nil || meta.OnDemand {
+		t.Errorf("scheduled backup OnDemand = %v (error %v), want false", meta != nil && meta.OnDemand, err)
+	}
+}
+
+func TestEngine_PreviewCleanup_RotatesSchedulesSeparately(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	// One nightly backup, then three newer hourly ones.
+	add := func(id, schedule string, ts time.Time) {
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = ts
+		meta.Schedule = schedule
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+	}
+	now := time.Now().UTC().Truncate(time.Hour)
+	add("nightly", "", now.Add(-4*time.Hour))
+	for i := 1; i <= 3; i++ {
+		add(fmt.Sprintf("hourly-%d", i), "hourly", now.Add(-time.Duration(i)*time.Hour))
+	}
+
+	hourlyRetention := config.RetentionConfig{Daily: 2}
+	cfg := &config.Config{
+		Retention: config.RetentionConfig{Daily: 1},
+		Schedules: []config.ScheduleEntry{
+			{Name: "hourly", Cron: "0 * * * *", Retention: &hourlyRetention},
+		},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	decisions, err := engine.PreviewCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PreviewCleanup() error = %v", err)
+	}
+
+	kept := make(map[string]bool)
+	for _, d := range decisions {
+		kept[d.Metadata.ID] = d.Keep
+	}
+	want := map[string]bool{"nightly": true, "hourly-1": true, "hourly-2": true, "hourly-3": false}
+	for id, keep := range want {
+		if kept[id] != keep {
+			t.Errorf("%s kept = %v, want %v", id, kept[id], keep)
+		}
+	}
+}
+
+func TestEngine_Run_KeyTemplate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Storage:     config.StorageConfig{KeyTemplate: "prod/{db}/{year}/{month}/{id}"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	prefix := "prod/app.db/" + result.Timestamp.Format("2006/01") + "/" + result.ID
+	for _, want := range []string{prefix + ".db.gz", prefix + ".meta.json"} {
+		if _, ok := store.files[want]; !ok {
+			t.Errorf("storage is missing %s", want)
+		}
+	}
+
+	meta, err := engine.GetBackup(context.Background(), result.ID)
+	if err != nil {
+		t.Fatalf("GetBackup() error = %v", err)
+	}
+	if len(meta.Files) == 0 || meta.Files[0] != prefix+".db.gz" {
+		t.Errorf("metadata files = %v, want keys under %s", meta.Files, prefix)
+	}
+
+	backups, err := engine.ListBackups(context.Background())
+	if err != nil || len(backups) != 1 {
+		t.Errorf("ListBackups() = %d backups, %v; want 1", len(backups), err)
+	}
+}
+
+func TestEngine_MetadataIndex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	// A backup written before the index existed.
+	store := newMockStorage()
+	legacy := postgres.NewBackupMetadata("backup_20200101_020000", "app.db", "local", "3.45.0")
+	legacy.Timestamp = time.Date(2020, 1, 1, 2, 0, 0, 0, time.UTC)
+	legacy.AddFile("backup_20200101_020000.db.gz")
+	data, _ := legacy.ToJSON()
+	store.files["backup_20200101_020000.meta.json"] = data
+	store.files["backup_20200101_020000.db.gz"] = []byte("dump")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 1},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+	ctx := context.Background()
+
+	backups, err := engine.ListBackups(ctx)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("ListBackups() without an index = %d backups, %v; want the legacy backup", len(backups), err)
+	}
+
+	result, err := engine.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, id := range []string{legacy.ID, result.ID} {
+		if _, ok := store.files[postgres.MetadataIndexPath(id)]; !ok {
+			t.Errorf("index is missing %s", id)
+		}
+	}
+
+	backups, err = engine.ListBackups(ctx)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("ListBackups() = %d backups, %v; want 2", len(backups), err)
+	}
+
+	if _, err := engine.Cleanup(ctx); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	for _, path := range []string{"backup_20200101_020000.meta.json", postgres.MetadataIndexPath(legacy.ID), "backup_20200101_020000.db.gz"} {
+		if _, ok := store.files[path]; ok {
+			t.Errorf("Cleanup() left %s behind", path)
+		}
+	}
+	if backups, _ := engine.ListBackups(ctx); len(backups) != 1 || backups[0].ID != result.ID {
+		t.Errorf("ListBackups() after cleanup = %d backups, want only %s", len(backups), result.ID)
+	}
+}
+
+// failingStorage rejects every write.
+type failingStorage struct {
+	*mockStorage
+}
+
+func (f *failingStorage) Write(ctx context.Context, path string, reader io.Reader) error {
+	return errors.New("access denied")
+}
+
+func TestEngine_Preflight(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Schedule: config.ScheduleConfig{Backup: "0 2 * * *"},
+		Storage:  config.StorageConfig{Backend: "local"},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results := engine.Preflight(context.Background())
+
+	names := make([]string, len(results))
+	for i, r := range results {
+		names[i] = r.Name
+		if !r.Passed() {
+			t.Errorf("check %s failed: %v", r.Name, r.Err)
+		}
+	}
+	if got := strings.Join(names, ","); got != "schedule,database,storage" {
+		t.Errorf("checks = %s, want schedule,database,storage", got)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("Preflight() left %d files in storage, want 0", len(store.files))
+	}
+}
+
+func TestEngine_Preflight_Failures(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Type:         "sqlite",
+			Path:         filepath.Join(t.TempDir(), "missing.db"),
+			SQLiteMethod: "backup",
+		},
+		Schedule: config.ScheduleConfig{Backup: "every night", VerifyRestore: "0 4 * * 0"},
+	}
+	engine := NewEngine(cfg, &failingStorage{newMockStorage()}, nil, nil, logger)
+
+	failed := make(map[string]bool)
+	for _, r := range engine.Preflight(context.Background()) {
+		failed[r.Name] = !r.Passed()
+	}
+
+	want := map[string]bool{
+		"schedule":                true,
+		"verify_restore schedule": false,
+		"database":                true,
+		"storage":                 true,
+	}
+	for name, wantFailed := range want {
+		got, ok := failed[name]
+		if !ok {
+			t.Errorf("check %s missing", name)
+			continue
+		}
+		if got != wantFailed {
+			t.Errorf("check %s failed = %v, want %v", name, got, wantFailed)
+		}
+	}
+}
+
+func TestEngine_RequiredBinaries(t *testing.T) {
+	tests := []struct {
+		name   string
+		db     config.DatabaseConfig
+		format string
+		want   string
+	}{
+		{"postgres", config.DatabaseConfig{Type: "postgres"}, "", "pg_dump,pg_restore"},
+		{"postgres plain", config.DatabaseConfig{Type: "postgres"}, "plain", "pg_dump,psql"},
+		{"sqlite dump", config.DatabaseConfig{Type: "sqlite"}, "", "sqlite3"},
+		{"sqlite backup API", config.DatabaseConfig{Type: "sqlite", SQLiteMethod: "backup"}, "", ""},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Database: tt.db, Backup: config.BackupConfig{Format: tt.format}}
+			engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+			if got := strings.Join(engine.requiredBinaries(), ","); got != tt.want {
+				t.Errorf("requiredBinaries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_CheckBinaries(t *testing.T) {
+	dir := t.TempDir()
+	pgDump := filepath.Join(dir, "pg_dump-16")
+	if err := os.WriteFile(pgDump, []byte("#!/bin/sh\necho 'pg_dump (PostgreSQL) 16.2'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Database: config.DatabaseConfig{
+		Type:          "postgres",
+		PGDumpPath:    pgDump,
+		PGRestorePath: filepath.Join(dir, "pg_restore-16"),
+	}}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	results := engine.checkBinaries(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("checkBinaries() returned %d results, want 2", len(results))
+	}
+	if r := results[0]; r.Name != "pg_dump binary" || !r.Passed() || r.Detail != pgDump+" (pg_dump (PostgreSQL) 16.2)" {
+		t.Errorf("pg_dump result = %+v, want the configured binary and its version", r)
+	}
+	if r := results[1]; r.Name != "pg_restore binary" || r.Passed() || !strings.Contains(r.Err.Error(), "not found or not executable") {
+		t.Errorf("pg_restore result = %+v, want a missing configured binary", r)
+	}
+}
+
+func TestEngine_Run_PreBackupHookFailureAborts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		// The unsupported type would fail the backup later; the hook must
+		// abort it first.
+		Database: config.DatabaseConfig{Type: "oracle", Name: "app"},
+		Hooks:    config.HooksConfig{PreBackup: []string{"echo flush failed >&2; exit 1"}},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	_, err := engine.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "flush failed") {
+		t.Fatalf("Run() error = %v, want pre_backup hook failure", err)
+	}
+	if engine.LastError() == nil {
+		t.Error("LastError() = nil, want the hook failure")
+	}
+}
+
+func TestEngine_Run_LogsCorrelationFields(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Backup:      config.BackupConfig{VerifyAfterBackup: true},
+		Hooks:       config.HooksConfig{PreBackup: []string{"true"}, PostBackup: []string{"true"}},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var messages []string
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("invalid log line %s: %v", line, err)
+		}
+		messages = append(messages, entry["msg"].(string))
+		if entry["backup_id"] != result.ID || entry["db"] != dbPath {
+			t.Errorf("log line %q has backup_id=%v db=%v, want %s and %s", entry["msg"], entry["backup_id"], entry["db"], result.ID, dbPath)
+		}
+	}
+	for _, want := range []string{"starting backup", "running hook", "backup verified successfully", "backup completed"} {
+		if !slices.Contains(messages, want) {
+			t.Errorf("no %q line among %v", want, messages)
+		}
+	}
+}
+
+func TestEngine_Run_CompressMetadata(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Backup:   config.BackupConfig{CompressMetadata: true},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	// A backup taken before compress_metadata was set stays readable.
+	old := postgres.NewBackupMetadata("backup_20240101_020000", dbPath, "sqlite", "3")
+	old.AddFile("backup_20240101_020000.db")
+	oldJSON, _ := old.ToJSON()
+	store.files["backup_20240101_020000.db"] = []byte("data")
+	store.files["backup_20240101_020000.meta.json"] = oldJSON
+	store.files[postgres.MetadataIndexPath(old.ID)] = oldJSON
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for _, p := range []string{result.ID + ".meta.json.gz", "meta/" + result.ID + ".meta.json.gz"} {
+		data, ok := store.files[p]
+		if !ok {
+			t.Fatalf("no metadata at %s", p)
+		}
+		if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			t.Errorf("%s is not gzipped", p)
+		}
+	}
+	if _, ok := store.files[result.ID+".meta.json"]; ok {
+		t.Errorf("uncompressed metadata written alongside the compressed copy")
+	}
+
+	backups, err := engine.ListBackups(context.Background())
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("ListBackups() = %d backups, want the old and the new one", len(backups))
+	}
+	for _, id := range []string{old.ID, result.ID} {
+		meta, err := engine.GetBackup(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetBackup(%s) error = %v", id, err)
+		}
+		if paths := dataPaths(meta); len(paths) != 1 {
+			t.Errorf("dataPaths(%s) = %v, want only the backup file", id, paths)
+		}
+	}
+
+	meta, _ := engine.GetBackup(context.Background(), result.ID)
+	errs := make([]error, 1)
+	engine.deleteFiles(context.Background(), []*postgres.BackupMetadata{meta}, errs, metadataPaths)
+	if errs[0] != nil {
+		t.Fatalf("deleteFiles() error = %v", errs[0])
+	}
+	for p := range store.files {
+		if strings.Contains(p, result.ID) && postgres.IsMetadataPath(p) {
+			t.Errorf("metadata %s left after deleting it", p)
+		}
+	}
+}
+
+func TestEngine_Run_Timeout(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "app.db")},
+		Backup:   config.BackupConfig{Timeout: "200ms"},
+		Hooks: config.HooksConfig{
+			// Stands in for a pg_dump stuck on an unreachable host.
+			PreBackup: []string{"sleep 30"},
+		},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	start := time.Now()
+	_, err := engine.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "backup timed out after 200ms") {
+		t.Fatalf("Run() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Run() took %v, want it stopped at the timeout", elapsed)
+	}
+	if engine.LastError() == nil {
+		t.Error("LastError() = nil, want the timeout")
+	}
+}
+
+func TestEngine_Run_PostBackupHookSeesResult(t *testing.T) {
+	hookOut := filepath.Join(t.TempDir(), "hook.env")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "oracle", Name: "app"},
+		Hooks: config.HooksConfig{
+			PreBackup:  []string{"true"},
+			PostBackup: []string{`echo "$DATASAVER_BACKUP_STATUS $DATASAVER_DATABASE" > ` + hookOut},
+		},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	if _, err := engine.Run(context.Background()); err == nil {
+		t.Fatal("Run() error = nil, want error for unsupported database type")
+	}
+
+	data, err := os.ReadFile(hookOut)
+	if err != nil {
+		t.Fatalf("post_backup hook did not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "failed app" {
+		t.Errorf("post_backup hook saw %q, want %q", got, "failed app")
+	}
+}
+
+func TestEngine_RunAll_MultipleDatabases(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"orders.db", "billing.db"} {
+		dbPath := filepath.Join(dir, name)
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatalf("sql.Open() error = %v", err)
+		}
+		if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+		db.Close()
+		paths = append(paths, dbPath)
+	}
+	paths = append(paths, filepath.Join(dir, "missing", "gone.db"))
+
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error = %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", SQLiteMethod: "backup"},
+		Databases: []config.DatabaseConfig{
+			{Path: paths[0]},
+			{Path: paths[1]},
+			{Path: paths[2]},
+		},
+		Backup:      config.BackupConfig{Concurrency: 2, MaxAttempts: 1},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 1},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results, err := engine.RunAll(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "gone.db") {
+		t.Fatalf("RunAll() error = %v, want failure naming gone.db", err)
+	}
+	var partial *PartialError
+	if !errors.As(err, &partial) || partial.Failed != 1 || partial.Total != 3 {
+		t.Errorf("RunAll() error = %#v, want a PartialError with 1 of 3 failed", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("RunAll() returned %d results, want 3", len(results))
+	}
+	for i, suffix := range []string{"_orders-db", "_billing-db"} {
+		if results[i].Error != nil {
+			t.Errorf("results[%d].Error = %v", i, results[i].Error)
+		}
+		if !strings.HasSuffix(results[i].ID, suffix) {
+			t.Errorf("results[%d].ID = %q, want suffix %q", i, results[i].ID, suffix)
+		}
+	}
+	if results[2].Error == nil {
+		t.Error("results[2].Error = nil, want the missing database to fail")
+	}
+
+	// Each database keeps its own daily backup under retention.
+	decisions, err := engine.PreviewCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PreviewCleanup() error = %v", err)
+	}
+	for _, d := range decisions {
+		if !d.Keep {
+			t.Errorf("backup %s marked for deletion: %s", d.Metadata.ID, d.Reason)
+		}
+	}
+}
+
+func TestEngine_RunAll_CachesEveryDatabase(t *testing.T) {
+	dir := t.TempDir()
+	var dbs []config.DatabaseConfig
+	for _, name := range []string{"orders.db", "billing.db"} {
+		dbPath := filepath.Join(dir, name)
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatalf("sql.Open() error = %v", err)
+		}
+		if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+		db.Close()
+		dbs = append(dbs, config.DatabaseConfig{Path: dbPath})
+	}
+
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error = %v", err)
+	}
+
+	cacheDir := filepath.Join(dir, "cache")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", SQLiteMethod: "backup"},
+		Databases:   dbs,
+		Backup:      config.BackupConfig{MaxAttempts: 1},
+		Storage:     config.StorageConfig{Cache: config.CacheConfig{Path: cacheDir}},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 1},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results, err := engine.RunAll(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("RunAll() error = %v", err)
+	}
+
+	var cached []string
+	err = filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			cached = append(cached, filepath.Base(path))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walking the cache: %v", err)
+	}
+	for _, r := range results {
+		found := false
+		for _, name := range cached {
+			if strings.Contains(name, r.ID) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("backup %s not in the cache, which holds %v", r.ID, cached)
+		}
+	}
+}
+
+func TestEngine_RunAll_AllDatabasesFail(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "sqlite", SQLiteMethod: "backup"},
+		Databases: []config.DatabaseConfig{
+			{Path: filepath.Join(dir, "missing", "a.db")},
+			{Path: filepath.Join(dir, "missing", "b.db")},
+		},
+		Backup:      config.BackupConfig{MaxAttempts: 1},
+		Compression: "none",
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	_, err := engine.RunAll(context.Background(), nil)
+	if err == nil {
+		t.Fatal("RunAll() error = nil, want failure")
+	}
+	if IsPartial(err) {
+		t.Errorf("IsPartial(%v) = true, want false when every database failed", err)
+	}
+	if !engine.LastRun().IsZero() {
+		t.Errorf("LastRun() = %v, want zero after a run with no successes", engine.LastRun())
+	}
+}
+
+func TestEngine_ArchiveWAL(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "000000010000000000000001")
+	if err := os.WriteFile(segment, bytes.Repeat([]byte("w"), 1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMockStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Compression: "gzip"}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	if err := engine.ArchiveWAL(context.Background(), segment); err != nil {
+		t.Fatalf("ArchiveWAL() error = %v", err)
+	}
+	if _, ok := store.files["wal/000000010000000000000001.gz"]; !ok {
+		t.Fatal("ArchiveWAL() did not store the compressed segment")
+	}
+
+	meta, err := postgres.ParseMetadata(store.files[postgres.WALMetadataPath("000000010000000000000001")])
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if meta.Backup.Kind != postgres.KindWAL || meta.Backup.Timeline != 1 {
+		t.Errorf("metadata kind/timeline = %s/%d, want wal/1", meta.Backup.Kind, meta.Backup.Timeline)
+	}
+	if meta.Backup.StartLSN != "0/400" || meta.Backup.EndLSN != "0/800" {
+		t.Errorf("LSN range = %s-%s, want 0/400-0/800", meta.Backup.StartLSN, meta.Backup.EndLSN)
+	}
+
+	// archive_command may be retried for a file that was already archived.
+	if err := engine.ArchiveWAL(context.Background(), segment); err != nil {
+		t.Errorf("ArchiveWAL() of an archived file error = %v", err)
+	}
+
+	if err := os.WriteFile(segment, []byte("different"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ArchiveWAL(context.Background(), segment); err == nil {
+		t.Error("ArchiveWAL() should refuse a different file under an archived name")
+	}
+
+	if err := engine.ArchiveWAL(context.Background(), filepath.Join(dir, "postgresql.conf")); err == nil {
+		t.Error("ArchiveWAL() should reject files that are not WAL")
+	}
+}
+
+func TestEngine_ArchiveWAL_SameSizeDifferentContents(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "000000010000000000000002")
+	if err := os.WriteFile(segment, bytes.Repeat([]byte("a"), 1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMockStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{Compression: "gzip"}, store, nil, nil, logger)
+
+	if err := engine.ArchiveWAL(context.Background(), segment); err != nil {
+		t.Fatalf("ArchiveWAL() error = %v", err)
+	}
+	metaPath := postgres.WALMetadataPath("000000010000000000000002")
+	meta, err := postgres.ParseMetadata(store.files[metaPath])
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if meta.Backup.ContentChecksum == "" {
+		t.Error("ArchiveWAL() recorded no content checksum")
+	}
+
+	// A segment of another cluster reusing the archive has the same name
+	// and size but different contents.
+	if err := os.WriteFile(segment, bytes.Repeat([]byte("b"), 1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ArchiveWAL(context.Background(), segment); err == nil {
+		t.Error("ArchiveWAL() should refuse a same-size file with different contents")
+	}
+
+	// Archives from before content checksums were recorded are compared
+	// with the archived file itself.
+	meta.Backup.ContentChecksum = ""
+	legacy, _ := meta.ToJSON()
+	store.files[metaPath] = legacy
+	if err := engine.ArchiveWAL(context.Background(), segment); err == nil {
+		t.Error("ArchiveWAL() should refuse a different file under a legacy archived name")
+	}
+	if err := os.WriteFile(segment, bytes.Repeat([]byte("a"), 1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ArchiveWAL(context.Background(), segment); err != nil {
+		t.Errorf("ArchiveWAL() of the archived file under a legacy name error = %v", err)
+	}
+}
+
+func TestEngine_PruneWAL(t *testing.T) {
+	dir := t.TempDir()
+	store := newMockStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{Compression: "none"}, store, nil, nil, logger)
+
+	// 1 KiB segments: ...01 covers 0/400-0/800, ...02 covers 0/800-0/C00.
+	for _, name := range []string{"000000010000000000000001", "000000010000000000000002", "00000002.history"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("w"), 1024), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := engine.ArchiveWAL(context.Background(), path); err != nil {
+			t.Fatalf("ArchiveWAL(%s) error = %v", name, err)
+		}
+	}
+
+	if n, _ := engine.pruneWAL(context.Background(), nil); n != 0 {
+		t.Errorf("pruneWAL() without a base backup deleted %d files, want 0", n)
+	}
+
+	base := postgres.NewBackupMetadata("backup_1", "app", "db", "16")
+	base.Backup.Kind = postgres.KindBase
+	base.Backup.StartLSN = "0/900"
+
+	deleted, err := engine.pruneWAL(context.Background(), []*postgres.BackupMetadata{base})
+	if err != nil {
+		t.Fatalf("pruneWAL() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("pruneWAL() deleted %d files, want 1", deleted)
+	}
+	if _, ok := store.files["wal/000000010000000000000001"]; ok {
+		t.Error("segment before the base backup was kept")
+	}
+	for _, kept := range []string{"wal/000000010000000000000002", "wal/00000002.history"} {
+		if _, ok := store.files[kept]; !ok {
+			t.Errorf("%s was deleted", kept)
+		}
+	}
+}
+
+func TestEngine_BaseBackupBefore(t *testing.T) {
+	store := newMockStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 2, 0, 0, 0, time.UTC) }
+	for i, kind := range []string{postgres.KindBase, postgres.KindBase, "", postgres.KindBase} {
+		meta := postgres.NewBackupMetadata(fmt.Sprintf("backup_%d", i+1), "app", "db", "16")
+		meta.Timestamp = day(i + 1)
+		meta.Backup.Kind = kind
+		data, _ := meta.ToJSON()
+		store.files[postgres.MetadataIndexPath(meta.ID)] = data
+	}
+
+	base, err := engine.BaseBackupBefore(context.Background(), day(3).Add(time.Hour))
+	if err != nil {
+		t.Fatalf("BaseBackupBefore() error = %v", err)
+	}
+	if base.ID != "backup_2" {
+		t.Errorf("BaseBackupBefore() = %s, want backup_2, the newest base backup before", base.ID)
+	}
+
+	if _, err := engine.BaseBackupBefore(context.Background(), day(1).Add(-time.Hour)); err == nil {
+		t.Error("BaseBackupBefore() should fail before the first base backup")
+	}
+}
+
+func TestWALShipper_Ship(t *testing.T) {
+	dir := t.TempDir()
+	store := newMockStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{Compression: "none"}, store, nil, nil, logger)
+	shipper := NewWALShipper(engine, dir, 0, logger)
+
+	write := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("w"), 1024), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Before any base backup, WAL is shipped to the top of the archive.
+	write("000000010000000000000001")
+	if n, err := shipper.Ship(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Ship() = %d, %v, want 1, nil", n, err)
+	}
+	if _, ok := store.files["wal/000000010000000000000001"]; !ok {
+		t.Error("segment shipped before a base backup is not under wal/")
+	}
+
+	base := postgres.NewBackupMetadata("backup_1", "app", "db", "16")
+	base.Backup.Kind = postgres.KindBase
+	baseJSON, _ := base.ToJSON()
+	store.files[postgres.MetadataIndexPath(base.ID)] = baseJSON
+
+	write("000000010000000000000003")
+	write("000000010000000000000002")
+	write("000000010000000000000004.partial")
+	write("postgresql.conf")
+	if n, err := shipper.Ship(context.Background()); err != nil || n != 2 {
+		t.Fatalf("Ship() = %d, %v, want 2, nil", n, err)
+	}
+
+	index, err := postgres.ParseWALIndex(store.files["wal/backup_1/index.json"])
+	if err != nil {
+		t.Fatalf("ParseWALIndex() error = %v", err)
+	}
+	if index.BaseBackup != "backup_1" || len(index.Files) != 2 {
+		t.Fatalf("index = %s with %d files, want backup_1 with 2", index.BaseBackup, len(index.Files))
+	}
+	if index.Files[0].Name != "000000010000000000000002" || index.Files[0].Path != "wal/backup_1/000000010000000000000002" {
+		t.Errorf("index.Files[0] = %+v, want the oldest segment under wal/backup_1/", index.Files[0])
+	}
+	if index.Files[1].EndLSN != "0/1000" {
+		t.Errorf("index.Files[1].EndLSN = %s, want 0/1000", index.Files[1].EndLSN)
+	}
+
+	// Fetching needs only the metadata, wherever the file was stored.
+	meta, err := postgres.ParseMetadata(store.files[postgres.WALMetadataPath("000000010000000000000002")])
+	if err != nil || meta.Files[0] != "wal/backup_1/000000010000000000000002" {
+		t.Errorf("metadata files = %v, %v", meta, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if want := []string{"000000010000000000000004.partial", "postgresql.conf"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("files left in the directory = %v, want %v", left, want)
+	}
+
+	// The index goes when its base backup is no longer kept.
+	other := postgres.NewBackupMetadata("backup_2", "app", "db", "16")
+	other.Backup.Kind = postgres.KindBase
+	other.Backup.StartLSN = "0/0"
+	if _, err := engine.pruneWAL(context.Background(), []*postgres.BackupMetadata{other}); err != nil {
+		t.Fatalf("pruneWAL() error = %v", err)
+	}
+	if _, ok := store.files["wal/backup_1/index.json"]; ok {
+		t.Error("pruneWAL() kept the index of a deleted base backup")
+	}
+}
+
+// metaWriteFailStorage fails every metadata write.
+type metaWriteFailStorage struct {
+	*mockStorage
+}
+
+func (m metaWriteFailStorage) Write(ctx context.Context, path string, reader io.Reader) error {
+	if strings.HasSuffix(path, ".meta.json") {
+		return errors.New("disk full")
+	}
+	return m.mockStorage.Write(ctx, path, reader)
+}
+
+func TestEngine_Run_MetadataWriteFailureIsFatal(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, metaWriteFailStorage{store}, nil, nil, logger)
+
+	_, err = engine.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to write metadata") {
+		t.Fatalf("Run() error = %v, want metadata write failure", err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("storage after failed run = %v, want the uploaded file removed", store.files)
+	}
+	if engine.LastError() == nil {
+		t.Error("LastError() = nil after failed run")
+	}
+}
+
+func TestEngine_History(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	t.Setenv("TMPDIR", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 1, KeepNewest: 1},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	history, err := engine.History(context.Background())
+	if err != nil || len(history) != 0 {
+		t.Fatalf("History() before any backup = %v, %v, want none", history, err)
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(1100 * time.Millisecond) // Backup IDs have second resolution.
+		}
+		result, err := engine.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		ids = append(ids, result.ID)
+	}
+
+	// Retention deletes the older backup, but not its history.
+	if _, err := engine.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if _, ok := store.files[postgres.HistoryPath]; !ok {
+		t.Fatal("Cleanup() deleted the history")
+	}
+
+	history, err = engine.History(context.Background())
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 2 || history[0].ID != ids[0] || history[1].ID != ids[1] {
+		t.Fatalf("History() = %d entries, want %v oldest first", len(history), ids)
+	}
+	if history[0].Backup.Checksum == "" {
+		t.Error("history entry is missing the backup's checksum")
+	}
+}
+
+func TestEngine_GC(t *testing.T) {
+	store := newMockStorage()
+
+	live := postgres.NewBackupMetadata("backup_20240101_020000", "app", "db", "16")
+	live.AddFile("backup_20240101_020000.dump.gz")
+	liveJSON, _ := live.ToJSON()
+	store.files["backup_20240101_020000.dump.gz"] = []byte("live")
+	store.files["backup_20240101_020000.meta.json"] = liveJSON
+	store.files[postgres.MetadataIndexPath(live.ID)] = liveJSON
+
+	gone := postgres.NewBackupMetadata("backup_20240102_020000", "app", "db", "16")
+	gone.AddFile("backup_20240102_020000.dump.gz")
+	goneJSON, _ := gone.ToJSON()
+	store.files["backup_20240102_020000.meta.json"] = goneJSON
+	store.files[postgres.MetadataIndexPath(gone.ID)] = goneJSON
+
+	store.files["backup_20240103_020000.dump.gz"] = []byte("orphan")
+	store.files["wal/000000010000000000000001.gz"] = []byte("wal")
+	store.files["notes.txt"] = []byte("not ours")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(&config.Config{Retention: config.RetentionConfig{Daily: 7}}, store, nil, nil, logger)
+
+	// Everything in the mock was just written, so the default grace period
+	// protects it.
+	result, err := engine.GC(context.Background(), time.Hour, true)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(result.OrphanedFiles) != 0 {
+		t.Errorf("GC() with min age = %v orphans, want none", result.OrphanedFiles)
+	}
+
+	result, err = engine.GC(context.Background(), 0, true)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	var orphans []string
+	for _, f := range result.OrphanedFiles {
+		orphans = append(orphans, f.Path)
+	}
+	wantOrphans := []string{"backup_20240103_020000.dump.gz", "wal/000000010000000000000001.gz"}
+	if !reflect.DeepEqual(orphans, wantOrphans) {
+		t.Errorf("OrphanedFiles = %v, want %v", orphans, wantOrphans)
+	}
+	wantDangling := []string{"backup_20240102_020000.meta.json", postgres.MetadataIndexPath(gone.ID)}
+	if !reflect.DeepEqual(result.DanglingMetadata, wantDangling) {
+		t.Errorf("DanglingMetadata = %v, want %v", result.DanglingMetadata, wantDangling)
+	}
+	if len(store.files) != 8 {
+		t.Errorf("dry run deleted files: %d left, want 8", len(store.files))
+	}
+
+	result, err = engine.GC(context.Background(), 0, false)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if result.Deleted != 4 || result.ReclaimedBytes != int64(len("orphan")+len("wal")) {
+		t.Errorf("Deleted = %d, ReclaimedBytes = %d", result.Deleted, result.ReclaimedBytes)
+	}
+	for _, kept := range []string{"backup_20240101_020000.dump.gz", "backup_20240101_020000.meta.json", postgres.MetadataIndexPath(live.ID), "notes.txt"} {
+		if _, ok := store.files[kept]; !ok {
+			t.Errorf("GC deleted %s", kept)
+		}
+	}
+}
+
+// onWarnHandler calls fn for every warning logged.
+type onWarnHandler struct {
+	slog.Handler
+	fn func()
+}
+
+func (h onWarnHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelWarn {
+		h.fn()
+	}
+	return nil
+}
+
+func (h onWarnHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func TestEngine_Run_RetriesTransientDumpFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "not-yet")
+	dbPath := filepath.Join(dir, "app.db")
+
+	// The database appears once the first attempt has failed and the retry
+	// is announced.
+	retries := 0
+	logger := slog.New(onWarnHandler{
+		Handler: slog.NewTextHandler(io.Discard, nil),
+		fn: func() {
+			retries++
+			os.MkdirAll(dir, 0755)
+			db, err := sql.Open("sqlite", dbPath)
+			if err != nil {
+				t.Errorf("sql.Open() error = %v", err)
+				return
+			}
+			defer db.Close()
+			db.Exec("CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY)")
+		},
+	})
+
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "none",
+		Retention:   config.RetentionConfig{Daily: 7},
+		Backup:      config.BackupConfig{MaxAttempts: 3, RetryWait: "10ms"},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("retries = %d, want 1", retries)
+	}
+	if _, ok := store.files[result.ID+".db"]; !ok {
+		t.Errorf("backup %s.db not stored", result.ID)
+	}
+}
+
+func TestEngine_VerifyAll(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	good, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	scheduled, err := engine.RunSchedule(context.Background(), config.ScheduleEntry{Name: "hourly", Cron: "0 * * * *"})
+	if err != nil {
+		t.Fatalf("RunSchedule() error = %v", err)
+	}
+
+	results, err := engine.VerifyAll(context.Background(), true)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("VerifyAll() returned %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if !r.Valid || !r.RestoreOK {
+			t.Errorf("backup %s: Valid = %v, RestoreOK = %v, errors = %v", r.BackupID, r.Valid, r.RestoreOK, r.Errors)
+		}
+	}
+
+	// Flip a byte of the scheduled backup's file, keeping its size.
+	bad := scheduled[0].ID
+	for path, data := range store.files {
+		if strings.HasPrefix(path, bad) && !strings.HasSuffix(path, ".json") {
+			data[len(data)/2] ^= 0xff
+		}
+	}
+
+	results, err = engine.VerifyAll(context.Background(), false)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	for _, r := range results {
+		switch r.BackupID {
+		case good.ID:
+			if !r.Valid || r.RestoreOK {
+				t.Errorf("backup %s: Valid = %v, RestoreOK = %v, want valid without a restore check", r.BackupID, r.Valid, r.RestoreOK)
+			}
+		case bad:
+			if r.Valid || r.ChecksumOK {
+				t.Errorf("corrupted backup %s passed verification", r.BackupID)
+			}
+		}
+	}
+}
+
+// slowDeleteStorage tracks how many deletes run at once and reports the given
+// path as still under object lock.
+type slowDeleteStorage struct {
+	*mockStorage
+	failPath string
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (s *slowDeleteStorage) Delete(ctx context.Context, path string) error {
+	s.mu.Lock()
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+
+	if path == s.failPath {
+		return &storage.StorageError{Op: "delete", Path: path, Err: storage.ErrObjectLocked}
+	}
+	return s.mockStorage.Delete(ctx, path)
+}
+
+func TestEngine_Cleanup_Concurrent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &slowDeleteStorage{mockStorage: newMockStorage(), failPath: "backup-003.dump"}
+
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = start.AddDate(0, 0, i)
+		meta.AddFile(id + ".dump")
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+		store.files[id+".dump"] = []byte("dump")
+	}
+
+	cfg := &config.Config{
+		Storage:   config.StorageConfig{Concurrency: 3},
+		Retention: config.RetentionConfig{Daily: 2},
+	}
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	deleted, err := engine.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if deleted != 9 {
+		t.Errorf("Cleanup() deleted = %d, want 9 (10 expired, one locked)", deleted)
+	}
+	if store.peak > 3 {
+		t.Errorf("Cleanup() ran %d deletes at once, want at most 3", store.peak)
+	}
+	if store.peak < 2 {
+		t.Errorf("Cleanup() ran deletes one at a time with concurrency 3")
+	}
+	if _, ok := store.files["backup-003.dump"]; !ok {
+		t.Error("locked file is missing")
+	}
+
+	// The locked backup keeps its metadata, so the next cleanup retries it.
+	backups, err := engine.ListBackups(context.Background())
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(backups) != 3 {
+		t.Errorf("ListBackups() after cleanup = %d backups, want the 2 kept and the locked one", len(backups))
+	}
+}
+
+func TestEngine_Migrate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	src := newMockStorage()
+	engine := NewEngine(cfg, src, nil, nil, logger)
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	dst := newMockStorage()
+	migrated, err := engine.Migrate(context.Background(), dst, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Migrate() dry run error = %v", err)
+	}
+	if len(migrated.Copied) != len(src.files) || len(dst.files) != 0 {
+		t.Errorf("dry run listed %d of %d objects and wrote %d, want all listed and none written", len(migrated.Copied), len(src.files), len(dst.files))
+	}
+
+	if _, err := engine.Migrate(context.Background(), dst, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	for p, data := range src.files {
+		if !bytes.Equal(dst.files[p], data) {
+			t.Errorf("%s was not copied intact", p)
+		}
+	}
+	if _, err := NewEngine(cfg, dst, nil, nil, logger).GetBackup(context.Background(), result.ID); err != nil {
+		t.Errorf("GetBackup() at the destination error = %v", err)
+	}
+
+	migrated, err = engine.Migrate(context.Background(), dst, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("Migrate() rerun error = %v", err)
+	}
+	if len(migrated.Copied) != 0 || migrated.Skipped != len(src.files) {
+		t.Errorf("rerun copied %d and skipped %d, want every object skipped", len(migrated.Copied), migrated.Skipped)
+	}
+
+	// Metadata is held back when its backup file cannot be copied.
+	failing := &failingStorage{mockStorage: newMockStorage()}
+	migrated, err = engine.Migrate(context.Background(), failing, MigrateOptions{MaxAttempts: 1})
+	if err == nil {
+		t.Fatal("Migrate() to a failing destination should return an error")
+	}
+	for p := range src.files {
+		if _, ok := migrated.Failed[p]; !ok {
+			t.Errorf("%s missing from Failed", p)
+		}
+	}
+	if err := migrated.Failed[result.ID+".meta.json"]; err == nil || !strings.Contains(err.Error(), "not copied because") {
+		t.Errorf("metadata failure = %v, want it held back for its backup file", err)
+	}
+}
+
+// flakyStorage is a mockStorage that records a checksum of each object on
+// write, like S3Storage, and fails the first writes of each path with a
+// transient error.
+type flakyStorage struct {
+	*mockStorage
+	failures int
+	writes   map[string]int
+	sums     map[string]string
+}
+
+func newFlakyStorage(failures int) *flakyStorage {
+	return &flakyStorage{
+		mockStorage: newMockStorage(),
+		failures:    failures,
+		writes:      make(map[string]int),
+		sums:        make(map[string]string),
+	}
+}
+
+func (f *flakyStorage) Write(ctx context.Context, path string, reader io.Reader) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.writes[path]++
+	failed := f.writes[path] <= f.failures
+	f.mu.Unlock()
+	if failed {
+		return errors.New("connection reset by peer")
+	}
+	sum := sha256.Sum256(data)
+	f.mu.Lock()
+	f.sums[path] = "sha256:" + hex.EncodeToString(sum[:])
+	f.mu.Unlock()
+	return f.mockStorage.Write(ctx, path, bytes.NewReader(data))
+}
+
+func (f *flakyStorage) Checksum(ctx context.Context, path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sums[path], nil
+}
+
+func TestEngine_Migrate_RetryAndResume(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Database: config.DatabaseConfig{Type: "sqlite"}}
+
+	src := newFlakyStorage(0)
+	for _, p := range []string{"backups/daily/a.db.gz", "backups/daily/b.db.gz"} {
+		if err := src.Write(context.Background(), p, strings.NewReader("contents of "+p)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	engine := NewEngine(cfg, src, nil, nil, logger)
+
+	// Transient write errors are retried until the copy succeeds.
+	dst := newFlakyStorage(2)
+	migrated, err := engine.Migrate(context.Background(), dst, MigrateOptions{MaxAttempts: 3, RetryWait: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(migrated.Copied) != 2 || migrated.Retried != 2 {
+		t.Errorf("Migrate() copied %d, %d after a retry, want 2 and 2", len(migrated.Copied), migrated.Retried)
+	}
+
+	// A copy of the same size whose checksum differs is copied again, and
+	// an intact one is skipped.
+	dst.files["backups/daily/a.db.gz"] = bytes.Repeat([]byte("x"), len(src.files["backups/daily/a.db.gz"]))
+	dst.sums["backups/daily/a.db.gz"] = "sha256:stale"
+	migrated, err = engine.Migrate(context.Background(), dst, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("Migrate() rerun error = %v", err)
+	}
+	if len(migrated.Copied) != 1 || migrated.Copied[0].Path != "backups/daily/a.db.gz" || migrated.Skipped != 1 {
+		t.Errorf("rerun copied %v and skipped %d, want only the stale copy replaced", migrated.Copied, migrated.Skipped)
+	}
+	if !bytes.Equal(dst.files["backups/daily/a.db.gz"], src.files["backups/daily/a.db.gz"]) {
+		t.Error("stale copy was not replaced")
+	}
+
+	// Objects that fail every attempt are reported, and the rest copied.
+	migrated, err = engine.Migrate(context.Background(), newFlakyStorage(5), MigrateOptions{MaxAttempts: 2, RetryWait: time.Millisecond})
+	if err == nil {
+		t.Fatal("Migrate() with persistent failures should return an error")
+	}
+	if len(migrated.Failed) != 2 || len(migrated.Copied) != 0 {
+		t.Errorf("Migrate() failed %d and copied %d, want 2 failed", len(migrated.Failed), len(migrated.Copied))
+	}
+}
+
+func TestEngine_Latest(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	for i, b := range []struct {
+		ts  time.Time
+		typ string
+	}{
+		{time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC), "monthly"},
+		{time.Date(2026, 3, 8, 2, 0, 0, 0, time.UTC), "weekly"},
+		{time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), "daily"},
+		{time.Date(2026, 3, 9, 2, 0, 0, 0, time.UTC), "daily"},
+	} {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = b.ts
+		meta.Type = b.typ
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+	}
+
+	engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+	tests := []struct {
+		typ     string
+		want    string
+		wantErr bool
+	}{
+		{typ: "", want: "backup-002"},
+		{typ: "daily", want: "backup-002"},
+		{typ: "weekly", want: "backup-001"},
+		{typ: "monthly", want: "backup-000"},
+		{typ: "yearly", wantErr: true},
+		{typ: "hourly", wantErr: true},
+	}
+	for _, tt := range tests {
+		meta, err := engine.Latest(context.Background(), tt.typ)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Latest(%q) = %s, want an error", tt.typ, meta.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Latest(%q) error = %v", tt.typ, err)
+			continue
+		}
+		if meta.ID != tt.want {
+			t.Errorf("Latest(%q) = %s, want %s", tt.typ, meta.ID, tt.want)
+		}
+	}
+}
+
+func TestEngine_MetadataSigning(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+		Encryption:  config.EncryptionConfig{MetadataSigningKey: "0123456789abcdef"},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	results, err := engine.VerifyAll(context.Background(), false)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].Valid {
+		t.Fatalf("VerifyAll() of a signed backup = %+v, want valid", results)
+	}
+
+	// Shrink the recorded size in both copies of the metadata, as someone
+	// covering up a truncated backup would.
+	for _, p := range []string{result.ID + ".meta.json", postgres.MetadataIndexPath(result.ID)} {
+		meta, err := postgres.ParseMetadata(store.files[p])
+		if err != nil {
+			t.Fatalf("ParseMetadata(%s) error = %v", p, err)
+		}
+		meta.Backup.CompressedSize--
+		store.files[p], _ = meta.ToJSON()
+	}
+
+	results, err = engine.VerifyAll(context.Background(), false)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Valid {
+		t.Fatalf("VerifyAll() of tampered metadata = %+v, want invalid", results)
+	}
+	if !strings.Contains(strings.Join(results[0].Errors, "; "), "metadata tampered") {
+		t.Errorf("Errors = %v, want a metadata tampered error", results[0].Errors)
+	}
+}
+
+func TestEngine_MetadataSigning_History(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+		Encryption:  config.EncryptionConfig{MetadataSigningKey: "0123456789abcdef"},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// The history line is the metadata Run kept in memory; it must be the
+	// signed object that was stored.
+	history, err := engine.History(context.Background())
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 1 || history[0].ID != result.ID {
+		t.Fatalf("History() = %v, want the backup just taken", history)
+	}
+	if err := history[0].VerifySignature(cfg.MetadataSigningKey()); err != nil {
+		t.Errorf("VerifySignature() of the returned metadata error = %v", err)
+	}
+
+	stored, err := engine.GetBackup(context.Background(), result.ID)
+	if err != nil {
+		t.Fatalf("GetBackup() error = %v", err)
+	}
+	if !reflect.DeepEqual(stored.Files, history[0].Files) {
+		t.Errorf("stored Files = %v, returned Files = %v, want the same", stored.Files, history[0].Files)
+	}
+}
+
+func TestEngine_Run_InsufficientSpace(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("os.Stat() error = %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+		Backup:      config.BackupConfig{SpaceMultiplier: 2},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	// Enough for the database itself, but not for twice its size.
+	free := uint64(info.Size()) + 1
+	engine.freeSpace = func(string) (uint64, error) { return free, nil }
+	if _, err := engine.Run(context.Background()); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Run() error = %v, want ErrInsufficientSpace", err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("storage has %d files after an aborted backup, want 0", len(store.files))
+	}
+
+	free = uint64(info.Size()) * 2
+	if _, err := engine.Run(context.Background()); err != nil {
+		t.Fatalf("Run() with enough space error = %v", err)
+	}
+
+	// Not knowing the free space skips the check rather than the backup.
+	engine.freeSpace = func(string) (uint64, error) { return 0, errors.ErrUnsupported }
+	if _, err := engine.Run(context.Background()); err != nil {
+		t.Errorf("Run() without free space information error = %v", err)
+	}
+}
+
+func TestEngine_Run_TempDir(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	tempDir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+		Backup:      config.BackupConfig{TempDir: tempDir},
+	}
+	engine := NewEngine(cfg, newMockStorage(), nil, nil, logger)
+
+	var staged string
+	engine.freeSpace = func(dir string) (uint64, error) {
+		staged = dir
+		return diskFree(dir)
+	}
+	if _, err := engine.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if filepath.Dir(staged) != tempDir {
+		t.Errorf("backup staged in %s, want a directory in %s", staged, tempDir)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp_dir has %d entries after the backup, want 0", len(entries))
+	}
+
+	results, err := engine.VerifyAll(context.Background(), true)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].Valid {
+		t.Errorf("VerifyAll() = %+v, want one valid backup", results)
+	}
+}
+
+// addStaleBackup stores a complete backup from 2026-01-05, well past any
+// daily retention window.
+func addStaleBackup(store *mockStorage, id string) {
+	meta := postgres.NewBackupMetadata(id, "app", "local", "3")
+	meta.Timestamp = time.Date(2026, 1, 5, 2, 0, 0, 0, time.UTC)
+	meta.AddFile(id + ".db.gz")
+	data, _ := meta.ToJSON()
+	store.files[id+".meta.json"] = data
+	store.files[id+".db.gz"] = []byte("backup")
+}
+
+func TestScheduler_CleanupOnlyAfterSuccessfulBackup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 1},
+		Backup:      config.BackupConfig{MaxAttempts: 1},
+	}
+	store := newMockStorage()
+	addStaleBackup(store, "backup-old")
+	addStaleBackup(store, "backup-older")
+
+	s := NewScheduler(NewEngine(cfg, store, nil, nil, logger), "0 2 * * *", logger)
+	j := &job{}
+
+	// The database does not exist yet, so the backup fails.
+	s.runBackup(context.Background(), j)
+	if _, ok := store.files["backup-older.meta.json"]; !ok {
+		t.Fatal("cleanup ran after a failed backup")
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	s.SetAutoCleanup(false)
+	s.runBackup(context.Background(), j)
+	if _, ok := store.files["backup-older.meta.json"]; !ok {
+		t.Fatal("cleanup ran with auto cleanup off")
+	}
+
+	s.SetAutoCleanup(true)
+	s.runBackup(context.Background(), j)
+	for _, id := range []string{"backup-old", "backup-older"} {
+		if _, ok := store.files[id+".meta.json"]; ok {
+			t.Errorf("%s survived the cleanup after a successful backup", id)
+		}
+	}
+}
+
+// relistStorage adds a backup to storage between Cleanup's two listings.
+type relistStorage struct {
+	*mockStorage
+	lists int
+	add   func()
+}
+
+func (r *relistStorage) List(ctx context.Context, prefix string) ([]storage.FileInfo, error) {
+	// ListBackups reads the empty meta/ index, then every object.
+	if prefix == "" {
+		if r.lists++; r.lists == 2 {
+			r.add()
+		}
+	}
+	return r.mockStorage.List(ctx, prefix)
+}
+
+func TestEngine_Cleanup_RelistsBeforeDeleting(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mock := newMockStorage()
+	for i, ts := range []time.Time{
+		time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), // Tuesday
+		time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC), // Wednesday
+	} {
+		id := fmt.Sprintf("backup-%03d", i)
+		meta := postgres.NewBackupMetadata(id, "db", "local", "16")
+		meta.Timestamp = ts
+		meta.AddFile(id + ".dump")
+		data, _ := meta.ToJSON()
+		mock.files[id+".meta.json"] = data
+		mock.files[id+".dump"] = []byte("dump")
+	}
+	store := &relistStorage{mockStorage: mock, add: func() {
+		meta := postgres.NewBackupMetadata("backup-new", "db", "local", "16")
+		meta.Timestamp = time.Date(2026, 3, 12, 2, 0, 0, 0, time.UTC) // Thursday
+		meta.AddFile("backup-new.dump")
+		data, _ := meta.ToJSON()
+		mock.files["backup-new.meta.json"] = data
+		mock.files["backup-new.dump"] = []byte("dump")
+	}}
+
+	engine := NewEngine(&config.Config{Retention: config.RetentionConfig{Daily: 1}}, store, nil, nil, logger)
+	deleted, err := engine.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if store.lists != 2 {
+		t.Errorf("Cleanup() listed storage %d times, want 2", store.lists)
+	}
+
+	// backup-001 was the newest when the cleanup started, so it is only
+	// deleted by the next one, even though backup-new has replaced it.
+	if deleted != 1 {
+		t.Errorf("Cleanup() deleted %d backups, want 1", deleted)
+	}
+	for _, id := range []string{"backup-new", "backup-001"} {
+		if _, ok := mock.files[id+".meta.json"]; !ok {
+			t.Errorf("%s was deleted", id)
+		}
+	}
+	if _, ok := mock.files["backup-000.meta.json"]; ok {
+		t.Error("backup-000 was not deleted")
+	}
+}
+
+func TestEngine_CheckAnomalies(t *testing.T) {
+	store := newMockStorage()
+	addBackup := func(id string, day int, size int64, seconds float64, schedule string) *postgres.BackupMetadata {
+		meta := postgres.NewBackupMetadata(id, "app", "local", "16")
+		meta.Timestamp = time.Date(2026, 3, day, 2, 0, 0, 0, time.UTC)
+		meta.Schedule = schedule
+		meta.Backup.CompressedSize = size
+		meta.Backup.DurationSeconds = seconds
+		data, _ := meta.ToJSON()
+		store.files[id+".meta.json"] = data
+		return meta
+	}
+	for day := 1; day <= 4; day++ {
+		addBackup(fmt.Sprintf("backup_2026030%d_020000", day), day, 1_000_000, 60, "")
+	}
+	// Backups of another schedule are not compared against.
+	addBackup("backup_20260305_030000", 5, 10, 1, "schema")
+
+	payloads := make(chan notify.WebhookPayload, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.WebhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		payloads <- p
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{Monitoring: config.MonitoringConfig{AnomalyWindow: 3}}
+	engine := NewEngine(cfg, store, notify.NewNotifier(server.URL, logger), nil, logger)
+
+	normal := addBackup("backup_20260306_020000", 6, 1_100_000, 70, "")
+	if got := engine.checkAnomalies(context.Background(), engine.logger, normal); len(got) != 0 {
+		t.Errorf("checkAnomalies() of a normal backup = %v, want none", got)
+	}
+
+	empty := addBackup("backup_20260307_020000", 7, 10_000, 200, "")
+	got := engine.checkAnomalies(context.Background(), engine.logger, empty)
+	if len(got) != 2 || !strings.Contains(got[0], "1% of the median size") || !strings.Contains(got[1], "duration") {
+		t.Fatalf("checkAnomalies() = %v, want a size and a duration anomaly", got)
+	}
+	for range got {
+		select {
+		case p := <-payloads:
+			if p.Event != "backup.anomaly" || p.BackupID != empty.ID {
+				t.Errorf("notification = %s for %s, want backup.anomaly for %s", p.Event, p.BackupID, empty.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no backup.anomaly notification sent")
+		}
+	}
+
+	// Without enough history there is no median to trust.
+	lone := addBackup("backup_20260308_030000", 8, 1, 1, "schema")
+	if got := engine.checkAnomalies(context.Background(), engine.logger, lone); len(got) != 0 {
+		t.Errorf("checkAnomalies() with one earlier backup = %v, want none", got)
+	}
+
+	cfg.Monitoring.AnomalyWindow = 0
+	if got := engine.checkAnomalies(context.Background(), engine.logger, empty); len(got) != 0 {
+		t.Errorf("checkAnomalies() with the check disabled = %v, want none", got)
+	}
+}
+
+func TestEngine_SelfTest(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "postgres", Host: "db.invalid"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	store.files["backups/daily/existing.sql.gz"] = []byte("existing")
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	results := engine.SelfTest(context.Background(), true)
+
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+		if !r.Passed() {
+			t.Errorf("phase %s failed: %v", r.Name, r.Err)
+		}
+	}
+	want := []string{"sample database", "backup", "verify", "restore", "cleanup"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("phases = %v, want %v", names, want)
+	}
+	if len(store.files) != 1 {
+		t.Errorf("storage has %d files after self-test, want only the existing backup: %v", len(store.files), store.files)
+	}
+}
+
+func TestIsSelfTest(t *testing.T) {
+	if !IsSelfTest(SelfTestPrefix + "20260101_000000") {
+		t.Error("IsSelfTest() = false for a self-test ID")
+	}
+	if IsSelfTest("20260101_000000") {
+		t.Error("IsSelfTest() = true for a regular ID")
+	}
+}
+
+// lockedStorage is a mockStorage behind S3 object lock: what is written
+// with retention cannot be deleted.
+type lockedStorage struct {
+	*mockStorage
+	retained map[string]bool
+}
+
+func (s *lockedStorage) Write(ctx context.Context, path string, reader io.Reader) error {
+	if !storage.RetentionSkipped(ctx) {
+		s.mu.Lock()
+		s.retained[path] = true
+		s.mu.Unlock()
+	}
+	return s.mockStorage.Write(ctx, path, reader)
+}
+
+func (s *lockedStorage) Delete(ctx context.Context, path string) error {
+	s.mu.Lock()
+	retained := s.retained[path]
+	s.mu.Unlock()
+	if retained {
+		return &storage.StorageError{Op: "delete", Path: path, Err: storage.ErrObjectLocked}
+	}
+	return s.mockStorage.Delete(ctx, path)
+}
+
+func TestEngine_AcquireInstanceLock_ObjectLock(t *testing.T) {
+	settle := instanceLockSettle
+	instanceLockSettle = 0
+	defer func() { instanceLockSettle = settle }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &lockedStorage{mockStorage: newMockStorage(), retained: make(map[string]bool)}
+	engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+	ctx := context.Background()
+
+	lock, err := engine.AcquireInstanceLock(ctx, 60*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) // let a heartbeat rewrite the lock
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v, want the lock deleted despite object lock", err)
+	}
+	if store.retained[InstanceLockPath] {
+		t.Error("instance lock written with object lock retention")
+	}
+
+	// A restarted daemon gets the lock back at once.
+	lock, err = engine.AcquireInstanceLock(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock() after Release() error = %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+}
+
+func TestEngine_AcquireInstanceLock(t *testing.T) {
+	settle := instanceLockSettle
+	instanceLockSettle = 0
+	defer func() { instanceLockSettle = settle }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+	engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+	ctx := context.Background()
+
+	lock, err := engine.AcquireInstanceLock(ctx, 60*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock() error = %v", err)
+	}
+	var first instanceLease
+	if err := json.Unmarshal(store.files[InstanceLockPath], &first); err != nil {
+		t.Fatalf("lock object is not a lease: %v", err)
+	}
+
+	// The heartbeat keeps the lock current past its TTL.
+	time.Sleep(150 * time.Millisecond)
+	_, err = engine.AcquireInstanceLock(ctx, time.Minute)
+	if !errors.Is(err, ErrInstanceLocked) || !strings.Contains(err.Error(), first.Owner) {
+		t.Errorf("second AcquireInstanceLock() error = %v, want ErrInstanceLocked naming %s", err, first.Owner)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok := store.files[InstanceLockPath]; ok {
+		t.Error("lock object left behind after Release()")
+	}
+
+	// A lock whose heartbeat is older than its TTL is taken over.
+	stale, _ := json.Marshal(instanceLease{Owner: "crashed:1", Token: "old", Heartbeat: time.Now().Add(-time.Hour), TTLSeconds: 60})
+	store.files[InstanceLockPath] = stale
+	lock, err = engine.AcquireInstanceLock(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock() over a stale lock error = %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+}
+
+func TestEngine_ChecksumAlgorithm(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database:    config.DatabaseConfig{Type: "sqlite", Path: dbPath, SQLiteMethod: "backup"},
+		Compression: "gzip",
+		Retention:   config.RetentionConfig{Daily: 7},
+	}
+	store := newMockStorage()
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	// A backup taken before the algorithm was changed must still verify.
+	old, err := engine.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	cfg.Backup.ChecksumAlgorithm = "xxhash"
+	scheduled, err := engine.RunSchedule(context.Background(), config.ScheduleEntry{Name: "hourly", Cron: "0 * * * *"})
+	if err != nil {
+		t.Fatalf("RunSchedule() error = %v", err)
+	}
+
+	for id, want := range map[string]string{old.ID: "sha256:", scheduled[0].ID: "xxhash:"} {
+		meta, err := engine.GetBackup(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetBackup(%s) error = %v", id, err)
+		}
+		if !strings.HasPrefix(meta.Backup.Checksum, want) {
+			t.Errorf("backup %s checksum = %q, want a %s checksum", id, meta.Backup.Checksum, want)
+		}
+		if meta.Backup.ContentChecksum != "" && !strings.HasPrefix(meta.Backup.ContentChecksum, want) {
+			t.Errorf("backup %s content checksum = %q, want a %s checksum", id, meta.Backup.ContentChecksum, want)
+		}
+	}
+
+	results, err := engine.VerifyAll(context.Background(), true)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("VerifyAll() returned %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if !r.Valid || !r.ChecksumOK || !r.RestoreOK {
+			t.Errorf("backup %s: Valid = %v, ChecksumOK = %v, RestoreOK = %v, errors = %v", r.BackupID, r.Valid, r.ChecksumOK, r.RestoreOK, r.Errors)
+		}
+	}
+
+	// Flip a byte of the xxhash backup's file, keeping its size.
+	for path, data := range store.files {
+		if strings.HasPrefix(path, scheduled[0].ID) && !strings.HasSuffix(path, ".json") {
+			data[len(data)/2] ^= 0xff
+		}
+	}
+	results, err = engine.VerifyAll(context.Background(), false)
+	if err != nil {
+		t.Fatalf("VerifyAll() error = %v", err)
+	}
+	for _, r := range results {
+		if r.BackupID == scheduled[0].ID && (r.Valid || r.ChecksumOK) {
+			t.Errorf("corrupted xxhash backup %s passed verification", r.BackupID)
+		}
+	}
+}
+
+func TestEngine_Diff(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockStorage()
+
+	before := postgres.NewBackupMetadata("backup-a", "db", "local", "16.1")
+	before.SetBackupInfo(4*1024*1024, 1024*1024, 10*time.Second, "")
+	before.Backup.TableStats = map[string]int64{"users": 100, "orders": 50, "old": 5}
+	after := postgres.NewBackupMetadata("backup-b", "db", "local", "16.2")
+	after.SetBackupInfo(8*1024*1024, 1024*1024, 15*time.Second, "")
+	after.Backup.TableStats = map[string]int64{"users": 100, "orders": 5000, "events": 7}
+	for _, meta := range []*postgres.BackupMetadata{before, after} {
+		data, _ := meta.ToJSON()
+		store.files[meta.ID+".meta.json"] = data
+	}
+
+	engine := NewEngine(&config.Config{}, store, nil, nil, logger)
+	diff, err := engine.Diff(context.Background(), "backup-a", "backup-b")
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+
+	changes := make(map[string]string)
+	for _, f := range diff.Fields {
+		changes[f.Field] = f.Change
+	}
+	want := map[string]string{
+		"size":             "+4.00 MB (+100.0%)",
+		"compressed_size":  "",
+		"duration":         "+5s (+50.0%)",
+		"database_version": "changed",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("field changes = %v, want %v", changes, want)
+	}
+
+	wantTables := []TableDiff{
+		{Table: "events", Before: -1, After: 7},
+		{Table: "old", Before: 5, After: -1},
+		{Table: "orders", Before: 50, After: 5000},
+	}
+	if !reflect.DeepEqual(diff.Tables, wantTables) {
+		t.Errorf("Tables = %v, want %v", diff.Tables, wantTables)
+	}
+	if diff.TablesCompared != 4 {
+		t.Errorf("TablesCompared = %d, want 4", diff.TablesCompared)
+	}
+
+	if _, err := engine.Diff(context.Background(), "backup-a", "missing"); err == nil {
+		t.Error("Diff() should fail for a missing backup")
+	}
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/localrivet/datasaver/pkg/database"
+	"github.com/robfig/cron/v3"
+)
+
+// CheckResult is the outcome of one preflight check. Detail carries extra
+// context for a passing check, such as the database version.
+type CheckResult struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+func (r CheckResult) Passed() bool {
+	return r.Err == nil
+}
+
+// Preflight checks everything a scheduled backup depends on without
+// creating one: the cron schedules, required client binaries, database
+// connectivity and that storage accepts writes.
+func (e *Engine) Preflight(ctx context.Context) []CheckResult {
+	var results []CheckResult
+
+	for _, sched := range e.cfg.BackupSchedules() {
+		name := "schedule"
+		if sched.Name != "" {
+			name = "schedule " + sched.Name
+		}
+		results = append(results, checkSchedule(name, sched.Cron))
+	}
+	if e.cfg.Schedule.VerifyRestore != "" {
+		results = append(results, checkSchedule("verify_restore schedule", e.cfg.Schedule.VerifyRestore))
+	}
+
+	results = append(results, e.checkBinaries(ctx)...)
+	results = append(results, e.checkDatabase(ctx))
+	results = append(results, e.checkStorage(ctx))
+
+	return results
+}
+
+func checkSchedule(name, spec string) CheckResult {
+	result := CheckResult{Name: name, Detail: spec}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		result.Err = fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		return result
+	}
+	result.Detail = fmt.Sprintf("%s (next run %s)", spec, sched.Next(time.Now()).Format("2006-01-02 15:04"))
+	return result
+}
+
+// checkBinaries finds each required client tool at its configured path or
+// on PATH and reports the binary and version that will run.
+func (e *Engine) checkBinaries(ctx context.Context) []CheckResult {
+	var results []CheckResult
+	for _, tool := range e.requiredBinaries() {
+		result := CheckResult{Name: tool + " binary"}
+		bin := e.cfg.Database.Binary(tool)
+		path, err := exec.LookPath(bin)
+		switch {
+		case err != nil && strings.ContainsRune(bin, filepath.Separator):
+			result.Err = fmt.Errorf("%s not found or not executable", bin)
+		case err != nil:
+			result.Err = fmt.Errorf("%s not found on PATH; install it or set database.%s_path", bin, tool)
+		default:
+			result.Detail = path
+			if version := binaryVersion(ctx, path); version != "" {
+				result.Detail += " (" + version + ")"
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// binaryVersion returns the first line of path --version, or "" when it
+// prints nothing or fails.
+func binaryVersion(ctx context.Context, path string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line)
+}
+
+// requiredBinaries lists the client tools backups and verification shell
+// out to for the configured database.
+func (e *Engine) requiredBinaries() []string {
+	if e.cfg.IsSQLite() {
+		if e.cfg.Database.SQLiteMethod == database.SQLiteMethodBackup {
+			return nil
+		}
+		return []string{"sqlite3"}
+	}
+	if e.cfg.Backup.Method == database.MethodPhysical {
+		return []string{"pg_basebackup"}
+	}
+	if e.cfg.Backup.Format == "plain" {
+		return []string{"pg_dump", "psql"}
+	}
+	return []string{"pg_dump", "pg_restore"}
+}
+
+func (e *Engine) checkDatabase(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "database"}
+
+	driver, err := database.NewDriver(e.driverConfig())
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create database driver: %w", err)
+		return result
+	}
+
+	if err := driver.Connect(ctx); err != nil {
+		result.Err = fmt.Errorf("failed to connect to database: %w", err)
+		return result
+	}
+	defer driver.Close()
+
+	version, err := driver.Version(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to get database version: %w", err)
+		return result
+	}
+	result.Detail = version
+
+	// A pg_dump older than the server fails every backup.
+	if pg, ok := driver.(*database.PostgresDriver); ok && pg.Format() != database.FormatBaseBackup {
+		if err := pg.CheckPGDump(ctx); err != nil {
+			result.Err = err
+		}
+	}
+
+	return result
+}
+
+// CheckStorage writes, reads back and deletes a small probe object, and
+// returns the first step that failed. The daemon runs it at startup so bad
+// credentials surface before the first scheduled backup.
+func (e *Engine) CheckStorage(ctx context.Context) error {
+	return e.checkStorage(ctx).Err
+}
+
+// checkStorage round-trips a small probe object through the backend. The
+// probe name never ends in .meta.json, so it cannot be mistaken for a backup.
+func (e *Engine) checkStorage(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "storage", Detail: e.cfg.Storage.Backend}
+
+	probePath := fmt.Sprintf(".datasaver-check-%d", time.Now().UnixNano())
+	probe := []byte("datasaver storage check\n")
+
+	if err := e.storage.Write(ctx, probePath, bytes.NewReader(probe)); err != nil {
+		result.Err = fmt.Errorf("failed to write probe: %w", err)
+		return result
+	}
+
+	reader, err := e.storage.Read(ctx, probePath)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read probe: %w", err)
+	} else {
+		data, readErr := io.ReadAll(reader)
+		reader.Close()
+		if readErr != nil {
+			result.Err = fmt.Errorf("failed to read probe: %w", readErr)
+		} else if !bytes.Equal(data, probe) {
+			result.Err = fmt.Errorf("probe read back %d bytes, want %d", len(data), len(probe))
+		}
+	}
+
+	if err := e.storage.Delete(ctx, probePath); err != nil && result.Err == nil {
+		result.Err = fmt.Errorf("failed to delete probe: %w", err)
+	}
+
+	return result
+}
@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/progress"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// Diff compares the metadata of two backups, for finding out what changed
+// between them, such as why one is much larger than the other.
+type Diff struct {
+	Before *postgres.BackupMetadata
+	After  *postgres.BackupMetadata
+
+	// Fields has one entry per compared field, changed or not.
+	Fields []FieldDiff
+
+	// Tables lists the tables whose row counts differ, or that only one
+	// backup has counts for, by name. TablesCompared is how many tables
+	// either backup recorded counts for; 0 means neither collected any.
+	Tables         []TableDiff
+	TablesCompared int
+}
+
+// FieldDiff is one metadata field of both backups, formatted for display.
+// Change is empty when the values are equal.
+type FieldDiff struct {
+	Field  string
+	Before string
+	After  string
+	Change string
+}
+
+// TableDiff is the row count of one table in both backups; -1 means the
+// backup has no count for the table.
+type TableDiff struct {
+	Table  string
+	Before int64
+	After  int64
+}
+
+// Diff loads the metadata of backups beforeID and afterID and compares them.
+func (e *Engine) Diff(ctx context.Context, beforeID, afterID string) (*Diff, error) {
+	before, err := e.GetBackup(ctx, beforeID)
+	if err != nil {
+		return nil, err
+	}
+	after, err := e.GetBackup(ctx, afterID)
+	if err != nil {
+		return nil, err
+	}
+	return DiffBackups(before, after), nil
+}
+
+// DiffBackups compares sizes, duration, database version and table row
+// counts of two backups. Row counts come from backup.collect_stats and the
+// sentinel tables, whichever a backup recorded.
+func DiffBackups(before, after *postgres.BackupMetadata) *Diff {
+	b, a := before.Backup, after.Backup
+	d := &Diff{
+		Before: before,
+		After:  after,
+		Fields: []FieldDiff{
+			bytesDiff("size", b.SizeBytes, a.SizeBytes),
+			bytesDiff("compressed_size", b.CompressedSize, a.CompressedSize),
+			durationDiff("duration", b.DurationSeconds, a.DurationSeconds),
+			stringDiff("database_version", before.Database.Version, after.Database.Version),
+		},
+	}
+
+	beforeRows, afterRows := tableRows(before), tableRows(after)
+	tables := make(map[string]bool)
+	for table := range beforeRows {
+		tables[table] = true
+	}
+	for table := range afterRows {
+		tables[table] = true
+	}
+	d.TablesCompared = len(tables)
+
+	for table := range tables {
+		bn, inBefore := beforeRows[table]
+		an, inAfter := afterRows[table]
+		if !inBefore {
+			bn = -1
+		}
+		if !inAfter {
+			an = -1
+		}
+		if bn != an {
+			d.Tables = append(d.Tables, TableDiff{Table: table, Before: bn, After: an})
+		}
+	}
+	sort.Slice(d.Tables, func(i, j int) bool { return d.Tables[i].Table < d.Tables[j].Table })
+	return d
+}
+
+// tableRows returns the row counts meta recorded, sentinel tables and
+// collected statistics together.
+func tableRows(meta *postgres.BackupMetadata) map[string]int64 {
+	rows := make(map[string]int64, len(meta.Backup.TableStats)+len(meta.Backup.TableCounts))
+	for table, n := range meta.Backup.TableStats {
+		rows[table] = n
+	}
+	for table, n := range meta.Backup.TableCounts {
+		rows[table] = n
+	}
+	return rows
+}
+
+func bytesDiff(field string, before, after int64) FieldDiff {
+	d := FieldDiff{Field: field, Before: progress.FormatBytes(before), After: progress.FormatBytes(after)}
+	if before != after {
+		delta := after - before
+		sign := "+"
+		if delta < 0 {
+			sign, delta = "-", -delta
+		}
+		d.Change = sign + progress.FormatBytes(delta) + percentChange(float64(before), float64(after))
+	}
+	return d
+}
+
+func durationDiff(field string, before, after float64) FieldDiff {
+	bd := time.Duration(before * float64(time.Second)).Round(time.Millisecond)
+	ad := time.Duration(after * float64(time.Second)).Round(time.Millisecond)
+	d := FieldDiff{Field: field, Before: bd.String(), After: ad.String()}
+	if bd != ad {
+		delta := ad - bd
+		sign := "+"
+		if delta < 0 {
+			sign, delta = "-", -delta
+		}
+		d.Change = sign + delta.String() + percentChange(before, after)
+	}
+	return d
+}
+
+func stringDiff(field, before, after string) FieldDiff {
+	d := FieldDiff{Field: field, Before: before, After: after}
+	if before != after {
+		d.Change = "changed"
+	}
+	return d
+}
+
+// percentChange formats the relative change from before to after, or ""
+// when before is 0.
+func percentChange(before, after float64) string {
+	if before == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f%%)", (after-before)/before*100)
+}
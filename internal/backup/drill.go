@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/localrivet/datasaver/internal/metrics"
+)
+
+// RestoreDrill restore-verifies the most recent backup into a scratch
+// database. Scheduled independently of backups, it gives continuous proof
+// that what we store can actually be restored, not just that it exists.
+type RestoreDrill struct {
+	engine  *Engine
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+}
+
+func NewRestoreDrill(engine *Engine, m *metrics.Metrics, logger *slog.Logger) *RestoreDrill {
+	return &RestoreDrill{
+		engine:  engine,
+		metrics: m,
+		logger:  logger,
+	}
+}
+
+// Run verifies the latest backup. Failures are alerted and recorded before
+// being returned, so callers only need to log the error.
+func (d *RestoreDrill) Run(ctx context.Context) error {
+	latest, err := d.engine.Latest(ctx, "")
+	if err != nil {
+		return d.fail("", err)
+	}
+
+	d.logger.Info("restore drill starting", "id", latest.ID)
+
+	if err := d.engine.newRestoreValidator(backupLogger(d.logger, latest.ID, latest.Database.Name)).VerifyRestoreIntegrity(ctx, latest); err != nil {
+		return d.fail(latest.ID, err)
+	}
+
+	if d.metrics != nil {
+		d.metrics.RecordVerifyRestoreSuccess()
+	}
+
+	d.logger.Info("restore drill completed", "id", latest.ID)
+
+	return nil
+}
+
+func (d *RestoreDrill) fail(backupID string, err error) error {
+	d.logger.Error("restore drill FAILED", "id", backupID, "error", err)
+
+	if d.metrics != nil {
+		d.metrics.RecordVerifyRestoreFailure()
+	}
+
+	if d.engine.notifier != nil {
+		d.engine.notifier.NotifyVerifyRestoreFailure(backupID, err)
+	}
+
+	return err
+}
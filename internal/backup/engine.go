@@ -2,20 +2,30 @@ package backup
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/hooks"
+	"github.com/localrivet/datasaver/internal/metrics"
 	"github.com/localrivet/datasaver/internal/notify"
+	"github.com/localrivet/datasaver/internal/oplock"
+	"github.com/localrivet/datasaver/internal/progress"
 	"github.com/localrivet/datasaver/internal/rotation"
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/internal/transform"
+	"github.com/localrivet/datasaver/pkg/checksum"
 	"github.com/localrivet/datasaver/pkg/database"
 	"github.com/localrivet/datasaver/pkg/postgres"
 )
@@ -23,223 +33,399 @@ import (
 type Engine struct {
 	cfg       *config.Config
 	storage   storage.Backend
+	rotatorMu sync.RWMutex
 	rotator   *rotation.GFSRotator
-	notifier  *notify.Notifier
+	notifier  notify.Sender
+	metrics   *metrics.Metrics
+	hooks     *hooks.Runner
 	logger    *slog.Logger
 	lastRun   time.Time
 	lastError error
-}
 
-func NewEngine(cfg *config.Config, store storage.Backend, notifier *notify.Notifier, logger *slog.Logger) *Engine {
-	policy := rotation.NewPolicy(
-		cfg.Retention.Daily,
-		cfg.Retention.Weekly,
-		cfg.Retention.Monthly,
-		cfg.Retention.MaxAgeDays,
-	)
+	// idSuffix tells apart backups of several databases taken in the same
+	// second; it is empty for a single database.
+	idSuffix string
+
+	// schedule is the schedules entry this engine backs up for, empty for
+	// the default schedule. scheduleRotators hold the retention of entries
+	// that override it, keyed by name, and are guarded by rotatorMu.
+	schedule         string
+	scheduleRotators map[string]*rotation.GFSRotator
+
+	// cache holds local copies of recent backups; nil when none is
+	// configured.
+	cache *storage.Cache
+
+	// selfTest is set on the engine SelfTest backs up with: its backup IDs
+	// start with SelfTestPrefix, and it records no history and checks no
+	// anomalies.
+	selfTest bool
 
-	return &Engine{
+	// freeSpace reports the bytes available in a directory; tests replace it.
+	freeSpace func(dir string) (uint64, error)
+}
+
+// NewEngine creates a backup engine. notifier and m are optional; when m is
+// set, every run's outcome is recorded in it.
+func NewEngine(cfg *config.Config, store storage.Backend, notifier notify.Sender, m *metrics.Metrics, logger *slog.Logger) *Engine {
+	e := &Engine{
 		cfg:      cfg,
 		storage:  store,
-		rotator:  rotation.NewGFSRotator(policy),
+		rotator:  newRotator(cfg.Retention),
 		notifier: notifier,
+		metrics:  m,
+		hooks:    hooks.NewRunner(cfg.HookTimeout(), logger),
 		logger:   logger,
+
+		cache:     storage.NewCache(cfg.Storage.Cache.Path, cfg.Storage.Cache.Backups(), cfg.Storage.Cache.MaxBytes),
+		freeSpace: diskFree,
+	}
+	e.SetSchedules(cfg.Schedules)
+	return e
+}
+
+func newRotator(r config.RetentionConfig) *rotation.GFSRotator {
+	policy := rotation.NewPolicy(r.Daily, r.Weekly, r.Monthly, r.MaxAgeDays)
+	policy.KeepYearly = r.Yearly
+	policy.MaxTotalBytes = r.MaxTotalBytes
+	policy.KeepNewest = r.KeepNewest
+	for _, rule := range r.Rules {
+		policy.Rules = append(policy.Rules, rotation.LabelRule{Match: rule.Match, KeepDays: rule.KeepDays})
+	}
+	return rotation.NewGFSRotator(policy)
+}
+
+// SetRetention replaces the retention policy used by later backups and
+// cleanups, e.g. after a config reload.
+func (e *Engine) SetRetention(r config.RetentionConfig) {
+	e.rotatorMu.Lock()
+	defer e.rotatorMu.Unlock()
+	e.rotator = newRotator(r)
+}
+
+// SetSchedules replaces the retention of the schedules entries that set
+// their own, e.g. after a config reload. Backups of other schedules follow
+// the policy set by SetRetention.
+func (e *Engine) SetSchedules(schedules []config.ScheduleEntry) {
+	rotators := make(map[string]*rotation.GFSRotator)
+	for _, s := range schedules {
+		if s.Retention != nil {
+			rotators[s.Name] = newRotator(*s.Retention)
+		}
+	}
+
+	e.rotatorMu.Lock()
+	defer e.rotatorMu.Unlock()
+	e.scheduleRotators = rotators
+}
+
+// StorageCap returns the retention cap on total backup storage in bytes, or
+// 0 when there is none.
+func (e *Engine) StorageCap() int64 {
+	return e.currentRotator().Policy().MaxTotalBytes
+}
+
+func (e *Engine) currentRotator() *rotation.GFSRotator {
+	e.rotatorMu.RLock()
+	defer e.rotatorMu.RUnlock()
+	return e.rotator
+}
+
+// rotatorFor returns the rotator for backups taken by the named schedule.
+func (e *Engine) rotatorFor(schedule string) *rotation.GFSRotator {
+	e.rotatorMu.RLock()
+	defer e.rotatorMu.RUnlock()
+	if r, ok := e.scheduleRotators[schedule]; ok {
+		return r
 	}
+	return e.rotator
 }
 
 type BackupResult struct {
 	ID              string
+	Database        string
 	Timestamp       time.Time
 	Size            int64
 	CompressedSize  int64
 	Duration        time.Duration
 	Checksum        string
+
+	// CompressionRatio is Size over CompressedSize and
+	// ThroughputBytesPerSec is Size over Duration.
+	CompressionRatio      float64
+	ThroughputBytesPerSec float64
+
 	Verified        bool   // True if backup was verified after creation
 	VerifyError     error  // Non-nil if verification failed
 	Error           error
 }
 
+// Run creates one backup. With backup.timeout set, the whole run, pre_backup
+// hooks and dump included, is canceled once it takes longer than that.
 func (e *Engine) Run(ctx context.Context) (*BackupResult, error) {
+	return e.RunWithLabels(ctx, nil)
+}
+
+// RunWithLabels creates one backup like Run and records labels in its
+// metadata. It fails with an error wrapping oplock.ErrInProgress while
+// another backup is running.
+func (e *Engine) RunWithLabels(ctx context.Context, labels map[string]string) (*BackupResult, error) {
+	release, err := e.lock()
+	if err != nil {
+		return &BackupResult{Database: e.databaseName(), Timestamp: time.Now(), Error: err}, err
+	}
+	defer release()
+
+	return e.runWithLabels(ctx, labels, false)
+}
+
+// backupLogger returns logger with the fields every line about one backup
+// carries, so its lifecycle can be followed through the log of concurrent
+// runs and other backups.
+func backupLogger(logger *slog.Logger, id, db string) *slog.Logger {
+	return logger.With("backup_id", id, "db", db)
+}
+
+// lock takes the backup lock, so backups started by the scheduler, MCP and
+// a CLI run on the same host never overlap. The lock file is in the system
+// temp directory whatever backup.temp_dir is, so every process finds it.
+func (e *Engine) lock() (func(), error) {
+	return oplock.Acquire("", "backup")
+}
+
+// runWithLabels creates one backup; onDemand marks it as taken by hand.
+func (e *Engine) runWithLabels(ctx context.Context, labels map[string]string, onDemand bool) (*BackupResult, error) {
+	// Post hooks still run, and report the failure, after a timeout.
+	hookCtx := ctx
+	if timeout := e.cfg.BackupTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("backup timed out after %s", timeout))
+		defer cancel()
+	}
+
 	startTime := time.Now()
 	backupID := postgres.GenerateBackupID(startTime)
+	if e.schedule != "" {
+		backupID += "_" + e.schedule
+	}
+	if e.idSuffix != "" {
+		backupID += "_" + e.idSuffix
+	}
+	if e.selfTest {
+		backupID = SelfTestPrefix + backupID
+	}
 
-	e.logger.Info("starting backup", "id", backupID, "db_type", e.cfg.Database.Type)
+	// Helpers, hooks and the validator log to logger too, so every line of
+	// the run carries its ID and database.
+	logger := backupLogger(e.logger, backupID, e.databaseName())
+	logger.Info("starting backup", "db_type", e.cfg.Database.Type)
 
 	result := &BackupResult{
 		ID:        backupID,
+		Database:  e.databaseName(),
 		Timestamp: startTime,
 	}
 
-	dbCfg := database.Config{
-		Type:     e.cfg.Database.Type,
-		Host:     e.cfg.Database.Host,
-		Port:     e.cfg.Database.Port,
-		Name:     e.cfg.Database.Name,
-		User:     e.cfg.Database.User,
-		Password: e.cfg.Database.Password,
-		URL:      e.cfg.Database.URL,
-		Path:     e.cfg.Database.Path,
-	}
-
-	driver, err := database.NewDriver(dbCfg)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create database driver: %w", err)
-		e.handleBackupError(result)
-		return result, result.Error
+	preEnv := []string{
+		"DATASAVER_BACKUP_ID=" + backupID,
+		"DATASAVER_DATABASE=" + e.databaseName(),
 	}
-
-	if err := driver.Connect(ctx); err != nil {
-		result.Error = fmt.Errorf("failed to connect to database: %w", err)
-		e.handleBackupError(result)
+	if err := e.hooks.WithLogger(logger).Run(ctx, hooks.PreBackup, e.cfg.Hooks.PreBackup, preEnv); err != nil {
+		result.Error = fmt.Errorf("backup aborted: %w", err)
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
-	defer driver.Close()
+	defer e.runPostBackupHooks(hookCtx, logger, result)
 
-	dbVersion, err := driver.Version(ctx)
+	driver, err := database.NewDriver(e.driverConfig())
 	if err != nil {
-		e.logger.Warn("failed to get database version", "error", err)
-		dbVersion = "unknown"
+		result.Error = fmt.Errorf("failed to create database driver: %w", err)
+		e.handleBackupError(ctx, logger, result)
+		return result, result.Error
 	}
 
-	tmpDir, err := os.MkdirTemp("", "datasaver-*")
+	tmpDir, err := os.MkdirTemp(e.cfg.Backup.TempDir, "datasaver-*")
 	if err != nil {
 		result.Error = fmt.Errorf("failed to create temp directory: %w", err)
-		e.handleBackupError(result)
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
 	defer os.RemoveAll(tmpDir)
 
+	if err := e.checkSpace(ctx, logger, driver, tmpDir); err != nil {
+		result.Error = err
+		e.handleBackupError(ctx, logger, result)
+		return result, result.Error
+	}
+
 	var dumpFile string
-	if e.cfg.IsSQLite() {
+	switch driver.Format() {
+	case database.FormatSQL:
 		dumpFile = filepath.Join(tmpDir, backupID+".sql")
-	} else {
+	case database.FormatDirectory, database.FormatBaseBackup:
+		dumpFile = filepath.Join(tmpDir, backupID+".tar")
+	case database.FormatSQLite:
+		dumpFile = filepath.Join(tmpDir, backupID+".db")
+	default:
 		dumpFile = filepath.Join(tmpDir, backupID+".dump")
 	}
 
-	dumpOutput, err := os.Create(dumpFile)
+	// Connection blips retry the whole connect and dump; errors such as a
+	// wrong password fail at once.
+	dumped, err := WithRetry(ctx, e.retryConfig(driver), logger, "database dump", func() (*dumpInfo, error) {
+		return e.dump(ctx, logger, driver, dumpFile)
+	})
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create dump file: %w", err)
-		e.handleBackupError(result)
-		return result, result.Error
-	}
-
-	if err := driver.Dump(ctx, dumpOutput); err != nil {
-		dumpOutput.Close()
-		result.Error = fmt.Errorf("database dump failed: %w", err)
-		e.handleBackupError(result)
+		result.Error = err
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
-	dumpOutput.Close()
+	dbVersion, walRange := dumped.version, dumped.walRange
 
-	dumpInfo, err := os.Stat(dumpFile)
+	fileInfo, err := os.Stat(dumpFile)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to stat dump file: %w", err)
-		e.handleBackupError(result)
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
-	result.Size = dumpInfo.Size()
+	result.Size = fileInfo.Size()
 
-	var finalFile string
-	var finalSize int64
+	// pg_dump may have compressed the dump already (backup.compress_in_db).
+	compressor, ok := driver.(database.DumpCompressor)
+	compressedInDB := ok && compressor.CompressesDump()
 
-	switch e.cfg.Compression {
-	case "gzip":
-		compressedFile := dumpFile + ".gz"
-		if err := compressGzip(dumpFile, compressedFile); err != nil {
-			result.Error = fmt.Errorf("compression failed: %w", err)
-			e.handleBackupError(result)
-			return result, result.Error
-		}
-		finalFile = compressedFile
-		info, _ := os.Stat(compressedFile)
-		finalSize = info.Size()
-	case "none":
-		finalFile = dumpFile
-		finalSize = result.Size
-	default:
-		finalFile = dumpFile
-		finalSize = result.Size
+	finalFile, finalSize, err := e.encode(ctx, logger, dumpFile, result.Size, compressedInDB)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to compress or encrypt backup: %w", err)
+		e.handleBackupError(ctx, logger, result)
+		return result, result.Error
 	}
 
 	result.CompressedSize = finalSize
 
-	checksum, err := postgres.CalculateChecksum(finalFile)
+	sum, err := checksum.File(finalFile, e.cfg.ChecksumAlgorithm())
 	if err != nil {
-		e.logger.Warn("failed to calculate checksum", "error", err)
+		logger.Warn("failed to calculate checksum", "error", err)
 	}
-	result.Checksum = checksum
+	result.Checksum = sum
 
 	f, err := os.Open(finalFile)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to open backup file: %w", err)
-		e.handleBackupError(result)
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
 	defer f.Close()
 
-	storagePath := filepath.Base(finalFile)
-	if err := e.storage.Write(ctx, storagePath, f); err != nil {
+	// The key replaces the backup ID in the file name, keeping its extension.
+	key := e.cfg.Storage.BackupKey(backupID, e.databaseName(), startTime)
+	storagePath := key + strings.TrimPrefix(filepath.Base(finalFile), backupID)
+	upload := progress.Track(ctx, logger, "upload", finalSize)
+	objectCtx := storage.WithObjectInfo(ctx, storage.ObjectInfo{BackupID: backupID, Database: e.databaseName(), Created: startTime})
+	if err := e.storage.Write(objectCtx, storagePath, upload.Reader(f)); err != nil {
 		result.Error = fmt.Errorf("failed to write backup to storage: %w", err)
-		e.handleBackupError(result)
+		e.handleBackupError(ctx, logger, result)
 		return result, result.Error
 	}
+	upload.Done()
 
-	dbName := e.cfg.Database.Name
-	if dbName == "" {
-		dbName = e.cfg.Database.Path
-	}
+	dbName := e.databaseName()
 	dbHost := e.cfg.Database.Host
 	if e.cfg.IsSQLite() {
 		dbHost = "local"
 	}
 	metadata := postgres.NewBackupMetadata(backupID, dbName, dbHost, dbVersion)
 	metadata.Backup.Method = driver.Type()
+	metadata.Backup.Format = driver.Format()
+	metadata.Backup.IncludeTables = e.cfg.Database.IncludeTables
+	metadata.Backup.ExcludeTables = e.cfg.Database.ExcludeTables
+	metadata.Backup.Mode = e.cfg.Backup.Mode
 	metadata.Backup.Compression = e.cfg.Compression
+	metadata.Backup.CompressionLevel = e.cfg.CompressionLevel
+	metadata.Backup.Encrypted = e.cfg.EncryptionKey() != nil
+	if compressedInDB {
+		metadata.Backup.Compression = "pg_dump"
+		metadata.Backup.CompressionLevel = e.cfg.DumpCompressionLevel()
+		metadata.Backup.CompressedInDB = true
+	}
+	metadata.Labels = labels
+	metadata.Schedule = e.schedule
+	metadata.OnDemand = onDemand
+	if walRange != nil {
+		metadata.Backup.Kind = postgres.KindBase
+		metadata.Backup.StartLSN = walRange.StartLSN
+		metadata.Backup.EndLSN = walRange.EndLSN
+		metadata.Backup.Timeline = walRange.Timeline
+	}
 
 	result.Duration = time.Since(startTime)
 	metadata.SetBackupInfo(result.Size, result.CompressedSize, result.Duration, result.Checksum)
+	result.CompressionRatio = metadata.Backup.CompressionRatio
+	result.ThroughputBytesPerSec = metadata.Backup.ThroughputBytesPerSec
+	metadata.Backup.ContentChecksum = dumped.contentChecksum
+	metadata.Backup.TableCounts = dumped.tableCounts
+	metadata.Backup.TableStats = dumped.tableStats
 
-	keepUntil, policy := e.rotator.GetRetentionInfo(startTime)
+	rotator := e.rotatorFor(e.schedule)
+	keepUntil, policy := rotator.GetRetentionInfo(startTime)
+	if rule, ok := rotator.Policy().RuleFor(metadata); ok {
+		keepUntil = rule.KeepUntil(startTime)
+	}
 	metadata.SetRetention(keepUntil, policy)
 	metadata.Type = policy
 	metadata.AddFile(storagePath)
+	// The signature covers Files, so the metadata lists its own path before
+	// it is signed and written; the copy kept in history then still verifies.
+	metaPath := key + postgres.MetadataExt(e.cfg.Backup.CompressMetadata)
+	metadata.AddFile(metaPath)
 
-	metaJSON, err := metadata.ToJSON()
-	if err != nil {
-		e.logger.Warn("failed to serialize metadata", "error", err)
-	} else {
-		metaPath := backupID + ".meta.json"
-		if err := e.storage.Write(ctx, metaPath, bytes.NewReader(metaJSON)); err != nil {
-			e.logger.Warn("failed to write metadata", "error", err)
-		}
-		metadata.AddFile(metaPath)
+	// Without its metadata the uploaded file is invisible to listing,
+	// restore and retention, so failing to write it fails the backup.
+	if err := e.writeMetadata(ctx, backupID, metaPath, metadata); err != nil {
+		result.Error = err
+		e.discardUpload(ctx, logger, storagePath, metaPath)
+		e.handleBackupError(ctx, logger, result)
+		return result, result.Error
+	}
+
+	// The cache only saves downloads, so a backup missing from it is fine.
+	if err := e.cache.Put(storagePath, finalFile); err != nil {
+		logger.Warn("failed to cache backup", "error", err)
 	}
 
 	// Verify backup if configured
 	if e.cfg.Backup.VerifyAfterBackup {
-		e.logger.Info("verifying backup integrity", "id", backupID)
-		validator := NewValidatorWithDBType(e.storage, e.logger, e.cfg.Database.Type)
-		if err := validator.VerifyRestoreIntegrity(ctx, metadata); err != nil {
+		logger.Info("verifying backup integrity")
+		if err := e.newRestoreValidator(logger).VerifyRestoreIntegrity(ctx, metadata); err != nil {
 			result.VerifyError = err
-			e.logger.Error("backup verification FAILED", "id", backupID, "error", err)
+			logger.Error("backup verification FAILED", "error", err)
 			// This is critical - the backup may be corrupted
 			if e.notifier != nil {
 				e.notifier.NotifyFailure(backupID, fmt.Errorf("backup verification failed: %w", err))
 			}
 		} else {
 			result.Verified = true
-			e.logger.Info("backup verified successfully", "id", backupID)
+			logger.Info("backup verified successfully")
+		}
+	}
+
+	// The backup is complete without its history line, so a failure to
+	// record it is only logged.
+	if !e.selfTest {
+		if err := e.appendHistory(ctx, metadata); err != nil {
+			logger.Error("failed to record backup in history", "error", err)
 		}
+		e.checkAnomalies(ctx, logger, metadata)
 	}
 
 	e.lastRun = startTime
 	e.lastError = nil
 
-	e.logger.Info("backup completed",
-		"id", backupID,
+	logger.Info("backup completed",
 		"size", result.Size,
 		"compressed_size", result.CompressedSize,
 		"duration", result.Duration,
+		"compression_ratio", result.CompressionRatio,
 		"type", metadata.Type,
 		"verified", result.Verified,
 	)
@@ -248,6 +434,10 @@ func (e *Engine) Run(ctx context.Context) (*BackupResult, error) {
 		e.notifier.NotifySuccess(backupID, result.Size, result.Duration)
 	}
 
+	if e.metrics != nil {
+		e.metrics.RecordBackupSuccess(metadata.Type, dbName, result.Duration, result.CompressedSize)
+	}
+
 	return result, nil
 }
 
@@ -258,66 +448,372 @@ func (e *Engine) Cleanup(ctx context.Context) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to list backups: %w", err)
 	}
+	planned := make(map[string]bool)
+	for _, d := range e.plan(backups) {
+		if !d.Keep {
+			planned[d.Metadata.ID] = true
+		}
+	}
+
+	// List again right before deleting and only delete what both views
+	// agree on, so a backup that finished, or one another instance removed,
+	// in the meantime is not judged by a stale listing.
+	var kept, doomed []*postgres.BackupMetadata
+	if len(planned) > 0 {
+		backups, err = e.ListBackups(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list backups: %w", err)
+		}
+	}
+	for _, d := range e.plan(backups) {
+		if d.Keep || !planned[d.Metadata.ID] {
+			kept = append(kept, d.Metadata)
+			continue
+		}
+		e.logger.Info("deleting old backup", "id", d.Metadata.ID)
+		doomed = append(doomed, d.Metadata)
+	}
 
-	toDelete := e.rotator.DetermineBackupsToDelete(backups)
+	// Data files go first and metadata only once they are gone, so a backup
+	// that cannot be fully deleted, e.g. because S3 object lock still
+	// retains it, stays listed and is retried by the next cleanup.
+	errs := make([]error, len(doomed))
+	e.deleteFiles(ctx, doomed, errs, dataPaths)
+	e.deleteFiles(ctx, doomed, errs, metadataPaths)
 
 	deletedCount := 0
-	for _, backup := range toDelete {
-		e.logger.Info("deleting old backup", "id", backup.ID)
-
-		for _, file := range backup.Files {
-			if err := e.storage.Delete(ctx, file); err != nil {
-				e.logger.Warn("failed to delete backup file", "file", file, "error", err)
-			}
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			deletedCount++
+		case errors.Is(err, storage.ErrObjectLocked):
+			kept = append(kept, doomed[i])
+			e.logger.Info("skipping backup still under object lock", "id", doomed[i].ID, "error", err)
+		default:
+			kept = append(kept, doomed[i])
+			e.logger.Warn("failed to delete backup", "id", doomed[i].ID, "error", err)
 		}
-		deletedCount++
 	}
 
-	e.logger.Info("cleanup completed", "deleted", deletedCount)
+	walDeleted, err := e.pruneWAL(ctx, kept)
+	if err != nil {
+		e.logger.Warn("failed to prune WAL archive", "error", err)
+	}
+
+	e.logger.Info("cleanup completed", "deleted", deletedCount, "wal_deleted", walDeleted)
 
 	return deletedCount, nil
 }
 
-func (e *Engine) ListBackups(ctx context.Context) ([]*postgres.BackupMetadata, error) {
-	files, err := e.storage.List(ctx, "")
+// PreviewCleanup returns the rotator's keep/delete decision for every backup
+// without deleting anything.
+func (e *Engine) PreviewCleanup(ctx context.Context) ([]rotation.Decision, error) {
+	backups, err := e.ListBackups(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to list backups: %w", err)
 	}
 
-	var backups []*postgres.BackupMetadata
+	return e.plan(backups), nil
+}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Path, ".meta.json") {
+// plan applies the retention policy. Each schedule's backups are rotated on
+// their own, under that schedule's policy, and with several databases
+// configured so are each database's, so one group's backups never push out
+// another's.
+func (e *Engine) plan(backups []*postgres.BackupMetadata) []rotation.Decision {
+	type group struct {
+		database string
+		schedule string
+	}
+	groups := make(map[group][]*postgres.BackupMetadata)
+	for _, b := range backups {
+		// SelfTest deletes its own backups; they must not take the place
+		// of a real one under retention meanwhile.
+		if IsSelfTest(b.ID) {
 			continue
 		}
+		g := group{schedule: b.Schedule}
+		if len(e.cfg.Databases) > 0 {
+			g.database = b.Database.Name
+		}
+		groups[g] = append(groups[g], b)
+	}
 
-		reader, err := e.storage.Read(ctx, file.Path)
-		if err != nil {
-			e.logger.Warn("failed to read metadata file", "path", file.Path, "error", err)
+	var decisions []rotation.Decision
+	for g, members := range groups {
+		decisions = append(decisions, e.rotatorFor(g.schedule).Plan(members)...)
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		return decisions[i].Metadata.Timestamp.After(decisions[j].Metadata.Timestamp)
+	})
+	return decisions
+}
+
+// ListBackups reads the metadata index under postgres.MetadataIndexPrefix.
+// Storage written before the index existed has none, so an empty index falls
+// back to scanning every object.
+func (e *Engine) ListBackups(ctx context.Context) ([]*postgres.BackupMetadata, error) {
+	backups, err := e.readMetadata(ctx, postgres.MetadataIndexPrefix)
+	if err != nil || len(backups) > 0 {
+		return backups, err
+	}
+	return e.readMetadata(ctx, "")
+}
+
+// Latest returns the most recent backup, or the most recent of backupType
+// (daily, weekly, monthly or yearly) when it is set.
+func (e *Engine) Latest(ctx context.Context, backupType string) (*postgres.BackupMetadata, error) {
+	switch rotation.BackupType(backupType) {
+	case "", rotation.BackupTypeDaily, rotation.BackupTypeWeekly, rotation.BackupTypeMonthly, rotation.BackupTypeYearly:
+	default:
+		return nil, fmt.Errorf("unknown backup type %q: must be daily, weekly, monthly or yearly", backupType)
+	}
+
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var latest *postgres.BackupMetadata
+	for _, b := range backups {
+		if IsSelfTest(b.ID) || (backupType != "" && b.Type != backupType) {
 			continue
 		}
+		if latest == nil || b.Timestamp.After(latest.Timestamp) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		if backupType != "" {
+			return nil, fmt.Errorf("no %s backups found", backupType)
+		}
+		return nil, fmt.Errorf("no backups found")
+	}
+	return latest, nil
+}
+
+// readMetadata parses every metadata file under prefix, gzipped or not, reading up to
+// storage.concurrency of them at once. The listing is streamed so only the
+// metadata paths are held. Files that cannot be read or parsed are logged
+// and skipped.
+func (e *Engine) readMetadata(ctx context.Context, prefix string) ([]*postgres.BackupMetadata, error) {
+	var paths []string
+	err := storage.Walk(ctx, e.storage, prefix, func(file storage.FileInfo) error {
+		if postgres.IsMetadataPath(file.Path) {
+			paths = append(paths, file.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]*postgres.BackupMetadata, len(paths))
+	errs := e.forEach(ctx, len(paths), func(i int) error {
+		reader, err := e.storage.Read(ctx, paths[i])
+		if err != nil {
+			return fmt.Errorf("failed to read metadata file: %w", err)
+		}
 
 		data, err := io.ReadAll(reader)
 		reader.Close()
 		if err != nil {
-			e.logger.Warn("failed to read metadata content", "path", file.Path, "error", err)
-			continue
+			return fmt.Errorf("failed to read metadata content: %w", err)
 		}
 
 		meta, err := postgres.ParseMetadata(data)
 		if err != nil {
-			e.logger.Warn("failed to parse metadata", "path", file.Path, "error", err)
-			continue
+			return fmt.Errorf("failed to parse metadata: %w", err)
 		}
+		metas[i] = meta
+		return nil
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
+	// Tampered metadata is still listed, so verify can report it and
+	// cleanup can remove it, but never silently trusted.
+	key := e.cfg.MetadataSigningKey()
+	var backups []*postgres.BackupMetadata
+	for i, meta := range metas {
+		if errs[i] != nil {
+			e.logger.Warn("skipping unreadable metadata", "path", paths[i], "error", errs[i])
+			continue
+		}
+		if key != nil {
+			if err := meta.VerifySignature(key); err != nil {
+				e.logger.Warn("backup metadata failed signature check", "id", meta.ID, "path", paths[i], "error", err)
+			}
+		}
 		backups = append(backups, meta)
 	}
 
 	return backups, nil
 }
 
+// forEach calls fn for each index below n, up to storage.concurrency at a
+// time, and returns fn's error for each index. Once ctx is canceled no
+// further calls are started; their errors are the cancellation cause.
+func (e *Engine) forEach(ctx context.Context, n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, e.cfg.Storage.Workers())
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			errs[i] = context.Cause(ctx)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// writeMetadata stores a backup's metadata at metaPath and in the index.
+func (e *Engine) writeMetadata(ctx context.Context, backupID, metaPath string, metadata *postgres.BackupMetadata) error {
+	if key := e.cfg.MetadataSigningKey(); key != nil {
+		if err := metadata.Sign(key); err != nil {
+			return fmt.Errorf("failed to sign metadata: %w", err)
+		}
+	}
+	metaJSON, err := metadata.Encode(e.cfg.Backup.CompressMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	objectCtx := storage.WithObjectInfo(ctx, storage.ObjectInfo{
+		BackupID: metadata.ID,
+		Database: metadata.Database.Name,
+		Created:  metadata.Timestamp,
+	})
+	if err := e.storage.Write(objectCtx, metaPath, bytes.NewReader(metaJSON)); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := e.writeMetadataIndex(ctx, backupID, metaJSON); err != nil {
+		return fmt.Errorf("failed to write metadata index: %w", err)
+	}
+	return nil
+}
+
+// discardUpload deletes what a failed backup already stored. Failures are
+// only logged; gc removes anything left behind.
+func (e *Engine) discardUpload(ctx context.Context, logger *slog.Logger, paths ...string) {
+	for _, p := range paths {
+		if err := e.storage.Delete(ctx, p); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			logger.Warn("failed to delete partial backup", "path", p, "error", err)
+		}
+	}
+}
+
+// writeMetadataIndex stores the index copy of a backup's metadata. The first
+// write into storage without an index also indexes the backups already there,
+// which ListBackups would otherwise stop seeing.
+func (e *Engine) writeMetadataIndex(ctx context.Context, backupID string, metaJSON []byte) error {
+	indexed := false
+	err := storage.Walk(ctx, e.storage, postgres.MetadataIndexPrefix, func(storage.FileInfo) error {
+		indexed = true
+		return storage.ErrStopWalk
+	})
+	if err != nil && !errors.Is(err, storage.ErrStopWalk) {
+		return err
+	}
+	if !indexed {
+		existing, err := e.readMetadata(ctx, "")
+		if err != nil {
+			return err
+		}
+		for _, meta := range existing {
+			if meta.ID == backupID {
+				continue
+			}
+			data, err := meta.Encode(e.cfg.Backup.CompressMetadata)
+			if err != nil {
+				return err
+			}
+			if err := e.storage.Write(ctx, e.metadataIndexPath(meta.ID), bytes.NewReader(data)); err != nil {
+				return err
+			}
+		}
+	}
+	return e.storage.Write(ctx, e.metadataIndexPath(backupID), bytes.NewReader(metaJSON))
+}
+
+// metadataIndexPath returns where the index copy of backup id's metadata
+// is written, gzipped with backup.compress_metadata.
+func (e *Engine) metadataIndexPath(id string) string {
+	return postgres.MetadataIndexPrefix + id + postgres.MetadataExt(e.cfg.Backup.CompressMetadata)
+}
+
+// deleteFiles deletes the files paths returns for each backup whose entry
+// in errs is still nil, concurrently, and records the first failure of each
+// backup in errs.
+func (e *Engine) deleteFiles(ctx context.Context, backups []*postgres.BackupMetadata, errs []error, paths func(*postgres.BackupMetadata) []string) {
+	type deletion struct {
+		backup int
+		path   string
+	}
+	var deletions []deletion
+	for i, b := range backups {
+		if errs[i] != nil {
+			continue
+		}
+		for _, p := range paths(b) {
+			deletions = append(deletions, deletion{backup: i, path: p})
+		}
+	}
+
+	for i, err := range e.forEach(ctx, len(deletions), func(i int) error {
+		return e.storage.Delete(ctx, deletions[i].path)
+	}) {
+		if err != nil && errs[deletions[i].backup] == nil {
+			errs[deletions[i].backup] = err
+		}
+	}
+}
+
+// dataPaths returns a backup's files other than its metadata.
+func dataPaths(backup *postgres.BackupMetadata) []string {
+	var paths []string
+	for _, f := range backup.Files {
+		if !postgres.IsMetadataPath(f) {
+			paths = append(paths, f)
+		}
+	}
+	return paths
+}
+
+// metadataPaths returns where a backup's metadata is stored: next to its
+// data file and in the index.
+func metadataPaths(backup *postgres.BackupMetadata) []string {
+	paths := postgres.MetadataIndexPaths(backup.ID)
+	if len(backup.Files) > 0 {
+		dir := path.Dir(backup.Files[0])
+		paths = append(paths,
+			path.Join(dir, backup.ID+postgres.MetadataExtGzip),
+			path.Join(dir, backup.ID+postgres.MetadataExtJSON))
+	}
+	return paths
+}
+
 func (e *Engine) GetBackup(ctx context.Context, backupID string) (*postgres.BackupMetadata, error) {
-	metaPath := backupID + ".meta.json"
+	metaPath, err := storage.Locate(ctx, e.storage, backupID+postgres.MetadataExtJSON, append(postgres.MetadataIndexPaths(backupID), backupID+postgres.MetadataExtGzip)...)
+	if err != nil {
+		return nil, fmt.Errorf("backup not found: %s", backupID)
+	}
 
 	reader, err := e.storage.Read(ctx, metaPath)
 	if err != nil {
@@ -341,16 +837,302 @@ func (e *Engine) LastError() error {
 	return e.lastError
 }
 
-func (e *Engine) handleBackupError(result *BackupResult) {
+func (e *Engine) handleBackupError(ctx context.Context, logger *slog.Logger, result *BackupResult) {
+	// A dump killed at the deadline only reports "signal: killed", so name
+	// the cancellation cause as well.
+	if ctx.Err() != nil {
+		result.Error = fmt.Errorf("%w: %w", context.Cause(ctx), result.Error)
+	}
+
 	e.lastError = result.Error
-	e.logger.Error("backup failed", "id", result.ID, "error", result.Error)
+	logger.Error("backup failed", "error", result.Error)
 
 	if e.notifier != nil {
 		e.notifier.NotifyFailure(result.ID, result.Error)
 	}
+
+	if e.metrics != nil {
+		_, backupType := e.rotatorFor(e.schedule).GetRetentionInfo(result.Timestamp)
+		e.metrics.RecordBackupFailure(backupType, e.databaseName())
+	}
+}
+
+func (e *Engine) driverConfig() database.Config {
+	return database.Config{
+		Type:     e.cfg.Database.Type,
+		Host:     e.cfg.Database.Host,
+		Port:     e.cfg.Database.Port,
+		Name:     e.cfg.Database.Name,
+		User:     e.cfg.Database.User,
+		Password: e.cfg.Database.Password,
+		URL:      e.cfg.Database.URL,
+		Path:     e.cfg.Database.Path,
+		DumpJobs: e.cfg.Database.DumpJobs,
+
+		IncludeTables: e.cfg.Database.IncludeTables,
+		ExcludeTables: e.cfg.Database.ExcludeTables,
+		Mode:          e.cfg.Backup.Mode,
+		SQLiteMethod:  e.cfg.Database.SQLiteMethod,
+		Method:        e.cfg.Backup.Method,
+		DumpFormat:    dumpFormat(e.cfg.Backup.Format),
+		CompressLevel: e.cfg.DumpCompressionLevel(),
+		TempDir:       e.cfg.Backup.TempDir,
+
+		SSLMode:     e.cfg.Database.SSLMode,
+		SSLRootCert: e.cfg.Database.SSLRootCert,
+		SSLCert:     e.cfg.Database.SSLCert,
+		SSLKey:      e.cfg.Database.SSLKey,
+
+		ConnectTimeout: e.cfg.Database.ConnectTimeoutSeconds,
+
+		PGDumpPath:       e.cfg.Database.PGDumpPath,
+		PGRestorePath:    e.cfg.Database.PGRestorePath,
+		PSQLPath:         e.cfg.Database.PSQLPath,
+		PGBasebackupPath: e.cfg.Database.PGBasebackupPath,
+		SQLite3Path:      e.cfg.Database.SQLite3Path,
+	}
+}
+
+// dumpFormat maps the backup.format setting to the driver's format.
+func dumpFormat(format string) string {
+	if format == "plain" {
+		return database.FormatSQL
+	}
+	return database.FormatCustom
+}
+
+// runPostBackupHooks passes the outcome of a backup to the post_backup hooks.
+// The backup is already finished either way, so hook failures are only logged.
+func (e *Engine) runPostBackupHooks(ctx context.Context, logger *slog.Logger, result *BackupResult) {
+	status := "success"
+	if result.Error != nil {
+		status = "failed"
+	}
+
+	env := []string{
+		"DATASAVER_BACKUP_ID=" + result.ID,
+		"DATASAVER_DATABASE=" + e.databaseName(),
+		"DATASAVER_BACKUP_STATUS=" + status,
+		"DATASAVER_BACKUP_SIZE=" + strconv.FormatInt(result.CompressedSize, 10),
+		"DATASAVER_BACKUP_DURATION_SECONDS=" + strconv.FormatFloat(result.Duration.Seconds(), 'f', 1, 64),
+	}
+	if result.Error != nil {
+		env = append(env, "DATASAVER_BACKUP_ERROR="+result.Error.Error())
+	}
+
+	if err := e.hooks.WithLogger(logger).Run(ctx, hooks.PostBackup, e.cfg.Hooks.PostBackup, env); err != nil {
+		logger.Error("post-backup hook failed", "error", err)
+	}
 }
 
-func compressGzip(src, dst string) error {
+// ErrInsufficientSpace is returned when the temp directory has too little
+// free space for the dump a backup is about to take.
+var ErrInsufficientSpace = errors.New("not enough free space for backup")
+
+// checkSpace estimates the temp space a dump of driver's database needs and
+// fails if dir has less free. A disk filling up mid-dump fails the backup
+// late and can take other services on the host down with it. When the size
+// or free space cannot be found the check is skipped, and the dump reports
+// connection errors itself.
+func (e *Engine) checkSpace(ctx context.Context, logger *slog.Logger, driver database.Driver, dir string) error {
+	sizer, ok := driver.(database.Sizer)
+	if !ok {
+		return nil
+	}
+
+	if err := driver.Connect(ctx); err != nil {
+		logger.Warn("skipping free space check", "error", err)
+		return nil
+	}
+	size, err := sizer.Size(ctx)
+	driver.Close()
+	if err != nil {
+		logger.Warn("skipping free space check", "error", err)
+		return nil
+	}
+
+	free, err := e.freeSpace(dir)
+	if err != nil {
+		logger.Warn("skipping free space check", "dir", dir, "error", err)
+		return nil
+	}
+
+	factor := e.cfg.Backup.SpaceFactor()
+	need := uint64(float64(size) * factor)
+	logger.Debug("checked free space", "dir", dir, "database_bytes", size, "needed_bytes", need, "free_bytes", free)
+	if free < need {
+		return fmt.Errorf("%w: %s has %d bytes free, need about %d (database %d bytes x %g)",
+			ErrInsufficientSpace, dir, free, need, size, factor)
+	}
+	return nil
+}
+
+// dumpInfo describes a finished dump.
+type dumpInfo struct {
+	version         string
+	walRange        *database.BaseBackupInfo // Base backups only
+	contentChecksum string                   // Of the dump as written, before compression
+	tableCounts     map[string]int64         // Rows in the sentinel tables
+	tableStats      map[string]int64         // Rows in every table, when collected
+}
+
+// dump connects with driver and dumps the database into file. The
+// connection is closed again and, on failure, the partial file removed, so
+// each retry starts clean.
+func (e *Engine) dump(ctx context.Context, logger *slog.Logger, driver database.Driver, file string) (_ *dumpInfo, err error) {
+	if err := driver.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer driver.Close()
+
+	info := &dumpInfo{}
+	info.version, err = driver.Version(ctx)
+	if err != nil {
+		logger.Warn("failed to get database version", "error", err)
+		info.version = "unknown"
+	}
+	info.tableCounts = e.countSentinelRows(ctx, logger, driver)
+	info.tableStats = e.collectStats(ctx, logger, driver)
+
+	out, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dump file: %w", err)
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(file)
+		}
+	}()
+
+	counter := progress.Track(ctx, logger, "dump", 0)
+	content, err := checksum.NewWriter(e.cfg.ChecksumAlgorithm())
+	if err != nil {
+		return nil, err
+	}
+	w := counter.Writer(io.MultiWriter(out, content))
+
+	// Base backups also report the WAL range they need.
+	if pg, ok := driver.(*database.PostgresDriver); ok && driver.Format() == database.FormatBaseBackup {
+		info.walRange, err = pg.BaseBackup(ctx, w)
+	} else {
+		err = driver.Dump(ctx, w)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database dump failed: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write dump file: %w", err)
+	}
+	counter.Done()
+	info.contentChecksum = content.Sum()
+	return info, nil
+}
+
+// countSentinelRows counts the rows of the configured sentinel tables, so a
+// restore of the backup can be checked against them. The counts are taken
+// just before dumping; writes in between can still make a restore differ.
+// Counting failures are logged and leave the backup without counts.
+func (e *Engine) countSentinelRows(ctx context.Context, logger *slog.Logger, driver database.Driver) map[string]int64 {
+	tables := e.cfg.Backup.SentinelTables
+	counter, ok := driver.(database.RowCounter)
+	if len(tables) == 0 || !ok || driver.Format() == database.FormatBaseBackup {
+		return nil
+	}
+
+	counts, err := counter.CountRows(ctx, tables)
+	if err != nil {
+		logger.Warn("failed to count sentinel table rows", "error", err)
+		return nil
+	}
+	return counts
+}
+
+// collectStats counts the rows of every table when backup.collect_stats is
+// set. Like the sentinel counts, failures are logged and leave the backup
+// without stats.
+func (e *Engine) collectStats(ctx context.Context, logger *slog.Logger, driver database.Driver) map[string]int64 {
+	collector, ok := driver.(database.StatsCollector)
+	if !e.cfg.Backup.CollectStats || !ok || driver.Format() == database.FormatBaseBackup {
+		return nil
+	}
+
+	stats, err := collector.TableStats(ctx)
+	if err != nil {
+		logger.Warn("failed to collect table statistics", "error", err)
+		return nil
+	}
+	return stats
+}
+
+// retryConfig returns how often and how patiently a failed dump is retried,
+// leaving out errors driver knows to be permanent.
+func (e *Engine) retryConfig(driver database.Driver) RetryConfig {
+	cfg := DefaultRetryConfig()
+	if classifier, ok := driver.(database.ErrorClassifier); ok {
+		cfg.IsPermanent = classifier.IsPermanentError
+	}
+	if e.cfg.Backup.MaxAttempts > 0 {
+		cfg.MaxAttempts = e.cfg.Backup.MaxAttempts
+	}
+	if wait := e.cfg.RetryWait(); wait > 0 {
+		cfg.InitialWait = wait
+	}
+	return cfg
+}
+
+// databaseName identifies the backed-up database in metadata and metrics.
+func (e *Engine) databaseName() string {
+	if e.cfg.Database.Name != "" {
+		return e.cfg.Database.Name
+	}
+	return e.cfg.Database.Path
+}
+
+// transforms returns the configured compression followed, when a key is set,
+// by encryption. Files that are already compressed skip the compression.
+func (e *Engine) transforms(compressed bool) (transform.Chain, error) {
+	var chain transform.Chain
+	if !compressed {
+		compression, err := transform.ForCompression(e.cfg.Compression, e.cfg.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, compression)
+	}
+	if key := e.cfg.EncryptionKey(); key != nil {
+		chain = append(chain, &transform.Encryption{Key: key})
+	}
+	return chain, nil
+}
+
+// encode applies the configured transforms to file, which is size bytes,
+// and returns the file to store and its size. A compressed file, such as a
+// dump pg_dump compressed itself, is only encrypted.
+func (e *Engine) encode(ctx context.Context, logger *slog.Logger, file string, size int64, compressed bool) (string, int64, error) {
+	chain, err := e.transforms(compressed)
+	if err != nil {
+		return "", 0, err
+	}
+
+	encoded := file
+	if suffix := chain.Suffix(); suffix != "" {
+		encoded = file + suffix
+		counter := progress.Track(ctx, logger, "compress", size)
+		if err := encodeFile(chain, file, encoded, counter); err != nil {
+			return "", 0, err
+		}
+		counter.Done()
+	}
+
+	info, err := os.Stat(encoded)
+	if err != nil {
+		return "", 0, err
+	}
+	return encoded, info.Size(), nil
+}
+
+func encodeFile(chain transform.Chain, src, dst string, counter *progress.Counter) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -363,9 +1145,32 @@ func compressGzip(src, dst string) error {
 	}
 	defer out.Close()
 
-	gw := gzip.NewWriter(out)
-	defer gw.Close()
+	w, err := chain.Wrap(out)
+	if err != nil {
+		return err
+	}
 
-	_, err = io.Copy(gw, in)
-	return err
+	if _, err := io.Copy(w, counter.Reader(in)); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// newRestoreValidator returns a validator for restore checks, restoring into
+// a scratch database when verify_scratch_restore is enabled.
+func (e *Engine) newRestoreValidator(logger *slog.Logger) *Validator {
+	validator := NewValidatorWithDBType(e.storage, logger, e.cfg.Database.Type)
+	if e.cfg.Backup.VerifyScratchRestore {
+		validator.SetScratchDatabase(e.cfg.Backup.ScratchDatabaseURL)
+	}
+	validator.SetEncryptionKey(e.cfg.EncryptionKey())
+	validator.SetMetadataSigningKey(e.cfg.MetadataSigningKey())
+	validator.SetTempDir(e.cfg.Backup.TempDir)
+	validator.SetCache(e.cache)
+	validator.SetBinaryPaths(e.cfg.Database.PGRestorePath, e.cfg.Database.PSQLPath, e.cfg.Database.SQLite3Path)
+	return validator
 }
@@ -9,11 +9,14 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/metrics"
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
 	_ "modernc.org/sqlite"
 )
 
@@ -63,7 +66,7 @@ func TestEngine_Integration_SQLiteBackup(t *testing.T) {
 	store := createLocalStorage(t, storagePath)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	engine := NewEngine(cfg, store, nil, logger)
+	engine := NewEngine(cfg, store, nil, nil, logger)
 
 	// Run backup
 	ctx := context.Background()
@@ -165,7 +168,7 @@ func TestEngine_Integration_SQLiteBackupWithCompression(t *testing.T) {
 	store := createLocalStorage(t, storagePath)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	engine := NewEngine(cfg, store, nil, logger)
+	engine := NewEngine(cfg, store, nil, nil, logger)
 
 	ctx := context.Background()
 	result, err := engine.Run(ctx)
@@ -232,7 +235,7 @@ func TestEngine_Integration_ListBackups(t *testing.T) {
 	store := createLocalStorage(t, storagePath)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	engine := NewEngine(cfg, store, nil, logger)
+	engine := NewEngine(cfg, store, nil, nil, logger)
 
 	ctx := context.Background()
 
@@ -300,7 +303,7 @@ func TestEngine_Integration_Cleanup(t *testing.T) {
 	store := createLocalStorage(t, storagePath)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	engine := NewEngine(cfg, store, nil, logger)
+	engine := NewEngine(cfg, store, nil, nil, logger)
 
 	ctx := context.Background()
 
@@ -365,7 +368,7 @@ func TestEngine_Integration_BackupMetadata(t *testing.T) {
 	store := createLocalStorage(t, storagePath)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	engine := NewEngine(cfg, store, nil, logger)
+	engine := NewEngine(cfg, store, nil, nil, logger)
 
 	ctx := context.Background()
 	result, err := engine.Run(ctx)
@@ -421,6 +424,96 @@ func TestEngine_Integration_BackupMetadata(t *testing.T) {
 		metadata.Database.Version, metadata.Backup.SizeBytes, metadata.Backup.Checksum)
 }
 
+func TestEngine_Integration_SQLiteOnlineBackup(t *testing.T) {
+	// The online backup API needs no sqlite3 CLI, so this runs everywhere.
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+	storagePath := filepath.Join(tmpDir, "backups")
+
+	createTestDB(t, dbPath)
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Type:         "sqlite",
+			Path:         dbPath,
+			SQLiteMethod: "backup",
+		},
+		Storage: config.StorageConfig{
+			Backend: "local",
+			Path:    storagePath,
+		},
+		Compression: "gzip",
+		Backup: config.BackupConfig{
+			VerifyAfterBackup: true,
+		},
+	}
+
+	store := createLocalStorage(t, storagePath)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(cfg, store, nil, nil, logger)
+
+	ctx := context.Background()
+	result, err := engine.Run(ctx)
+	if err != nil {
+		t.Fatalf("Engine.Run() error: %v", err)
+	}
+
+	backup, err := engine.GetBackup(ctx, result.ID)
+	if err != nil {
+		t.Fatalf("GetBackup() error: %v", err)
+	}
+	if backup.Backup.Format != "sqlite" {
+		t.Errorf("Backup.Format = %v, want sqlite", backup.Backup.Format)
+	}
+	if len(backup.Files) == 0 || filepath.Ext(strings.TrimSuffix(backup.Files[0], ".gz")) != ".db" {
+		t.Errorf("Backup files = %v, want a .db dump", backup.Files)
+	}
+
+	validator := NewValidatorWithDBType(store, logger, "sqlite")
+	if err := validator.VerifyRestoreIntegrity(ctx, backup); err != nil {
+		t.Errorf("VerifyRestoreIntegrity() error: %v", err)
+	}
+}
+
+func TestEngine_Integration_RecordsBackupMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	m := metrics.New("engine_ok")
+
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+	storagePath := filepath.Join(tmpDir, "backups")
+
+	createTestDB(t, dbPath)
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Type:         "sqlite",
+			Path:         dbPath,
+			SQLiteMethod: "backup",
+		},
+		Compression: "gzip",
+	}
+
+	store := createLocalStorage(t, storagePath)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := NewEngine(cfg, store, nil, m, logger)
+
+	if _, err := engine.Run(context.Background()); err != nil {
+		t.Fatalf("Engine.Run() error: %v", err)
+	}
+
+	if got := gatherValue(t, reg, "engine_ok_backups_total"); got != 1 {
+		t.Errorf("backups_total = %v, want 1", got)
+	}
+	if got := gatherValue(t, reg, "engine_ok_last_backup_success"); got != 1 {
+		t.Errorf("last_backup_success = %v, want 1", got)
+	}
+	if got := gatherValue(t, reg, "engine_ok_backup_size_bytes"); got <= 0 {
+		t.Errorf("backup_size_bytes = %v, want > 0", got)
+	}
+}
+
 // Helper functions
 
 func hasSQLite3CLI() bool {
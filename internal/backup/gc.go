@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// GCResult lists what GC found, and what it deleted unless it was a dry run.
+type GCResult struct {
+	// OrphanedFiles are backup and WAL files no metadata refers to, e.g.
+	// from a run that died between uploading the file and its metadata.
+	OrphanedFiles []storage.FileInfo
+	// DanglingMetadata are metadata files whose backup files are all gone.
+	DanglingMetadata []string
+
+	Deleted        int
+	ReclaimedBytes int64
+}
+
+// GC cross-references every object in storage against the backup and WAL
+// metadata. Files younger than minAge are left alone, since a backup in
+// progress uploads its file before its metadata. Only names datasaver writes
+// are considered, so unrelated objects in the same bucket are never touched.
+// Unless dryRun is set, orphaned files and dangling metadata are deleted.
+func (e *Engine) GC(ctx context.Context, minAge time.Duration, dryRun bool) (*GCResult, error) {
+	files, err := e.storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	present := make(map[string]bool, len(files))
+	for _, f := range files {
+		present[f.Path] = true
+	}
+
+	result := &GCResult{}
+	referenced := make(map[string]bool)
+	for _, f := range files {
+		if !isMetadataFile(f.Path) {
+			continue
+		}
+		meta, err := e.readMetadataFile(ctx, f.Path)
+		if err != nil {
+			// Without it there is no telling which files it refers to, so
+			// anything deleted now could be a live backup.
+			return nil, fmt.Errorf("failed to read metadata %s: %w", f.Path, err)
+		}
+
+		if len(meta.Files) == 0 {
+			continue
+		}
+		found := false
+		for _, file := range meta.Files {
+			referenced[file] = true
+			if present[file] {
+				found = true
+			}
+		}
+		if !found {
+			result.DanglingMetadata = append(result.DanglingMetadata, f.Path)
+		}
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		if isMetadataFile(f.Path) || referenced[f.Path] || !isBackupFile(f.Path) {
+			continue
+		}
+		if now.Sub(f.LastModified) < minAge {
+			continue
+		}
+		result.OrphanedFiles = append(result.OrphanedFiles, f)
+	}
+
+	sort.Slice(result.OrphanedFiles, func(i, j int) bool {
+		return result.OrphanedFiles[i].Path < result.OrphanedFiles[j].Path
+	})
+	sort.Strings(result.DanglingMetadata)
+
+	if dryRun {
+		return result, nil
+	}
+
+	for _, f := range result.OrphanedFiles {
+		if err := e.storage.Delete(ctx, f.Path); err != nil {
+			e.logger.Warn("failed to delete orphaned file", "path", f.Path, "error", err)
+			continue
+		}
+		result.Deleted++
+		result.ReclaimedBytes += f.Size
+	}
+	for _, p := range result.DanglingMetadata {
+		if err := e.storage.Delete(ctx, p); err != nil {
+			e.logger.Warn("failed to delete dangling metadata", "path", p, "error", err)
+			continue
+		}
+		result.Deleted++
+	}
+
+	e.logger.Info("gc completed",
+		"orphaned_files", len(result.OrphanedFiles),
+		"dangling_metadata", len(result.DanglingMetadata),
+		"deleted", result.Deleted,
+		"reclaimed_bytes", result.ReclaimedBytes,
+	)
+
+	return result, nil
+}
+
+// isMetadataFile reports whether p is backup or WAL metadata.
+func isMetadataFile(p string) bool {
+	return postgres.IsMetadataPath(p) || strings.HasSuffix(p, ".wal.json")
+}
+
+// isBackupFile reports whether p is named like a file datasaver stores:
+// an archived WAL file, or a backup, whose key always ends in its ID,
+// including one left behind by an interrupted SelfTest. WAL indexes are
+// not: cleanup removes them with their base backup.
+func isBackupFile(p string) bool {
+	if strings.HasPrefix(p, postgres.WALPrefix) {
+		_, index := walIndexBase(p)
+		return !index
+	}
+	base := path.Base(p)
+	return strings.HasPrefix(base, "backup_") || strings.HasPrefix(base, SelfTestPrefix)
+}
+
+// readMetadataFile parses the backup or WAL metadata at p.
+func (e *Engine) readMetadataFile(ctx context.Context, p string) (*postgres.BackupMetadata, error) {
+	reader, err := e.storage.Read(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return postgres.ParseMetadata(data)
+}
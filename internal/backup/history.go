@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// historyMu serializes appends to the history within this process. Storage
+// cannot append, so each one rewrites the object, and databases backed up
+// concurrently would otherwise drop each other's lines.
+var historyMu sync.Mutex
+
+// appendHistory adds metadata as a line at the end of the backup history.
+func (e *Engine) appendHistory(ctx context.Context, metadata *postgres.BackupMetadata) error {
+	line, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	data, err := e.readHistory(ctx)
+	if err != nil {
+		return err
+	}
+	data = append(data, line...)
+	data = append(data, '\n')
+
+	if err := e.storage.Write(ctx, postgres.HistoryPath, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write backup history: %w", err)
+	}
+	return nil
+}
+
+// History returns the metadata of every backup ever completed, oldest
+// first, including those cleanup has since deleted.
+func (e *Engine) History(ctx context.Context) ([]*postgres.BackupMetadata, error) {
+	data, err := e.readHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*postgres.BackupMetadata
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		meta, err := postgres.ParseMetadata(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("backup history line %d: %w", n, err)
+		}
+		history = append(history, meta)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read backup history: %w", err)
+	}
+	return history, nil
+}
+
+// readHistory returns the history object, or nil if there is none yet.
+func (e *Engine) readHistory(ctx context.Context) ([]byte, error) {
+	exists, err := e.storage.Exists(ctx, postgres.HistoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check backup history: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	reader, err := e.storage.Read(ctx, postgres.HistoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup history: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup history: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/storage"
+)
+
+// InstanceLockPath is the storage object a daemon holds while it runs.
+const InstanceLockPath = ".datasaver-daemon.lock"
+
+// ErrInstanceLocked is wrapped by AcquireInstanceLock's error when another
+// daemon holds the lock.
+var ErrInstanceLocked = errors.New("another daemon is running against this storage")
+
+// instanceLockSettle is how long AcquireInstanceLock waits after writing
+// the lock before reading it back, so of two daemons starting at once the
+// one whose write landed first wins.
+var instanceLockSettle = 2 * time.Second
+
+// instanceLease is the content of the lock object.
+type instanceLease struct {
+	Owner      string    `json:"owner"` // host:pid
+	Token      string    `json:"token"`
+	Started    time.Time `json:"started"`
+	Heartbeat  time.Time `json:"heartbeat"`
+	TTLSeconds float64   `json:"ttl_seconds"`
+}
+
+func (l *instanceLease) expires() time.Time {
+	return l.Heartbeat.Add(time.Duration(l.TTLSeconds * float64(time.Second)))
+}
+
+// InstanceLock is an advisory lock a daemon holds in storage so a second
+// daemon on the same storage, on this host or another, refuses to start
+// rather than run overlapping backups and cleanups. The holder rewrites
+// its heartbeat every third of the TTL; a lock whose heartbeat is older
+// than the TTL, left by a daemon that crashed, is taken over.
+type InstanceLock struct {
+	storage storage.Backend
+	logger  *slog.Logger
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	lease instanceLease
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// AcquireInstanceLock takes the instance lock for ttl and keeps it alive
+// until Release. It fails with ErrInstanceLocked, naming the holder, while
+// another daemon's lock is current.
+func (e *Engine) AcquireInstanceLock(ctx context.Context, ttl time.Duration) (*InstanceLock, error) {
+	host, _ := os.Hostname()
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	l := &InstanceLock{
+		storage: e.storage,
+		logger:  e.logger,
+		ttl:     ttl,
+		lease: instanceLease{
+			Owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+			Token:      hex.EncodeToString(token),
+			Started:    now,
+			Heartbeat:  now,
+			TTLSeconds: ttl.Seconds(),
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	held, err := l.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil && time.Now().Before(held.expires()) {
+		return nil, lockedError(held)
+	}
+	if held != nil {
+		e.logger.Warn("taking over expired instance lock", "owner", held.Owner, "heartbeat", held.Heartbeat)
+	}
+
+	if err := l.write(ctx); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(instanceLockSettle):
+	}
+	held, err = l.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if held == nil || held.Token != l.lease.Token {
+		if held == nil {
+			return nil, fmt.Errorf("%w: the lock disappeared while it was taken", ErrInstanceLocked)
+		}
+		return nil, lockedError(held)
+	}
+
+	e.logger.Info("instance lock acquired", "owner", l.lease.Owner, "ttl", ttl)
+	go l.heartbeat()
+	return l, nil
+}
+
+func lockedError(held *instanceLease) error {
+	return fmt.Errorf("%w: %s has held the lock since %s, last heartbeat %s ago; it expires at %s if that daemon has stopped",
+		ErrInstanceLocked, held.Owner, held.Started.Format(time.RFC3339),
+		time.Since(held.Heartbeat).Round(time.Second), held.expires().Format(time.RFC3339))
+}
+
+// heartbeat refreshes the lock until Release. It gives up, with an error
+// in the log, once another daemon has taken the lock over.
+func (l *InstanceLock) heartbeat() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		held, err := l.read(ctx)
+		if err == nil && held != nil && held.Token != l.lease.Token {
+			cancel()
+			l.logger.Error("instance lock taken over by another daemon; backups may overlap", "owner", held.Owner)
+			return
+		}
+		l.mu.Lock()
+		l.lease.Heartbeat = time.Now().UTC()
+		l.mu.Unlock()
+		if err := l.write(ctx); err != nil {
+			l.logger.Warn("failed to refresh instance lock", "error", err)
+		}
+		cancel()
+	}
+}
+
+// Release stops the heartbeat and deletes the lock if it is still this
+// daemon's.
+func (l *InstanceLock) Release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+
+	held, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if held == nil || held.Token != l.lease.Token {
+		return nil
+	}
+	if err := l.storage.Delete(ctx, InstanceLockPath); err != nil {
+		return fmt.Errorf("failed to release instance lock: %w", err)
+	}
+	return nil
+}
+
+// read returns the lease in storage, or nil when there is none. A lock
+// object that cannot be parsed is treated as expired.
+func (l *InstanceLock) read(ctx context.Context) (*instanceLease, error) {
+	reader, err := l.storage.Read(ctx, InstanceLockPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance lock: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance lock: %w", err)
+	}
+	var lease instanceLease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		l.logger.Warn("ignoring unreadable instance lock", "path", InstanceLockPath, "error", err)
+		return &instanceLease{Owner: "unknown"}, nil
+	}
+	return &lease, nil
+}
+
+// write stores the lease. It is rewritten on every heartbeat and deleted on
+// Release, so it is written without object lock retention: retained, each
+// heartbeat would leave an undeletable version and Release would fail,
+// keeping a restarted daemon out until the TTL expired.
+func (l *InstanceLock) write(ctx context.Context) error {
+	l.mu.Lock()
+	data, err := json.Marshal(l.lease)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := l.storage.Write(storage.WithoutRetention(ctx), InstanceLockPath, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write instance lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/storage"
+)
+
+// MigrateOptions controls a Migrate run.
+type MigrateOptions struct {
+	// DryRun lists what would be copied without copying it.
+	DryRun bool
+
+	// MaxAttempts bounds how often copying one object is tried when it
+	// fails with a transient error, waiting RetryWait and then twice as
+	// long each time. 0 uses 3 attempts and 1s; 1 disables retries.
+	MaxAttempts int
+	RetryWait   time.Duration
+}
+
+// retryConfig returns how a failed object copy is retried. An object gone
+// from the source is not worth retrying.
+func (o MigrateOptions) retryConfig() RetryConfig {
+	cfg := DefaultRetryConfig()
+	cfg.IsPermanent = func(err error) bool { return errors.Is(err, storage.ErrNotFound) }
+	if o.MaxAttempts > 0 {
+		cfg.MaxAttempts = o.MaxAttempts
+	}
+	if o.RetryWait > 0 {
+		cfg.InitialWait = o.RetryWait
+	}
+	return cfg
+}
+
+// MigrateResult lists what Migrate copied, or would copy on a dry run.
+type MigrateResult struct {
+	Copied  []storage.FileInfo
+	Skipped int // Already at the destination with the same size and checksum
+	Bytes   int64
+
+	// Retried counts the objects that copied only after a retry.
+	Retried int
+
+	// Failed maps the path of each object that could not be copied to the
+	// reason. Metadata whose files failed is held back and listed here too.
+	Failed map[string]error
+}
+
+// checksummer is implemented by backends that record a checksum of each
+// object on write, such as S3Storage, so a copy can be verified without
+// reading it back.
+type checksummer interface {
+	Checksum(ctx context.Context, path string) (string, error)
+}
+
+// Migrate copies every object in the engine's storage to dst, up to
+// storage.concurrency at a time, and verifies each copy's size and
+// checksum. A copy that fails with a transient error is retried with
+// backoff; one that still fails is recorded in the result and the
+// migration carries on with the next object. Objects already at dst with
+// the same size, and the same checksum when both backends record one, are
+// skipped, so running the same migration again resumes it. Backup and WAL
+// files are copied before the metadata that refers to them, and metadata
+// is held back if any of its files failed, so dst never lists a backup it
+// cannot restore.
+func (e *Engine) Migrate(ctx context.Context, dst storage.Backend, opts MigrateOptions) (*MigrateResult, error) {
+	files, err := e.storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source storage: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var data, metadata []storage.FileInfo
+	for _, f := range files {
+		// A daemon's instance lock belongs to the source; copied, it would
+		// keep a daemon on dst from starting until it expired.
+		if f.IsDir || f.Path == InstanceLockPath {
+			continue
+		}
+		if isMetadataFile(f.Path) {
+			metadata = append(metadata, f)
+		} else {
+			data = append(data, f)
+		}
+	}
+
+	result := &MigrateResult{Failed: make(map[string]error)}
+	e.migrateFiles(ctx, dst, data, opts, result)
+
+	var ready []storage.FileInfo
+	for _, f := range metadata {
+		meta, err := e.readMetadataFile(ctx, f.Path)
+		if err != nil {
+			result.Failed[f.Path] = fmt.Errorf("failed to read metadata: %w", err)
+			continue
+		}
+		held := false
+		for _, file := range meta.Files {
+			if err, ok := result.Failed[file]; ok {
+				result.Failed[f.Path] = fmt.Errorf("not copied because %s failed: %w", file, err)
+				held = true
+				break
+			}
+		}
+		if !held {
+			ready = append(ready, f)
+		}
+	}
+	e.migrateFiles(ctx, dst, ready, opts, result)
+
+	e.logger.Info("migration completed",
+		"copied", len(result.Copied),
+		"skipped", result.Skipped,
+		"retried", result.Retried,
+		"failed", len(result.Failed),
+		"bytes", result.Bytes,
+		"dry_run", opts.DryRun,
+	)
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("%d of %d objects failed to migrate", len(result.Failed), len(data)+len(metadata))
+	}
+	return result, nil
+}
+
+// migrateFiles copies files to dst concurrently and records the outcome of
+// each in result, in the order of files.
+func (e *Engine) migrateFiles(ctx context.Context, dst storage.Backend, files []storage.FileInfo, opts MigrateOptions, result *MigrateResult) {
+	retry := opts.retryConfig()
+	skipped := make([]bool, len(files))
+	attempts := make([]int, len(files))
+	errs := e.forEach(ctx, len(files), func(i int) error {
+		f := files[i]
+		if e.alreadyMigrated(ctx, dst, f) {
+			skipped[i] = true
+			return nil
+		}
+		if opts.DryRun {
+			return nil
+		}
+		_, err := WithRetry(ctx, retry, e.logger, "migrate "+f.Path, func() (struct{}, error) {
+			attempts[i]++
+			return struct{}{}, e.copyObject(ctx, dst, f)
+		})
+		return err
+	})
+
+	for i, f := range files {
+		switch {
+		case errs[i] != nil:
+			e.logger.Warn("failed to migrate object", "path", f.Path, "attempts", attempts[i], "error", errs[i])
+			result.Failed[f.Path] = errs[i]
+		case skipped[i]:
+			result.Skipped++
+		default:
+			result.Copied = append(result.Copied, f)
+			result.Bytes += f.Size
+			if attempts[i] > 1 {
+				result.Retried++
+			}
+		}
+	}
+}
+
+// alreadyMigrated reports whether dst holds a copy of f: an object of the
+// same size whose checksum matches when both backends record one. Anything
+// else, including an error looking at dst, is copied again.
+func (e *Engine) alreadyMigrated(ctx context.Context, dst storage.Backend, f storage.FileInfo) bool {
+	size, err := dst.Size(ctx, f.Path)
+	if err != nil || size != f.Size {
+		return false
+	}
+
+	src, srcOK := e.storage.(checksummer)
+	dstSums, dstOK := dst.(checksummer)
+	if !srcOK || !dstOK {
+		return true
+	}
+	want, err := src.Checksum(ctx, f.Path)
+	if err != nil {
+		return false
+	}
+	got, err := dstSums.Checksum(ctx, f.Path)
+	if err != nil {
+		return false
+	}
+	return want == "" || got == "" || got == want
+}
+
+// copyObject streams f from the engine's storage to dst and checks that the
+// copy has f's size and the checksum of what was read.
+func (e *Engine) copyObject(ctx context.Context, dst storage.Backend, f storage.FileInfo) error {
+	reader, err := e.storage.Read(ctx, f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read: %w", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if err := dst.Write(ctx, f.Path, io.TeeReader(reader, hash)); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	want := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	size, err := dst.Size(ctx, f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to check copy: %w", err)
+	}
+	if size != f.Size {
+		return fmt.Errorf("copy is %d bytes, source is %d", size, f.Size)
+	}
+
+	got, err := destinationChecksum(ctx, dst, f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to check copy: %w", err)
+	}
+	if got != "" && got != want {
+		return fmt.Errorf("copy checksum %s does not match source %s", got, want)
+	}
+	return nil
+}
+
+// destinationChecksum returns the checksum dst recorded for p, or hashes the
+// object when dst records none.
+func destinationChecksum(ctx context.Context, dst storage.Backend, p string) (string, error) {
+	if c, ok := dst.(checksummer); ok {
+		if sum, err := c.Checksum(ctx, p); err != nil || sum != "" {
+			return sum, err
+		}
+	}
+
+	reader, err := dst.Read(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/hooks"
+)
+
+// PartialError is returned when some of several databases were backed up
+// and others failed. Err joins the failures.
+type PartialError struct {
+	Failed int
+	Total  int
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d databases failed: %v", e.Failed, e.Total, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// IsPartial reports whether err is a PartialError: the run backed up at
+// least one database.
+func IsPartial(err error) bool {
+	var partial *PartialError
+	return errors.As(err, &partial)
+}
+
+// RunAll backs up every configured database, up to backup.concurrency at a
+// time, and returns one result per database in config order. A failed
+// database does not stop the others; their errors are joined in the returned
+// error, which is a *PartialError when some databases succeeded. Once ctx is
+// canceled no further databases are started; they are reported as failed.
+// While another backup is running it returns no results and an error
+// wrapping oplock.ErrInProgress.
+//
+// RunAll takes on-demand backups, for the backup command and the
+// backup_now tool; the scheduler uses RunSchedule. Once
+// backup.max_on_demand_per_hour were taken it returns no results and an
+// error wrapping ErrRateLimited.
+func (e *Engine) RunAll(ctx context.Context, labels map[string]string) ([]*BackupResult, error) {
+	release, err := e.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := e.checkOnDemandLimit(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	return e.runAll(ctx, labels, true)
+}
+
+func (e *Engine) runAll(ctx context.Context, labels map[string]string, onDemand bool) ([]*BackupResult, error) {
+	if len(e.cfg.Databases) == 0 {
+		result, err := e.runWithLabels(ctx, labels, onDemand)
+		return []*BackupResult{result}, err
+	}
+
+	targets := e.cfg.DatabaseTargets()
+	workers := e.cfg.Backup.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	startTime := time.Now()
+	results := make([]*BackupResult, len(targets))
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		name := target.DisplayName()
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			errs[i] = fmt.Errorf("database %s: not started: %w", name, context.Cause(ctx))
+			results[i] = &BackupResult{Database: name, Timestamp: startTime, Error: errs[i]}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, err := e.forDatabase(target).runWithLabels(ctx, labels, onDemand)
+			results[i] = result
+			if err != nil {
+				errs[i] = fmt.Errorf("database %s: %w", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+		}
+	}
+	if failed < len(results) {
+		e.lastRun = startTime
+		if err != nil {
+			err = &PartialError{Failed: failed, Total: len(results), Err: err}
+		}
+	}
+	e.lastError = err
+
+	return results, err
+}
+
+// RunSchedule backs up every configured database like RunAll, as the
+// schedules entry s: with its mode and compression, and with its name in
+// each backup's ID and metadata.
+func (e *Engine) RunSchedule(ctx context.Context, s config.ScheduleEntry) ([]*BackupResult, error) {
+	release, err := e.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if s.Name == "" {
+		return e.runAll(ctx, nil, false)
+	}
+
+	run := e.derive(e.cfg.ForSchedule(s), e.logger.With("schedule", s.Name))
+	run.schedule = s.Name
+	run.idSuffix = e.idSuffix
+
+	results, err := run.runAll(ctx, nil, false)
+	if lastRun := run.LastRun(); !lastRun.IsZero() {
+		e.lastRun = lastRun
+	}
+	e.lastError = err
+	return results, err
+}
+
+// forDatabase returns an engine that backs up d alone, sharing this
+// engine's storage, retention, notifier, metrics and cache.
+func (e *Engine) forDatabase(d config.DatabaseConfig) *Engine {
+	cfg := *e.cfg
+	cfg.Database = d
+	cfg.Databases = nil
+
+	name := d.DisplayName()
+	run := e.derive(&cfg, e.logger.With("database", name))
+	run.schedule = e.schedule
+	run.idSuffix = idSafe.ReplaceAllString(filepath.Base(name), "-")
+	return run
+}
+
+// derive returns an engine running with cfg that shares this engine's
+// storage, retention, notifier, metrics and cache.
+func (e *Engine) derive(cfg *config.Config, logger *slog.Logger) *Engine {
+	e.rotatorMu.RLock()
+	defer e.rotatorMu.RUnlock()
+	return &Engine{
+		cfg:              cfg,
+		storage:          e.storage,
+		rotator:          e.rotator,
+		scheduleRotators: e.scheduleRotators,
+		notifier:         e.notifier,
+		metrics:          e.metrics,
+		hooks:            hooks.NewRunner(cfg.HookTimeout(), logger),
+		logger:           logger,
+		cache:            e.cache,
+		freeSpace:        e.freeSpace,
+	}
+}
+
+// idSafe matches characters kept out of backup IDs, which become file names.
+var idSafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
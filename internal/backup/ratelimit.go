@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrRateLimited is wrapped by RunAll's error when on-demand backups hit
+// backup.max_on_demand_per_hour.
+var ErrRateLimited = errors.New("rate limited")
+
+// onDemandWindow is the period backup.max_on_demand_per_hour counts over.
+const onDemandWindow = time.Hour
+
+// checkOnDemandLimit fails with ErrRateLimited when any database already
+// has backup.max_on_demand_per_hour on-demand backups taken in the hour
+// before now, saying when the next one is allowed. Scheduled backups are
+// not counted, so a runaway caller cannot starve them either.
+func (e *Engine) checkOnDemandLimit(ctx context.Context, now time.Time) error {
+	limit := e.cfg.Backup.MaxOnDemandPerHour
+	if limit <= 0 {
+		return nil
+	}
+
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list backups for the on-demand limit: %w", err)
+	}
+
+	recent := make(map[string][]time.Time)
+	for _, b := range backups {
+		if b.OnDemand && now.Sub(b.Timestamp) < onDemandWindow {
+			recent[b.Database.Name] = append(recent[b.Database.Name], b.Timestamp)
+		}
+	}
+
+	var limited string
+	var retryAt time.Time
+	for name, times := range recent {
+		if len(times) < limit {
+			continue
+		}
+		// Another backup is allowed once all but limit-1 of them are
+		// older than the window.
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		at := times[len(times)-limit].Add(onDemandWindow)
+		if at.After(retryAt) {
+			limited, retryAt = name, at
+		}
+	}
+	if limited == "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s already has %d on-demand backups in the last hour (backup.max_on_demand_per_hour is %d); try again at %s",
+		ErrRateLimited, limited, len(recent[limited]), limit, retryAt.Local().Format(time.RFC3339))
+}
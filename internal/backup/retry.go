@@ -12,6 +12,10 @@ type RetryConfig struct {
 	InitialWait time.Duration
 	MaxWait     time.Duration
 	Multiplier  float64
+
+	// IsPermanent, when set, recognizes further errors not worth
+	// retrying, in addition to the generic ones isRetryable knows.
+	IsPermanent func(error) bool
 }
 
 func DefaultRetryConfig() RetryConfig {
@@ -42,7 +46,7 @@ func WithRetry[T any](ctx context.Context, cfg RetryConfig, logger *slog.Logger,
 
 		lastErr = err
 
-		if !isRetryable(err) {
+		if !cfg.retryable(err) {
 			return zero, err
 		}
 
@@ -71,6 +75,13 @@ func WithRetry[T any](ctx context.Context, cfg RetryConfig, logger *slog.Logger,
 	return zero, lastErr
 }
 
+func (cfg RetryConfig) retryable(err error) bool {
+	if cfg.IsPermanent != nil && err != nil && cfg.IsPermanent(err) {
+		return false
+	}
+	return isRetryable(err)
+}
+
 func isRetryable(err error) bool {
 	if err == nil {
 		return false
@@ -2,32 +2,126 @@ package backup
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
+
+	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/oplock"
 )
 
 type Scheduler struct {
-	engine   *Engine
-	cron     *cron.Cron
-	schedule string
-	logger   *slog.Logger
-	mu       sync.RWMutex
-	running  bool
-	nextRun  time.Time
+	engine    *Engine
+	cron      *cron.Cron
+	schedules []config.ScheduleEntry
+	logger    *slog.Logger
+	mu        sync.RWMutex
+	running   bool
+	jobs      []*job
+
+	drill         *RestoreDrill
+	drillSchedule string
+	drillEntryID  cron.EntryID
+
+	catchUp     bool
+	autoCleanup bool
+	keepGoing   bool
+	backupMu    sync.Mutex // Held while a backup runs so jobs firing together run one after another
+
+	jitterMin time.Duration
+	jitterMax time.Duration
+	stop      chan struct{} // Closed by Stop to cut a jitter delay short
+}
+
+// job is one scheduled backup.
+type job struct {
+	schedule config.ScheduleEntry
+	entryID  cron.EntryID
+	running  sync.Mutex // Held while the job's backup runs so a slow run skips its next one
+}
+
+// ScheduledRun is the next run of one backup schedule.
+type ScheduledRun struct {
+	Name string // Empty for the default schedule
+	Cron string
+	Next time.Time
 }
 
+// cronParser parses the job schedules. Configured schedules are five-field
+// expressions; a leading seconds field of 0 is added before parsing.
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// NewScheduler runs engine on a single cron schedule; SetSchedules replaces
+// it with several.
 func NewScheduler(engine *Engine, schedule string, logger *slog.Logger) *Scheduler {
 	return &Scheduler{
-		engine:   engine,
-		schedule: schedule,
-		logger:   logger,
-		cron:     cron.New(cron.WithSeconds()),
+		engine:    engine,
+		schedules: []config.ScheduleEntry{{Cron: schedule}},
+		logger:    logger,
+		cron:      cron.New(cron.WithParser(cronParser)),
+
+		autoCleanup: true,
 	}
 }
 
+// SetSchedules replaces the backup jobs with one per entry. It must be
+// called before Start; use Reschedule afterwards.
+func (s *Scheduler) SetSchedules(schedules []config.ScheduleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = schedules
+}
+
+// SetRestoreDrill runs drill on its own cron schedule alongside the backup
+// job. An empty schedule leaves the drill unscheduled until Reschedule sets
+// one. It must be called before Start.
+func (s *Scheduler) SetRestoreDrill(schedule string, drill *RestoreDrill) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drillSchedule = schedule
+	s.drill = drill
+}
+
+// SetCatchUp enables running a backup on Start when the most recent scheduled
+// run was missed, e.g. because the daemon was down. It must be called before
+// Start.
+func (s *Scheduler) SetCatchUp(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.catchUp = enabled
+}
+
+// SetAutoCleanup sets whether each successful scheduled backup is followed
+// by a retention cleanup. With it off, backups are only deleted by running
+// cleanup by hand or from another scheduler.
+func (s *Scheduler) SetAutoCleanup(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoCleanup = enabled
+}
+
+// SetKeepGoing sets whether a scheduled run where only some databases
+// failed is still followed by a retention cleanup.
+func (s *Scheduler) SetKeepGoing(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepGoing = enabled
+}
+
+// SetJitter delays each scheduled backup by a random duration between low
+// and high, drawn anew for every run. Catch-up runs start immediately.
+func (s *Scheduler) SetJitter(low, high time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jitterMin = low
+	s.jitterMax = high
+}
+
 func (s *Scheduler) Start(ctx context.Context) error {
 	s.mu.Lock()
 	if s.running {
@@ -35,54 +129,170 @@ func (s *Scheduler) Start(ctx context.Context) error {
 		return nil
 	}
 	s.running = true
+	s.stop = make(chan struct{})
+	schedules, drillSchedule, catchUp := s.schedules, s.drillSchedule, s.catchUp
 	s.mu.Unlock()
 
-	schedule := "0 " + s.schedule
-
-	entryID, err := s.cron.AddFunc(schedule, func() {
-		s.runBackup(ctx)
-	})
-	if err != nil {
+	if err := s.Reschedule(ctx, schedules, drillSchedule); err != nil {
 		return err
 	}
 
 	s.cron.Start()
 
-	entry := s.cron.Entry(entryID)
-	s.mu.Lock()
-	s.nextRun = entry.Next
-	s.mu.Unlock()
+	s.mu.RLock()
+	drillEntryID := s.drillEntryID
+	s.mu.RUnlock()
 
-	s.logger.Info("scheduler started",
-		"schedule", s.schedule,
-		"next_run", s.nextRun,
-	)
+	s.logger.Info("scheduler started", "next_run", s.NextRun())
+	for _, run := range s.NextRuns() {
+		s.logger.Info("backup scheduled",
+			"name", run.Name,
+			"schedule", run.Cron,
+			"next_run", run.Next,
+		)
+	}
+
+	if drillEntryID != 0 {
+		s.logger.Info("restore drill scheduled",
+			"schedule", drillSchedule,
+			"next_run", s.cron.Entry(drillEntryID).Next,
+		)
+	}
+
+	if catchUp {
+		go s.runCatchUp(ctx)
+	}
 
 	return nil
 }
 
-func (s *Scheduler) Stop() {
+// Reschedule replaces the backup jobs and the restore drill schedule. All
+// are parsed before any job is replaced, so an invalid schedule leaves the
+// current ones running. A job keeps running under the same name, so a
+// backup in progress still skips its next run. An empty drillSchedule
+// removes the drill job.
+func (s *Scheduler) Reschedule(ctx context.Context, schedules []config.ScheduleEntry, drillSchedule string) error {
+	backupScheds := make([]cron.Schedule, len(schedules))
+	for i, sched := range schedules {
+		parsed, err := cronParser.Parse("0 " + sched.Cron)
+		if err != nil {
+			if sched.Name != "" {
+				return fmt.Errorf("invalid backup schedule %s: %w", sched.Name, err)
+			}
+			return fmt.Errorf("invalid backup schedule: %w", err)
+		}
+		backupScheds[i] = parsed
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var drillSched cron.Schedule
+	if s.drill != nil && drillSchedule != "" {
+		var err error
+		drillSched, err = cronParser.Parse("0 " + drillSchedule)
+		if err != nil {
+			return fmt.Errorf("invalid verify_restore schedule: %w", err)
+		}
+	}
+
+	current := make(map[string]*job, len(s.jobs))
+	for _, j := range s.jobs {
+		s.cron.Remove(j.entryID)
+		current[j.schedule.Name] = j
+	}
+
+	jobs := make([]*job, len(schedules))
+	for i, sched := range schedules {
+		j := current[sched.Name]
+		if j == nil {
+			j = &job{}
+		}
+		j.schedule = sched
+		j.entryID = s.cron.Schedule(backupScheds[i], cron.FuncJob(func() {
+			if s.waitJitter(ctx, j) {
+				s.runBackup(ctx, j)
+			}
+		}))
+		jobs[i] = j
+	}
+	s.jobs = jobs
+	s.schedules = schedules
+
+	if s.drillEntryID != 0 {
+		s.cron.Remove(s.drillEntryID)
+		s.drillEntryID = 0
+	}
+	if drillSched != nil {
+		s.drillEntryID = s.cron.Schedule(drillSched, cron.FuncJob(func() {
+			s.runRestoreDrill(ctx)
+		}))
+	}
+	s.drillSchedule = drillSchedule
+
+	return nil
+}
+
+// Stop waits for running jobs to finish. The lock is released first, since
+// the jobs take it too.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
 	if !s.running {
+		s.mu.Unlock()
 		return
 	}
+	s.running = false
+	close(s.stop)
+	s.mu.Unlock()
 
 	ctx := s.cron.Stop()
 	<-ctx.Done()
-	s.running = false
 	s.logger.Info("scheduler stopped")
 }
 
-func (s *Scheduler) RunNow(ctx context.Context) (*BackupResult, error) {
-	return s.engine.Run(ctx)
+func (s *Scheduler) RunNow(ctx context.Context) ([]*BackupResult, error) {
+	return s.engine.RunAll(ctx, nil)
 }
 
+// NextRun returns the soonest next run across all backup jobs. Jitter is
+// not included; it is applied when a job fires.
 func (s *Scheduler) NextRun() time.Time {
+	var next time.Time
+	for _, run := range s.NextRuns() {
+		if next.IsZero() || (!run.Next.IsZero() && run.Next.Before(next)) {
+			next = run.Next
+		}
+	}
+	return next
+}
+
+// NextRuns returns the next run of each backup job, in config order.
+func (s *Scheduler) NextRuns() []ScheduledRun {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.nextRun
+
+	runs := make([]ScheduledRun, len(s.jobs))
+	for i, j := range s.jobs {
+		runs[i] = ScheduledRun{
+			Name: j.schedule.Name,
+			Cron: j.schedule.Cron,
+			Next: s.cron.Entry(j.entryID).Next,
+		}
+	}
+	return runs
+}
+
+// UpcomingRuns returns the next run after now of each of schedules, for
+// callers without a running Scheduler. Invalid schedules get a zero Next.
+func UpcomingRuns(schedules []config.ScheduleEntry, now time.Time) []ScheduledRun {
+	runs := make([]ScheduledRun, len(schedules))
+	for i, sched := range schedules {
+		runs[i] = ScheduledRun{Name: sched.Name, Cron: sched.Cron}
+		if spec, err := cronParser.Parse("0 " + sched.Cron); err == nil {
+			runs[i].Next = spec.Next(now)
+		}
+	}
+	return runs
 }
 
 func (s *Scheduler) IsRunning() bool {
@@ -91,26 +301,172 @@ func (s *Scheduler) IsRunning() bool {
 	return s.running
 }
 
-func (s *Scheduler) runBackup(ctx context.Context) {
-	s.logger.Info("scheduled backup starting")
+func (s *Scheduler) runBackup(ctx context.Context, j *job) {
+	logger := s.logger
+	if j.schedule.Name != "" {
+		logger = logger.With("schedule", j.schedule.Name)
+	}
 
-	result, err := s.engine.Run(ctx)
-	if err != nil {
-		s.logger.Error("scheduled backup failed", "error", err)
-	} else {
-		s.logger.Info("scheduled backup completed", "id", result.ID)
+	if !j.running.TryLock() {
+		logger.Warn("backup already in progress, skipping scheduled run")
+		return
+	}
+	defer j.running.Unlock()
+
+	// Jobs firing at the same time queue here rather than dumping the
+	// database side by side.
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	logger.Info("scheduled backup starting")
+
+	results, err := s.engine.RunSchedule(ctx, j.schedule)
+	if errors.Is(err, oplock.ErrInProgress) {
+		logger.Warn("another backup is in progress, skipping scheduled run")
+		return
+	}
+	for _, result := range results {
+		if result != nil && result.Error == nil {
+			logger.Info("scheduled backup completed", "id", result.ID)
+		}
+	}
+	s.mu.RLock()
+	keepGoing := s.keepGoing
+	s.mu.RUnlock()
+
+	switch {
+	case err != nil && IsPartial(err) && keepGoing:
+		logger.Warn("scheduled backup partially failed, cleaning up anyway", "error", err)
+	case err != nil:
+		// Pruning now would shrink the safety margin just when the newest
+		// backups may be the last good ones.
+		logger.Error("scheduled backup failed, skipping cleanup", "error", err)
+		return
+	}
+
+	s.mu.RLock()
+	autoCleanup := s.autoCleanup
+	s.mu.RUnlock()
+	if !autoCleanup {
+		return
 	}
 
 	_, err = s.engine.Cleanup(ctx)
 	if err != nil {
-		s.logger.Error("cleanup after backup failed", "error", err)
+		logger.Error("cleanup after backup failed", "error", err)
 	}
+}
 
-	entries := s.cron.Entries()
-	if len(entries) > 0 {
-		s.mu.Lock()
-		s.nextRun = entries[0].Next
-		s.mu.Unlock()
+// waitJitter sleeps for the jitter delay before j's scheduled backup. It
+// returns false if the scheduler stops or ctx is cancelled meanwhile.
+func (s *Scheduler) waitJitter(ctx context.Context, j *job) bool {
+	s.mu.RLock()
+	low, high, stop := s.jitterMin, s.jitterMax, s.stop
+	next := s.cron.Entry(j.entryID).Next
+	s.mu.RUnlock()
+
+	delay := jitterDelay(low, high, time.Until(next))
+	if delay <= 0 {
+		return true
+	}
+
+	s.logger.Info("delaying scheduled backup", "jitter", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// jitterDelay draws a delay in [low, high]. It is capped at half of
+// untilNext, the time until the following scheduled run, so that a delayed
+// backup never slips into the next window and keeps the other half to finish.
+func jitterDelay(low, high, untilNext time.Duration) time.Duration {
+	if high <= 0 {
+		return 0
+	}
+	delay := low
+	if high > low {
+		delay += rand.N(high - low + 1)
+	}
+	if limit := untilNext / 2; delay > limit {
+		delay = max(limit, 0)
+	}
+	return delay
+}
+
+// runCatchUp runs, one after another, each job whose last scheduled run was
+// missed.
+func (s *Scheduler) runCatchUp(ctx context.Context) {
+	s.mu.RLock()
+	jobs := s.jobs
+	s.mu.RUnlock()
+
+	for _, j := range jobs {
+		missed, err := s.missedRun(ctx, j.schedule, time.Now())
+		if err != nil {
+			s.logger.Error("catch-up check failed", "schedule", j.schedule.Name, "error", err)
+			continue
+		}
+		if missed.IsZero() {
+			continue
+		}
+
+		s.logger.Info("scheduled backup was missed, catching up", "schedule", j.schedule.Name, "missed_run", missed)
+		s.runBackup(ctx, j)
+	}
+}
+
+// missedRun returns the first run of sched after its last successful backup
+// if that time has already passed, or the zero time if nothing was missed.
+// Backups taken by hand and by SelfTest are not scheduled runs and do not
+// count. With no backups at all the schedule is overdue by definition, so
+// now is returned.
+// Keying off stored metadata rather than local state means a daemon that
+// restarts repeatedly only catches up once: the catch-up backup itself moves
+// the next expected run into the future.
+func (s *Scheduler) missedRun(ctx context.Context, sched config.ScheduleEntry, now time.Time) (time.Time, error) {
+	spec, err := cronParser.Parse("0 " + sched.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	backups, err := s.engine.ListBackups(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var lastBackup time.Time
+	for _, b := range backups {
+		if b.OnDemand || IsSelfTest(b.ID) {
+			continue
+		}
+		if b.Schedule == sched.Name && b.Timestamp.After(lastBackup) {
+			lastBackup = b.Timestamp
+		}
+	}
+
+	if lastBackup.IsZero() {
+		return now, nil
+	}
+
+	// Metadata timestamps are UTC; evaluate the cron in the daemon's zone.
+	expected := spec.Next(lastBackup.In(now.Location()))
+	if expected.After(now) {
+		return time.Time{}, nil
+	}
+
+	return expected, nil
+}
+
+func (s *Scheduler) runRestoreDrill(ctx context.Context) {
+	if err := s.drill.Run(ctx); err != nil {
+		s.logger.Error("scheduled restore drill failed", "error", err)
 	}
 }
 
@@ -0,0 +1,251 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/transform"
+	"github.com/localrivet/datasaver/pkg/database"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// SelfTestPrefix starts the ID of every backup SelfTest takes, so they are
+// never mistaken for real backups.
+const SelfTestPrefix = "selftest_"
+
+// IsSelfTest reports whether id is a backup taken by SelfTest. Retention
+// and Latest ignore such backups while they exist.
+func IsSelfTest(id string) bool {
+	return strings.HasPrefix(id, SelfTestPrefix)
+}
+
+// selfTestTable and selfTestRows are the contents of the sample database.
+const (
+	selfTestTable = "datasaver_selftest"
+	selfTestRows  = 100
+)
+
+// SelfTest proves the whole pipeline works end to end: it backs up a small
+// SQLite sample database, or the configured database when sample is false,
+// verifies the backup, restores it into a scratch target and checks the
+// data, then deletes everything it stored. It returns one result per
+// phase, after one per client tool the backup runs; a failed phase skips
+// the ones after it except cleanup.
+//
+// The backup goes through the configured storage, compression and
+// encryption, but runs no hooks, sends no notifications, records no metrics
+// or history, and its ID starts with SelfTestPrefix, so existing backups
+// and their retention are left alone.
+func (e *Engine) SelfTest(ctx context.Context, sample bool) []CheckResult {
+	var results []CheckResult
+
+	tmpDir, err := os.MkdirTemp(e.cfg.Backup.TempDir, "datasaver-selftest-*")
+	if err != nil {
+		return append(results, CheckResult{Name: "setup", Err: fmt.Errorf("failed to create temp directory: %w", err)})
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := *e.cfg
+	cfg.Hooks = config.HooksConfig{}
+	cfg.Backup.VerifyAfterBackup = false
+	cfg.Monitoring.AnomalyWindow = 0
+	cfg.Database = cfg.DatabaseTargets()[0]
+	cfg.Databases = nil
+	if sample {
+		result := CheckResult{Name: "sample database"}
+		samplePath := filepath.Join(tmpDir, "sample.db")
+		if err := createSampleDatabase(ctx, samplePath); err != nil {
+			result.Err = err
+			return append(results, result)
+		}
+		result.Detail = fmt.Sprintf("%d rows in %s", selfTestRows, selfTestTable)
+		results = append(results, result)
+
+		cfg.Database = config.DatabaseConfig{Type: "sqlite", Path: samplePath, SQLiteMethod: database.SQLiteMethodBackup}
+		cfg.Backup.Method = ""
+		cfg.Backup.Mode = ""
+		cfg.Backup.Format = ""
+		cfg.Backup.CompressInDB = false
+		cfg.Backup.SentinelTables = nil
+		cfg.Backup.CollectStats = false
+	}
+
+	run := e.derive(&cfg, e.logger.With("self_test", true))
+	run.notifier = nil
+	run.metrics = nil
+	run.selfTest = true
+
+	// Report which client tools the backup runs before running it.
+	for _, result := range run.checkBinaries(ctx) {
+		results = append(results, result)
+		if !result.Passed() {
+			return results
+		}
+	}
+
+	backupResult := CheckResult{Name: "backup"}
+	res, err := run.Run(ctx)
+	if err != nil {
+		backupResult.Err = err
+		return append(results, backupResult)
+	}
+	meta, err := run.GetBackup(ctx, res.ID)
+	if err != nil {
+		backupResult.Err = err
+		return append(results, backupResult)
+	}
+	backupResult.Detail = fmt.Sprintf("%s, %d bytes stored", res.ID, res.CompressedSize)
+	results = append(results, backupResult)
+
+	verifyResult := CheckResult{Name: "verify", Detail: "size, checksum and restore integrity"}
+	verified, err := run.Verify(ctx, meta, true)
+	switch {
+	case err != nil:
+		verifyResult.Err = err
+	case !verified.Valid:
+		verifyResult.Err = errors.New(strings.Join(verified.Errors, "; "))
+	}
+	results = append(results, verifyResult)
+
+	if verifyResult.Passed() {
+		results = append(results, run.selfTestRestore(ctx, meta, sample, tmpDir))
+	}
+
+	return append(results, run.selfTestCleanup(ctx, meta))
+}
+
+// createSampleDatabase writes the self-test sample database to path.
+func createSampleDatabase(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to create sample database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE "+selfTestTable+" (id INTEGER PRIMARY KEY, value TEXT NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to create sample table: %w", err)
+	}
+	for i := 1; i <= selfTestRows; i++ {
+		if _, err := db.ExecContext(ctx, "INSERT INTO "+selfTestTable+" (id, value) VALUES (?, ?)", i, fmt.Sprintf("row %d", i)); err != nil {
+			return fmt.Errorf("failed to fill sample table: %w", err)
+		}
+	}
+	return nil
+}
+
+// selfTestRestore restores the self-test backup into a scratch target and
+// checks its data: SQLite backups into a file in tmpDir, compared with the
+// sample's rows or the row counts recorded at backup time, and PostgreSQL
+// dumps into a temporary database on backup.scratch_database_url.
+func (e *Engine) selfTestRestore(ctx context.Context, meta *postgres.BackupMetadata, sample bool, tmpDir string) CheckResult {
+	result := CheckResult{Name: "restore"}
+
+	if !e.cfg.IsSQLite() {
+		switch {
+		case meta.Backup.Kind == postgres.KindBase:
+			result.Detail = "skipped: physical backups restore into a data directory"
+		case e.cfg.Backup.ScratchDatabaseURL == "":
+			result.Detail = "skipped: set backup.scratch_database_url to restore into a scratch database"
+		default:
+			validator := e.newRestoreValidator(e.logger)
+			validator.SetScratchDatabase(e.cfg.Backup.ScratchDatabaseURL)
+			result.Err = validator.VerifyRestoreIntegrity(ctx, meta)
+			result.Detail = "restored into a scratch database"
+		}
+		return result
+	}
+
+	target := filepath.Join(tmpDir, "restored.db")
+	if err := e.restoreSQLiteFile(ctx, meta, target); err != nil {
+		result.Err = err
+		return result
+	}
+
+	want := meta.Backup.TableCounts
+	if sample {
+		want = map[string]int64{selfTestTable: selfTestRows}
+	}
+	if len(want) == 0 {
+		result.Detail = "restored; no row counts recorded to compare"
+		return result
+	}
+
+	driver, err := database.NewSQLiteDriver(database.Config{Path: target})
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create database driver: %w", err)
+		return result
+	}
+	if err := driver.Connect(ctx); err != nil {
+		result.Err = fmt.Errorf("failed to open restored database: %w", err)
+		return result
+	}
+	defer driver.Close()
+
+	tables := make([]string, 0, len(want))
+	for table := range want {
+		tables = append(tables, table)
+	}
+	got, err := driver.CountRows(ctx, tables)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to count restored rows: %w", err)
+		return result
+	}
+	for _, table := range tables {
+		if got[table] != want[table] {
+			result.Err = fmt.Errorf("restored %s has %d rows, want %d", table, got[table], want[table])
+			return result
+		}
+	}
+	result.Detail = fmt.Sprintf("row counts match in %d table(s)", len(tables))
+	return result
+}
+
+// restoreSQLiteFile writes the SQLite backup described by meta to target.
+func (e *Engine) restoreSQLiteFile(ctx context.Context, meta *postgres.BackupMetadata, target string) error {
+	paths := dataPaths(meta)
+	if len(paths) == 0 {
+		return fmt.Errorf("no backup file found in metadata")
+	}
+
+	reader, err := e.storage.Read(ctx, paths[0])
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer reader.Close()
+
+	chain, _ := transform.NewRegistry(e.cfg.EncryptionKey()).Parse(paths[0])
+	src, err := chain.Unwrap(reader)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	driver, err := database.NewSQLiteDriver(database.Config{Path: target, SQLite3Path: e.cfg.Database.SQLite3Path})
+	if err != nil {
+		return fmt.Errorf("failed to create database driver: %w", err)
+	}
+	if err := driver.Restore(ctx, src, target); err != nil {
+		return fmt.Errorf("sqlite restore failed: %w", err)
+	}
+	return nil
+}
+
+// selfTestCleanup deletes the self-test backup's files and metadata.
+func (e *Engine) selfTestCleanup(ctx context.Context, meta *postgres.BackupMetadata) CheckResult {
+	result := CheckResult{Name: "cleanup", Detail: "deleted " + meta.ID}
+
+	errs := make([]error, 1)
+	doomed := []*postgres.BackupMetadata{meta}
+	e.deleteFiles(ctx, doomed, errs, dataPaths)
+	e.deleteFiles(ctx, doomed, errs, metadataPaths)
+	if errs[0] != nil {
+		result.Err = fmt.Errorf("failed to delete %s: %w", meta.ID, errs[0])
+	}
+	return result
+}
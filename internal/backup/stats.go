@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/localrivet/datasaver/internal/rotation"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// Stats summarizes the stored backups and what the retention policy would
+// remove on the next cleanup.
+type Stats struct {
+	Count       int
+	CountByType map[string]int
+
+	// Sizes are compressed, as stored. SizeByType is TotalSize split by
+	// backup type (daily, weekly, monthly, yearly).
+	TotalSize   int64
+	SizeByType  map[string]int64
+	AverageSize int64
+	MinSize     int64
+	MaxSize     int64
+
+	// UncompressedSize is the sum of the original dump sizes.
+	UncompressedSize int64
+	// CompressionRatio is UncompressedSize / TotalSize, or 0 when unknown.
+	CompressionRatio float64
+
+	Oldest *postgres.BackupMetadata
+	Newest *postgres.BackupMetadata
+
+	ProjectedDeletions int
+	ReclaimableBytes   int64
+
+	// MaxTotalBytes is the retention storage cap, 0 when unset.
+	MaxTotalBytes int64
+}
+
+// Stats reads every backup's metadata and summarizes counts, sizes and the
+// deletions the current retention policy would make.
+func (e *Engine) Stats(ctx context.Context) (*Stats, error) {
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	stats := &Stats{
+		CountByType:   make(map[string]int),
+		SizeByType:    SizeByType(backups),
+		MaxTotalBytes: e.StorageCap(),
+	}
+
+	for _, b := range backups {
+		size := b.Backup.CompressedSize
+
+		stats.Count++
+		stats.CountByType[backupType(b)]++
+		stats.TotalSize += size
+		stats.UncompressedSize += b.Backup.SizeBytes
+
+		if stats.Count == 1 || size < stats.MinSize {
+			stats.MinSize = size
+		}
+		if size > stats.MaxSize {
+			stats.MaxSize = size
+		}
+		if stats.Oldest == nil || b.Timestamp.Before(stats.Oldest.Timestamp) {
+			stats.Oldest = b
+		}
+		if stats.Newest == nil || b.Timestamp.After(stats.Newest.Timestamp) {
+			stats.Newest = b
+		}
+	}
+
+	if stats.Count > 0 {
+		stats.AverageSize = stats.TotalSize / int64(stats.Count)
+	}
+	if stats.TotalSize > 0 {
+		stats.CompressionRatio = float64(stats.UncompressedSize) / float64(stats.TotalSize)
+	}
+
+	for _, d := range e.plan(backups) {
+		if !d.Keep {
+			stats.ProjectedDeletions++
+			stats.ReclaimableBytes += d.Metadata.Backup.CompressedSize
+		}
+	}
+
+	return stats, nil
+}
+
+// SizeByType sums the compressed size of backups per backup type, the
+// daily, weekly, monthly or yearly tier retention keeps them under, to show
+// which tier the storage goes to.
+func SizeByType(backups []*postgres.BackupMetadata) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, b := range backups {
+		sizes[backupType(b)] += b.Backup.CompressedSize
+	}
+	return sizes
+}
+
+// backupType returns the type recorded when b was taken, or for metadata
+// written without one, the type its timestamp classifies it as.
+func backupType(b *postgres.BackupMetadata) string {
+	if b.Type != "" {
+		return b.Type
+	}
+	return string(rotation.GetPrimaryType(b.Timestamp))
+}
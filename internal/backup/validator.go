@@ -2,9 +2,9 @@ package backup
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,39 +13,96 @@ import (
 	"strings"
 
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/internal/transform"
+	"github.com/localrivet/datasaver/pkg/checksum"
+	"github.com/localrivet/datasaver/pkg/database"
 	"github.com/localrivet/datasaver/pkg/postgres"
 	_ "modernc.org/sqlite"
 )
 
 type Validator struct {
-	storage storage.Backend
-	logger  *slog.Logger
-	dbType  string
+	storage    storage.Backend
+	logger     *slog.Logger
+	dbType     string
+	scratchURL string
+	transforms *transform.Registry
+	signingKey []byte
+	tempDir    string
+	cache      *storage.Cache
+
+	// Client binaries verification runs; empty finds them on PATH.
+	pgRestore string
+	psql      string
+	sqlite3   string
 }
 
 func NewValidator(store storage.Backend, logger *slog.Logger) *Validator {
 	return &Validator{
-		storage: store,
-		logger:  logger,
-		dbType:  "postgres", // default
+		storage:    store,
+		logger:     logger,
+		dbType:     "postgres", // default
+		transforms: transform.NewRegistry(nil),
 	}
 }
 
 func NewValidatorWithDBType(store storage.Backend, logger *slog.Logger, dbType string) *Validator {
 	return &Validator{
-		storage: store,
-		logger:  logger,
-		dbType:  dbType,
+		storage:    store,
+		logger:     logger,
+		dbType:     dbType,
+		transforms: transform.NewRegistry(nil),
 	}
 }
 
+// SetScratchDatabase makes PostgreSQL restore verification go beyond
+// pg_restore --list: each dump is restored into a temporary database on the
+// server at url, sanity-checked and dropped. An empty url disables it.
+func (v *Validator) SetScratchDatabase(url string) {
+	v.scratchURL = url
+}
+
+// SetEncryptionKey sets the key used to read encrypted backups during
+// restore verification.
+func (v *Validator) SetEncryptionKey(key []byte) {
+	v.transforms = transform.NewRegistry(key)
+}
+
+// SetMetadataSigningKey makes Validate check each backup's metadata
+// signature before trusting the sizes and checksums in it.
+func (v *Validator) SetMetadataSigningKey(key []byte) {
+	v.signingKey = key
+}
+
+// SetTempDir sets where backups are downloaded and unpacked for
+// verification. Empty uses the system default.
+func (v *Validator) SetTempDir(dir string) {
+	v.tempDir = dir
+}
+
+// SetBinaryPaths sets the pg_restore, psql and sqlite3 binaries restore
+// verification runs. An empty path finds the tool on PATH.
+func (v *Validator) SetBinaryPaths(pgRestore, psql, sqlite3 string) {
+	v.pgRestore = pgRestore
+	v.psql = psql
+	v.sqlite3 = sqlite3
+}
+
+// SetCache lets restore verification use cached copies of backups instead
+// of downloading them. Validate still reads storage, since it checks the
+// stored copy.
+func (v *Validator) SetCache(cache *storage.Cache) {
+	v.cache = cache
+}
+
 type ValidationResult struct {
-	BackupID     string
-	Valid        bool
-	FileExists   bool
-	SizeMatch    bool
-	ChecksumOK   bool
-	Errors       []string
+	BackupID      string
+	Valid         bool
+	FileExists    bool
+	SizeMatch     bool
+	ChecksumOK    bool
+	CompressionOK bool // The compressed stream decompressed cleanly, or the file is not compressed
+	RestoreOK     bool // Set when a deep check restored the backup
+	Errors        []string
 }
 
 func (v *Validator) Validate(ctx context.Context, metadata *postgres.BackupMetadata) (*ValidationResult, error) {
@@ -54,6 +111,14 @@ func (v *Validator) Validate(ctx context.Context, metadata *postgres.BackupMetad
 		Valid:    true,
 	}
 
+	if v.signingKey != nil {
+		if err := metadata.VerifySignature(v.signingKey); err != nil {
+			result.Valid = false
+			result.Errors = append(result.Errors, err.Error())
+			return result, nil
+		}
+	}
+
 	if len(metadata.Files) == 0 {
 		result.Valid = false
 		result.Errors = append(result.Errors, "no files listed in metadata")
@@ -62,7 +127,7 @@ func (v *Validator) Validate(ctx context.Context, metadata *postgres.BackupMetad
 
 	backupFile := ""
 	for _, f := range metadata.Files {
-		if f != metadata.ID+".meta.json" {
+		if !postgres.IsMetadataPath(f) {
 			backupFile = f
 			break
 		}
@@ -100,42 +165,128 @@ func (v *Validator) Validate(ctx context.Context, metadata *postgres.BackupMetad
 		))
 	}
 
-	if metadata.Backup.Checksum != "" {
-		reader, err := v.storage.Read(ctx, backupFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read backup file: %w", err)
-		}
+	// pg_dump's own compression is only readable by pg_restore.
+	chain, _ := v.transforms.Parse(backupFile)
+	if metadata.Backup.CompressedInDB {
+		chain = chain.WithoutCompression()
+	}
+	compressed := chain.Compressed()
 
-		tmpFile, err := createTempFile(reader)
-		reader.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create temp file: %w", err)
+	// A checksum the backend recorded on write, such as S3 user metadata,
+	// shows a mismatch without a download, and a match is enough when there
+	// is no compression to check.
+	stored := v.storedChecksum(ctx, backupFile, metadata.Backup.Checksum)
+	if stored != "" {
+		result.ChecksumOK = stored == metadata.Backup.Checksum
+		if !result.ChecksumOK {
+			result.Valid = false
+			result.Errors = append(result.Errors, "checksum mismatch")
+			return result, nil
+		}
+		if !compressed {
+			result.CompressionOK = true
+			return result, nil
 		}
-		defer tmpFile.cleanup()
+	}
 
-		checksum, err := postgres.CalculateChecksum(tmpFile.path)
+	if metadata.Backup.Checksum == "" && !compressed {
+		result.ChecksumOK = true
+		result.CompressionOK = true
+		return result, nil
+	}
+
+	reader, err := v.storage.Read(ctx, backupFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	tmpFile, err := createTempFile(v.tempDir, reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.cleanup()
+
+	switch {
+	case stored != "":
+		// Already matched against the stored checksum.
+	case metadata.Backup.Checksum != "":
+		sum, err := checksum.FileFor(tmpFile.path, metadata.Backup.Checksum)
 		if err != nil {
 			v.logger.Warn("failed to calculate checksum", "error", err)
 		} else {
-			result.ChecksumOK = checksum == metadata.Backup.Checksum
+			result.ChecksumOK = sum == metadata.Backup.Checksum
 			if !result.ChecksumOK {
 				result.Valid = false
 				result.Errors = append(result.Errors, "checksum mismatch")
 			}
 		}
-	} else {
+	default:
 		result.ChecksumOK = true
 	}
 
+	result.CompressionOK = true
+	if compressed {
+		err := v.checkCompression(tmpFile.path, chain)
+		switch {
+		case errors.Is(err, transform.ErrNoKey):
+			v.logger.Warn("cannot check compression of an encrypted backup without its key")
+		case err != nil:
+			result.CompressionOK = false
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("compressed stream is corrupt: %v", err))
+		}
+	}
+
 	return result, nil
 }
 
+// storedChecksum returns the checksum the storage backend recorded for
+// backupFile when it wrote it, or "" when there is none, want is empty, or
+// the two were taken with different algorithms and cannot be compared.
+// Failing to read it is only logged; the file is then downloaded instead.
+func (v *Validator) storedChecksum(ctx context.Context, backupFile, want string) string {
+	c, ok := v.storage.(checksummer)
+	if !ok || want == "" {
+		return ""
+	}
+	stored, err := c.Checksum(ctx, backupFile)
+	if err != nil {
+		v.logger.Warn("failed to read stored checksum", "file", backupFile, "error", err)
+		return ""
+	}
+	if stored != "" && checksum.Algorithm(stored) != checksum.Algorithm(want) {
+		return ""
+	}
+	return stored
+}
+
+// checkCompression decompresses the file at path and discards the output,
+// so a truncated stream or a bad header or CRC is caught without restoring
+// it. Encrypted backups are decrypted first.
+func (v *Validator) checkCompression(path string, chain transform.Chain) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := chain.Unwrap(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
 type tempFile struct {
 	path string
 }
 
-func createTempFile(reader io.Reader) (*tempFile, error) {
-	f, err := os.CreateTemp("", "datasaver-validate-*")
+func createTempFile(dir string, reader io.Reader) (*tempFile, error) {
+	f, err := os.CreateTemp(dir, "datasaver-validate-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
@@ -163,49 +314,110 @@ func (v *Validator) VerifyRestoreIntegrity(ctx context.Context, metadata *postgr
 		return fmt.Errorf("no backup file found in metadata")
 	}
 
-	// Read the backup file
-	reader, err := v.storage.Read(ctx, backupFile)
-	if err != nil {
-		return fmt.Errorf("failed to read backup: %w", err)
-	}
-
-	tmpFile, err := createTempFile(reader)
-	reader.Close()
+	tmpFile, err := v.fetch(ctx, backupFile, metadata.Backup.Checksum)
 	if err != nil {
 		return err
 	}
 	defer tmpFile.cleanup()
 
-	compressed := strings.HasSuffix(backupFile, ".gz")
+	chain, _ := v.transforms.Parse(backupFile)
+	if metadata.Backup.CompressedInDB {
+		// pg_restore reads pg_dump's own compression.
+		chain = chain.WithoutCompression()
+	}
+
+	if want := metadata.Backup.ContentChecksum; want != "" {
+		if err := v.verifyContent(tmpFile.path, chain, want); err != nil {
+			return err
+		}
+	}
 
 	switch strings.ToLower(v.dbType) {
 	case "sqlite", "sqlite3":
-		return v.verifySQLiteRestore(ctx, tmpFile.path, compressed)
+		return v.verifySQLiteRestore(ctx, tmpFile.path, chain)
 	case "postgres", "postgresql", "pg", "":
-		return v.verifyPostgresRestore(ctx, tmpFile.path, compressed)
+		return v.verifyPostgresRestore(ctx, tmpFile.path, chain, metadata.Backup.Format)
 	default:
 		return fmt.Errorf("unsupported database type: %s", v.dbType)
 	}
 }
 
+// fetch copies the backup file to a temp file, from the cache when it holds
+// a copy matching checksum and from storage otherwise.
+func (v *Validator) fetch(ctx context.Context, backupFile, checksum string) (*tempFile, error) {
+	if v.cache != nil {
+		tmp, err := os.CreateTemp(v.tempDir, "datasaver-validate-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		ok, err := v.cache.Fetch(backupFile, checksum, tmp.Name())
+		if err != nil {
+			v.logger.Warn("not using cached backup", "file", backupFile, "error", err)
+		}
+		if ok {
+			v.logger.Info("using cached backup", "file", backupFile)
+			return &tempFile{path: tmp.Name()}, nil
+		}
+	}
+
+	reader, err := v.storage.Read(ctx, backupFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer reader.Close()
+
+	return createTempFile(v.tempDir, reader)
+}
+
+// verifyContent checks the decompressed and decrypted backup at path
+// against the checksum the dump had before it was stored.
+func (v *Validator) verifyContent(path string, chain transform.Chain, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer f.Close()
+
+	reader, err := chain.Unwrap(f)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer reader.Close()
+
+	content, err := checksum.NewWriterFor(want)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(content, reader); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if got := content.Sum(); got != want {
+		return fmt.Errorf("content checksum mismatch: expected %s, got %s", want, got)
+	}
+	return nil
+}
+
 func (v *Validator) findBackupFile(metadata *postgres.BackupMetadata) string {
 	for _, f := range metadata.Files {
-		if !strings.HasSuffix(f, ".meta.json") {
+		if !postgres.IsMetadataPath(f) {
 			return f
 		}
 	}
 	return ""
 }
 
-func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string, compressed bool) error {
+func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string, chain transform.Chain) error {
 	// Read and decompress if needed
-	content, err := v.readBackupContent(backupPath, compressed)
+	content, err := v.readBackupContent(backupPath, chain)
 	if err != nil {
 		return fmt.Errorf("failed to read backup: %w", err)
 	}
 
 	// Create temp database
-	tmpDB, err := os.CreateTemp("", "datasaver-verify-*.db")
+	tmpDB, err := os.CreateTemp(v.tempDir, "datasaver-verify-*.db")
 	if err != nil {
 		return fmt.Errorf("failed to create temp database: %w", err)
 	}
@@ -213,9 +425,25 @@ func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string,
 	tmpDB.Close()
 	defer os.Remove(tmpPath)
 
+	// Online-backup copies are already a database file, so only the
+	// integrity check is needed.
+	if bytes.HasPrefix(content, []byte(database.SQLiteHeader)) {
+		if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+			return fmt.Errorf("failed to write temp database: %w", err)
+		}
+
+		db, err := sql.Open("sqlite", tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to open temp database: %w", err)
+		}
+		defer db.Close()
+
+		return v.sqliteIntegrityCheck(ctx, db)
+	}
+
 	// Try sqlite3 CLI first
 	if v.hasSQLite3CLI() {
-		cmd := exec.CommandContext(ctx, "sqlite3", tmpPath)
+		cmd := exec.CommandContext(ctx, v.sqlite3Binary(), tmpPath)
 		cmd.Stdin = bytes.NewReader(content)
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -223,7 +451,7 @@ func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string,
 		}
 
 		// Run integrity check
-		integrityCmd := exec.CommandContext(ctx, "sqlite3", tmpPath, "PRAGMA integrity_check;")
+		integrityCmd := exec.CommandContext(ctx, v.sqlite3Binary(), tmpPath, "PRAGMA integrity_check;")
 		output, err = integrityCmd.CombinedOutput()
 		if err != nil {
 			return fmt.Errorf("integrity check failed: %w", err)
@@ -246,6 +474,10 @@ func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string,
 		return fmt.Errorf("failed to execute SQL: %w", err)
 	}
 
+	return v.sqliteIntegrityCheck(ctx, db)
+}
+
+func (v *Validator) sqliteIntegrityCheck(ctx context.Context, db *sql.DB) error {
 	var result string
 	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
 		return fmt.Errorf("integrity check query failed: %w", err)
@@ -257,27 +489,61 @@ func (v *Validator) verifySQLiteRestore(ctx context.Context, backupPath string,
 	return nil
 }
 
-func (v *Validator) verifyPostgresRestore(ctx context.Context, backupPath string, compressed bool) error {
+func (v *Validator) verifyPostgresRestore(ctx context.Context, backupPath string, chain transform.Chain, format string) error {
 	actualPath := backupPath
 
 	// Decompress if needed
-	if compressed {
-		tmpFile, err := os.CreateTemp("", "datasaver-verify-*.dump")
+	if len(chain) > 0 {
+		tmpFile, err := os.CreateTemp(v.tempDir, "datasaver-verify-*.dump")
 		if err != nil {
 			return fmt.Errorf("failed to create temp file: %w", err)
 		}
 		defer os.Remove(tmpFile.Name())
 
-		if err := v.decompressFile(backupPath, tmpFile); err != nil {
+		if err := v.decompressFile(backupPath, tmpFile, chain); err != nil {
 			tmpFile.Close()
 			return fmt.Errorf("failed to decompress: %w", err)
 		}
 		tmpFile.Close()
 		actualPath = tmpFile.Name()
 	}
+	dumpPath := actualPath
+
+	if format == database.FormatBaseBackup {
+		return verifyBaseBackup(actualPath)
+	}
+
+	if format == database.FormatSQL {
+		if err := verifySQLDump(actualPath); err != nil {
+			return err
+		}
+		if v.scratchURL != "" {
+			return v.scratchRestore(ctx, dumpPath)
+		}
+		return nil
+	}
+
+	if format == database.FormatDirectory {
+		dumpDir, err := os.MkdirTemp(v.tempDir, "datasaver-verify-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		defer os.RemoveAll(dumpDir)
+
+		archive, err := os.Open(actualPath)
+		if err != nil {
+			return fmt.Errorf("failed to open dump archive: %w", err)
+		}
+		err = database.UntarDirectory(archive, dumpDir)
+		archive.Close()
+		if err != nil {
+			return err
+		}
+		actualPath = dumpDir
+	}
 
 	// Use pg_restore --list to validate the archive without needing a database
-	cmd := exec.CommandContext(ctx, "pg_restore", "--list", actualPath)
+	cmd := exec.CommandContext(ctx, v.pgRestoreBinary(), "--list", actualPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("pg_restore validation failed: %w, output: %s", err, string(output))
@@ -288,47 +554,132 @@ func (v *Validator) verifyPostgresRestore(ctx context.Context, backupPath string
 	}
 
 	v.logger.Debug("postgres backup verified", "entries", strings.Count(string(output), "\n"))
+
+	if v.scratchURL != "" {
+		return v.scratchRestore(ctx, dumpPath)
+	}
+	return nil
+}
+
+// verifyBaseBackup checks that the base backup at path reads as a tar and
+// holds the files every PostgreSQL data directory starts from. Restoring it
+// would need a stopped server of the same version, so this is as far as
+// verification goes.
+func verifyBaseBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open base backup: %w", err)
+	}
+	defer f.Close()
+
+	_, err = database.CheckBaseBackup(f)
+	return err
+}
+
+// verifySQLDump checks that the plain SQL dump at path was written by
+// pg_dump and creates something. Only a restore can prove every statement
+// runs, which is what verify_scratch_restore is for.
+func verifySQLDump(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open SQL dump: %w", err)
+	}
+	defer f.Close()
+
+	_, objects, err := postgres.ScanSQL(f)
+	if err != nil {
+		return err
+	}
+	if objects == 0 {
+		return fmt.Errorf("backup appears to be empty")
+	}
+	return nil
+}
+
+// scratchRestore restores the dump at dumpPath into a throwaway database and
+// fails if it comes back without any tables.
+func (v *Validator) scratchRestore(ctx context.Context, dumpPath string) error {
+	driver, err := database.NewPostgresDriver(database.Config{
+		Type:          "postgres",
+		URL:           v.scratchURL,
+		TempDir:       v.tempDir,
+		PGRestorePath: v.pgRestore,
+		PSQLPath:      v.psql,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create scratch driver: %w", err)
+	}
+	if err := driver.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to scratch server: %w", err)
+	}
+	defer driver.Close()
+
+	dump, err := os.Open(dumpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer dump.Close()
+
+	tables, err := driver.ScratchRestore(ctx, dump)
+	if err != nil {
+		return fmt.Errorf("scratch restore failed: %w", err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("scratch restore produced no tables")
+	}
+
+	v.logger.Info("scratch restore verified", "tables", tables)
 	return nil
 }
 
-func (v *Validator) readBackupContent(path string, compressed bool) ([]byte, error) {
+func (v *Validator) readBackupContent(path string, chain transform.Chain) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	var reader io.Reader = f
-	if compressed {
-		gr, err := gzip.NewReader(f)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer gr.Close()
-		reader = gr
+	reader, err := chain.Unwrap(f)
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
 
 	return io.ReadAll(reader)
 }
 
-func (v *Validator) decompressFile(src string, dst *os.File) error {
+func (v *Validator) decompressFile(src string, dst *os.File, chain transform.Chain) error {
 	f, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	gr, err := gzip.NewReader(f)
+	reader, err := chain.Unwrap(f)
 	if err != nil {
 		return err
 	}
-	defer gr.Close()
+	defer reader.Close()
 
-	_, err = io.Copy(dst, gr)
+	_, err = io.Copy(dst, reader)
 	return err
 }
 
 func (v *Validator) hasSQLite3CLI() bool {
-	_, err := exec.LookPath("sqlite3")
+	_, err := exec.LookPath(v.sqlite3Binary())
 	return err == nil
 }
+
+func (v *Validator) pgRestoreBinary() string {
+	if v.pgRestore != "" {
+		return v.pgRestore
+	}
+	return "pg_restore"
+}
+
+func (v *Validator) sqlite3Binary() string {
+	if v.sqlite3 != "" {
+		return v.sqlite3
+	}
+	return "sqlite3"
+}
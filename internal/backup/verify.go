@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// Verify checks that the backup's file exists with the recorded size and
+// checksum. With deep set, a backup passing those checks is also restored
+// into a temporary database, as verify_after_backup does.
+func (e *Engine) Verify(ctx context.Context, meta *postgres.BackupMetadata, deep bool) (*ValidationResult, error) {
+	validator := e.newRestoreValidator(backupLogger(e.logger, meta.ID, meta.Database.Name))
+
+	result, err := validator.Validate(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	if !deep || !result.Valid {
+		return result, nil
+	}
+
+	if err := validator.VerifyRestoreIntegrity(ctx, meta); err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("restore check failed: %v", err))
+		return result, nil
+	}
+	result.RestoreOK = true
+	return result, nil
+}
+
+// VerifyAll runs Verify on every backup, newest first, checking up to
+// storage.concurrency backups at once. A backup whose check cannot run, e.g.
+// because storage is unreachable, is reported as invalid with the error
+// rather than stopping the rest.
+func (e *Engine) VerifyAll(ctx context.Context, deep bool) ([]*ValidationResult, error) {
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+
+	results := make([]*ValidationResult, len(backups))
+	errs := e.forEach(ctx, len(backups), func(i int) error {
+		result, err := e.Verify(ctx, backups[i], deep)
+		results[i] = result
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, b := range backups {
+		if errs[i] != nil {
+			results[i] = &ValidationResult{BackupID: b.ID, Errors: []string{errs[i].Error()}}
+		}
+		if !results[i].Valid {
+			backupLogger(e.logger, b.ID, b.Database.Name).Warn("backup failed verification", "errors", results[i].Errors)
+		}
+	}
+	return results, nil
+}
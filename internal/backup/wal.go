@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/transform"
+	"github.com/localrivet/datasaver/pkg/checksum"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// ArchiveWAL stores the WAL file at path in the WAL archive. It is meant to
+// run as PostgreSQL's archive_command, so a file that is already archived
+// with the same contents succeeds again, while a different file of the same
+// name is refused.
+func (e *Engine) ArchiveWAL(ctx context.Context, path string) error {
+	_, err := e.archiveWAL(ctx, path, postgres.WALPrefix)
+	return err
+}
+
+// archiveWAL stores the WAL file at path under prefix and returns its
+// metadata, or the metadata it was archived with before.
+func (e *Engine) archiveWAL(ctx context.Context, path, prefix string) (*postgres.BackupMetadata, error) {
+	name := filepath.Base(path)
+	if !postgres.IsWALFileName(name) {
+		return nil, fmt.Errorf("not a WAL file: %s", name)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+
+	metaPath := postgres.WALMetadataPath(name)
+	existing, err := e.readWALMetadata(ctx, metaPath)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		same, err := e.sameWAL(ctx, path, info.Size(), existing)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			return nil, fmt.Errorf("WAL file %s is already archived with different contents", name)
+		}
+		e.logger.Info("WAL file already archived", "file", name)
+		return existing, nil
+	}
+
+	startTime := time.Now()
+
+	tmpDir, err := os.MkdirTemp(e.cfg.Backup.TempDir, "datasaver-wal-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Copy first: PostgreSQL may recycle the segment once we return, and
+	// compression reads the file by name.
+	localPath := filepath.Join(tmpDir, name)
+	if err := copyFile(path, localPath); err != nil {
+		return nil, fmt.Errorf("failed to copy WAL file: %w", err)
+	}
+
+	contentSum, err := checksum.File(localPath, e.cfg.ChecksumAlgorithm())
+	if err != nil {
+		return nil, err
+	}
+
+	finalFile, finalSize, err := e.encode(ctx, e.logger, localPath, info.Size(), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress or encrypt WAL file: %w", err)
+	}
+
+	sum, err := checksum.File(finalFile, e.cfg.ChecksumAlgorithm())
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(finalFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	defer f.Close()
+
+	storagePath := prefix + filepath.Base(finalFile)
+	if err := e.storage.Write(ctx, storagePath, f); err != nil {
+		return nil, fmt.Errorf("failed to write WAL file to storage: %w", err)
+	}
+
+	metadata := postgres.NewBackupMetadata(name, e.databaseName(), e.cfg.Database.Host, "")
+	metadata.Type = postgres.KindWAL
+	metadata.Backup.Method = "postgres"
+	metadata.Backup.Format = postgres.KindWAL
+	metadata.Backup.Kind = postgres.KindWAL
+	metadata.Backup.Compression = e.cfg.Compression
+	metadata.Backup.CompressionLevel = e.cfg.CompressionLevel
+	metadata.Backup.Encrypted = e.cfg.EncryptionKey() != nil
+	if timeline, start, end, ok := postgres.WALSegmentRange(name, info.Size()); ok {
+		metadata.Backup.Timeline = timeline
+		metadata.Backup.StartLSN = postgres.FormatLSN(start)
+		metadata.Backup.EndLSN = postgres.FormatLSN(end)
+	}
+	metadata.SetBackupInfo(info.Size(), finalSize, time.Since(startTime), sum)
+	metadata.Backup.ContentChecksum = contentSum
+	metadata.AddFile(storagePath)
+	if key := e.cfg.MetadataSigningKey(); key != nil {
+		if err := metadata.Sign(key); err != nil {
+			return nil, fmt.Errorf("failed to sign WAL metadata: %w", err)
+		}
+	}
+
+	metaJSON, err := metadata.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize WAL metadata: %w", err)
+	}
+	// The metadata is written last: its presence means the file is archived.
+	if err := e.storage.Write(ctx, metaPath, bytes.NewReader(metaJSON)); err != nil {
+		return nil, fmt.Errorf("failed to write WAL metadata: %w", err)
+	}
+
+	e.logger.Info("WAL file archived", "file", name, "size", info.Size(), "compressed_size", finalSize)
+	return metadata, nil
+}
+
+// sameWAL reports whether the WAL file at path, of size bytes, has the
+// contents archived as existing. Every segment has the same size, so the
+// contents are compared by checksum: against the content checksum recorded
+// at archive time, or for files archived before one was recorded, against
+// the archived file itself, downloaded and decoded.
+func (e *Engine) sameWAL(ctx context.Context, path string, size int64, existing *postgres.BackupMetadata) (bool, error) {
+	if existing.Backup.SizeBytes != size {
+		return false, nil
+	}
+
+	want := existing.Backup.ContentChecksum
+	if want == "" {
+		var err error
+		want, err = e.archivedWALChecksum(ctx, existing)
+		if err != nil {
+			return false, err
+		}
+	}
+	got, err := checksum.FileFor(path, want)
+	if err != nil {
+		return false, err
+	}
+	return got == want, nil
+}
+
+// archivedWALChecksum returns the checksum of the decoded contents of the
+// WAL file existing describes.
+func (e *Engine) archivedWALChecksum(ctx context.Context, existing *postgres.BackupMetadata) (string, error) {
+	paths := dataPaths(existing)
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no archived file in WAL metadata for %s", existing.ID)
+	}
+
+	reader, err := e.storage.Read(ctx, paths[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to read archived WAL file: %w", err)
+	}
+	defer reader.Close()
+
+	chain, _ := transform.NewRegistry(e.cfg.EncryptionKey()).Parse(paths[0])
+	src, err := chain.Unwrap(reader)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	w, err := checksum.NewWriter(e.cfg.ChecksumAlgorithm())
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return "", fmt.Errorf("failed to read archived WAL file: %w", err)
+	}
+	return w.Sum(), nil
+}
+
+// BaseBackupBefore returns the most recent base backup finished at or
+// before t, the one point-in-time recovery to t starts from.
+func (e *Engine) BaseBackupBefore(ctx context.Context, t time.Time) (*postgres.BackupMetadata, error) {
+	backups, err := e.ListBackups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var base *postgres.BackupMetadata
+	for _, b := range backups {
+		if b.Backup.Kind != postgres.KindBase || b.Timestamp.After(t) {
+			continue
+		}
+		if base == nil || b.Timestamp.After(base.Timestamp) {
+			base = b
+		}
+	}
+	if base == nil {
+		return nil, fmt.Errorf("no base backup finished before %s", t.UTC().Format(time.RFC3339))
+	}
+	return base, nil
+}
+
+// readWALMetadata returns the metadata at metaPath, or nil if there is none.
+func (e *Engine) readWALMetadata(ctx context.Context, metaPath string) (*postgres.BackupMetadata, error) {
+	exists, err := e.storage.Exists(ctx, metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check WAL archive: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	reader, err := e.storage.Read(ctx, metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL metadata: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL metadata: %w", err)
+	}
+	return postgres.ParseMetadata(data)
+}
+
+// pruneWAL deletes archived WAL segments that end before the oldest kept
+// base backup starts; no remaining backup can replay them. Without a base
+// backup nothing is deleted, and history files are always kept. The WAL
+// indexes of base backups that are no longer kept are deleted with them.
+func (e *Engine) pruneWAL(ctx context.Context, kept []*postgres.BackupMetadata) (int, error) {
+	var oldest uint64
+	found := false
+	keptBases := make(map[string]bool)
+	for _, b := range kept {
+		if b.Backup.Kind != postgres.KindBase {
+			continue
+		}
+		keptBases[b.ID] = true
+		if b.Backup.StartLSN == "" {
+			continue
+		}
+		lsn, err := postgres.ParseLSN(b.Backup.StartLSN)
+		if err != nil {
+			continue
+		}
+		if !found || lsn < oldest {
+			oldest, found = lsn, true
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+
+	files, err := e.storage.List(ctx, postgres.WALPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list WAL archive: %w", err)
+	}
+
+	deleted := 0
+	for _, file := range files {
+		if base, ok := walIndexBase(file.Path); ok {
+			if base != "" && !keptBases[base] {
+				if err := e.storage.Delete(ctx, file.Path); err != nil {
+					e.logger.Warn("failed to delete WAL index", "file", file.Path, "error", err)
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(file.Path, ".wal.json") {
+			continue
+		}
+		meta, err := e.readWALMetadata(ctx, file.Path)
+		if err != nil || meta == nil || meta.Backup.EndLSN == "" {
+			continue
+		}
+		end, err := postgres.ParseLSN(meta.Backup.EndLSN)
+		if err != nil || end > oldest {
+			continue
+		}
+
+		for _, f := range append(meta.Files, file.Path) {
+			if err := e.storage.Delete(ctx, f); err != nil {
+				e.logger.Warn("failed to delete WAL file", "file", f, "error", err)
+			}
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// walIndexBase returns the base backup whose WAL index is at path.
+func walIndexBase(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, postgres.WALPrefix)
+	if !ok {
+		return "", false
+	}
+	base, ok := strings.CutSuffix(rest, "index.json")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSuffix(base, "/"), true
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// DefaultWALPollInterval is how often a WALShipper looks for new files when
+// no interval is configured.
+const DefaultWALPollInterval = 10 * time.Second
+
+// WALShipper ships WAL files from a local directory to storage as they
+// appear. PostgreSQL fills the directory, either through an archive_command
+// that copies segments into it or through pg_receivewal streaming into it.
+// Each file is stored under the prefix of the latest base backup and
+// recorded in that base backup's WAL index, then removed from the
+// directory.
+type WALShipper struct {
+	engine   *Engine
+	dir      string
+	interval time.Duration
+	logger   *slog.Logger
+
+	// index is the WAL index of the base backup files are currently shipped
+	// under; it is loaded once and rewritten after each batch.
+	index *postgres.WALIndex
+}
+
+// NewWALShipper returns a shipper for dir that polls every interval, or
+// every DefaultWALPollInterval when interval is 0.
+func NewWALShipper(engine *Engine, dir string, interval time.Duration, logger *slog.Logger) *WALShipper {
+	if interval <= 0 {
+		interval = DefaultWALPollInterval
+	}
+	return &WALShipper{
+		engine:   engine,
+		dir:      dir,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Run ships files until ctx is cancelled. A failed pass is logged and
+// retried on the next poll; files stay in the directory until they are
+// shipped.
+func (s *WALShipper) Run(ctx context.Context) {
+	s.logger.Info("WAL shipping started", "dir", s.dir, "interval", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Ship(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("WAL shipping failed", "dir", s.dir, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ship ships every complete WAL file in the directory, oldest first, and
+// returns how many it shipped. Partial segments are skipped: pg_receivewal
+// is still writing them, and renames them once they are complete.
+func (s *WALShipper) Ship(ctx context.Context) (int, error) {
+	names, err := s.pending()
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+
+	base, err := s.latestBaseBackup(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.loadIndex(ctx, base); err != nil {
+		return 0, err
+	}
+
+	shipped := 0
+	var shipErr error
+	for _, name := range names {
+		path := filepath.Join(s.dir, name)
+		meta, err := s.engine.archiveWAL(ctx, path, postgres.WALBasePrefix(base))
+		if err != nil {
+			shipErr = fmt.Errorf("failed to ship %s: %w", name, err)
+			break
+		}
+		s.index.Add(meta)
+		shipped++
+
+		if err := os.Remove(path); err != nil {
+			s.logger.Warn("failed to remove shipped WAL file", "file", path, "error", err)
+		}
+	}
+
+	if shipped > 0 {
+		if err := s.writeIndex(ctx); err != nil && shipErr == nil {
+			shipErr = err
+		}
+	}
+	return shipped, shipErr
+}
+
+// pending returns the names of the complete WAL files in the directory in
+// the order PostgreSQL wrote them.
+func (s *WALShipper) pending() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !postgres.IsWALFileName(name) || strings.HasSuffix(name, ".partial") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// latestBaseBackup returns the ID of the newest base backup, or "" when
+// there is none yet.
+func (s *WALShipper) latestBaseBackup(ctx context.Context) (string, error) {
+	backups, err := s.engine.ListBackups(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var latest *postgres.BackupMetadata
+	for _, b := range backups {
+		if b.Backup.Kind != postgres.KindBase {
+			continue
+		}
+		if latest == nil || b.Timestamp.After(latest.Timestamp) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.ID, nil
+}
+
+// loadIndex makes s.index the WAL index of base backup base, reading it
+// from storage when the base backup changed.
+func (s *WALShipper) loadIndex(ctx context.Context, base string) error {
+	if s.index != nil && s.index.BaseBackup == base {
+		return nil
+	}
+
+	indexPath := postgres.WALIndexPath(base)
+	exists, err := s.engine.storage.Exists(ctx, indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to check WAL index: %w", err)
+	}
+	if !exists {
+		s.index = &postgres.WALIndex{BaseBackup: base}
+		return nil
+	}
+
+	reader, err := s.engine.storage.Read(ctx, indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL index: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL index: %w", err)
+	}
+	index, err := postgres.ParseWALIndex(data)
+	if err != nil {
+		return err
+	}
+	index.BaseBackup = base
+	s.index = index
+	return nil
+}
+
+func (s *WALShipper) writeIndex(ctx context.Context) error {
+	data, err := s.index.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize WAL index: %w", err)
+	}
+	if err := s.engine.storage.Write(ctx, postgres.WALIndexPath(s.index.BaseBackup), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write WAL index: %w", err)
+	}
+	return nil
+}
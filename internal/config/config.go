@@ -1,28 +1,188 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/localrivet/datasaver/pkg/checksum"
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Database    DatabaseConfig    `yaml:"database"`
-	Schedule    string            `yaml:"schedule"`
-	Storage     StorageConfig     `yaml:"storage"`
-	Retention   RetentionConfig   `yaml:"retention"`
-	Compression string            `yaml:"compression"`
-	Monitoring  MonitoringConfig  `yaml:"monitoring"`
-	Backup      BackupConfig      `yaml:"backup"`
+	Database         DatabaseConfig   `yaml:"database"`
+	Databases        []DatabaseConfig `yaml:"databases"` // Backed up in the same run; entries override Database's fields
+	Schedule         ScheduleConfig   `yaml:"schedule"`
+	Schedules        []ScheduleEntry  `yaml:"schedules"`       // Named backup jobs; replaces schedule.backup when set
+	ScheduleJitter   string           `yaml:"schedule_jitter"` // Random delay before each scheduled backup, e.g. "0-15m"
+	Storage          StorageConfig    `yaml:"storage"`
+	Retention        RetentionConfig  `yaml:"retention"`
+	Compression      string           `yaml:"compression"`
+	CompressionLevel int              `yaml:"compression_level"` // 0 uses the algorithm's default
+	Encryption       EncryptionConfig `yaml:"encryption"`
+	Monitoring       MonitoringConfig `yaml:"monitoring"`
+	Backup           BackupConfig     `yaml:"backup"`
+	Hooks            HooksConfig      `yaml:"hooks"`
+}
+
+// ScheduleConfig holds the cron expressions for the daemon's jobs. In YAML it
+// accepts either a bare cron string (the backup schedule, as in older configs)
+// or a mapping with one key per job.
+type ScheduleConfig struct {
+	Backup        string `yaml:"backup"`
+	VerifyRestore string `yaml:"verify_restore"` // Optional restore drill of the latest backup
+}
+
+func (s *ScheduleConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Backup)
+	}
+
+	type plain ScheduleConfig
+	return node.Decode((*plain)(s))
+}
+
+// ScheduleEntry is one named backup job. Mode, compression and retention
+// default to the top-level settings. Each schedule's backups are rotated on
+// their own, so frequent small backups never push out the nightly ones.
+type ScheduleEntry struct {
+	Name             string           `yaml:"name"` // Recorded in backup IDs and metadata
+	Cron             string           `yaml:"cron"`
+	Mode             string           `yaml:"mode"`
+	Compression      string           `yaml:"compression"`
+	CompressionLevel int              `yaml:"compression_level"`
+	Retention        *RetentionConfig `yaml:"retention"`
 }
 
 type BackupConfig struct {
 	VerifyAfterBackup bool `yaml:"verify_after_backup"` // Restore to temp DB to verify backup integrity
 	VerifyChecksum    bool `yaml:"verify_checksum"`     // Verify checksum on restore
+	CatchUpMissed     bool `yaml:"catch_up_missed"`     // Back up on startup if the last scheduled run was missed
+
+	Mode    string `yaml:"mode"`    // full, schema or data
+	Timeout string `yaml:"timeout"` // Go duration such as 2h bounding a whole run; empty means no limit
+
+	// Method is logical (pg_dump of one database) or physical
+	// (pg_basebackup of the whole cluster, carried forward by WAL archived
+	// with datasaver wal-push).
+	Method string `yaml:"method"`
+
+	// Format is the pg_dump format of logical PostgreSQL backups: custom
+	// (restored with pg_restore) or plain (SQL restored with psql, which
+	// carries across major versions more reliably).
+	Format string `yaml:"format"`
+
+	// Concurrency is how many of the configured databases are backed up at
+	// once; 0 or 1 backs them up one after another.
+	Concurrency int `yaml:"concurrency"`
+
+	// KeepGoing treats a run where some of several databases failed as a
+	// success: retention cleanup still follows a scheduled run, and the
+	// backup command exits 0. Each failure is still notified.
+	KeepGoing bool `yaml:"keep_going"`
+
+	// SpaceMultiplier estimates the temp space a backup needs as the
+	// database size times this factor, covering the dump and its compressed
+	// copy. A backup aborts before dumping when the temp directory has less
+	// free. 0 uses DefaultSpaceMultiplier.
+	SpaceMultiplier float64 `yaml:"space_multiplier"`
+
+	// TempDir is where dumps are staged before upload and where restores
+	// and verification unpack them. Empty uses the system default, usually
+	// /tmp, which containers often mount as a small tmpfs.
+	TempDir string `yaml:"temp_dir"`
+
+	// MaxAttempts bounds how often connecting and dumping are tried when
+	// they fail with a transient error, waiting RetryWait (a Go duration)
+	// and then twice as long each time. 0 uses 3 attempts and 1s; 1
+	// disables retries.
+	MaxAttempts int    `yaml:"max_attempts"`
+	RetryWait   string `yaml:"retry_wait"`
+
+	// VerifyScratchRestore restores each verified PostgreSQL backup into a
+	// temporary database on ScratchDatabaseURL's server, counts its tables
+	// and drops it. The URL's user needs CREATEDB.
+	VerifyScratchRestore bool   `yaml:"verify_scratch_restore"`
+	ScratchDatabaseURL   string `yaml:"scratch_database_url"`
+
+	// WALArchiveDir is a directory the daemon ships WAL files from as they
+	// appear, filled by an archive_command that copies segments into it or
+	// by pg_receivewal. Shipped files are removed from it. It is checked
+	// every WALPollInterval (a Go duration, 10s when empty). Empty disables
+	// shipping; wal-push archives without it.
+	WALArchiveDir   string `yaml:"wal_archive_dir"`
+	WALPollInterval string `yaml:"wal_poll_interval"`
+
+	// SentinelTables are tables whose row counts are recorded with each
+	// logical backup and compared after restoring it; a restore whose
+	// counts differ is reported as suspect.
+	SentinelTables []string `yaml:"sentinel_tables"`
+
+	// CollectStats records the row count of every table with each logical
+	// backup. Counting runs a full scan of each table before the dump.
+	CollectStats bool `yaml:"collect_stats"`
+
+	// CompressInDB has pg_dump compress custom and directory format dumps
+	// itself (pg_dump -Z, at compression_level or DefaultDumpCompressionLevel)
+	// instead of datasaver compressing the dump afterwards.
+	CompressInDB bool `yaml:"compress_in_db"`
+
+	// InstanceLockTTL (a Go duration) is how long the lock a daemon holds
+	// in storage stays valid without a heartbeat, so a second daemon on the
+	// same storage refuses to start while the first runs, and can start once
+	// a crashed one's lock expires. Empty uses DefaultInstanceLockTTL; 0
+	// disables the lock.
+	InstanceLockTTL string `yaml:"instance_lock_ttl"`
+
+	// ChecksumAlgorithm is the checksum recorded for new backups: sha256
+	// (the default), sha512 or xxhash. Checksums carry the algorithm, so
+	// changing it leaves existing backups verifiable.
+	ChecksumAlgorithm string `yaml:"checksum_algorithm"`
+
+	// MaxOnDemandPerHour caps the backups of each database taken by hand,
+	// with the backup command or over MCP, within any hour, so a runaway
+	// caller cannot fill storage. Scheduled backups are not counted. 0
+	// sets no cap.
+	MaxOnDemandPerHour int `yaml:"max_on_demand_per_hour"`
+
+	// CompressMetadata gzips the metadata written with each new backup, as
+	// .meta.json.gz instead of .meta.json. Both are always read, so it can
+	// be switched either way without touching existing backups.
+	CompressMetadata bool `yaml:"compress_metadata"`
+}
+
+// DefaultInstanceLockTTL is the backup.instance_lock_ttl used when none is
+// set.
+const DefaultInstanceLockTTL = 2 * time.Minute
+
+// EncryptionConfig enables client-side encryption of backup files. Key is
+// 32 bytes of hex (64 characters); an empty key stores backups unencrypted.
+type EncryptionConfig struct {
+	Key string `yaml:"key"`
+
+	// MetadataSigningKey signs each backup's metadata with HMAC-SHA256 so
+	// verify and restore can detect edited sizes or checksums. Empty
+	// disables signing.
+	MetadataSigningKey string `yaml:"metadata_signing_key"`
+}
+
+// HooksConfig lists shell commands run around backups and restores. A
+// failing pre_backup command aborts the backup; post hook failures are only
+// logged.
+type HooksConfig struct {
+	PreBackup      []string `yaml:"pre_backup"`
+	PostBackup     []string `yaml:"post_backup"`
+	PostRestore    []string `yaml:"post_restore"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"` // Per command
 }
 
 type DatabaseConfig struct {
@@ -34,20 +194,200 @@ type DatabaseConfig struct {
 	Password string `yaml:"password"`
 	URL      string `yaml:"url"`
 	Path     string `yaml:"path"`
+	DumpJobs int    `yaml:"dump_jobs"` // >1 runs pg_dump -F d -j N
+
+	IncludeTables []string `yaml:"include_tables"` // pg_dump --table patterns
+	ExcludeTables []string `yaml:"exclude_tables"` // pg_dump --exclude-table patterns
+	SQLiteMethod  string   `yaml:"sqlite_method"`  // dump (sqlite3 CLI) or backup (online backup API)
+
+	ConnectTimeoutSeconds int `yaml:"connect_timeout_seconds"` // pg_dump/pg_restore connect timeout; 0 waits forever
+
+	// Client binaries to run, for hosts with several PostgreSQL versions
+	// installed, version-suffixed tools such as pg_dump-16, or tools outside
+	// PATH. Empty finds the tool on PATH.
+	PGDumpPath       string `yaml:"pg_dump_path"`
+	PGRestorePath    string `yaml:"pg_restore_path"`
+	PSQLPath         string `yaml:"psql_path"`
+	PGBasebackupPath string `yaml:"pg_basebackup_path"`
+	SQLite3Path      string `yaml:"sqlite3_path"`
+
+	// SQLiteRestoreCopies is how many timestamped copies of a SQLite file
+	// replaced by restores are kept; 0 deletes the copy once the restore
+	// succeeds.
+	SQLiteRestoreCopies int `yaml:"sqlite_restore_copies"`
+
+	// SSLMode is disable, require, verify-ca or verify-full. Unset keeps
+	// sslmode=disable for host/port settings and leaves a URL's own sslmode.
+	SSLMode     string `yaml:"ssl_mode"`
+	SSLRootCert string `yaml:"ssl_root_cert"` // CA certificate for verify-ca/verify-full
+	SSLCert     string `yaml:"ssl_cert"`      // Client certificate
+	SSLKey      string `yaml:"ssl_key"`       // Client certificate key
 }
 
+// sslModes are the sslmode values lib/pq supports.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
 func (d *DatabaseConfig) ConnectionString() string {
 	if d.URL != "" {
 		return d.URL
 	}
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		d.User, d.Password, d.Host, d.Port, d.Name)
+	sslMode := d.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	params := url.Values{"sslmode": {sslMode}}
+	if d.SSLRootCert != "" {
+		params.Set("sslrootcert", d.SSLRootCert)
+	}
+	if d.SSLCert != "" {
+		params.Set("sslcert", d.SSLCert)
+	}
+	if d.SSLKey != "" {
+		params.Set("sslkey", d.SSLKey)
+	}
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?%s",
+		d.User, d.Password, d.Host, d.Port, d.Name, params.Encode())
 }
 
 type StorageConfig struct {
 	Backend string       `yaml:"backend"`
 	Path    string       `yaml:"path"`
 	S3      S3Config     `yaml:"s3"`
+
+	// KeyTemplate places backups under a key such as
+	// prod/{db}/{year}/{month}/{id}; the file extension is appended. Empty
+	// stores them at the root.
+	KeyTemplate string `yaml:"key_template"`
+
+	// Concurrency is how many objects are read, deleted or verified at once
+	// when listing, cleaning up or verifying backups; 0 uses
+	// DefaultStorageConcurrency.
+	Concurrency int `yaml:"concurrency"`
+
+	// StartupCheck is what the daemon does when it cannot write, read and
+	// delete a probe object at startup: warn (the default) logs the failure
+	// and reports not ready, fail refuses to start, and off skips the probe.
+	StartupCheck string `yaml:"startup_check"`
+
+	// Secondaries are extra destinations every backup is copied to, given
+	// as local:<path> or s3:<bucket>; S3 ones use the s3 settings above with
+	// the bucket replaced. A failed write to one is only logged, and reads
+	// fall back to them when the primary backend fails.
+	Secondaries []string `yaml:"secondaries"`
+
+	// Cache keeps local copies of recent backups for restores and
+	// verification to use instead of downloading them.
+	Cache CacheConfig `yaml:"cache"`
+}
+
+// CacheConfig is the local warm cache of recent backup files.
+type CacheConfig struct {
+	Path       string `yaml:"path"`        // Empty disables the cache
+	MaxBackups int    `yaml:"max_backups"` // 0 uses DefaultCacheBackups unless max_bytes is set
+	MaxBytes   int64  `yaml:"max_bytes"`   // 0 is no size limit
+}
+
+// DefaultCacheBackups is how many backups the cache keeps when neither
+// limit is set.
+const DefaultCacheBackups = 3
+
+// Backups returns how many backups the cache keeps, 0 meaning only
+// max_bytes limits it.
+func (c *CacheConfig) Backups() int {
+	if c.MaxBackups == 0 && c.MaxBytes == 0 {
+		return DefaultCacheBackups
+	}
+	return c.MaxBackups
+}
+
+// FailOnStartupCheck reports whether the daemon refuses to start when the
+// storage probe fails.
+func (s *StorageConfig) FailOnStartupCheck() bool {
+	return s.StartupCheck == "fail"
+}
+
+// SkipStartupCheck reports whether the daemon skips the storage probe.
+func (s *StorageConfig) SkipStartupCheck() bool {
+	return s.StartupCheck == "off"
+}
+
+// DefaultStorageConcurrency is the storage.concurrency used when none is set.
+// It hides most of S3's per-request latency without tripping rate limits.
+const DefaultStorageConcurrency = 8
+
+// Workers returns the configured concurrency, or DefaultStorageConcurrency.
+func (s *StorageConfig) Workers() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return DefaultStorageConcurrency
+}
+
+// DefaultSpaceMultiplier is the backup.space_multiplier used when none is
+// set: room for a dump as large as the database and half as much again.
+const DefaultSpaceMultiplier = 1.5
+
+// DefaultDumpCompressionLevel is the pg_dump -Z level compress_in_db uses
+// when compression_level is not set.
+const DefaultDumpCompressionLevel = 6
+
+// DumpCompressionLevel returns the -Z level pg_dump compresses dumps at, or
+// 0 when datasaver compresses them itself. compression_level is used when
+// it is a level pg_dump accepts.
+func (c *Config) DumpCompressionLevel() int {
+	if !c.Backup.CompressInDB {
+		return 0
+	}
+	if c.CompressionLevel >= 1 && c.CompressionLevel <= 9 {
+		return c.CompressionLevel
+	}
+	return DefaultDumpCompressionLevel
+}
+
+// SpaceFactor returns the configured space multiplier, or
+// DefaultSpaceMultiplier.
+func (b *BackupConfig) SpaceFactor() float64 {
+	if b.SpaceMultiplier > 0 {
+		return b.SpaceMultiplier
+	}
+	return DefaultSpaceMultiplier
+}
+
+// keyPlaceholder matches the {name} placeholders in a key template.
+var keyPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+var keyPlaceholders = []string{"{year}", "{month}", "{day}", "{db}", "{id}"}
+
+// BackupKey returns the storage key, without extension, for backup id of
+// database db taken at t. Metadata is stored as the key plus .meta.json, or
+// .meta.json.gz with backup.compress_metadata, next to the backup file.
+func (s *StorageConfig) BackupKey(id, db string, t time.Time) string {
+	if s.KeyTemplate == "" {
+		return id
+	}
+	return strings.NewReplacer(
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+		"{db}", path.Base(db), // SQLite databases are named by file path
+		"{id}", id,
+	).Replace(s.KeyTemplate)
+}
+
+func validateKeyTemplate(template string) error {
+	if !strings.HasSuffix(template, "{id}") {
+		return fmt.Errorf("storage key_template must end with {id}")
+	}
+	if strings.HasPrefix(template, "/") || slices.Contains(strings.Split(template, "/"), "..") {
+		return fmt.Errorf("storage key_template must be a relative path without '..'")
+	}
+	for _, placeholder := range keyPlaceholder.FindAllString(template, -1) {
+		if !slices.Contains(keyPlaceholders, placeholder) {
+			return fmt.Errorf("storage key_template has unknown placeholder %s (supported: %s)",
+				placeholder, strings.Join(keyPlaceholders, ", "))
+		}
+	}
+	return nil
 }
 
 type S3Config struct {
@@ -57,20 +397,98 @@ type S3Config struct {
 	AccessKey string `yaml:"access_key"`
 	SecretKey string `yaml:"secret_key"`
 	UseSSL    bool   `yaml:"use_ssl"`
+
+	MaxAttempts int `yaml:"max_attempts"` // Attempts per operation on transient errors (default 3)
+
+	// ObjectLockDays writes every object with S3 object lock retention for
+	// this many days, so it cannot be deleted or overwritten before then.
+	// ObjectLockMode is GOVERNANCE (the default) or COMPLIANCE.
+	ObjectLockDays int    `yaml:"object_lock_days"`
+	ObjectLockMode string `yaml:"object_lock_mode"`
 }
 
 type RetentionConfig struct {
 	Daily      int `yaml:"daily"`
 	Weekly     int `yaml:"weekly"`
 	Monthly    int `yaml:"monthly"`
+	Yearly     int `yaml:"yearly"` // Jan 1 backups, e.g. 7 for compliance archives
 	MaxAgeDays int `yaml:"max_age_days"`
+
+	// MaxTotalBytes caps the compressed size of all kept backups; the
+	// oldest are deleted beyond it. 0 means no cap.
+	MaxTotalBytes int64 `yaml:"max_total_bytes"`
+
+	// KeepNewest is how many of the most recent backups are never deleted,
+	// whatever their tier, age or size. At least one always is.
+	KeepNewest int `yaml:"keep_newest"`
+
+	// AutoCleanup applies retention after each successful scheduled backup.
+	// Turned off, only the cleanup command deletes backups, for setups that
+	// prune on their own schedule.
+	AutoCleanup bool `yaml:"auto_cleanup"`
+
+	// Rules keep labeled backups, such as ad-hoc ones taken before an
+	// upgrade, for a fixed time instead of by the tiers above. The first
+	// rule matching a backup's labels applies.
+	Rules []RetentionRule `yaml:"rules"`
+}
+
+// RetentionRule keeps backups carrying every label in Match for KeepDays
+// days, then deletes them, whatever the tiers, max age and size cap say.
+type RetentionRule struct {
+	Match    map[string]string `yaml:"match"`
+	KeepDays int               `yaml:"keep_days"`
 }
 
 type MonitoringConfig struct {
 	MetricsPort     int           `yaml:"metrics_port"`
 	WebhookURL      string        `yaml:"webhook_url"`
+	WebhookFormat   string        `yaml:"webhook_format"` // generic, slack or discord
 	AlertAfterHours int           `yaml:"alert_after_hours"`
 	HealthPort      int           `yaml:"health_port"`
+	Email           EmailConfig   `yaml:"email"`
+
+	// AuditLog is a file that restores and cleanups, from the CLI or MCP,
+	// are appended to as JSON lines. Empty writes them to the main log.
+	AuditLog string `yaml:"audit_log"`
+
+	// AnomalyWindow is how many earlier backups a new backup's compressed
+	// size and duration are compared against; 0 disables the check. A
+	// backup.anomaly notification is sent when either deviates from the
+	// median by more than AnomalyPercent percent, 0 meaning
+	// DefaultAnomalyPercent.
+	AnomalyWindow  int     `yaml:"anomaly_window"`
+	AnomalyPercent float64 `yaml:"anomaly_percent"`
+}
+
+// DefaultAnomalyPercent is the monitoring.anomaly_percent used when none is
+// set: a backup half or one and a half times the usual size or duration.
+const DefaultAnomalyPercent = 50
+
+// AnomalyThreshold returns the deviation from the median, as a fraction,
+// beyond which a backup counts as an anomaly.
+func (m *MonitoringConfig) AnomalyThreshold() float64 {
+	if m.AnomalyPercent > 0 {
+		return m.AnomalyPercent / 100
+	}
+	return DefaultAnomalyPercent / 100.0
+}
+
+// EmailConfig configures SMTP notifications. They are sent only when Host is
+// set, and STARTTLS is used whenever the server offers it.
+type EmailConfig struct {
+	Host           string   `yaml:"host"`
+	Port           int      `yaml:"port"`
+	Username       string   `yaml:"username"`
+	Password       string   `yaml:"password"`
+	From           string   `yaml:"from"`
+	To             []string `yaml:"to"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+}
+
+// Enabled reports whether email notifications are configured.
+func (e EmailConfig) Enabled() bool {
+	return e.Host != ""
 }
 
 func Load(configPath string) (*Config, error) {
@@ -79,9 +497,19 @@ func Load(configPath string) (*Config, error) {
 			Type: "postgres",
 			Host: "localhost",
 			Port: 5432,
+
+			ConnectTimeoutSeconds: 30,
+			SQLiteRestoreCopies:   3,
+		},
+		Schedule: ScheduleConfig{
+			Backup: "0 2 * * *",
 		},
-		Schedule:    "0 2 * * *",
 		Compression: "gzip",
+		Backup: BackupConfig{
+			Mode:   "full",
+			Method: "logical",
+			Format: "custom",
+		},
 		Storage: StorageConfig{
 			Backend: "local",
 			Path:    "/backups",
@@ -91,11 +519,21 @@ func Load(configPath string) (*Config, error) {
 			Weekly:     4,
 			Monthly:    6,
 			MaxAgeDays: 90,
+			KeepNewest:  1,
+			AutoCleanup: true,
 		},
 		Monitoring: MonitoringConfig{
 			MetricsPort:     9090,
 			HealthPort:      8080,
 			AlertAfterHours: 26,
+			WebhookFormat:   "generic",
+			Email: EmailConfig{
+				Port:           587,
+				TimeoutSeconds: 30,
+			},
+		},
+		Hooks: HooksConfig{
+			TimeoutSeconds: 300,
 		},
 	}
 
@@ -105,7 +543,9 @@ func Load(configPath string) (*Config, error) {
 		}
 	}
 
-	cfg.loadFromEnv()
+	if err := cfg.loadFromEnv(); err != nil {
+		return nil, err
+	}
 
 	if err := cfg.validate(); err != nil {
 		return nil, err
@@ -120,7 +560,11 @@ func (c *Config) loadFromFile(path string) error {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	expanded := os.ExpandEnv(string(data))
+	strict := !strings.EqualFold(os.Getenv("DATASAVER_CONFIG_STRICT_ENV"), "false")
+	expanded, err := expandEnv(string(data), strict)
+	if err != nil {
+		return err
+	}
 
 	if err := yaml.Unmarshal([]byte(expanded), c); err != nil {
 		return fmt.Errorf("failed to parse config file: %w", err)
@@ -129,12 +573,54 @@ func (c *Config) loadFromFile(path string) error {
 	return nil
 }
 
-func (c *Config) loadFromEnv() {
+// expandEnv replaces $VAR and ${VAR} in a config file with the variable's
+// value, and ${VAR:-default} with default when VAR is unset or empty. $$ is
+// a literal $. Comment lines are left alone. When strict, a reference to an
+// unset variable without a default is an error, so a missing secret fails
+// at load instead of as a blank password at dump time; otherwise it expands
+// to the empty string.
+func expandEnv(data string, strict bool) (string, error) {
+	var unset []string
+	mapping := func(ref string) string {
+		if ref == "$" {
+			return "$"
+		}
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		value, ok := os.LookupEnv(name)
+		if hasDefault && value == "" {
+			return def
+		}
+		if !ok && !slices.Contains(unset, name) {
+			unset = append(unset, name)
+		}
+		return value
+	}
+
+	lines := strings.SplitAfter(data, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines[i] = os.Expand(line, mapping)
+	}
+
+	if strict && len(unset) > 0 {
+		return "", fmt.Errorf("config file references unset environment variables %s; set them, give a default as ${VAR:-default}, or set DATASAVER_CONFIG_STRICT_ENV=false",
+			strings.Join(unset, ", "))
+	}
+	return strings.Join(lines, ""), nil
+}
+
+func (c *Config) loadFromEnv() error {
 	if v := os.Getenv("DATASAVER_DB_TYPE"); v != "" {
 		c.Database.Type = v
 	}
-	if v := os.Getenv("DATASAVER_DATABASE_URL"); v != "" {
-		c.Database.URL = v
+	databaseURL, err := secretFromEnv("DATASAVER_DATABASE_URL")
+	if err != nil {
+		return err
+	}
+	if databaseURL != "" {
+		c.Database.URL = databaseURL
 	}
 	if v := os.Getenv("DATASAVER_DB_HOST"); v != "" {
 		c.Database.Host = v
@@ -153,12 +639,73 @@ func (c *Config) loadFromEnv() {
 	if v := os.Getenv("DATASAVER_DB_USER"); v != "" {
 		c.Database.User = v
 	}
-	if v := os.Getenv("DATASAVER_DB_PASSWORD"); v != "" {
-		c.Database.Password = v
+	password, err := secretFromEnv("DATASAVER_DB_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if password != "" {
+		c.Database.Password = password
+	}
+	if v := os.Getenv("DATASAVER_DB_DUMP_JOBS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Database.DumpJobs = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_DB_SQLITE_METHOD"); v != "" {
+		c.Database.SQLiteMethod = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SQLITE_RESTORE_COPIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Database.SQLiteRestoreCopies = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_DB_INCLUDE_TABLES"); v != "" {
+		c.Database.IncludeTables = splitList(v)
+	}
+	if v := os.Getenv("DATASAVER_DB_EXCLUDE_TABLES"); v != "" {
+		c.Database.ExcludeTables = splitList(v)
+	}
+	if v := os.Getenv("DATASAVER_DB_CONNECT_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Database.ConnectTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_DB_PG_DUMP_PATH"); v != "" {
+		c.Database.PGDumpPath = v
+	}
+	if v := os.Getenv("DATASAVER_DB_PG_RESTORE_PATH"); v != "" {
+		c.Database.PGRestorePath = v
+	}
+	if v := os.Getenv("DATASAVER_DB_PSQL_PATH"); v != "" {
+		c.Database.PSQLPath = v
+	}
+	if v := os.Getenv("DATASAVER_DB_PG_BASEBACKUP_PATH"); v != "" {
+		c.Database.PGBasebackupPath = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SQLITE3_PATH"); v != "" {
+		c.Database.SQLite3Path = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SSL_MODE"); v != "" {
+		c.Database.SSLMode = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SSL_ROOT_CERT"); v != "" {
+		c.Database.SSLRootCert = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SSL_CERT"); v != "" {
+		c.Database.SSLCert = v
+	}
+	if v := os.Getenv("DATASAVER_DB_SSL_KEY"); v != "" {
+		c.Database.SSLKey = v
 	}
 
 	if v := os.Getenv("DATASAVER_SCHEDULE"); v != "" {
-		c.Schedule = v
+		c.Schedule.Backup = v
+	}
+	if v := os.Getenv("DATASAVER_VERIFY_RESTORE_SCHEDULE"); v != "" {
+		c.Schedule.VerifyRestore = v
+	}
+	if v := os.Getenv("DATASAVER_SCHEDULE_JITTER"); v != "" {
+		c.ScheduleJitter = v
 	}
 
 	if v := os.Getenv("DATASAVER_STORAGE_BACKEND"); v != "" {
@@ -167,6 +714,33 @@ func (c *Config) loadFromEnv() {
 	if v := os.Getenv("DATASAVER_STORAGE_PATH"); v != "" {
 		c.Storage.Path = v
 	}
+	if v := os.Getenv("DATASAVER_STORAGE_KEY_TEMPLATE"); v != "" {
+		c.Storage.KeyTemplate = v
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Storage.Concurrency = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_STARTUP_CHECK"); v != "" {
+		c.Storage.StartupCheck = strings.ToLower(v)
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_SECONDARIES"); v != "" {
+		c.Storage.Secondaries = splitList(v)
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_CACHE_PATH"); v != "" {
+		c.Storage.Cache.Path = v
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_CACHE_MAX_BACKUPS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Storage.Cache.MaxBackups = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_STORAGE_CACHE_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Storage.Cache.MaxBytes = n
+		}
+	}
 
 	if v := os.Getenv("DATASAVER_S3_BUCKET"); v != "" {
 		c.Storage.S3.Bucket = v
@@ -177,11 +751,32 @@ func (c *Config) loadFromEnv() {
 	if v := os.Getenv("DATASAVER_S3_REGION"); v != "" {
 		c.Storage.S3.Region = v
 	}
-	if v := os.Getenv("DATASAVER_S3_ACCESS_KEY"); v != "" {
-		c.Storage.S3.AccessKey = v
+	accessKey, err := secretFromEnv("DATASAVER_S3_ACCESS_KEY")
+	if err != nil {
+		return err
+	}
+	if accessKey != "" {
+		c.Storage.S3.AccessKey = accessKey
+	}
+	secretKey, err := secretFromEnv("DATASAVER_S3_SECRET_KEY")
+	if err != nil {
+		return err
+	}
+	if secretKey != "" {
+		c.Storage.S3.SecretKey = secretKey
+	}
+	if v := os.Getenv("DATASAVER_S3_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Storage.S3.MaxAttempts = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_S3_OBJECT_LOCK_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Storage.S3.ObjectLockDays = n
+		}
 	}
-	if v := os.Getenv("DATASAVER_S3_SECRET_KEY"); v != "" {
-		c.Storage.S3.SecretKey = v
+	if v := os.Getenv("DATASAVER_S3_OBJECT_LOCK_MODE"); v != "" {
+		c.Storage.S3.ObjectLockMode = v
 	}
 	if v := os.Getenv("DATASAVER_S3_USE_SSL"); v != "" {
 		c.Storage.S3.UseSSL = strings.ToLower(v) == "true"
@@ -202,15 +797,52 @@ func (c *Config) loadFromEnv() {
 			c.Retention.Monthly = n
 		}
 	}
+	if v := os.Getenv("DATASAVER_KEEP_YEARLY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Retention.Yearly = n
+		}
+	}
 	if v := os.Getenv("DATASAVER_MAX_AGE_DAYS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			c.Retention.MaxAgeDays = n
 		}
 	}
+	if v := os.Getenv("DATASAVER_AUTO_CLEANUP"); v != "" {
+		c.Retention.AutoCleanup = strings.ToLower(v) == "true"
+	}
+	if v := os.Getenv("DATASAVER_KEEP_NEWEST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Retention.KeepNewest = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_MAX_TOTAL_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Retention.MaxTotalBytes = n
+		}
+	}
 
 	if v := os.Getenv("DATASAVER_COMPRESSION"); v != "" {
 		c.Compression = v
 	}
+	if v := os.Getenv("DATASAVER_COMPRESSION_LEVEL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.CompressionLevel = n
+		}
+	}
+	encryptionKey, err := secretFromEnv("DATASAVER_ENCRYPTION_KEY")
+	if err != nil {
+		return err
+	}
+	if encryptionKey != "" {
+		c.Encryption.Key = encryptionKey
+	}
+	signingKey, err := secretFromEnv("DATASAVER_METADATA_SIGNING_KEY")
+	if err != nil {
+		return err
+	}
+	if signingKey != "" {
+		c.Encryption.MetadataSigningKey = signingKey
+	}
 
 	if v := os.Getenv("DATASAVER_METRICS_PORT"); v != "" {
 		if port, err := strconv.Atoi(v); err == nil {
@@ -225,11 +857,56 @@ func (c *Config) loadFromEnv() {
 	if v := os.Getenv("DATASAVER_WEBHOOK_URL"); v != "" {
 		c.Monitoring.WebhookURL = v
 	}
+	if v := os.Getenv("DATASAVER_WEBHOOK_FORMAT"); v != "" {
+		c.Monitoring.WebhookFormat = strings.ToLower(v)
+	}
+	if v := os.Getenv("DATASAVER_AUDIT_LOG"); v != "" {
+		c.Monitoring.AuditLog = v
+	}
+	if v := os.Getenv("DATASAVER_SMTP_HOST"); v != "" {
+		c.Monitoring.Email.Host = v
+	}
+	if v := os.Getenv("DATASAVER_SMTP_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			c.Monitoring.Email.Port = port
+		}
+	}
+	if v := os.Getenv("DATASAVER_SMTP_USERNAME"); v != "" {
+		c.Monitoring.Email.Username = v
+	}
+	smtpPassword, err := secretFromEnv("DATASAVER_SMTP_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if smtpPassword != "" {
+		c.Monitoring.Email.Password = smtpPassword
+	}
+	if v := os.Getenv("DATASAVER_SMTP_FROM"); v != "" {
+		c.Monitoring.Email.From = v
+	}
+	if v := os.Getenv("DATASAVER_SMTP_TO"); v != "" {
+		c.Monitoring.Email.To = splitList(v)
+	}
+	if v := os.Getenv("DATASAVER_SMTP_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Monitoring.Email.TimeoutSeconds = n
+		}
+	}
 	if v := os.Getenv("DATASAVER_ALERT_AFTER_HOURS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			c.Monitoring.AlertAfterHours = n
 		}
 	}
+	if v := os.Getenv("DATASAVER_ANOMALY_WINDOW"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Monitoring.AnomalyWindow = n
+		}
+	}
+	if v := os.Getenv("DATASAVER_ANOMALY_PERCENT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			c.Monitoring.AnomalyPercent = f
+		}
+	}
 
 	if v := os.Getenv("DATASAVER_VERIFY_BACKUP"); v != "" {
 		c.Backup.VerifyAfterBackup = strings.ToLower(v) == "true"
@@ -237,57 +914,835 @@ func (c *Config) loadFromEnv() {
 	if v := os.Getenv("DATASAVER_VERIFY_CHECKSUM"); v != "" {
 		c.Backup.VerifyChecksum = strings.ToLower(v) == "true"
 	}
-}
-
-func (c *Config) validate() error {
-	dbType := strings.ToLower(c.Database.Type)
-	if dbType == "" {
-		dbType = "postgres"
+	if v := os.Getenv("DATASAVER_CATCH_UP_MISSED"); v != "" {
+		c.Backup.CatchUpMissed = strings.ToLower(v) == "true"
 	}
-
-	switch dbType {
-	case "postgres", "postgresql", "pg":
-		if c.Database.URL == "" && c.Database.Name == "" {
-			return fmt.Errorf("database name or URL is required for PostgreSQL")
+	if v := os.Getenv("DATASAVER_BACKUP_MODE"); v != "" {
+		c.Backup.Mode = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_TIMEOUT"); v != "" {
+		c.Backup.Timeout = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_METHOD"); v != "" {
+		c.Backup.Method = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_FORMAT"); v != "" {
+		c.Backup.Format = v
+	}
+	if v := os.Getenv("DATASAVER_TEMP_DIR"); v != "" {
+		c.Backup.TempDir = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_SPACE_MULTIPLIER"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			c.Backup.SpaceMultiplier = f
 		}
-	case "sqlite", "sqlite3":
-		if c.Database.Path == "" && c.Database.Name == "" {
-			return fmt.Errorf("database path is required for SQLite")
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Backup.MaxAttempts = n
 		}
-	default:
-		return fmt.Errorf("unsupported database type: %s (supported: postgres, sqlite)", c.Database.Type)
 	}
-
-	if c.Storage.Backend != "local" && c.Storage.Backend != "s3" {
-		return fmt.Errorf("storage backend must be 'local' or 's3'")
+	if v := os.Getenv("DATASAVER_BACKUP_RETRY_WAIT"); v != "" {
+		c.Backup.RetryWait = v
 	}
-
-	if c.Storage.Backend == "s3" {
-		if c.Storage.S3.Bucket == "" {
-			return fmt.Errorf("S3 bucket is required when using S3 storage")
+	if v := os.Getenv("DATASAVER_BACKUP_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Backup.Concurrency = n
 		}
-		if c.Storage.S3.AccessKey == "" || c.Storage.S3.SecretKey == "" {
-			return fmt.Errorf("S3 access key and secret key are required")
+	}
+	if v := os.Getenv("DATASAVER_VERIFY_SCRATCH_RESTORE"); v != "" {
+		c.Backup.VerifyScratchRestore = strings.ToLower(v) == "true"
+	}
+	scratchURL, err := secretFromEnv("DATASAVER_SCRATCH_DATABASE_URL")
+	if err != nil {
+		return err
+	}
+	if scratchURL != "" {
+		c.Backup.ScratchDatabaseURL = scratchURL
+	}
+	if v := os.Getenv("DATASAVER_WAL_ARCHIVE_DIR"); v != "" {
+		c.Backup.WALArchiveDir = v
+	}
+	if v := os.Getenv("DATASAVER_WAL_POLL_INTERVAL"); v != "" {
+		c.Backup.WALPollInterval = v
+	}
+	if v := os.Getenv("DATASAVER_INSTANCE_LOCK_TTL"); v != "" {
+		c.Backup.InstanceLockTTL = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_CHECKSUM_ALGORITHM"); v != "" {
+		c.Backup.ChecksumAlgorithm = v
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_MAX_ON_DEMAND_PER_HOUR"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Backup.MaxOnDemandPerHour = n
 		}
 	}
-
-	if c.Compression != "gzip" && c.Compression != "zstd" && c.Compression != "none" {
-		return fmt.Errorf("compression must be 'gzip', 'zstd', or 'none'")
+	if v := os.Getenv("DATASAVER_SENTINEL_TABLES"); v != "" {
+		c.Backup.SentinelTables = splitList(v)
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_COLLECT_STATS"); v != "" {
+		c.Backup.CollectStats = strings.ToLower(v) == "true"
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_KEEP_GOING"); v != "" {
+		c.Backup.KeepGoing = strings.ToLower(v) == "true"
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_COMPRESS_IN_DB"); v != "" {
+		c.Backup.CompressInDB = strings.ToLower(v) == "true"
+	}
+	if v := os.Getenv("DATASAVER_BACKUP_COMPRESS_METADATA"); v != "" {
+		c.Backup.CompressMetadata = strings.ToLower(v) == "true"
 	}
 
-	return nil
-}
-
-func (c *Config) AlertDuration() time.Duration {
+	if v := os.Getenv("DATASAVER_PRE_BACKUP_HOOK"); v != "" {
+		c.Hooks.PreBackup = []string{v}
+	}
+	if v := os.Getenv("DATASAVER_POST_BACKUP_HOOK"); v != "" {
+		c.Hooks.PostBackup = []string{v}
+	}
+	if v := os.Getenv("DATASAVER_POST_RESTORE_HOOK"); v != "" {
+		c.Hooks.PostRestore = []string{v}
+	}
+	if v := os.Getenv("DATASAVER_HOOK_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Hooks.TimeoutSeconds = n
+		}
+	}
+
+	return nil
+}
+
+// secretFromEnv returns the value of the environment variable name or, when
+// name_FILE is set instead, the contents of that file without trailing
+// newlines. This lets Docker and Kubernetes secrets be mounted as files.
+func secretFromEnv(name string) (string, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set; use only one", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (c *Config) validate() error {
+	for i, d := range c.DatabaseTargets() {
+		if err := c.validateDatabase(&d); err != nil {
+			if len(c.Databases) > 0 {
+				return fmt.Errorf("databases[%d]: %w", i, err)
+			}
+			return err
+		}
+	}
+	if err := c.validateDatabaseNames(); err != nil {
+		return err
+	}
+
+	if err := validateSchedule("schedule.backup", c.Schedule.Backup); err != nil {
+		return err
+	}
+	if c.Schedule.VerifyRestore != "" {
+		if err := validateSchedule("schedule.verify_restore", c.Schedule.VerifyRestore); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateSchedules(); err != nil {
+		return err
+	}
+
+	if _, _, err := parseJitter(c.ScheduleJitter); err != nil {
+		return err
+	}
+
+	switch c.Backup.Mode {
+	case "full", "schema", "data":
+	default:
+		return fmt.Errorf("backup mode must be 'full', 'schema', or 'data'")
+	}
+
+	switch c.Backup.Method {
+	case "logical":
+	case "physical":
+		if c.Backup.Mode != "full" {
+			return fmt.Errorf("backup method 'physical' copies the whole cluster and requires mode 'full'")
+		}
+		if len(c.Databases) > 0 {
+			return fmt.Errorf("backup method 'physical' does not support a databases list")
+		}
+		if c.Backup.VerifyScratchRestore {
+			return fmt.Errorf("verify_scratch_restore is not supported for physical backups")
+		}
+	default:
+		return fmt.Errorf("backup method must be 'logical' or 'physical'")
+	}
+
+	switch c.Backup.Format {
+	case "custom":
+	case "plain":
+		if c.Backup.Method == "physical" {
+			return fmt.Errorf("backup format 'plain' requires method 'logical'")
+		}
+	default:
+		return fmt.Errorf("backup format must be 'custom' or 'plain'")
+	}
+
+	if c.Backup.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Backup.Timeout)
+		if err != nil {
+			return fmt.Errorf("backup timeout %q is not a valid duration: %w", c.Backup.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("backup timeout must be positive")
+		}
+	}
+
+	if c.Backup.MaxAttempts < 0 {
+		return fmt.Errorf("backup max_attempts must not be negative")
+	}
+	if c.Backup.RetryWait != "" {
+		wait, err := time.ParseDuration(c.Backup.RetryWait)
+		if err != nil {
+			return fmt.Errorf("backup retry_wait %q is not a valid duration: %w", c.Backup.RetryWait, err)
+		}
+		if wait <= 0 {
+			return fmt.Errorf("backup retry_wait must be positive")
+		}
+	}
+
+	if c.Backup.Concurrency < 0 {
+		return fmt.Errorf("backup concurrency must not be negative")
+	}
+
+	if c.Backup.SpaceMultiplier < 0 {
+		return fmt.Errorf("backup space_multiplier must not be negative")
+	}
+
+	if c.Backup.TempDir != "" {
+		if err := checkWritableDir(c.Backup.TempDir); err != nil {
+			return fmt.Errorf("backup temp_dir %q is not usable: %w", c.Backup.TempDir, err)
+		}
+	}
+
+	if len(c.Backup.SentinelTables) > 0 {
+		if c.Backup.Method == "physical" {
+			return fmt.Errorf("sentinel_tables are not supported for physical backups")
+		}
+		if c.Backup.Mode == "schema" {
+			return fmt.Errorf("sentinel_tables need backups that include data, not mode 'schema'")
+		}
+	}
+
+	if c.Backup.WALArchiveDir != "" {
+		if c.Backup.Method != "physical" {
+			return fmt.Errorf("wal_archive_dir requires backup method 'physical'")
+		}
+		if info, err := os.Stat(c.Backup.WALArchiveDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("wal_archive_dir %q is not a directory", c.Backup.WALArchiveDir)
+		}
+	}
+	if c.Backup.WALPollInterval != "" {
+		interval, err := time.ParseDuration(c.Backup.WALPollInterval)
+		if err != nil {
+			return fmt.Errorf("backup wal_poll_interval %q is not a valid duration: %w", c.Backup.WALPollInterval, err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("backup wal_poll_interval must be positive")
+		}
+	}
+	if c.Backup.InstanceLockTTL != "" {
+		ttl, err := time.ParseDuration(c.Backup.InstanceLockTTL)
+		if err != nil {
+			return fmt.Errorf("backup instance_lock_ttl %q is not a valid duration: %w", c.Backup.InstanceLockTTL, err)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("backup instance_lock_ttl must not be negative")
+		}
+	}
+	if c.Backup.ChecksumAlgorithm != "" && !checksum.Valid(c.Backup.ChecksumAlgorithm) {
+		return fmt.Errorf("backup checksum_algorithm %q is not supported (use %s)", c.Backup.ChecksumAlgorithm, strings.Join(checksum.Algorithms(), ", "))
+	}
+	if c.Backup.MaxOnDemandPerHour < 0 {
+		return fmt.Errorf("backup max_on_demand_per_hour must not be negative")
+	}
+
+	if c.Backup.VerifyScratchRestore {
+		if c.Backup.Mode == "data" {
+			return fmt.Errorf("verify_scratch_restore cannot restore data-only backups")
+		}
+		if c.Backup.ScratchDatabaseURL == "" {
+			return fmt.Errorf("scratch_database_url is required when verify_scratch_restore is enabled")
+		}
+		if !strings.HasPrefix(c.Backup.ScratchDatabaseURL, "postgres://") && !strings.HasPrefix(c.Backup.ScratchDatabaseURL, "postgresql://") {
+			return fmt.Errorf("scratch_database_url must be a postgres:// URL")
+		}
+	}
+
+	if c.Storage.Backend != "local" && c.Storage.Backend != "s3" {
+		return fmt.Errorf("storage backend must be 'local' or 's3'")
+	}
+
+	if c.Storage.Concurrency < 0 {
+		return fmt.Errorf("storage concurrency must not be negative")
+	}
+	switch c.Storage.StartupCheck {
+	case "", "warn", "fail", "off":
+	default:
+		return fmt.Errorf("storage startup_check must be 'warn', 'fail', or 'off'")
+	}
+	for _, spec := range c.Storage.Secondaries {
+		kind, location, ok := strings.Cut(spec, ":")
+		if !ok || location == "" || (kind != "local" && kind != "s3") {
+			return fmt.Errorf("storage secondary %q must be local:<path> or s3:<bucket>", spec)
+		}
+	}
+	if c.Storage.Cache.MaxBackups < 0 || c.Storage.Cache.MaxBytes < 0 {
+		return fmt.Errorf("storage cache max_backups and max_bytes must not be negative")
+	}
+
+	if c.Storage.KeyTemplate != "" {
+		if err := validateKeyTemplate(c.Storage.KeyTemplate); err != nil {
+			return err
+		}
+	}
+
+	if c.Storage.Backend == "s3" {
+		if c.Storage.S3.Bucket == "" {
+			return fmt.Errorf("S3 bucket is required when using S3 storage")
+		}
+		if c.Storage.S3.AccessKey == "" || c.Storage.S3.SecretKey == "" {
+			return fmt.Errorf("S3 access key and secret key are required")
+		}
+		if c.Storage.S3.MaxAttempts < 0 {
+			return fmt.Errorf("S3 max_attempts must not be negative")
+		}
+		if c.Storage.S3.ObjectLockDays < 0 {
+			return fmt.Errorf("S3 object_lock_days must not be negative")
+		}
+		switch strings.ToUpper(c.Storage.S3.ObjectLockMode) {
+		case "", "GOVERNANCE", "COMPLIANCE":
+		default:
+			return fmt.Errorf("S3 object_lock_mode must be GOVERNANCE or COMPLIANCE, got %q", c.Storage.S3.ObjectLockMode)
+		}
+	} else if c.Storage.S3.ObjectLockDays > 0 {
+		return fmt.Errorf("S3 object_lock_days requires the s3 storage backend")
+	}
+
+	if c.Retention.MaxTotalBytes < 0 {
+		return fmt.Errorf("retention max_total_bytes must not be negative")
+	}
+	if c.Retention.KeepNewest < 0 {
+		return fmt.Errorf("retention keep_newest must not be negative")
+	}
+	for i, r := range c.Retention.Rules {
+		if len(r.Match) == 0 {
+			return fmt.Errorf("retention rules[%d]: match must list at least one label", i)
+		}
+		if r.KeepDays <= 0 {
+			return fmt.Errorf("retention rules[%d]: keep_days must be positive", i)
+		}
+	}
+
+	if c.Compression != "gzip" && c.Compression != "zstd" && c.Compression != "none" {
+		return fmt.Errorf("compression must be 'gzip', 'zstd', or 'none'")
+	}
+
+	if c.Monitoring.AnomalyWindow < 0 {
+		return fmt.Errorf("monitoring anomaly_window must not be negative")
+	}
+	if c.Monitoring.AnomalyPercent < 0 {
+		return fmt.Errorf("monitoring anomaly_percent must not be negative")
+	}
+
+	switch c.Monitoring.WebhookFormat {
+	case "", "generic", "slack", "discord":
+	default:
+		return fmt.Errorf("webhook_format must be 'generic', 'slack', or 'discord'")
+	}
+
+	if email := c.Monitoring.Email; email.Enabled() {
+		if email.Port < 1 || email.Port > 65535 {
+			return fmt.Errorf("email port must be between 1 and 65535")
+		}
+		if email.From == "" || len(email.To) == 0 {
+			return fmt.Errorf("email from and to are required when email host is set")
+		}
+		if email.TimeoutSeconds <= 0 {
+			return fmt.Errorf("email timeout_seconds must be positive")
+		}
+	}
+
+	if c.Encryption.Key != "" {
+		key, err := hex.DecodeString(c.Encryption.Key)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("encryption key must be 64 hex characters (32 bytes)")
+		}
+	}
+	if k := c.Encryption.MetadataSigningKey; k != "" && len(k) < 16 {
+		return fmt.Errorf("metadata_signing_key must be at least 16 characters")
+	}
+
+	if c.Hooks.TimeoutSeconds < 0 {
+		return fmt.Errorf("hooks timeout_seconds must not be negative")
+	}
+
+	if c.Backup.CompressInDB {
+		if c.Backup.Method == "physical" {
+			return fmt.Errorf("compress_in_db is not supported for physical backups")
+		}
+		if c.Backup.Format == "plain" {
+			return fmt.Errorf("compress_in_db requires backup format 'custom'")
+		}
+		if c.CompressionLevel < 0 || c.CompressionLevel > 9 {
+			return fmt.Errorf("compression_level must be between 1 and 9 for compress_in_db")
+		}
+	} else if c.CompressionLevel != 0 {
+		switch c.Compression {
+		case "gzip":
+			if c.CompressionLevel < 1 || c.CompressionLevel > 9 {
+				return fmt.Errorf("compression_level must be between 1 and 9 for gzip")
+			}
+		case "zstd":
+			if c.CompressionLevel < 1 || c.CompressionLevel > 19 {
+				return fmt.Errorf("compression_level must be between 1 and 19 for zstd")
+			}
+		default:
+			return fmt.Errorf("compression_level requires gzip or zstd compression")
+		}
+	}
+
+	return nil
+}
+
+// validateDatabase checks the settings of one database to back up.
+func (c *Config) validateDatabase(d *DatabaseConfig) error {
+	dbType := strings.ToLower(d.Type)
+	if dbType == "" {
+		dbType = "postgres"
+	}
+
+	switch dbType {
+	case "postgres", "postgresql", "pg":
+		if d.URL == "" && d.Name == "" {
+			return fmt.Errorf("database name or URL is required for PostgreSQL")
+		}
+	case "sqlite", "sqlite3":
+		if d.Path == "" && d.Name == "" {
+			return fmt.Errorf("database path is required for SQLite")
+		}
+	default:
+		return fmt.Errorf("unsupported database type: %s (supported: postgres, sqlite)", d.Type)
+	}
+
+	if d.SSLMode != "" || d.SSLRootCert != "" || d.SSLCert != "" || d.SSLKey != "" {
+		if d.IsSQLite() {
+			return fmt.Errorf("database ssl settings are only supported for PostgreSQL")
+		}
+		if d.SSLMode != "" && !slices.Contains(sslModes, d.SSLMode) {
+			return fmt.Errorf("database ssl_mode must be one of %s", strings.Join(sslModes, ", "))
+		}
+		if (d.SSLCert == "") != (d.SSLKey == "") {
+			return fmt.Errorf("database ssl_cert and ssl_key must be set together")
+		}
+	}
+
+	if d.ConnectTimeoutSeconds < 0 {
+		return fmt.Errorf("database connect_timeout_seconds must not be negative")
+	}
+
+	if d.DumpJobs < 0 {
+		return fmt.Errorf("database dump_jobs must not be negative")
+	}
+
+	if len(d.IncludeTables) > 0 || len(d.ExcludeTables) > 0 {
+		if d.IsSQLite() {
+			return fmt.Errorf("include_tables/exclude_tables are only supported for PostgreSQL")
+		}
+		for _, include := range d.IncludeTables {
+			for _, exclude := range d.ExcludeTables {
+				if include == exclude {
+					return fmt.Errorf("table pattern %q is both included and excluded", include)
+				}
+			}
+		}
+	}
+
+	if d.SQLiteRestoreCopies < 0 {
+		return fmt.Errorf("database sqlite_restore_copies must not be negative")
+	}
+
+	switch d.SQLiteMethod {
+	case "", "dump":
+	case "backup":
+		if c.Backup.Mode == "schema" {
+			return fmt.Errorf("backup mode 'schema' requires sqlite_method 'dump'")
+		}
+	default:
+		return fmt.Errorf("database sqlite_method must be 'dump' or 'backup'")
+	}
+
+	if c.Backup.Method == "physical" {
+		if d.IsSQLite() {
+			return fmt.Errorf("backup method 'physical' is only supported for PostgreSQL")
+		}
+		if len(d.IncludeTables) > 0 || len(d.ExcludeTables) > 0 {
+			return fmt.Errorf("include_tables/exclude_tables are not supported for physical backups")
+		}
+	}
+
+	if c.Backup.Format == "plain" {
+		if d.IsSQLite() {
+			return fmt.Errorf("backup format 'plain' is only supported for PostgreSQL")
+		}
+		if d.DumpJobs > 1 {
+			return fmt.Errorf("database dump_jobs requires backup format 'custom'")
+		}
+	}
+
+	if d.IsSQLite() && c.Backup.Mode == "data" {
+		return fmt.Errorf("backup mode 'data' is not supported for SQLite")
+	}
+	if d.IsSQLite() && c.Backup.VerifyScratchRestore {
+		return fmt.Errorf("verify_scratch_restore is only supported for PostgreSQL")
+	}
+	if d.IsSQLite() && c.Backup.CompressInDB {
+		return fmt.Errorf("compress_in_db is only supported for PostgreSQL")
+	}
+
+	return nil
+}
+
+// checkWritableDir fails unless dir is a directory a file can be created in.
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	f, err := os.CreateTemp(dir, ".datasaver-write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// scheduleParser accepts the five-field expressions the scheduler runs.
+// Descriptors such as @daily are rejected because the scheduler prefixes a
+// seconds field, which they do not support.
+var scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+func validateSchedule(field, spec string) error {
+	if _, err := scheduleParser.Parse(spec); err != nil {
+		return fmt.Errorf("%s %q is not a valid cron expression: %w", field, spec, err)
+	}
+	return nil
+}
+
+// parseJitter parses a schedule_jitter range such as "0-15m" or "5m-10m". A
+// single duration such as "15m" means "0-15m", and an empty value no jitter.
+func parseJitter(spec string) (time.Duration, time.Duration, error) {
+	if spec == "" {
+		return 0, 0, nil
+	}
+
+	lowSpec, highSpec, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		lowSpec, highSpec = "0", spec
+	}
+	parse := func(v string) (time.Duration, error) {
+		if v = strings.TrimSpace(v); v == "0" {
+			return 0, nil
+		}
+		return time.ParseDuration(v)
+	}
+	low, err := parse(lowSpec)
+	if err != nil {
+		return 0, 0, fmt.Errorf("schedule_jitter %q is not a valid range: %w", spec, err)
+	}
+	high, err := parse(highSpec)
+	if err != nil {
+		return 0, 0, fmt.Errorf("schedule_jitter %q is not a valid range: %w", spec, err)
+	}
+	if low < 0 || high < low {
+		return 0, 0, fmt.Errorf("schedule_jitter %q must be a non-negative range from low to high", spec)
+	}
+	return low, high, nil
+}
+
+// splitList parses a comma-separated environment value, dropping blanks.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ChangedSections returns the YAML names of the top-level sections that
+// differ between c and other, e.g. to report what a reload changed.
+func (c *Config) ChangedSections(other *Config) []string {
+	sections := []struct {
+		name    string
+		changed bool
+	}{
+		{"database", !reflect.DeepEqual(c.Database, other.Database) || !reflect.DeepEqual(c.Databases, other.Databases)},
+		{"schedule", c.Schedule != other.Schedule || c.ScheduleJitter != other.ScheduleJitter || !reflect.DeepEqual(c.Schedules, other.Schedules)},
+		{"storage", !reflect.DeepEqual(c.Storage, other.Storage)},
+		{"retention", !reflect.DeepEqual(c.Retention, other.Retention)},
+		{"compression", c.Compression != other.Compression || c.CompressionLevel != other.CompressionLevel},
+		{"encryption", c.Encryption != other.Encryption},
+		{"monitoring", !reflect.DeepEqual(c.Monitoring, other.Monitoring)},
+		{"backup", !reflect.DeepEqual(c.Backup, other.Backup)},
+		{"hooks", !reflect.DeepEqual(c.Hooks, other.Hooks)},
+	}
+
+	var changed []string
+	for _, section := range sections {
+		if section.changed {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
+
+// ScheduleJitterRange returns the bounds of the random delay applied before
+// each scheduled backup.
+func (c *Config) ScheduleJitterRange() (time.Duration, time.Duration) {
+	low, high, _ := parseJitter(c.ScheduleJitter)
+	return low, high
+}
+
+func (c *Config) AlertDuration() time.Duration {
 	return time.Duration(c.Monitoring.AlertAfterHours) * time.Hour
 }
 
+// BackupTimeout returns the deadline for one backup run, or 0 for none.
+func (c *Config) BackupTimeout() time.Duration {
+	timeout, _ := time.ParseDuration(c.Backup.Timeout)
+	return timeout
+}
+
+// EncryptionKey returns the decoded encryption key, or nil when backups are
+// not encrypted.
+func (c *Config) EncryptionKey() []byte {
+	key, err := hex.DecodeString(c.Encryption.Key)
+	if err != nil || len(key) == 0 {
+		return nil
+	}
+	return key
+}
+
+// MetadataSigningKey returns the key backup metadata is signed with, or nil
+// when signing is off.
+func (c *Config) MetadataSigningKey() []byte {
+	if c.Encryption.MetadataSigningKey == "" {
+		return nil
+	}
+	return []byte(c.Encryption.MetadataSigningKey)
+}
+
+// RetryWait returns the wait before the first dump retry, or 0 for the
+// default.
+func (c *Config) RetryWait() time.Duration {
+	wait, _ := time.ParseDuration(c.Backup.RetryWait)
+	return wait
+}
+
+// InstanceLockTTL returns how long the daemon's instance lock lasts without
+// a heartbeat, or 0 when the lock is disabled.
+func (c *Config) InstanceLockTTL() time.Duration {
+	if c.Backup.InstanceLockTTL == "" {
+		return DefaultInstanceLockTTL
+	}
+	ttl, _ := time.ParseDuration(c.Backup.InstanceLockTTL)
+	return ttl
+}
+
+// ChecksumAlgorithm returns the algorithm checksums of new backups use.
+func (c *Config) ChecksumAlgorithm() string {
+	if c.Backup.ChecksumAlgorithm == "" {
+		return checksum.Default
+	}
+	return c.Backup.ChecksumAlgorithm
+}
+
+// WALPollInterval returns how often the WAL archive directory is checked,
+// or 0 for the default.
+func (c *Config) WALPollInterval() time.Duration {
+	interval, _ := time.ParseDuration(c.Backup.WALPollInterval)
+	return interval
+}
+
+func (c *Config) EmailTimeout() time.Duration {
+	return time.Duration(c.Monitoring.Email.TimeoutSeconds) * time.Second
+}
+
+// HookTimeout bounds each hook command; zero means no limit.
+func (c *Config) HookTimeout() time.Duration {
+	return time.Duration(c.Hooks.TimeoutSeconds) * time.Second
+}
+
 func (c *Config) IsSQLite() bool {
-	t := strings.ToLower(c.Database.Type)
-	return t == "sqlite" || t == "sqlite3"
+	return c.Database.IsSQLite()
 }
 
 func (c *Config) IsPostgres() bool {
-	t := strings.ToLower(c.Database.Type)
+	return c.Database.IsPostgres()
+}
+
+func (d *DatabaseConfig) IsSQLite() bool {
+	t := strings.ToLower(d.Type)
+	return t == "sqlite" || t == "sqlite3"
+}
+
+func (d *DatabaseConfig) IsPostgres() bool {
+	t := strings.ToLower(d.Type)
 	return t == "" || t == "postgres" || t == "postgresql" || t == "pg"
 }
+
+// DisplayName identifies the database in backup metadata, logs and metrics:
+// its name, or its path for SQLite databases configured by path alone.
+func (d *DatabaseConfig) DisplayName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.Path
+}
+
+// Binary returns the binary to run for the client tool named tool, such as
+// "pg_restore": its configured path, or tool itself to find it on PATH.
+func (d *DatabaseConfig) Binary(tool string) string {
+	var path string
+	switch tool {
+	case "pg_dump":
+		path = d.PGDumpPath
+	case "pg_restore":
+		path = d.PGRestorePath
+	case "psql":
+		path = d.PSQLPath
+	case "pg_basebackup":
+		path = d.PGBasebackupPath
+	case "sqlite3":
+		path = d.SQLite3Path
+	}
+	if path == "" {
+		return tool
+	}
+	return path
+}
+
+// DatabaseTargets returns the databases a backup run covers: each entry of
+// Databases laid over Database, or Database alone when the list is empty.
+func (c *Config) DatabaseTargets() []DatabaseConfig {
+	if len(c.Databases) == 0 {
+		return []DatabaseConfig{c.Database}
+	}
+
+	targets := make([]DatabaseConfig, len(c.Databases))
+	for i, override := range c.Databases {
+		targets[i] = mergeDatabase(c.Database, override)
+	}
+	return targets
+}
+
+// mergeDatabase returns base with every field override sets replaced.
+func mergeDatabase(base, override DatabaseConfig) DatabaseConfig {
+	merged := reflect.ValueOf(&base).Elem()
+	o := reflect.ValueOf(override)
+	for i := 0; i < o.NumField(); i++ {
+		if !o.Field(i).IsZero() {
+			merged.Field(i).Set(o.Field(i))
+		}
+	}
+	return base
+}
+
+// BackupSchedules returns the backup jobs to schedule: the schedules list,
+// or a single unnamed job running schedule.backup when the list is empty.
+func (c *Config) BackupSchedules() []ScheduleEntry {
+	if len(c.Schedules) == 0 {
+		return []ScheduleEntry{{Cron: c.Schedule.Backup}}
+	}
+	return c.Schedules
+}
+
+// ForSchedule returns a copy of c with s's mode, compression and retention
+// in place of the top-level ones.
+func (c *Config) ForSchedule(s ScheduleEntry) *Config {
+	cfg := *c
+	if s.Mode != "" {
+		cfg.Backup.Mode = s.Mode
+	}
+	if s.Compression != "" {
+		cfg.Compression = s.Compression
+		cfg.CompressionLevel = s.CompressionLevel
+	}
+	if s.Retention != nil {
+		cfg.Retention = *s.Retention
+	}
+	return &cfg
+}
+
+// scheduleName matches schedule names, which become part of backup IDs.
+var scheduleName = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// validateSchedules checks every schedules entry, including the settings it
+// overrides, by validating the config as that schedule runs it.
+func (c *Config) validateSchedules() error {
+	seen := make(map[string]bool)
+	for i, s := range c.Schedules {
+		if !scheduleName.MatchString(s.Name) {
+			return fmt.Errorf("schedules[%d]: name %q must be letters, digits and dashes", i, s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("schedules[%d]: duplicate schedule %q", i, s.Name)
+		}
+		seen[s.Name] = true
+
+		if err := validateSchedule(fmt.Sprintf("schedules[%d].cron", i), s.Cron); err != nil {
+			return err
+		}
+		if s.CompressionLevel != 0 && s.Compression == "" {
+			return fmt.Errorf("schedules[%d]: compression_level requires compression", i)
+		}
+
+		cfg := c.ForSchedule(s)
+		cfg.Schedules = nil
+		if err := cfg.validate(); err != nil {
+			return fmt.Errorf("schedule %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateDatabaseNames rejects databases entries that would share a name,
+// since backups are told apart by it.
+func (c *Config) validateDatabaseNames() error {
+	if len(c.Databases) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for i, d := range c.DatabaseTargets() {
+		name := d.DisplayName()
+		if name == "" {
+			return fmt.Errorf("databases[%d]: name or path is required", i)
+		}
+		if seen[name] {
+			return fmt.Errorf("databases[%d]: duplicate database %q", i, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
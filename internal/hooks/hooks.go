@@ -0,0 +1,86 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	PreBackup   = "pre_backup"
+	PostBackup  = "post_backup"
+	PostRestore = "post_restore"
+)
+
+// Runner executes user-configured shell commands around backups and restores.
+type Runner struct {
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+// NewRunner creates a Runner that gives each command up to timeout to finish.
+// A zero timeout only bounds commands by the caller's context.
+func NewRunner(timeout time.Duration, logger *slog.Logger) *Runner {
+	return &Runner{
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// WithLogger returns a Runner like r that logs to logger, such as one
+// carrying the ID of the backup the hooks run for.
+func (r *Runner) WithLogger(logger *slog.Logger) *Runner {
+	return &Runner{timeout: r.timeout, logger: logger}
+}
+
+// Run executes commands in order with "sh -c", stopping at the first failure.
+// Each command sees the daemon's environment plus env and DATASAVER_HOOK set
+// to stage.
+func (r *Runner) Run(ctx context.Context, stage string, commands []string, env []string) error {
+	for _, command := range commands {
+		if err := r.run(ctx, stage, command, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, stage, command string, env []string) error {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	r.logger.Info("running hook", "stage", stage, "command", command)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "DATASAVER_HOOK="+stage)
+	// Run the hook in its own process group so a timeout or cancellation
+	// kills everything it started, not just the shell.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", r.timeout)
+		}
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("%s hook %q failed: %w: %s", stage, command, err, out)
+		}
+		return fmt.Errorf("%s hook %q failed: %w", stage, command, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package hooks
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRunner(timeout time.Duration) *Runner {
+	return NewRunner(timeout, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestRunner_PassesEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	r := newTestRunner(time.Minute)
+
+	err := r.Run(context.Background(), PostBackup,
+		[]string{`echo "$DATASAVER_HOOK $DATASAVER_BACKUP_ID" > ` + out},
+		[]string{"DATASAVER_BACKUP_ID=backup_20260101_020000"},
+	)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook did not write output: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "post_backup backup_20260101_020000" {
+		t.Errorf("hook saw %q, want post_backup backup_20260101_020000", got)
+	}
+}
+
+func TestRunner_StopsAtFirstFailure(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	r := newTestRunner(time.Minute)
+
+	err := r.Run(context.Background(), PreBackup, []string{
+		"echo cache flush failed >&2; exit 3",
+		"touch " + marker,
+	}, nil)
+	if err == nil {
+		t.Fatal("Run() error = nil, want error from failing hook")
+	}
+	if !strings.Contains(err.Error(), "cache flush failed") {
+		t.Errorf("Run() error = %v, want it to include the hook's output", err)
+	}
+	if _, statErr := os.Stat(marker); statErr == nil {
+		t.Error("second hook ran after the first failed")
+	}
+}
+
+func TestRunner_Timeout(t *testing.T) {
+	r := newTestRunner(100 * time.Millisecond)
+
+	start := time.Now()
+	err := r.Run(context.Background(), PreBackup, []string{"sleep 10"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Run() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %v, want it to stop at the timeout", elapsed)
+	}
+}
+
+func TestRunner_ContextCanceled(t *testing.T) {
+	r := newTestRunner(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := r.Run(ctx, PreBackup, []string{"true"}, nil); err == nil {
+		t.Error("Run() error = nil, want error for canceled context")
+	}
+}
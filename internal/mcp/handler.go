@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/localrivet/datasaver/internal/audit"
 	"github.com/localrivet/datasaver/internal/config"
 	"github.com/localrivet/datasaver/internal/mcp/mcpauth"
+	"github.com/localrivet/datasaver/internal/metrics"
 	"github.com/localrivet/datasaver/internal/notify"
 	"github.com/localrivet/datasaver/internal/storage"
 
@@ -15,12 +17,15 @@ import (
 )
 
 // Handler handles MCP HTTP requests with Bearer token authentication.
-// Supports API keys via DATASAVER_MCP_API_KEY environment variable.
+// Supports API keys via the DATASAVER_MCP_API_KEY and DATASAVER_MCP_API_KEYS
+// environment variables.
 type Handler struct {
 	cfg             *config.Config
 	storage         storage.Backend
-	notifier        *notify.Notifier
+	notifier        notify.Sender
+	metrics         *metrics.Metrics
 	logger          *slog.Logger
+	audit           *audit.Logger
 	authenticator   *mcpauth.Authenticator
 	httpHandler     http.Handler
 	resourceMetaURL string
@@ -28,20 +33,24 @@ type Handler struct {
 
 // NewHandler creates a new MCP handler with authentication.
 // baseURL is used to construct the resource metadata URL for OAuth discovery.
-func NewHandler(cfg *config.Config, store storage.Backend, notifier *notify.Notifier, logger *slog.Logger, baseURL string) *Handler {
+// Restores and cleanups run through the tools are recorded to auditLog, and
+// backups and restores they run to m.
+func NewHandler(cfg *config.Config, store storage.Backend, notifier notify.Sender, m *metrics.Metrics, logger *slog.Logger, auditLog *audit.Logger, baseURL string) *Handler {
 	baseURL = strings.TrimSuffix(baseURL, "/")
 
 	h := &Handler{
 		cfg:             cfg,
 		storage:         store,
 		notifier:        notifier,
+		metrics:         m,
 		logger:          logger,
+		audit:           auditLog,
 		authenticator:   mcpauth.NewAuthenticator(),
 		resourceMetaURL: baseURL + "/.well-known/oauth-protected-resource",
 	}
 
 	if !h.authenticator.Enabled() {
-		logger.Warn("DATASAVER_MCP_API_KEY not set - MCP endpoint will reject all requests")
+		logger.Warn("DATASAVER_MCP_API_KEY and DATASAVER_MCP_API_KEYS not set - MCP endpoint will reject all requests")
 	}
 
 	// Create the streamable HTTP handler in stateless mode.
@@ -97,6 +106,10 @@ func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if userInfo := mcpauth.UserInfoFromTokenInfo(tokenInfo); userInfo != nil {
+			h.logger.Debug("MCP request authenticated", "key_id", userInfo.KeyID, "scopes", userInfo.Scopes)
+		}
+
 		// Add token info to request context and continue
 		ctx := mcpauth.ContextWithTokenInfo(r.Context(), tokenInfo)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -113,7 +126,7 @@ func (h *Handler) writeUnauthorized(w http.ResponseWriter, _ string) {
 
 // getServerForRequest creates a new MCP server for each request.
 func (h *Handler) getServerForRequest(r *http.Request) *mcp.Server {
-	return NewServer(r.Context(), h.cfg, h.storage, h.notifier, h.logger)
+	return NewServer(r.Context(), h.cfg, h.storage, h.notifier, h.metrics, h.logger, h.audit)
 }
 
 // ServeHTTP handles all MCP HTTP requests.
@@ -20,11 +20,51 @@ const (
 	AuthModeAPIKey AuthMode = "api_key"
 )
 
+const (
+	// ScopeRead allows the tools that only read backups and status.
+	ScopeRead = "mcp:read"
+	// ScopeFull also allows tools that create, restore or delete backups.
+	ScopeFull = "mcp:full"
+)
+
+// Scopes lists every scope an API key can have.
+var Scopes = []string{ScopeRead, ScopeFull}
+
+// HasScope reports whether info grants scope. mcp:full includes mcp:read.
+func HasScope(info *auth.TokenInfo, scope string) bool {
+	if info == nil {
+		return false
+	}
+	for _, s := range info.Scopes {
+		if s == scope || s == ScopeFull {
+			return true
+		}
+	}
+	return false
+}
+
 // UserInfo contains authenticated user information.
 type UserInfo struct {
 	AuthMode  AuthMode
 	Scopes    []string
 	ExpiresAt time.Time
+
+	// KeyID identifies the API key that authenticated, for logging: the
+	// first characters of its HashToken, never the key itself.
+	KeyID string
+}
+
+// keyIDLength is how many hex characters of a key's hash make its KeyID.
+const keyIDLength = 12
+
+// UserInfoFromTokenInfo returns the UserInfo verifyAPIKey stored in info,
+// or nil.
+func UserInfoFromTokenInfo(info *auth.TokenInfo) *UserInfo {
+	if info == nil {
+		return nil
+	}
+	userInfo, _ := info.Extra["user_info"].(*UserInfo)
+	return userInfo
 }
 
 // tokenInfoKey is used to store TokenInfo in context.
@@ -45,27 +85,63 @@ func TokenInfoFromContext(ctx context.Context) *auth.TokenInfo {
 
 // Authenticator handles MCP authentication via API key.
 type Authenticator struct {
-	apiKey     string
-	apiKeyHash string
+	keys []scopedKey
+}
+
+type scopedKey struct {
+	key   string
+	hash  string
+	scope string
 }
 
 // NewAuthenticator creates a new MCP authenticator.
-// Reads API key from DATASAVER_MCP_API_KEY environment variable.
+// Reads full-access API keys from DATASAVER_MCP_API_KEY and scoped keys
+// from DATASAVER_MCP_API_KEYS. DATASAVER_MCP_API_KEY may list several
+// comma-separated keys, so a new key can be added before the old one is
+// removed when rotating.
 func NewAuthenticator() *Authenticator {
-	apiKey := os.Getenv("DATASAVER_MCP_API_KEY")
-	var apiKeyHash string
-	if apiKey != "" {
-		apiKeyHash = HashToken(apiKey)
+	keys := ParseScopedKeys(os.Getenv("DATASAVER_MCP_API_KEYS"))
+	for _, key := range strings.Split(os.Getenv("DATASAVER_MCP_API_KEY"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = ScopeFull
+		}
 	}
-	return &Authenticator{
-		apiKey:     apiKey,
-		apiKeyHash: apiKeyHash,
+	return NewAuthenticatorWithKeys(keys)
+}
+
+// NewAuthenticatorWithKeys creates an authenticator accepting each key in
+// keys with the scope it maps to. A scope other than mcp:full gives
+// mcp:read, so a typo never grants more than reading.
+func NewAuthenticatorWithKeys(keys map[string]string) *Authenticator {
+	a := &Authenticator{}
+	for key, scope := range keys {
+		if key == "" {
+			continue
+		}
+		if scope != ScopeFull {
+			scope = ScopeRead
+		}
+		a.keys = append(a.keys, scopedKey{key: key, hash: HashToken(key), scope: scope})
 	}
+	return a
+}
+
+// ParseScopedKeys parses comma-separated key=scope pairs, such as
+// "k1=mcp:read,k2=mcp:full". A key without a scope gets mcp:read.
+func ParseScopedKeys(s string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		key, scope, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = strings.TrimSpace(scope)
+		}
+	}
+	return keys
 }
 
 // Enabled returns true if an API key is configured.
 func (a *Authenticator) Enabled() bool {
-	return a.apiKey != ""
+	return len(a.keys) > 0
 }
 
 // TokenVerifier returns a token verifier function for use with auth.RequireBearerToken.
@@ -75,20 +151,25 @@ func (a *Authenticator) TokenVerifier() func(ctx context.Context, token string,
 	}
 }
 
-// verifyAPIKey validates an API key and returns token info.
+// verifyAPIKey validates an API key and returns token info carrying the
+// matching key's scope.
 func (a *Authenticator) verifyAPIKey(_ context.Context, apiKey string) (*auth.TokenInfo, error) {
-	if a.apiKey == "" {
-		return nil, auth.ErrInvalidToken
+	// Constant-time comparison to prevent timing attacks. Every key is
+	// compared so the time taken does not reveal which one matched.
+	var match *scopedKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(a.keys[i].key)) == 1 {
+			match = &a.keys[i]
+		}
 	}
-
-	// Constant-time comparison to prevent timing attacks
-	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(a.apiKey)) != 1 {
+	if match == nil {
 		return nil, auth.ErrInvalidToken
 	}
 
 	userInfo := &UserInfo{
 		AuthMode: AuthModeAPIKey,
-		Scopes:   []string{"mcp:full"},
+		Scopes:   []string{match.scope},
+		KeyID:    match.hash[:keyIDLength],
 	}
 
 	return &auth.TokenInfo{
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
 )
 
 func TestNewAuthenticator_NoAPIKey(t *testing.T) {
@@ -181,3 +183,103 @@ func TestTokenInfoFromContext_Empty(t *testing.T) {
 		t.Error("Expected nil tokenInfo from empty context")
 	}
 }
+
+func TestAuthenticator_ScopedKeys(t *testing.T) {
+	t.Setenv("DATASAVER_MCP_API_KEY", "full-key")
+	t.Setenv("DATASAVER_MCP_API_KEYS", "read-key=mcp:read, typo-key=mcp:fulll")
+
+	auth := NewAuthenticator()
+
+	tests := []struct {
+		key       string
+		wantScope string
+	}{
+		{"full-key", ScopeFull},
+		{"read-key", ScopeRead},
+		{"typo-key", ScopeRead},
+	}
+	for _, tt := range tests {
+		info, err := auth.ValidateAuthHeader("Bearer " + tt.key)
+		if err != nil {
+			t.Fatalf("ValidateAuthHeader(%s) error: %v", tt.key, err)
+		}
+		if len(info.Scopes) != 1 || info.Scopes[0] != tt.wantScope {
+			t.Errorf("%s scopes = %v, want [%s]", tt.key, info.Scopes, tt.wantScope)
+		}
+	}
+
+	if _, err := auth.ValidateAuthHeader("Bearer other-key"); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
+
+func TestNewAuthenticator_OnlyScopedKeys(t *testing.T) {
+	t.Setenv("DATASAVER_MCP_API_KEY", "")
+	t.Setenv("DATASAVER_MCP_API_KEYS", "read-key=mcp:read")
+
+	if !NewAuthenticator().Enabled() {
+		t.Error("Expected Enabled() to be true with only scoped keys")
+	}
+}
+
+func TestParseScopedKeys(t *testing.T) {
+	got := ParseScopedKeys(" a=mcp:read ,b=mcp:full,c,,")
+	want := map[string]string{"a": "mcp:read", "b": "mcp:full", "c": ""}
+	if len(got) != len(want) {
+		t.Fatalf("ParseScopedKeys() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("ParseScopedKeys()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	full := &auth.TokenInfo{Scopes: []string{ScopeFull}}
+	read := &auth.TokenInfo{Scopes: []string{ScopeRead}}
+
+	if !HasScope(full, ScopeRead) || !HasScope(full, ScopeFull) {
+		t.Error("mcp:full should grant both scopes")
+	}
+	if !HasScope(read, ScopeRead) || HasScope(read, ScopeFull) {
+		t.Error("mcp:read should grant only mcp:read")
+	}
+	if HasScope(nil, ScopeRead) {
+		t.Error("nil token info should grant nothing")
+	}
+}
+
+func TestNewAuthenticator_RotatedKeys(t *testing.T) {
+	t.Setenv("DATASAVER_MCP_API_KEYS", "")
+	t.Setenv("DATASAVER_MCP_API_KEY", "new-key, old-key")
+
+	auth := NewAuthenticator()
+
+	ids := make(map[string]bool)
+	for _, key := range []string{"new-key", "old-key"} {
+		info, err := auth.ValidateAuthHeader("Bearer " + key)
+		if err != nil {
+			t.Fatalf("ValidateAuthHeader(%s) error: %v", key, err)
+		}
+		if info.Scopes[0] != ScopeFull {
+			t.Errorf("%s scopes = %v, want [mcp:full]", key, info.Scopes)
+		}
+
+		userInfo := UserInfoFromTokenInfo(info)
+		if userInfo == nil {
+			t.Fatal("Expected user_info in Extra")
+		}
+		if want := HashToken(key)[:12]; userInfo.KeyID != want {
+			t.Errorf("%s KeyID = %q, want %q", key, userInfo.KeyID, want)
+		}
+		ids[userInfo.KeyID] = true
+	}
+	if len(ids) != 2 {
+		t.Error("Expected each key to have its own KeyID")
+	}
+
+	if _, err := auth.ValidateAuthHeader("Bearer new-key, old-key"); err == nil {
+		t.Error("Expected the raw list to be rejected as a key")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+
+	"github.com/localrivet/datasaver/internal/mcp/mcpauth"
 )
 
 // Handler provides MCP OAuth discovery endpoints.
@@ -45,7 +47,7 @@ func (h *Handler) HandleProtectedResourceMetadata(w http.ResponseWriter, r *http
 	metadata := ProtectedResourceMetadata{
 		Resource:               h.baseURL + "/mcp",
 		AuthorizationServers:   []string{h.baseURL},
-		ScopesSupported:        []string{"mcp:full"},
+		ScopesSupported:        mcpauth.Scopes,
 		BearerMethodsSupported: []string{"header"},
 	}
 
@@ -68,7 +70,7 @@ func (h *Handler) HandleAuthServerMetadata(w http.ResponseWriter, r *http.Reques
 	metadata := AuthorizationServerMetadata{
 		Issuer:                            h.baseURL,
 		TokenEndpoint:                     h.baseURL + "/oauth/token",
-		ScopesSupported:                   []string{"mcp:full"},
+		ScopesSupported:                   mcpauth.Scopes,
 		ResponseTypesSupported:            []string{}, // No authorization code flow
 		GrantTypesSupported:               []string{}, // API key only
 		TokenEndpointAuthMethodsSupported: []string{"bearer"},
@@ -50,8 +50,8 @@ func TestHandler_ProtectedResourceMetadata(t *testing.T) {
 		t.Errorf("Expected authorization server to be https://backup.example.com, got %v", metadata.AuthorizationServers)
 	}
 
-	if len(metadata.ScopesSupported) != 1 || metadata.ScopesSupported[0] != "mcp:full" {
-		t.Errorf("Expected scopes to be [mcp:full], got %v", metadata.ScopesSupported)
+	if len(metadata.ScopesSupported) != 2 || metadata.ScopesSupported[0] != "mcp:read" || metadata.ScopesSupported[1] != "mcp:full" {
+		t.Errorf("Expected scopes to be [mcp:read mcp:full], got %v", metadata.ScopesSupported)
 	}
 
 	if len(metadata.BearerMethodsSupported) != 1 || metadata.BearerMethodsSupported[0] != "header" {
@@ -84,8 +84,8 @@ func TestHandler_AuthServerMetadata(t *testing.T) {
 		t.Errorf("Expected token endpoint to be https://backup.example.com/oauth/token, got %s", metadata.TokenEndpoint)
 	}
 
-	if len(metadata.ScopesSupported) != 1 || metadata.ScopesSupported[0] != "mcp:full" {
-		t.Errorf("Expected scopes to be [mcp:full], got %v", metadata.ScopesSupported)
+	if len(metadata.ScopesSupported) != 2 || metadata.ScopesSupported[0] != "mcp:read" || metadata.ScopesSupported[1] != "mcp:full" {
+		t.Errorf("Expected scopes to be [mcp:read mcp:full], got %v", metadata.ScopesSupported)
 	}
 }
 
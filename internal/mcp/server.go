@@ -4,9 +4,12 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/localrivet/datasaver/internal/audit"
 	"github.com/localrivet/datasaver/internal/backup"
 	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/mcp/mcpauth"
 	"github.com/localrivet/datasaver/internal/mcp/tools"
+	"github.com/localrivet/datasaver/internal/metrics"
 	"github.com/localrivet/datasaver/internal/notify"
 	"github.com/localrivet/datasaver/internal/restore"
 	"github.com/localrivet/datasaver/internal/storage"
@@ -15,7 +18,11 @@ import (
 )
 
 // NewServer creates a new MCP server with all backup tools registered.
-func NewServer(ctx context.Context, cfg *config.Config, store storage.Backend, notifier *notify.Notifier, logger *slog.Logger) *mcp.Server {
+// When ctx carries the token info of a read-only API key, the tools that
+// create, restore or delete backups refuse to run. Restores and cleanups
+// are recorded to auditLog under the caller's key ID, and the backups and
+// restores the tools run to m like the daemon's own.
+func NewServer(ctx context.Context, cfg *config.Config, store storage.Backend, notifier notify.Sender, m *metrics.Metrics, logger *slog.Logger, auditLog *audit.Logger) *mcp.Server {
 	server := mcp.NewServer(&mcp.Implementation{
 		Name:    "datasaver",
 		Version: "1.0.0",
@@ -24,9 +31,16 @@ func NewServer(ctx context.Context, cfg *config.Config, store storage.Backend, n
 	toolCtx := &tools.ToolContext{
 		Config:        cfg,
 		Storage:       store,
-		BackupEngine:  backup.NewEngine(cfg, store, notifier, logger),
-		RestoreEngine: restore.NewEngine(cfg, store, logger),
+		BackupEngine:  backup.NewEngine(cfg, store, notifier, m, logger),
+		RestoreEngine: restore.NewEngine(cfg, store, notifier, m, logger),
 		Logger:        logger,
+		Audit:         auditLog,
+	}
+	if info := mcpauth.TokenInfoFromContext(ctx); info != nil {
+		toolCtx.ReadOnly = !mcpauth.HasScope(info, mcpauth.ScopeFull)
+		if user := mcpauth.UserInfoFromTokenInfo(info); user != nil {
+			toolCtx.KeyID = user.KeyID
+		}
 	}
 
 	// Register backup tools
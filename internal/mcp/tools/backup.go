@@ -5,11 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/localrivet/datasaver/internal/audit"
 	"github.com/localrivet/datasaver/internal/backup"
+	"github.com/localrivet/datasaver/internal/progress"
 	"github.com/localrivet/datasaver/internal/restore"
+	"github.com/localrivet/datasaver/pkg/postgres"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
@@ -17,27 +22,56 @@ import (
 
 type EmptyInput struct{}
 
+type BackupNowInput struct {
+	Labels map[string]string `json:"labels,omitempty" jsonschema:"Optional labels to record on the backup, e.g. {\"reason\": \"pre-upgrade\"}"`
+}
+
 type BackupNowOutput struct {
-	BackupID       string `json:"backup_id"`
-	Timestamp      string `json:"timestamp"`
+	BackupID       string            `json:"backup_id"`
+	Timestamp      string            `json:"timestamp"`
+	SizeBytes      int64             `json:"size_bytes"`
+	CompressedSize int64             `json:"compressed_size"`
+	DurationMs     int64             `json:"duration_ms"`
+	Checksum       string            `json:"checksum"`
+	Labels         map[string]string `json:"labels,omitempty"`
+
+	CompressionRatio      float64 `json:"compression_ratio"`
+	ThroughputBytesPerSec float64 `json:"throughput_bytes_per_sec"`
+
+	// Databases has one entry per database when several are configured;
+	// the fields above are then left empty.
+	Databases []DatabaseBackupOutput `json:"databases,omitempty"`
+}
+
+type DatabaseBackupOutput struct {
+	Database       string `json:"database"`
+	BackupID       string `json:"backup_id,omitempty"`
 	SizeBytes      int64  `json:"size_bytes"`
 	CompressedSize int64  `json:"compressed_size"`
 	DurationMs     int64  `json:"duration_ms"`
-	Checksum       string `json:"checksum"`
+	Checksum       string `json:"checksum,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	CompressionRatio      float64 `json:"compression_ratio,omitempty"`
+	ThroughputBytesPerSec float64 `json:"throughput_bytes_per_sec,omitempty"`
 }
 
 type ListBackupsInput struct {
-	Limit int `json:"limit" jsonschema:"Maximum number of backups to return (default: 20)"`
+	Limit  int               `json:"limit,omitempty" jsonschema:"Maximum number of backups to return, from 1 to 1000 (default: 20)"`
+	Labels map[string]string `json:"labels,omitempty" jsonschema:"Optional: only return backups with all of these labels"`
+	Since  string            `json:"since,omitempty" jsonschema:"Optional: only backups taken at or after this RFC 3339 time, date (2024-01-15) or age (7d, 2w, 12h)"`
+	Until  string            `json:"until,omitempty" jsonschema:"Optional: only backups taken before this RFC 3339 time, age, or the end of this date"`
 }
 
 type BackupItem struct {
-	ID             string `json:"id"`
-	Timestamp      string `json:"timestamp"`
-	Database       string `json:"database"`
-	SizeBytes      int64  `json:"size_bytes"`
-	CompressedSize int64  `json:"compressed_size"`
-	Type           string `json:"type"`
-	Checksum       string `json:"checksum"`
+	ID             string            `json:"id"`
+	Timestamp      string            `json:"timestamp"`
+	Database       string            `json:"database"`
+	SizeBytes      int64             `json:"size_bytes"`
+	CompressedSize int64             `json:"compressed_size"`
+	Type           string            `json:"type"`
+	Checksum       string            `json:"checksum"`
+	Labels         map[string]string `json:"labels,omitempty"`
 }
 
 type ListBackupsOutput struct {
@@ -57,12 +91,73 @@ type GetBackupOutput struct {
 	Backup    map[string]interface{} `json:"backup"`
 	Files     []string               `json:"files"`
 	Retention map[string]interface{} `json:"retention"`
+
+	// TableStats are the row counts of each table when the backup was
+	// taken, present when backup.collect_stats was set.
+	TableStats map[string]int64 `json:"table_stats,omitempty"`
+}
+
+type CompareBackupsInput struct {
+	BackupIDA string `json:"backup_id_a" jsonschema:"The backup to compare from, usually the older one"`
+	BackupIDB string `json:"backup_id_b" jsonschema:"The backup to compare to"`
+}
+
+type CompareBackupsOutput struct {
+	Before BackupItem    `json:"before"`
+	After  BackupItem    `json:"after"`
+	Fields []FieldChange `json:"fields"`
+
+	// Tables lists the tables whose row counts differ; TablesCompared is
+	// how many tables either backup recorded counts for.
+	Tables         []TableChange `json:"tables,omitempty"`
+	TablesCompared int           `json:"tables_compared"`
+}
+
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Change string `json:"change,omitempty"`
+}
+
+// TableChange is a table's row count in both backups; -1 means the backup
+// has no count for it.
+type TableChange struct {
+	Table  string `json:"table"`
+	Before int64  `json:"before"`
+	After  int64  `json:"after"`
+}
+
+// NewCompareBackupsOutput converts a backup diff for output.
+func NewCompareBackupsOutput(diff *backup.Diff) CompareBackupsOutput {
+	output := CompareBackupsOutput{
+		Before:         *ToBackupItem(diff.Before),
+		After:          *ToBackupItem(diff.After),
+		Fields:         make([]FieldChange, 0, len(diff.Fields)),
+		TablesCompared: diff.TablesCompared,
+	}
+	for _, f := range diff.Fields {
+		output.Fields = append(output.Fields, FieldChange{Field: f.Field, Before: f.Before, After: f.After, Change: f.Change})
+	}
+	for _, t := range diff.Tables {
+		output.Tables = append(output.Tables, TableChange{Table: t.Table, Before: t.Before, After: t.After})
+	}
+	return output
 }
 
 type RestoreBackupInput struct {
-	BackupID string `json:"backup_id" jsonschema:"The backup ID to restore from"`
-	TargetDB string `json:"target_db,omitempty" jsonschema:"Optional: restore to a different database name"`
-	DryRun   bool   `json:"dry_run,omitempty" jsonschema:"If true, validate the restore without applying changes"`
+	BackupID string `json:"backup_id,omitempty" jsonschema:"The backup ID to restore from; omit when latest is set"`
+	Latest   bool   `json:"latest,omitempty" jsonschema:"If true, restore the most recent backup instead of backup_id"`
+	Type     string `json:"type,omitempty" jsonschema:"Optional with latest: the most recent backup of this type (daily, weekly, monthly or yearly)"`
+	TargetDB string `json:"target_db,omitempty" jsonschema:"Optional: restore to a different database name; required for SQLite backups, as the path of a file that does not exist yet"`
+	DryRun   bool   `json:"dry_run,omitempty" jsonschema:"If true, download and validate the backup without restoring it"`
+
+	TargetHost     string `json:"target_host,omitempty" jsonschema:"Optional: restore into this PostgreSQL server instead of the configured one"`
+	TargetPort     int    `json:"target_port,omitempty" jsonschema:"Optional: port of the target server"`
+	TargetUser     string `json:"target_user,omitempty" jsonschema:"Optional: user for the target server"`
+	TargetPassword string `json:"target_password,omitempty" jsonschema:"Optional: password for the target server"`
+
+	Table string `json:"table,omitempty" jsonschema:"Optional: restore only this table (name or schema.name) of a PostgreSQL custom or directory format dump, leaving the rest of the target alone"`
 }
 
 type RestoreBackupOutput struct {
@@ -70,61 +165,351 @@ type RestoreBackupOutput struct {
 	TargetDB string `json:"target_db"`
 	Success  bool   `json:"success"`
 	DryRun   bool   `json:"dry_run"`
+	Mode     string `json:"mode"`
+
+	// Set by dry runs: what validation found in the backup.
+	ChecksumValid bool `json:"checksum_valid,omitempty"`
+	ContentValid  bool `json:"content_valid,omitempty"`
+	Tables        int  `json:"tables,omitempty"`
+	Objects       int  `json:"objects,omitempty"`
+
+	// Suspect is set when the restored sentinel tables could not be counted
+	// or differ from the row counts recorded at backup time.
+	Suspect            bool               `json:"suspect,omitempty"`
+	RowCountMismatches []RowCountMismatch `json:"row_count_mismatches,omitempty"`
+
+	// Warnings are caveats about what was restored, such as foreign keys a
+	// single-table restore may leave unsatisfied.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type RowCountMismatch struct {
+	Table    string `json:"table"`
+	Expected int64  `json:"expected"`
+	Actual   int64  `json:"actual"`
 }
 
 type BackupStatusOutput struct {
 	Status       string `json:"status"`
 	TotalBackups int    `json:"total_backups"`
 	StorageBytes int64  `json:"storage_bytes"`
+	StorageCap   int64  `json:"storage_cap_bytes,omitempty"`
 	LastBackup   string `json:"last_backup,omitempty"`
 	LastRun      string `json:"last_run,omitempty"`
 	LastError    string `json:"last_error,omitempty"`
+
+	Schedules []ScheduleStatus `json:"schedules,omitempty"`
+}
+
+// ScheduleStatus is the next run of one named backup schedule.
+type ScheduleStatus struct {
+	Name       string `json:"name"`
+	Schedule   string `json:"schedule"`
+	NextBackup string `json:"next_backup,omitempty"`
+}
+
+// resolveBackupID returns the backup restore_backup should restore: the
+// given ID, or with latest set the most recent backup of the given type.
+func resolveBackupID(ctx context.Context, engine *backup.Engine, input RestoreBackupInput) (string, error) {
+	switch {
+	case input.Latest && input.BackupID != "":
+		return "", fmt.Errorf("set either backup_id or latest, not both")
+	case input.Latest:
+		meta, err := engine.Latest(ctx, input.Type)
+		if err != nil {
+			return "", err
+		}
+		return meta.ID, nil
+	case input.BackupID == "":
+		return "", fmt.Errorf("backup_id is required unless latest is set")
+	case input.Type != "":
+		return "", fmt.Errorf("type only applies with latest")
+	}
+	return input.BackupID, nil
+}
+
+// NamedSchedules converts runs for output, leaving out the default
+// schedule, which has no name.
+func NamedSchedules(runs []backup.ScheduledRun) []ScheduleStatus {
+	var statuses []ScheduleStatus
+	for _, run := range runs {
+		if run.Name == "" {
+			continue
+		}
+		status := ScheduleStatus{Name: run.Name, Schedule: run.Cron}
+		if !run.Next.IsZero() {
+			status.NextBackup = run.Next.Format(time.RFC3339)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+type BackupStatsOutput struct {
+	TotalBackups          int              `json:"total_backups"`
+	CountByType           map[string]int   `json:"count_by_type"`
+	SizeByTypeBytes       map[string]int64 `json:"size_by_type_bytes"`
+	TotalSizeBytes        int64            `json:"total_size_bytes"`
+	AverageSizeBytes      int64            `json:"average_size_bytes"`
+	MinSizeBytes          int64            `json:"min_size_bytes"`
+	MaxSizeBytes          int64            `json:"max_size_bytes"`
+	UncompressedSizeBytes int64            `json:"uncompressed_size_bytes"`
+	CompressionRatio      float64          `json:"compression_ratio"`
+	OldestBackup          *BackupItem      `json:"oldest_backup,omitempty"`
+	NewestBackup          *BackupItem      `json:"newest_backup,omitempty"`
+	ProjectedDeletions    int              `json:"projected_deletions"`
+	ReclaimableBytes      int64            `json:"reclaimable_bytes"`
+	StorageCapBytes       int64            `json:"storage_cap_bytes,omitempty"`
+}
+
+type CleanupInput struct {
+	DryRun bool `json:"dry_run,omitempty" jsonschema:"If true, report what would be deleted without deleting anything"`
 }
 
 type CleanupOutput struct {
-	DeletedCount int    `json:"deleted_count"`
-	Message      string `json:"message"`
+	DeletedCount   int               `json:"deleted_count"`
+	Message        string            `json:"message"`
+	DryRun         bool              `json:"dry_run"`
+	ReclaimedBytes int64             `json:"reclaimed_bytes,omitempty"`
+	Backups        []CleanupDecision `json:"backups,omitempty"`
+}
+
+type CleanupDecision struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Action    string `json:"action"` // keep or delete
+	SizeBytes int64  `json:"size_bytes"`
+	Reason    string `json:"reason"`
 }
 
 type VerifyBackupInput struct {
 	BackupID string `json:"backup_id" jsonschema:"The backup ID to verify"`
+	Deep     bool   `json:"deep,omitempty" jsonschema:"If true, also restore the backup into a temporary database"`
 }
 
 type VerifyBackupOutput struct {
-	BackupID   string   `json:"backup_id"`
-	Valid      bool     `json:"valid"`
-	FileExists bool     `json:"file_exists"`
-	SizeMatch  bool     `json:"size_match"`
-	ChecksumOK bool     `json:"checksum_ok"`
-	Errors     []string `json:"errors,omitempty"`
+	BackupID      string   `json:"backup_id"`
+	Valid         bool     `json:"valid"`
+	FileExists    bool     `json:"file_exists"`
+	SizeMatch     bool     `json:"size_match"`
+	ChecksumOK    bool     `json:"checksum_ok"`
+	CompressionOK bool     `json:"compression_ok"`
+	RestoreOK     bool     `json:"restore_ok,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
 }
 
-// RegisterBackupTools registers all backup-related tools with the MCP server.
-func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
-	// backup_now - Trigger an immediate backup
-	mcp.AddTool(server, &mcp.Tool{
-		Name:        "backup_now",
-		Description: "Trigger an immediate database backup",
-	}, func(ctx context.Context, req *mcp.CallToolRequest, input EmptyInput) (*mcp.CallToolResult, BackupNowOutput, error) {
-		result, err := toolCtx.BackupEngine.Run(ctx)
-		if err != nil {
-			return nil, BackupNowOutput{}, err
+// NewVerifyBackupOutput converts a validation result for output.
+func NewVerifyBackupOutput(result *backup.ValidationResult) VerifyBackupOutput {
+	return VerifyBackupOutput{
+		BackupID:      result.BackupID,
+		Valid:         result.Valid,
+		FileExists:    result.FileExists,
+		SizeMatch:     result.SizeMatch,
+		ChecksumOK:    result.ChecksumOK,
+		CompressionOK: result.CompressionOK,
+		RestoreOK:     result.RestoreOK,
+		Errors:        result.Errors,
+	}
+}
+
+type VerifyAllBackupsInput struct {
+	Deep bool `json:"deep,omitempty" jsonschema:"If true, also restore each backup into a temporary database"`
+}
+
+type VerifyAllBackupsOutput struct {
+	Total   int                  `json:"total"`
+	Passed  int                  `json:"passed"`
+	Failed  int                  `json:"failed"`
+	Backups []VerifyBackupOutput `json:"backups"`
+}
+
+// NewVerifyAllBackupsOutput summarizes the results of verifying every backup.
+func NewVerifyAllBackupsOutput(results []*backup.ValidationResult) VerifyAllBackupsOutput {
+	output := VerifyAllBackupsOutput{
+		Total:   len(results),
+		Backups: make([]VerifyBackupOutput, 0, len(results)),
+	}
+	for _, result := range results {
+		if result.Valid {
+			output.Passed++
+		} else {
+			output.Failed++
 		}
+		output.Backups = append(output.Backups, NewVerifyBackupOutput(result))
+	}
+	return output
+}
+
+// ToBackupItem converts backup metadata to its listing form.
+func ToBackupItem(b *postgres.BackupMetadata) *BackupItem {
+	return &BackupItem{
+		ID:             b.ID,
+		Timestamp:      b.Timestamp.Format(time.RFC3339),
+		Database:       b.Database.Name,
+		SizeBytes:      b.Backup.SizeBytes,
+		CompressedSize: b.Backup.CompressedSize,
+		Type:           b.Type,
+		Checksum:       b.Backup.Checksum,
+		Labels:         b.Labels,
+	}
+}
 
-		return nil, BackupNowOutput{
+// NewBackupNowOutput describes the results of a backup run. A single
+// database fills the top-level fields; several are listed in Databases.
+func NewBackupNowOutput(results []*backup.BackupResult, labels map[string]string) BackupNowOutput {
+	if len(results) == 1 {
+		result := results[0]
+		return BackupNowOutput{
 			BackupID:       result.ID,
 			Timestamp:      result.Timestamp.Format(time.RFC3339),
 			SizeBytes:      result.Size,
 			CompressedSize: result.CompressedSize,
 			DurationMs:     result.Duration.Milliseconds(),
 			Checksum:       result.Checksum,
-		}, nil
+			Labels:         labels,
+
+			CompressionRatio:      result.CompressionRatio,
+			ThroughputBytesPerSec: result.ThroughputBytesPerSec,
+		}
+	}
+
+	output := BackupNowOutput{Labels: labels}
+	for _, result := range results {
+		db := DatabaseBackupOutput{
+			Database: result.Database,
+			BackupID: result.ID,
+		}
+		if result.Error != nil {
+			db.Error = result.Error.Error()
+		} else {
+			db.SizeBytes = result.Size
+			db.CompressedSize = result.CompressedSize
+			db.DurationMs = result.Duration.Milliseconds()
+			db.Checksum = result.Checksum
+			db.CompressionRatio = result.CompressionRatio
+			db.ThroughputBytesPerSec = result.ThroughputBytesPerSec
+		}
+		output.Databases = append(output.Databases, db)
+	}
+	return output
+}
+
+// withProgress makes a backup or restore run under ctx send MCP progress
+// notifications when the client asked for them with a progress token.
+// Progress counts bytes across the dump, compress and upload steps (or
+// download and restore), so it only ever increases; the message names the
+// current step.
+func withProgress(ctx context.Context, req *mcp.CallToolRequest) context.Context {
+	if req == nil || req.Session == nil || req.Params == nil {
+		return ctx
+	}
+	token := req.Params.GetProgressToken()
+	if token == nil {
+		return ctx
+	}
+
+	var mu sync.Mutex // Databases may back up concurrently
+	var base, last int64
+	var op string
+	return progress.WithFunc(ctx, func(u progress.Update) {
+		mu.Lock()
+		defer mu.Unlock()
+		if u.Op != op {
+			base += last
+			op = u.Op
+		}
+		last = u.Bytes
+		_ = req.Session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+			ProgressToken: token,
+			Progress:      float64(base + u.Bytes),
+			Message:       u.String(),
+		})
+	})
+}
+
+func anySucceeded(results []*backup.BackupResult) bool {
+	for _, result := range results {
+		if result.Error == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func rowCountMismatches(mismatches []restore.RowCountMismatch) []RowCountMismatch {
+	var out []RowCountMismatch
+	for _, m := range mismatches {
+		out = append(out, RowCountMismatch{Table: m.Table, Expected: m.Expected, Actual: m.Actual})
+	}
+	return out
+}
+
+// filterByLabels keeps the backups carrying every label in want.
+func filterByLabels(backups []*postgres.BackupMetadata, want map[string]string) []*postgres.BackupMetadata {
+	if len(want) == 0 {
+		return backups
+	}
+	var matched []*postgres.BackupMetadata
+	for _, b := range backups {
+		if b.HasLabels(want) {
+			matched = append(matched, b)
+		}
+	}
+	return matched
+}
+
+// filterByTime keeps the backups taken within the since and until bounds
+// of input.
+func filterByTime(backups []*postgres.BackupMetadata, input ListBackupsInput) ([]*postgres.BackupMetadata, error) {
+	r, err := postgres.ParseTimeRange(input.Since, input.Until, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if r == (postgres.TimeRange{}) {
+		return backups, nil
+	}
+	var matched []*postgres.BackupMetadata
+	for _, b := range backups {
+		if r.Contains(b.Timestamp) {
+			matched = append(matched, b)
+		}
+	}
+	return matched, nil
+}
+
+// RegisterBackupTools registers all backup-related tools with the MCP server.
+func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
+	// backup_now - Trigger an immediate backup
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "backup_now",
+		Description: "Trigger an immediate database backup. Fails with a rate limited error, saying when to try again, once backup.max_on_demand_per_hour backups were taken by hand within the hour",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(false)},
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input BackupNowInput) (*mcp.CallToolResult, BackupNowOutput, error) {
+		if err := toolCtx.requireFull("backup_now"); err != nil {
+			return nil, BackupNowOutput{}, err
+		}
+
+		results, err := toolCtx.BackupEngine.RunAll(withProgress(ctx, req), input.Labels)
+		// With several databases, partial failures are reported per
+		// database; the call fails only if nothing was backed up.
+		if err != nil && !anySucceeded(results) {
+			return nil, BackupNowOutput{}, err
+		}
+
+		return nil, NewBackupNowOutput(results, input.Labels), nil
 	})
 
 	// list_backups - List all available backups
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "list_backups",
 		Description: "List all available database backups",
+		Annotations: readOnly,
+		InputSchema: inputSchema[ListBackupsInput](func(props map[string]*jsonschema.Schema) {
+			props["limit"].Minimum = float(1)
+			props["limit"].Maximum = float(1000)
+			props["limit"].Default = json.RawMessage("20")
+		}),
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input ListBackupsInput) (*mcp.CallToolResult, ListBackupsOutput, error) {
 		limit := input.Limit
 		if limit <= 0 {
@@ -135,6 +520,11 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 		if err != nil {
 			return nil, ListBackupsOutput{}, err
 		}
+		backups = filterByLabels(backups, input.Labels)
+		backups, err = filterByTime(backups, input)
+		if err != nil {
+			return nil, ListBackupsOutput{}, err
+		}
 
 		// Sort by timestamp descending
 		sort.Slice(backups, func(i, j int) bool {
@@ -149,15 +539,7 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 		// Convert to response format
 		items := make([]BackupItem, len(backups))
 		for i, b := range backups {
-			items[i] = BackupItem{
-				ID:             b.ID,
-				Timestamp:      b.Timestamp.Format(time.RFC3339),
-				Database:       b.Database.Name,
-				SizeBytes:      b.Backup.SizeBytes,
-				CompressedSize: b.Backup.CompressedSize,
-				Type:           b.Type,
-				Checksum:       b.Backup.Checksum,
-			}
+			items[i] = *ToBackupItem(b)
 		}
 
 		return nil, ListBackupsOutput{
@@ -170,6 +552,8 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "get_backup",
 		Description: "Get detailed information about a specific backup",
+		Annotations: readOnly,
+		InputSchema: inputSchema[GetBackupInput](backupIDProperty),
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input GetBackupInput) (*mcp.CallToolResult, GetBackupOutput, error) {
 		meta, err := toolCtx.BackupEngine.GetBackup(ctx, input.BackupID)
 		if err != nil {
@@ -186,32 +570,84 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 				"version": meta.Database.Version,
 			},
 			Backup: map[string]interface{}{
-				"method":          meta.Backup.Method,
-				"format":          meta.Backup.Format,
-				"compression":     meta.Backup.Compression,
-				"size_bytes":      meta.Backup.SizeBytes,
-				"compressed_size": meta.Backup.CompressedSize,
-				"duration_s":      meta.Backup.DurationSeconds,
-				"checksum":        meta.Backup.Checksum,
+				"method":                   meta.Backup.Method,
+				"format":                   meta.Backup.Format,
+				"compression":              meta.Backup.Compression,
+				"compressed_in_db":         meta.Backup.CompressedInDB,
+				"size_bytes":               meta.Backup.SizeBytes,
+				"compressed_size":          meta.Backup.CompressedSize,
+				"duration_s":               meta.Backup.DurationSeconds,
+				"checksum":                 meta.Backup.Checksum,
+				"include_tables":           meta.Backup.IncludeTables,
+				"exclude_tables":           meta.Backup.ExcludeTables,
+				"mode":                     meta.BackupMode(),
+				"compression_ratio":        meta.Backup.CompressionRatio,
+				"throughput_bytes_per_sec": meta.Backup.ThroughputBytesPerSec,
 			},
 			Files: meta.Files,
 			Retention: map[string]interface{}{
 				"keep_until": meta.Retention.KeepUntil.Format(time.RFC3339),
 				"policy":     meta.Retention.Policy,
 			},
+			TableStats: meta.Backup.TableStats,
 		}, nil
 	})
 
+	// compare_backups - Diff the metadata of two backups
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "compare_backups",
+		Description: "Compare two backups' metadata and report the change in size, compressed size, duration, database version and table row counts, e.g. to find out why a backup grew",
+		Annotations: readOnly,
+		InputSchema: inputSchema[CompareBackupsInput](func(props map[string]*jsonschema.Schema) {
+			props["backup_id_a"].Examples = []any{backupIDExample}
+			props["backup_id_b"].Examples = []any{backupIDExample}
+		}),
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input CompareBackupsInput) (*mcp.CallToolResult, CompareBackupsOutput, error) {
+		diff, err := toolCtx.BackupEngine.Diff(ctx, input.BackupIDA, input.BackupIDB)
+		if err != nil {
+			return nil, CompareBackupsOutput{}, err
+		}
+
+		return nil, NewCompareBackupsOutput(diff), nil
+	})
+
 	// restore_backup - Restore from a backup
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "restore_backup",
-		Description: "Restore the database from a backup. Use with caution!",
+		Description: "Restore the database from a backup, overwriting its current data unless dry_run is set. Use with caution and confirm with the user first!",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(true)},
+		InputSchema: inputSchema[RestoreBackupInput](func(props map[string]*jsonschema.Schema) {
+			backupIDProperty(props)
+			props["type"].Enum = backupTypeEnum()
+			props["target_port"].Minimum = float(1)
+			props["target_port"].Maximum = float(65535)
+		}),
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input RestoreBackupInput) (*mcp.CallToolResult, RestoreBackupOutput, error) {
-		result, err := toolCtx.RestoreEngine.Restore(ctx, restore.RestoreOptions{
-			BackupID: input.BackupID,
+		if !input.DryRun {
+			if err := toolCtx.requireFull("restore_backup"); err != nil {
+				return nil, RestoreBackupOutput{}, err
+			}
+		}
+
+		backupID, err := resolveBackupID(ctx, toolCtx.BackupEngine, input)
+		if err != nil {
+			toolCtx.audit(audit.Event{Op: "restore", TargetDB: input.TargetDB, DryRun: input.DryRun, Err: err})
+			return nil, RestoreBackupOutput{}, err
+		}
+
+		result, err := toolCtx.RestoreEngine.Restore(withProgress(ctx, req), restore.RestoreOptions{
+			BackupID: backupID,
 			TargetDB: input.TargetDB,
 			DryRun:   input.DryRun,
+
+			TargetHost:     input.TargetHost,
+			TargetPort:     input.TargetPort,
+			TargetUser:     input.TargetUser,
+			TargetPassword: input.TargetPassword,
+
+			Table: input.Table,
 		})
+		toolCtx.audit(audit.Event{Op: "restore", BackupID: backupID, TargetDB: result.TargetDB, DryRun: input.DryRun, Err: err})
 		if err != nil {
 			return nil, RestoreBackupOutput{}, err
 		}
@@ -221,6 +657,17 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 			TargetDB: result.TargetDB,
 			Success:  result.Success,
 			DryRun:   input.DryRun,
+			Mode:     result.Mode,
+
+			ChecksumValid: result.ChecksumValid,
+			ContentValid:  result.ContentValid,
+			Tables:        result.Tables,
+			Objects:       result.Objects,
+
+			Suspect:            result.Suspect,
+			RowCountMismatches: rowCountMismatches(result.RowCountMismatches),
+
+			Warnings: result.Warnings,
 		}, nil
 	})
 
@@ -228,6 +675,7 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "backup_status",
 		Description: "Get the current status of the backup system",
+		Annotations: readOnly,
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input EmptyInput) (*mcp.CallToolResult, BackupStatusOutput, error) {
 		backups, err := toolCtx.BackupEngine.ListBackups(ctx)
 		if err != nil {
@@ -257,6 +705,8 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 			Status:       status,
 			TotalBackups: len(backups),
 			StorageBytes: totalSize,
+			StorageCap:   toolCtx.BackupEngine.StorageCap(),
+			Schedules:    NamedSchedules(backup.UpcomingRuns(toolCtx.Config.BackupSchedules(), time.Now())),
 		}
 
 		if !lastBackup.IsZero() {
@@ -272,12 +722,82 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 		return nil, output, nil
 	})
 
+	// backup_stats - Summarize backup sizes and retention
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "backup_stats",
+		Description: "Summarize backup counts and compressed sizes by type, total sizes, compression ratio, oldest and newest backups, and deletions projected by the retention policy",
+		Annotations: readOnly,
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input EmptyInput) (*mcp.CallToolResult, BackupStatsOutput, error) {
+		stats, err := toolCtx.BackupEngine.Stats(ctx)
+		if err != nil {
+			return nil, BackupStatsOutput{}, err
+		}
+
+		output := BackupStatsOutput{
+			TotalBackups:          stats.Count,
+			CountByType:           stats.CountByType,
+			SizeByTypeBytes:       stats.SizeByType,
+			TotalSizeBytes:        stats.TotalSize,
+			AverageSizeBytes:      stats.AverageSize,
+			MinSizeBytes:          stats.MinSize,
+			MaxSizeBytes:          stats.MaxSize,
+			UncompressedSizeBytes: stats.UncompressedSize,
+			CompressionRatio:      stats.CompressionRatio,
+			ProjectedDeletions:    stats.ProjectedDeletions,
+			ReclaimableBytes:      stats.ReclaimableBytes,
+			StorageCapBytes:       stats.MaxTotalBytes,
+		}
+
+		if stats.Oldest != nil {
+			output.OldestBackup = ToBackupItem(stats.Oldest)
+		}
+		if stats.Newest != nil {
+			output.NewestBackup = ToBackupItem(stats.Newest)
+		}
+
+		return nil, output, nil
+	})
+
 	// cleanup_backups - Run backup cleanup based on retention policy
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "cleanup_backups",
-		Description: "Run backup cleanup to remove old backups based on retention policy",
-	}, func(ctx context.Context, req *mcp.CallToolRequest, input EmptyInput) (*mcp.CallToolResult, CleanupOutput, error) {
+		Description: "Run backup cleanup to permanently delete old backups based on retention policy. Use dry_run to preview which backups would be deleted and why, and confirm with the user before deleting",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(true), IdempotentHint: true},
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input CleanupInput) (*mcp.CallToolResult, CleanupOutput, error) {
+		if input.DryRun {
+			decisions, err := toolCtx.BackupEngine.PreviewCleanup(ctx)
+			if err != nil {
+				return nil, CleanupOutput{}, err
+			}
+
+			output := CleanupOutput{DryRun: true}
+			for _, d := range decisions {
+				action := "keep"
+				if !d.Keep {
+					action = "delete"
+					output.DeletedCount++
+					output.ReclaimedBytes += d.Metadata.Backup.CompressedSize
+				}
+				output.Backups = append(output.Backups, CleanupDecision{
+					ID:        d.Metadata.ID,
+					Timestamp: d.Metadata.Timestamp.Format(time.RFC3339),
+					Type:      string(d.Type),
+					Action:    action,
+					SizeBytes: d.Metadata.Backup.CompressedSize,
+					Reason:    d.Reason,
+				})
+			}
+			output.Message = fmt.Sprintf("Dry run: %d backups would be deleted", output.DeletedCount)
+
+			return nil, output, nil
+		}
+
+		if err := toolCtx.requireFull("cleanup_backups"); err != nil {
+			return nil, CleanupOutput{}, err
+		}
+
 		count, err := toolCtx.BackupEngine.Cleanup(ctx)
+		toolCtx.audit(audit.Event{Op: "cleanup", Deleted: count, Err: err})
 		if err != nil {
 			return nil, CleanupOutput{}, err
 		}
@@ -292,51 +812,68 @@ func RegisterBackupTools(server *mcp.Server, toolCtx *ToolContext) {
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "verify_backup",
 		Description: "Validate the integrity of a specific backup",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(false)},
+		InputSchema: inputSchema[VerifyBackupInput](backupIDProperty),
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input VerifyBackupInput) (*mcp.CallToolResult, VerifyBackupOutput, error) {
 		meta, err := toolCtx.BackupEngine.GetBackup(ctx, input.BackupID)
 		if err != nil {
 			return nil, VerifyBackupOutput{}, err
 		}
 
-		validator := backup.NewValidator(toolCtx.Storage, toolCtx.Logger)
-		result, err := validator.Validate(ctx, meta)
+		result, err := toolCtx.BackupEngine.Verify(ctx, meta, input.Deep)
 		if err != nil {
 			return nil, VerifyBackupOutput{}, err
 		}
 
-		return nil, VerifyBackupOutput{
-			BackupID:   input.BackupID,
-			Valid:      result.Valid,
-			FileExists: result.FileExists,
-			SizeMatch:  result.SizeMatch,
-			ChecksumOK: result.ChecksumOK,
-			Errors:     result.Errors,
-		}, nil
+		return nil, NewVerifyBackupOutput(result), nil
+	})
+
+	// verify_all_backups - Validate every backup
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "verify_all_backups",
+		Description: "Validate the integrity of every backup and summarize how many passed",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(false)},
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input VerifyAllBackupsInput) (*mcp.CallToolResult, VerifyAllBackupsOutput, error) {
+		results, err := toolCtx.BackupEngine.VerifyAll(ctx, input.Deep)
+		if err != nil {
+			return nil, VerifyAllBackupsOutput{}, err
+		}
+
+		return nil, NewVerifyAllBackupsOutput(results), nil
 	})
 }
 
 // registerBackupToolsToRegistry registers tools to a registry for direct invocation.
 func registerBackupToolsToRegistry(registry *ToolRegistry, toolCtx *ToolContext) {
 	registry.Register("backup_now", func(ctx context.Context, args json.RawMessage) (interface{}, error) {
-		result, err := toolCtx.BackupEngine.Run(ctx)
-		if err != nil {
+		var input BackupNowInput
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &input); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+		if err := toolCtx.requireFull("backup_now"); err != nil {
 			return nil, err
 		}
-		return map[string]any{
-			"backup_id":       result.ID,
-			"timestamp":       result.Timestamp.Format(time.RFC3339),
-			"size_bytes":      result.Size,
-			"compressed_size": result.CompressedSize,
-			"duration_ms":     result.Duration.Milliseconds(),
-		}, nil
+		results, err := toolCtx.BackupEngine.RunAll(ctx, input.Labels)
+		if err != nil && !anySucceeded(results) {
+			return nil, err
+		}
+		return NewBackupNowOutput(results, input.Labels), nil
 	})
 
 	registry.Register("list_backups", func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		var input ListBackupsInput
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &input); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
 		backups, err := toolCtx.BackupEngine.ListBackups(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return backups, nil
+		return filterByTime(filterByLabels(backups, input.Labels), input)
 	})
 
 	registry.Register("backup_status", func(ctx context.Context, args json.RawMessage) (interface{}, error) {
@@ -1,10 +1,13 @@
 package tools
 
 import (
+	"fmt"
 	"log/slog"
 
+	"github.com/localrivet/datasaver/internal/audit"
 	"github.com/localrivet/datasaver/internal/backup"
 	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/mcp/mcpauth"
 	"github.com/localrivet/datasaver/internal/restore"
 	"github.com/localrivet/datasaver/internal/storage"
 )
@@ -16,4 +19,29 @@ type ToolContext struct {
 	BackupEngine  *backup.Engine
 	RestoreEngine *restore.Engine
 	Logger        *slog.Logger
+
+	// ReadOnly is set when the caller's API key only has mcp:read. Tools
+	// that create, restore or delete backups then refuse to run.
+	ReadOnly bool
+
+	// Audit records restores and cleanups, attributed to KeyID, the
+	// caller's API key. Nil discards them.
+	Audit *audit.Logger
+	KeyID string
+}
+
+// audit records e as coming from the caller's API key.
+func (c *ToolContext) audit(e audit.Event) {
+	e.Source = audit.SourceMCP
+	e.Actor = c.KeyID
+	c.Audit.Record(e)
+}
+
+// requireFull returns a permission error from tool when the caller's key
+// is read-only.
+func (c *ToolContext) requireFull(tool string) error {
+	if c.ReadOnly {
+		return fmt.Errorf("permission denied: %s requires an API key with the %s scope", tool, mcpauth.ScopeFull)
+	}
+	return nil
 }
@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/localrivet/datasaver/internal/rotation"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// backupIDExample shows clients the format of the IDs GenerateBackupID
+// produces.
+const backupIDExample = "backup_20240115_020000"
+
+// inputSchema infers T's input schema the way mcp.AddTool would, then lets
+// refine add the constraints a jsonschema struct tag cannot express. The
+// SDK validates arguments against the result before calling the tool.
+func inputSchema[T any](refine func(props map[string]*jsonschema.Schema)) *jsonschema.Schema {
+	schema, err := jsonschema.For[T](nil)
+	if err != nil {
+		// Input types are fixed at compile time; AddTool panics the same way.
+		panic(fmt.Sprintf("inferring tool input schema: %v", err))
+	}
+	refine(schema.Properties)
+	return schema
+}
+
+// backupIDProperty adds an example ID to a backup_id property.
+func backupIDProperty(props map[string]*jsonschema.Schema) {
+	props["backup_id"].Examples = []any{backupIDExample}
+}
+
+// backupTypeEnum lists the backup types ClassifyBackup assigns.
+func backupTypeEnum() []any {
+	return []any{
+		string(rotation.BackupTypeDaily),
+		string(rotation.BackupTypeWeekly),
+		string(rotation.BackupTypeMonthly),
+		string(rotation.BackupTypeYearly),
+	}
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// readOnly marks tools that only read backups and status.
+var readOnly = &mcp.ToolAnnotations{ReadOnlyHint: true}
@@ -9,42 +9,55 @@ import (
 )
 
 type Metrics struct {
-	backupDuration    prometheus.Histogram
-	backupSize        prometheus.Gauge
-	backupTotal       prometheus.Counter
-	backupFailures    prometheus.Counter
+	backupDuration    *prometheus.HistogramVec
+	backupSize        *prometheus.GaugeVec
+	backupTotal       *prometheus.CounterVec
+	backupFailures    *prometheus.CounterVec
 	lastBackupTime    prometheus.Gauge
 	lastBackupSuccess prometheus.Gauge
-	storageUsed       prometheus.Gauge
+	storageUsed       *prometheus.GaugeVec
+	lastBackupAge     prometheus.Gauge
+	backupOverdue     prometheus.Gauge
+
+	verifyRestoreTotal       prometheus.Counter
+	verifyRestoreFailures    prometheus.Counter
+	lastVerifyRestoreSuccess prometheus.Gauge
+
+	restoreDuration prometheus.Histogram
+	restoreTotal    prometheus.Counter
+	restoreFailures prometheus.Counter
 }
 
+// backupLabels distinguish backups by GFS tier and source database.
+var backupLabels = []string{"backup_type", "database"}
+
 func New(namespace string) *Metrics {
 	if namespace == "" {
 		namespace = "datasaver"
 	}
 
 	m := &Metrics{
-		backupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+		backupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "backup_duration_seconds",
 			Help:      "Duration of backup operations in seconds",
 			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
-		}),
-		backupSize: prometheus.NewGauge(prometheus.GaugeOpts{
+		}, backupLabels),
+		backupSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "backup_size_bytes",
 			Help:      "Size of the last backup in bytes",
-		}),
-		backupTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		}, backupLabels),
+		backupTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "backups_total",
 			Help:      "Total number of backups attempted",
-		}),
-		backupFailures: prometheus.NewCounter(prometheus.CounterOpts{
+		}, backupLabels),
+		backupFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "backup_failures_total",
 			Help:      "Total number of failed backups",
-		}),
+		}, backupLabels),
 		lastBackupTime: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "last_backup_timestamp",
@@ -55,10 +68,51 @@ func New(namespace string) *Metrics {
 			Name:      "last_backup_success",
 			Help:      "Whether the last backup was successful (1) or not (0)",
 		}),
-		storageUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+		storageUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "storage_used_bytes",
-			Help:      "Total storage used by all backups in bytes",
+			Help:      "Storage used by backups in bytes, by backup type",
+		}, []string{"type"}),
+		lastBackupAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_backup_age_seconds",
+			Help:      "Seconds since the last successful backup",
+		}),
+		backupOverdue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backup_overdue",
+			Help:      "Whether the last successful backup is older than the alert threshold (1) or not (0)",
+		}),
+		verifyRestoreTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "verify_restore_total",
+			Help:      "Total number of scheduled restore drills attempted",
+		}),
+		verifyRestoreFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "verify_restore_failures_total",
+			Help:      "Total number of scheduled restore drills that failed",
+		}),
+		lastVerifyRestoreSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_verify_restore_success",
+			Help:      "Whether the last restore drill was successful (1) or not (0)",
+		}),
+		restoreDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "restore_duration_seconds",
+			Help:      "Duration of restore operations in seconds",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		restoreTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "restores_total",
+			Help:      "Total number of restores attempted",
+		}),
+		restoreFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "restore_failures_total",
+			Help:      "Total number of failed restores",
 		}),
 	}
 
@@ -70,28 +124,81 @@ func New(namespace string) *Metrics {
 		m.lastBackupTime,
 		m.lastBackupSuccess,
 		m.storageUsed,
+		m.lastBackupAge,
+		m.backupOverdue,
+		m.verifyRestoreTotal,
+		m.verifyRestoreFailures,
+		m.lastVerifyRestoreSuccess,
+		m.restoreDuration,
+		m.restoreTotal,
+		m.restoreFailures,
 	)
 
 	return m
 }
 
-func (m *Metrics) RecordBackupSuccess(duration time.Duration, sizeBytes int64) {
-	m.backupTotal.Inc()
-	m.backupDuration.Observe(duration.Seconds())
-	m.backupSize.Set(float64(sizeBytes))
+func (m *Metrics) RecordBackupSuccess(backupType, database string, duration time.Duration, sizeBytes int64) {
+	m.backupTotal.WithLabelValues(backupType, database).Inc()
+	m.backupDuration.WithLabelValues(backupType, database).Observe(duration.Seconds())
+	m.backupSize.WithLabelValues(backupType, database).Set(float64(sizeBytes))
 	m.lastBackupTime.SetToCurrentTime()
 	m.lastBackupSuccess.Set(1)
+	m.lastBackupAge.Set(0)
+	m.backupOverdue.Set(0)
 }
 
-func (m *Metrics) RecordBackupFailure() {
-	m.backupTotal.Inc()
-	m.backupFailures.Inc()
+func (m *Metrics) RecordBackupFailure(backupType, database string) {
+	m.backupTotal.WithLabelValues(backupType, database).Inc()
+	m.backupFailures.WithLabelValues(backupType, database).Inc()
 	m.lastBackupTime.SetToCurrentTime()
 	m.lastBackupSuccess.Set(0)
 }
 
-func (m *Metrics) SetStorageUsed(bytes int64) {
-	m.storageUsed.Set(float64(bytes))
+func (m *Metrics) RecordVerifyRestoreSuccess() {
+	m.verifyRestoreTotal.Inc()
+	m.lastVerifyRestoreSuccess.Set(1)
+}
+
+func (m *Metrics) RecordVerifyRestoreFailure() {
+	m.verifyRestoreTotal.Inc()
+	m.verifyRestoreFailures.Inc()
+	m.lastVerifyRestoreSuccess.Set(0)
+}
+
+func (m *Metrics) RecordRestore(success bool, duration time.Duration) {
+	m.restoreTotal.Inc()
+	m.restoreDuration.Observe(duration.Seconds())
+	if !success {
+		m.restoreFailures.Inc()
+	}
+}
+
+// storageTypes always have a storage used series, 0 when no backup of the
+// type is stored, so dashboards do not show gaps for empty tiers.
+var storageTypes = []string{"daily", "weekly", "monthly", "yearly"}
+
+// SetStorageUsed records the bytes stored per backup type. Types missing
+// from byType since the last call are dropped, or set to 0 for the GFS
+// tiers.
+func (m *Metrics) SetStorageUsed(byType map[string]int64) {
+	m.storageUsed.Reset()
+	for _, t := range storageTypes {
+		m.storageUsed.WithLabelValues(t).Set(0)
+	}
+	for t, bytes := range byType {
+		m.storageUsed.WithLabelValues(t).Set(float64(bytes))
+	}
+}
+
+// SetLastBackupAge records how long ago the last successful backup ran and
+// whether that is longer than maxAge.
+func (m *Metrics) SetLastBackupAge(age, maxAge time.Duration) {
+	m.lastBackupAge.Set(age.Seconds())
+	if age > maxAge {
+		m.backupOverdue.Set(1)
+	} else {
+		m.backupOverdue.Set(0)
+	}
 }
 
 func Handler() http.Handler {
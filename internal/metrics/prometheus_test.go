@@ -63,7 +63,7 @@ func TestMetrics_RecordBackupSuccess(t *testing.T) {
 	m := New("test_success")
 
 	// Record a successful backup
-	m.RecordBackupSuccess(5*time.Second, 1024*1024)
+	m.RecordBackupSuccess("daily", "app", 5*time.Second, 1024*1024)
 
 	// We can't easily verify the values without exposing the metrics
 	// but at least we verify no panic occurs
@@ -75,19 +75,204 @@ func TestMetrics_RecordBackupFailure(t *testing.T) {
 	m := New("test_failure")
 
 	// Record a failed backup
-	m.RecordBackupFailure()
+	m.RecordBackupFailure("daily", "app")
 
 	// Verify no panic
 }
 
+func TestMetrics_BackupLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+
+	m := New("test_labels")
+
+	m.RecordBackupSuccess("daily", "app", time.Second, 1024)
+	m.RecordBackupSuccess("monthly", "app", time.Minute, 4096)
+	m.RecordBackupFailure("daily", "billing")
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+
+	totals := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() != "test_labels_backups_total" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range metric.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			totals[labels["backup_type"]+"/"+labels["database"]] = metric.GetCounter().GetValue()
+		}
+	}
+
+	want := map[string]float64{
+		"daily/app":     1,
+		"monthly/app":   1,
+		"daily/billing": 1,
+	}
+	for key, v := range want {
+		if totals[key] != v {
+			t.Errorf("backups_total{%s} = %v, want %v", key, totals[key], v)
+		}
+	}
+}
+
+func TestMetrics_RecordVerifyRestore(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+
+	m := New("test_verify_restore")
+
+	m.RecordVerifyRestoreSuccess()
+	m.RecordVerifyRestoreFailure()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, metric := range mf.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[mf.GetName()] = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[mf.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+
+	if got := values["test_verify_restore_verify_restore_total"]; got != 2 {
+		t.Errorf("verify_restore_total = %v, want 2", got)
+	}
+	if got := values["test_verify_restore_verify_restore_failures_total"]; got != 1 {
+		t.Errorf("verify_restore_failures_total = %v, want 1", got)
+	}
+	if got := values["test_verify_restore_last_verify_restore_success"]; got != 0 {
+		t.Errorf("last_verify_restore_success = %v, want 0", got)
+	}
+}
+
+func TestMetrics_RecordRestore(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+
+	m := New("test_restore")
+
+	m.RecordRestore(true, 30*time.Second)
+	m.RecordRestore(false, 5*time.Second)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, metric := range mf.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[mf.GetName()] = metric.GetCounter().GetValue()
+			case metric.GetHistogram() != nil:
+				values[mf.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	if got := values["test_restore_restores_total"]; got != 2 {
+		t.Errorf("restores_total = %v, want 2", got)
+	}
+	if got := values["test_restore_restore_failures_total"]; got != 1 {
+		t.Errorf("restore_failures_total = %v, want 1", got)
+	}
+	if got := values["test_restore_restore_duration_seconds"]; got != 2 {
+		t.Errorf("restore_duration_seconds count = %v, want 2", got)
+	}
+}
+
 func TestMetrics_SetStorageUsed(t *testing.T) {
-	resetRegistry()
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
 
 	m := New("test_storage")
 
-	m.SetStorageUsed(1024 * 1024 * 100) // 100MB
+	byType := func() map[string]float64 {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Gather() error: %v", err)
+		}
+		values := make(map[string]float64)
+		for _, mf := range families {
+			if mf.GetName() != "test_storage_storage_used_bytes" {
+				continue
+			}
+			for _, metric := range mf.GetMetric() {
+				values[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+			}
+		}
+		return values
+	}
 
-	// Verify no panic
+	m.SetStorageUsed(map[string]int64{"daily": 100 * 1024 * 1024, "monthly": 300, "wal": 50})
+	got := byType()
+	want := map[string]float64{"daily": 100 * 1024 * 1024, "weekly": 0, "monthly": 300, "yearly": 0, "wal": 50}
+	if len(got) != len(want) {
+		t.Errorf("storage_used_bytes = %v, want %v", got, want)
+	}
+	for typ, v := range want {
+		if got[typ] != v {
+			t.Errorf("storage_used_bytes{type=%q} = %v, want %v", typ, got[typ], v)
+		}
+	}
+
+	// A type with no backups left drops out, except the GFS tiers.
+	m.SetStorageUsed(map[string]int64{"weekly": 10})
+	got = byType()
+	if _, ok := got["wal"]; ok || got["daily"] != 0 || got["weekly"] != 10 || len(got) != 4 {
+		t.Errorf("storage_used_bytes after update = %v, want daily, monthly and yearly 0, weekly 10", got)
+	}
+}
+
+func TestMetrics_SetLastBackupAge(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+
+	m := New("test_backup_age")
+
+	gauge := func(name string) float64 {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Gather() error: %v", err)
+		}
+		for _, mf := range families {
+			if mf.GetName() == name {
+				return mf.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		t.Fatalf("metric %s not found", name)
+		return 0
+	}
+
+	m.SetLastBackupAge(30*time.Hour, 26*time.Hour)
+	if got := gauge("test_backup_age_last_backup_age_seconds"); got != 30*3600 {
+		t.Errorf("last_backup_age_seconds = %v, want %v", got, 30*3600)
+	}
+	if got := gauge("test_backup_age_backup_overdue"); got != 1 {
+		t.Errorf("backup_overdue = %v, want 1", got)
+	}
+
+	m.RecordBackupSuccess("daily", "app", time.Second, 1024)
+	if got := gauge("test_backup_age_last_backup_age_seconds"); got != 0 {
+		t.Errorf("last_backup_age_seconds after backup = %v, want 0", got)
+	}
+	if got := gauge("test_backup_age_backup_overdue"); got != 0 {
+		t.Errorf("backup_overdue after backup = %v, want 0", got)
+	}
 }
 
 func TestMetrics_MultipleOperations(t *testing.T) {
@@ -97,13 +282,13 @@ func TestMetrics_MultipleOperations(t *testing.T) {
 
 	// Simulate multiple backup cycles
 	for i := 0; i < 5; i++ {
-		m.RecordBackupSuccess(time.Duration(i)*time.Second, int64(i)*1024)
+		m.RecordBackupSuccess("daily", "app", time.Duration(i)*time.Second, int64(i)*1024)
 	}
 
-	m.RecordBackupFailure()
-	m.RecordBackupFailure()
+	m.RecordBackupFailure("daily", "app")
+	m.RecordBackupFailure("daily", "app")
 
-	m.SetStorageUsed(5 * 1024)
+	m.SetStorageUsed(map[string]int64{"daily": 5 * 1024})
 
 	// All operations should complete without panic
 }
@@ -148,7 +333,7 @@ func TestMetrics_HistogramBuckets(t *testing.T) {
 	}
 
 	for _, d := range durations {
-		m.RecordBackupSuccess(d, 1024)
+		m.RecordBackupSuccess("daily", "app", d, 1024)
 	}
 
 	// Verify no panic with various bucket values
@@ -160,8 +345,8 @@ func TestMetrics_LargeValues(t *testing.T) {
 	m := New("test_large")
 
 	// Test with large values (10TB backup, 1 hour duration)
-	m.RecordBackupSuccess(time.Hour, 10*1024*1024*1024*1024)
-	m.SetStorageUsed(100 * 1024 * 1024 * 1024 * 1024) // 100TB
+	m.RecordBackupSuccess("daily", "app", time.Hour, 10*1024*1024*1024*1024)
+	m.SetStorageUsed(map[string]int64{"daily": 100 * 1024 * 1024 * 1024 * 1024}) // 100TB
 
 	// Should handle large values without overflow
 }
@@ -172,8 +357,8 @@ func TestMetrics_ZeroValues(t *testing.T) {
 	m := New("test_zero")
 
 	// Test with zero values
-	m.RecordBackupSuccess(0, 0)
-	m.SetStorageUsed(0)
+	m.RecordBackupSuccess("daily", "app", 0, 0)
+	m.SetStorageUsed(map[string]int64{})
 
 	// Should handle zero values
 }
@@ -190,11 +375,11 @@ func TestMetrics_ConcurrentAccess(t *testing.T) {
 		go func(id int) {
 			for j := 0; j < 100; j++ {
 				if j%2 == 0 {
-					m.RecordBackupSuccess(time.Second, 1024)
+					m.RecordBackupSuccess("daily", "app", time.Second, 1024)
 				} else {
-					m.RecordBackupFailure()
+					m.RecordBackupFailure("daily", "app")
 				}
-				m.SetStorageUsed(int64(j * 1024))
+				m.SetStorageUsed(map[string]int64{"daily": int64(j * 1024)})
 			}
 			done <- true
 		}(i)
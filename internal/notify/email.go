@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailConfig holds the SMTP settings for EmailNotifier.
+type EmailConfig struct {
+	Host     string
+	Port     int
+	Username string // Authenticates with PLAIN when set
+	Password string
+	From     string
+	To       []string
+	Timeout  time.Duration // Bounds the whole SMTP conversation
+}
+
+// EmailNotifier sends each event as a plain-text email.
+type EmailNotifier struct {
+	cfg    EmailConfig
+	logger *slog.Logger
+}
+
+// NewEmailNotifier returns nil when no SMTP host is configured.
+func NewEmailNotifier(cfg EmailConfig, logger *slog.Logger) *EmailNotifier {
+	if cfg.Host == "" {
+		return nil
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	return &EmailNotifier{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (n *EmailNotifier) NotifySuccess(backupID string, size int64, duration time.Duration) {
+	if n == nil {
+		return
+	}
+	n.send(successPayload(backupID, size, duration))
+}
+
+func (n *EmailNotifier) NotifyFailure(backupID string, err error) {
+	if n == nil {
+		return
+	}
+	n.send(failurePayload(backupID, err))
+}
+
+func (n *EmailNotifier) NotifyVerifyRestoreFailure(backupID string, err error) {
+	if n == nil {
+		return
+	}
+	n.send(verifyRestoreFailurePayload(backupID, err))
+}
+
+func (n *EmailNotifier) NotifyRestore(backupID, targetDB string, success bool, err error) {
+	if n == nil {
+		return
+	}
+	n.send(restorePayload(backupID, targetDB, success, err))
+}
+
+func (n *EmailNotifier) NotifyAlert(message string) {
+	if n == nil {
+		return
+	}
+	n.send(alertPayload(message))
+}
+
+// NotifyAnomaly reports a backup that succeeded but looks unlike the ones
+// before it as a backup.anomaly event.
+func (n *EmailNotifier) NotifyAnomaly(backupID, message string) {
+	if n == nil {
+		return
+	}
+	n.send(anomalyPayload(backupID, message))
+}
+
+func (n *EmailNotifier) send(payload WebhookPayload) {
+	logger := payload.logger(n.logger)
+	if err := n.sendMail(payload.email(n.cfg.From, n.cfg.To)); err != nil {
+		logger.Error("failed to send notification email", "event", payload.Event, "error", err)
+		return
+	}
+	logger.Debug("notification email sent", "event", payload.Event)
+}
+
+// sendMail delivers msg like smtp.SendMail, but with every step bounded by
+// the configured timeout so an unresponsive server cannot stall a backup.
+func (n *EmailNotifier) sendMail(msg []byte) error {
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(n.cfg.Port))
+	conn, err := net.DialTimeout("tcp", addr, n.cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(n.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.cfg.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if n.cfg.Username != "" {
+		auth := smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// email renders the payload as an RFC 5322 message with a plain-text body.
+func (p WebhookPayload) email(from string, to []string) []byte {
+	emoji, _ := style(p.Status)
+	subject := fmt.Sprintf("[datasaver] %s %s", emoji, p.Message)
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s\r\n\r\n", p.Message)
+	for _, f := range p.fields() {
+		fmt.Fprintf(&body, "%-9s %s\r\n", f.name+":", f.value)
+	}
+	fmt.Fprintf(&body, "\r\nEvent:    %s\r\nTime:     %s\r\n", p.Event, p.Timestamp.Format(time.RFC3339))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", p.Timestamp.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
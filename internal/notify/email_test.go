@@ -0,0 +1,210 @@
+package notify
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smtpSession struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP accepts one session on a local port, records the envelope and
+// message, and sends them on the returned channel.
+func fakeSMTP(t *testing.T) (string, int, <-chan smtpSession) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		var session smtpSession
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				session.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				session.to = append(session.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				session.data = data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				sessions <- session
+				return
+			default:
+				reply("502 Unrecognized command")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, sessions
+}
+
+func TestNewEmailNotifier_NoHost(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if n := NewEmailNotifier(EmailConfig{}, logger); n != nil {
+		t.Error("NewEmailNotifier without a host should return nil")
+	}
+}
+
+func TestEmailNotifier_NotifyFailure(t *testing.T) {
+	host, port, sessions := fakeSMTP(t)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewEmailNotifier(EmailConfig{
+		Host:    host,
+		Port:    port,
+		From:    "datasaver@example.com",
+		To:      []string{"ops@example.com", "dba@example.com"},
+		Timeout: 5 * time.Second,
+	}, logger)
+
+	n.NotifyFailure("backup_123", &testError{msg: "pg_dump failed"})
+
+	var session smtpSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No email received")
+	}
+
+	if session.from != "datasaver@example.com" {
+		t.Errorf("from = %q", session.from)
+	}
+	if len(session.to) != 2 || session.to[1] != "dba@example.com" {
+		t.Errorf("to = %v", session.to)
+	}
+	for _, want := range []string{
+		"To: ops@example.com, dba@example.com\r\n",
+		"Subject: =?utf-8?q?[datasaver]_=E2=9D=8C_Backup_backup=5F123_failed?=\r\n",
+		"Backup:   backup_123\r\n",
+		"Error:    pg_dump failed\r\n",
+		"Event:    backup.failed\r\n",
+	} {
+		if !strings.Contains(session.data, want) {
+			t.Errorf("message missing %q:\n%s", want, session.data)
+		}
+	}
+}
+
+func TestEmailNotifier_Timeout(t *testing.T) {
+	// A server that accepts the connection but never greets.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	addr := ln.Addr().(*net.TCPAddr)
+	n := NewEmailNotifier(EmailConfig{
+		Host:    addr.IP.String(),
+		Port:    addr.Port,
+		From:    "datasaver@example.com",
+		To:      []string{"ops@example.com"},
+		Timeout: 100 * time.Millisecond,
+	}, logger)
+
+	start := time.Now()
+	n.NotifyAlert("No backup in 26 hours")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("NotifyAlert took %v, want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	if Multi() != nil {
+		t.Error("Multi() with no senders should return nil")
+	}
+
+	var a, b recordingSender
+	m := Multi(&a, &b)
+	m.NotifyAlert("late")
+	m.NotifyRestore("backup_1", "db", true, nil)
+
+	for _, s := range []*recordingSender{&a, &b} {
+		if strings.Join(s.calls, ",") != "alert,restore" {
+			t.Errorf("calls = %v, want [alert restore]", s.calls)
+		}
+	}
+}
+
+type recordingSender struct {
+	calls []string
+}
+
+func (s *recordingSender) NotifySuccess(string, int64, time.Duration) {
+	s.calls = append(s.calls, "success")
+}
+
+func (s *recordingSender) NotifyFailure(string, error) {
+	s.calls = append(s.calls, "failure")
+}
+
+func (s *recordingSender) NotifyVerifyRestoreFailure(string, error) {
+	s.calls = append(s.calls, "verify_restore")
+}
+
+func (s *recordingSender) NotifyRestore(string, string, bool, error) {
+	s.calls = append(s.calls, "restore")
+}
+
+func (s *recordingSender) NotifyAlert(string) {
+	s.calls = append(s.calls, "alert")
+}
+
+func (s *recordingSender) NotifyAnomaly(string, string) {
+	s.calls = append(s.calls, "anomaly")
+}
@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+)
+
+// Webhook payload formats.
+const (
+	FormatGeneric = "generic" // WebhookPayload as JSON
+	FormatSlack   = "slack"   // Slack incoming webhook message
+	FormatDiscord = "discord" // Discord webhook embed
+)
+
+// style returns the emoji and RGB color an event is shown with in chat.
+func style(status string) (string, int) {
+	switch status {
+	case "success":
+		return "✅", 0x2eb886
+	case "failure":
+		return "❌", 0xd50200
+	default:
+		return "⚠️", 0xdaa038
+	}
+}
+
+type field struct {
+	name  string
+	value string
+}
+
+// fields lists the payload's details as name/value pairs for chat formats.
+func (p WebhookPayload) fields() []field {
+	var fields []field
+	if p.BackupID != "" {
+		fields = append(fields, field{"Backup", p.BackupID})
+	}
+	if p.Details.TargetDB != "" {
+		fields = append(fields, field{"Target", p.Details.TargetDB})
+	}
+	if p.Details.Size > 0 {
+		fields = append(fields, field{"Size", formatSize(p.Details.Size)})
+	}
+	if p.Details.Duration > 0 {
+		fields = append(fields, field{"Duration", (time.Duration(p.Details.Duration) * time.Millisecond).String()})
+	}
+	if p.Details.Error != "" {
+		fields = append(fields, field{"Error", p.Details.Error})
+	}
+	return fields
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"` // Shown in notifications and clients without blocks
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (p WebhookPayload) slack() slackMessage {
+	emoji, color := style(p.Status)
+	text := fmt.Sprintf("%s %s", emoji, p.Message)
+
+	blocks := []slackBlock{{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*" + text + "*"}}}
+	if fields := p.fields(); len(fields) > 0 {
+		section := slackBlock{Type: "section"}
+		for _, f := range fields {
+			section.Fields = append(section.Fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", f.name, f.value)})
+		}
+		blocks = append(blocks, section)
+	}
+	blocks = append(blocks, slackBlock{
+		Type: "section",
+		Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("`%s` at %s", p.Event, p.Timestamp.Format(time.RFC3339))},
+	})
+
+	return slackMessage{
+		Text:        text,
+		Attachments: []slackAttachment{{Color: fmt.Sprintf("#%06x", color), Blocks: blocks}},
+	}
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	Color     int            `json:"color"`
+	Timestamp string         `json:"timestamp"`
+	Fields    []discordField `json:"fields,omitempty"`
+	Footer    discordFooter  `json:"footer"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+func (p WebhookPayload) discord() discordMessage {
+	emoji, color := style(p.Status)
+	embed := discordEmbed{
+		Title:     fmt.Sprintf("%s %s", emoji, p.Message),
+		Color:     color,
+		Timestamp: p.Timestamp.Format(time.RFC3339),
+		Footer:    discordFooter{Text: "datasaver · " + p.Event},
+	}
+	for _, f := range p.fields() {
+		embed.Fields = append(embed.Fields, discordField{Name: f.name, Value: f.value, Inline: f.name != "Error"})
+	}
+	return discordMessage{Embeds: []discordEmbed{embed}}
+}
+
+// render returns the body to send for format.
+func (p WebhookPayload) render(format string) any {
+	switch format {
+	case FormatSlack:
+		return p.slack()
+	case FormatDiscord:
+		return p.discord()
+	default:
+		return p
+	}
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
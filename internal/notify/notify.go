@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sender is the notification surface the backup and restore engines use.
+// Notifier posts to a webhook and EmailNotifier sends mail; Multi combines
+// them.
+type Sender interface {
+	NotifySuccess(backupID string, size int64, duration time.Duration)
+	NotifyFailure(backupID string, err error)
+	NotifyVerifyRestoreFailure(backupID string, err error)
+	NotifyRestore(backupID, targetDB string, success bool, err error)
+	NotifyAlert(message string)
+	NotifyAnomaly(backupID, message string)
+}
+
+// Multi returns a Sender that notifies each of senders in turn. It returns
+// nil when senders is empty, so callers can keep checking for a nil Sender.
+func Multi(senders ...Sender) Sender {
+	switch len(senders) {
+	case 0:
+		return nil
+	case 1:
+		return senders[0]
+	}
+	return multi(senders)
+}
+
+type multi []Sender
+
+func (m multi) NotifySuccess(backupID string, size int64, duration time.Duration) {
+	for _, s := range m {
+		s.NotifySuccess(backupID, size, duration)
+	}
+}
+
+func (m multi) NotifyFailure(backupID string, err error) {
+	for _, s := range m {
+		s.NotifyFailure(backupID, err)
+	}
+}
+
+func (m multi) NotifyVerifyRestoreFailure(backupID string, err error) {
+	for _, s := range m {
+		s.NotifyVerifyRestoreFailure(backupID, err)
+	}
+}
+
+func (m multi) NotifyRestore(backupID, targetDB string, success bool, err error) {
+	for _, s := range m {
+		s.NotifyRestore(backupID, targetDB, success, err)
+	}
+}
+
+func (m multi) NotifyAlert(message string) {
+	for _, s := range m {
+		s.NotifyAlert(message)
+	}
+}
+
+func (m multi) NotifyAnomaly(backupID, message string) {
+	for _, s := range m {
+		s.NotifyAnomaly(backupID, message)
+	}
+}
+
+func successPayload(backupID string, size int64, duration time.Duration) WebhookPayload {
+	return WebhookPayload{
+		Event:     "backup.completed",
+		Timestamp: time.Now().UTC(),
+		BackupID:  backupID,
+		Status:    "success",
+		Message:   fmt.Sprintf("Backup %s completed successfully", backupID),
+		Details: Details{
+			Size:     size,
+			Duration: duration.Milliseconds(),
+		},
+	}
+}
+
+func failurePayload(backupID string, err error) WebhookPayload {
+	return WebhookPayload{
+		Event:     "backup.failed",
+		Timestamp: time.Now().UTC(),
+		BackupID:  backupID,
+		Status:    "failure",
+		Message:   fmt.Sprintf("Backup %s failed", backupID),
+		Details: Details{
+			Error: err.Error(),
+		},
+	}
+}
+
+func verifyRestoreFailurePayload(backupID string, err error) WebhookPayload {
+	return WebhookPayload{
+		Event:     "verify_restore.failed",
+		Timestamp: time.Now().UTC(),
+		BackupID:  backupID,
+		Status:    "failure",
+		Message:   fmt.Sprintf("Restore drill of backup %s failed", backupID),
+		Details: Details{
+			Error: err.Error(),
+		},
+	}
+}
+
+func restorePayload(backupID, targetDB string, success bool, err error) WebhookPayload {
+	payload := WebhookPayload{
+		Event:     "restore.completed",
+		Timestamp: time.Now().UTC(),
+		BackupID:  backupID,
+		Status:    "success",
+		Message:   fmt.Sprintf("Restore of backup %s completed successfully", backupID),
+		Details: Details{
+			TargetDB: targetDB,
+		},
+	}
+	if !success {
+		payload.Event = "restore.failed"
+		payload.Status = "failure"
+		payload.Message = fmt.Sprintf("Restore of backup %s failed", backupID)
+		if err != nil {
+			payload.Details.Error = err.Error()
+		}
+	}
+	return payload
+}
+
+func alertPayload(message string) WebhookPayload {
+	return WebhookPayload{
+		Event:     "backup.alert",
+		Timestamp: time.Now().UTC(),
+		Status:    "alert",
+		Message:   message,
+	}
+}
+
+func anomalyPayload(backupID, message string) WebhookPayload {
+	return WebhookPayload{
+		Event:     "backup.anomaly",
+		Timestamp: time.Now().UTC(),
+		BackupID:  backupID,
+		Status:    "alert",
+		Message:   message,
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
@@ -12,6 +11,7 @@ import (
 
 type Notifier struct {
 	webhookURL string
+	format     string
 	httpClient *http.Client
 	logger     *slog.Logger
 }
@@ -23,6 +23,7 @@ func NewNotifier(webhookURL string, logger *slog.Logger) *Notifier {
 
 	return &Notifier{
 		webhookURL: webhookURL,
+		format:     FormatGeneric,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -30,6 +31,15 @@ func NewNotifier(webhookURL string, logger *slog.Logger) *Notifier {
 	}
 }
 
+// SetFormat selects the payload shape: FormatGeneric, FormatSlack or
+// FormatDiscord.
+func (n *Notifier) SetFormat(format string) {
+	if n == nil || format == "" {
+		return
+	}
+	n.format = format
+}
+
 type WebhookPayload struct {
 	Event     string    `json:"event"`
 	Timestamp time.Time `json:"timestamp"`
@@ -39,9 +49,19 @@ type WebhookPayload struct {
 	Details   Details   `json:"details,omitempty"`
 }
 
+// logger returns logger with the payload's backup ID, so delivery failures
+// can be matched with the backup they were about.
+func (p WebhookPayload) logger(logger *slog.Logger) *slog.Logger {
+	if p.BackupID == "" {
+		return logger
+	}
+	return logger.With("backup_id", p.BackupID)
+}
+
 type Details struct {
 	Size     int64  `json:"size_bytes,omitempty"`
 	Duration int64  `json:"duration_ms,omitempty"`
+	TargetDB string `json:"target_db,omitempty"`
 	Error    string `json:"error,omitempty"`
 }
 
@@ -49,60 +69,53 @@ func (n *Notifier) NotifySuccess(backupID string, size int64, duration time.Dura
 	if n == nil {
 		return
 	}
-
-	payload := WebhookPayload{
-		Event:     "backup.completed",
-		Timestamp: time.Now().UTC(),
-		BackupID:  backupID,
-		Status:    "success",
-		Message:   fmt.Sprintf("Backup %s completed successfully", backupID),
-		Details: Details{
-			Size:     size,
-			Duration: duration.Milliseconds(),
-		},
-	}
-
-	n.send(payload)
+	n.send(successPayload(backupID, size, duration))
 }
 
 func (n *Notifier) NotifyFailure(backupID string, err error) {
 	if n == nil {
 		return
 	}
+	n.send(failurePayload(backupID, err))
+}
 
-	payload := WebhookPayload{
-		Event:     "backup.failed",
-		Timestamp: time.Now().UTC(),
-		BackupID:  backupID,
-		Status:    "failure",
-		Message:   fmt.Sprintf("Backup %s failed", backupID),
-		Details: Details{
-			Error: err.Error(),
-		},
+func (n *Notifier) NotifyVerifyRestoreFailure(backupID string, err error) {
+	if n == nil {
+		return
 	}
+	n.send(verifyRestoreFailurePayload(backupID, err))
+}
 
-	n.send(payload)
+// NotifyRestore reports the outcome of restoring backupID into targetDB as a
+// restore.completed or restore.failed event.
+func (n *Notifier) NotifyRestore(backupID, targetDB string, success bool, err error) {
+	if n == nil {
+		return
+	}
+	n.send(restorePayload(backupID, targetDB, success, err))
 }
 
 func (n *Notifier) NotifyAlert(message string) {
 	if n == nil {
 		return
 	}
+	n.send(alertPayload(message))
+}
 
-	payload := WebhookPayload{
-		Event:     "backup.alert",
-		Timestamp: time.Now().UTC(),
-		Status:    "alert",
-		Message:   message,
+// NotifyAnomaly reports a backup that succeeded but looks unlike the ones
+// before it as a backup.anomaly event.
+func (n *Notifier) NotifyAnomaly(backupID, message string) {
+	if n == nil {
+		return
 	}
-
-	n.send(payload)
+	n.send(anomalyPayload(backupID, message))
 }
 
 func (n *Notifier) send(payload WebhookPayload) {
-	data, err := json.Marshal(payload)
+	logger := payload.logger(n.logger)
+	data, err := json.Marshal(payload.render(n.format))
 	if err != nil {
-		n.logger.Error("failed to marshal webhook payload", "error", err)
+		logger.Error("failed to marshal webhook payload", "error", err)
 		return
 	}
 
@@ -111,7 +124,7 @@ func (n *Notifier) send(payload WebhookPayload) {
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(data))
 	if err != nil {
-		n.logger.Error("failed to create webhook request", "error", err)
+		logger.Error("failed to create webhook request", "error", err)
 		return
 	}
 
@@ -120,14 +133,14 @@ func (n *Notifier) send(payload WebhookPayload) {
 
 	resp, err := n.httpClient.Do(req)
 	if err != nil {
-		n.logger.Error("failed to send webhook", "error", err)
+		logger.Error("failed to send webhook", "error", err)
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		n.logger.Warn("webhook returned error status", "status", resp.StatusCode)
+		logger.Warn("webhook returned error status", "status", resp.StatusCode)
 	} else {
-		n.logger.Debug("webhook sent successfully", "event", payload.Event)
+		logger.Debug("webhook sent successfully", "event", payload.Event)
 	}
 }
@@ -115,6 +115,72 @@ func TestNotifier_NotifyFailure(t *testing.T) {
 	}
 }
 
+func TestNotifier_NotifyVerifyRestoreFailure(t *testing.T) {
+	var receivedPayload WebhookPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNotifier(server.URL, logger)
+
+	n.NotifyVerifyRestoreFailure("backup_789", &testError{msg: "integrity check failed"})
+
+	if receivedPayload.Event != "verify_restore.failed" {
+		t.Errorf("Expected event verify_restore.failed, got %s", receivedPayload.Event)
+	}
+
+	if receivedPayload.BackupID != "backup_789" {
+		t.Errorf("Expected backup_id backup_789, got %s", receivedPayload.BackupID)
+	}
+
+	if receivedPayload.Details.Error != "integrity check failed" {
+		t.Errorf("Expected error message, got %s", receivedPayload.Details.Error)
+	}
+}
+
+func TestNotifier_NotifyRestore(t *testing.T) {
+	var receivedPayload WebhookPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNotifier(server.URL, logger)
+
+	n.NotifyRestore("backup_123", "app_drill", true, nil)
+
+	if receivedPayload.Event != "restore.completed" {
+		t.Errorf("Expected event restore.completed, got %s", receivedPayload.Event)
+	}
+	if receivedPayload.Status != "success" {
+		t.Errorf("Expected status success, got %s", receivedPayload.Status)
+	}
+	if receivedPayload.Details.TargetDB != "app_drill" {
+		t.Errorf("Expected target_db app_drill, got %s", receivedPayload.Details.TargetDB)
+	}
+
+	n.NotifyRestore("backup_123", "app_drill", false, &testError{msg: "pg_restore failed"})
+
+	if receivedPayload.Event != "restore.failed" {
+		t.Errorf("Expected event restore.failed, got %s", receivedPayload.Event)
+	}
+	if receivedPayload.Status != "failure" {
+		t.Errorf("Expected status failure, got %s", receivedPayload.Status)
+	}
+	if receivedPayload.Details.Error != "pg_restore failed" {
+		t.Errorf("Expected error message, got %s", receivedPayload.Details.Error)
+	}
+}
+
 func TestNotifier_NotifyAlert(t *testing.T) {
 	var receivedPayload WebhookPayload
 
@@ -145,13 +211,44 @@ func TestNotifier_NotifyAlert(t *testing.T) {
 	}
 }
 
+func TestNotifier_NotifyAnomaly(t *testing.T) {
+	var receivedPayload WebhookPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNotifier(server.URL, logger)
+
+	n.NotifyAnomaly("backup_123", "Backup backup_123 is 1% of the usual size")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if receivedPayload.Event != "backup.anomaly" {
+		t.Errorf("Expected event backup.anomaly, got %s", receivedPayload.Event)
+	}
+	if receivedPayload.BackupID != "backup_123" {
+		t.Errorf("Expected backup ID backup_123, got %s", receivedPayload.BackupID)
+	}
+	if receivedPayload.Status != "alert" {
+		t.Errorf("Expected status alert, got %s", receivedPayload.Status)
+	}
+}
+
 func TestNotifier_NilSafe(t *testing.T) {
 	var n *Notifier = nil
 
 	// These should not panic
 	n.NotifySuccess("test", 0, 0)
 	n.NotifyFailure("test", &testError{msg: "test"})
+	n.NotifyVerifyRestoreFailure("test", &testError{msg: "test"})
+	n.NotifyRestore("test", "db", false, &testError{msg: "test"})
 	n.NotifyAlert("test")
+	n.NotifyAnomaly("test", "test")
 }
 
 func TestNotifier_ServerError(t *testing.T) {
@@ -177,6 +274,79 @@ func TestNotifier_InvalidURL(t *testing.T) {
 	n.NotifySuccess("test", 100, time.Second)
 }
 
+func TestNotifier_SlackFormat(t *testing.T) {
+	var received slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNotifier(server.URL, logger)
+	n.SetFormat(FormatSlack)
+
+	n.NotifyFailure("backup_123", &testError{msg: "pg_dump failed"})
+
+	if received.Text != "❌ Backup backup_123 failed" {
+		t.Errorf("Text = %q", received.Text)
+	}
+	if len(received.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(received.Attachments))
+	}
+	attachment := received.Attachments[0]
+	if attachment.Color != "#d50200" {
+		t.Errorf("Color = %q, want #d50200", attachment.Color)
+	}
+	if len(attachment.Blocks) != 3 || len(attachment.Blocks[1].Fields) != 2 {
+		t.Fatalf("Unexpected blocks: %+v", attachment.Blocks)
+	}
+	if got := attachment.Blocks[1].Fields[1].Text; got != "*Error*\npg_dump failed" {
+		t.Errorf("Error field = %q", got)
+	}
+}
+
+func TestNotifier_DiscordFormat(t *testing.T) {
+	var received discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := NewNotifier(server.URL, logger)
+	n.SetFormat(FormatDiscord)
+
+	n.NotifySuccess("backup_123", 3*1024*1024, 5*time.Second)
+
+	if len(received.Embeds) != 1 {
+		t.Fatalf("Expected 1 embed, got %d", len(received.Embeds))
+	}
+	embed := received.Embeds[0]
+	if embed.Title != "✅ Backup backup_123 completed successfully" {
+		t.Errorf("Title = %q", embed.Title)
+	}
+	if embed.Color != 0x2eb886 {
+		t.Errorf("Color = %#x, want 0x2eb886", embed.Color)
+	}
+	want := []discordField{
+		{Name: "Backup", Value: "backup_123", Inline: true},
+		{Name: "Size", Value: "3.0 MiB", Inline: true},
+		{Name: "Duration", Value: "5s", Inline: true},
+	}
+	if len(embed.Fields) != len(want) {
+		t.Fatalf("Fields = %+v, want %+v", embed.Fields, want)
+	}
+	for i := range want {
+		if embed.Fields[i] != want[i] {
+			t.Errorf("Fields[%d] = %+v, want %+v", i, embed.Fields[i], want[i])
+		}
+	}
+}
+
 func TestWebhookPayload_JSON(t *testing.T) {
 	payload := WebhookPayload{
 		Event:     "backup.completed",
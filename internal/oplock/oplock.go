@@ -0,0 +1,45 @@
+// Package oplock keeps heavy operations such as backups and restores from
+// overlapping, both within one process and between datasaver processes on
+// the same host, such as a CLI backup run next to the daemon.
+package oplock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrInProgress is wrapped by Acquire's error when the lock is held.
+var ErrInProgress = errors.New("already in progress")
+
+// Acquire takes the lock for the operation name without waiting, using
+// flock on a lock file in dir (the system temp directory when empty). Lock
+// files are left behind, so callers keep them out of directories that
+// should be emptied, such as backup.temp_dir.
+// Every Acquire opens the file anew, so it excludes callers in the same
+// process as well as other processes, and the lock is released if the
+// holder dies. The returned function releases it.
+func Acquire(dir, name string) (release func(), err error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, "datasaver-"+name+".lock")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s lock: %w", name, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s %w", name, ErrInProgress)
+		}
+		return nil, fmt.Errorf("failed to take %s lock: %w", name, err)
+	}
+
+	// The file is left in place: removing it would let a new caller lock a
+	// fresh file while an old one still holds the unlinked one.
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,43 @@
+package oplock
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAcquire_ExcludesUntilReleased(t *testing.T) {
+	dir := t.TempDir()
+
+	release, err := Acquire(dir, "backup")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	_, err = Acquire(dir, "backup")
+	if !errors.Is(err, ErrInProgress) {
+		t.Fatalf("second Acquire() error = %v, want ErrInProgress", err)
+	}
+	if err.Error() != "backup already in progress" {
+		t.Errorf("error = %q", err)
+	}
+
+	// Other operations have their own lock.
+	releaseRestore, err := Acquire(dir, "restore")
+	if err != nil {
+		t.Fatalf("Acquire(restore) error = %v", err)
+	}
+	releaseRestore()
+
+	release()
+	release, err = Acquire(dir, "backup")
+	if err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func TestAcquire_MissingDir(t *testing.T) {
+	if _, err := Acquire(t.TempDir()+"/missing", "backup"); err == nil || errors.Is(err, ErrInProgress) {
+		t.Errorf("Acquire() error = %v, want a failure to open the lock file", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package progress reports how far the copies behind a backup or restore
+// have got, so multi-GB runs are not silent between start and finish.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval is how often a Counter reports while bytes flow.
+	Interval = time.Second
+
+	// LogInterval is how often Log writes a progress entry.
+	LogInterval = 10 * time.Second
+)
+
+// Update is a snapshot of one operation, such as a dump or an upload.
+type Update struct {
+	Op      string
+	Bytes   int64
+	Total   int64 // 0 when the size is not known up front
+	Elapsed time.Duration
+	Done    bool // Set on the last update, once the copy has ended
+}
+
+// Percent returns how much of Total is done, or -1 when Total is unknown.
+func (u Update) Percent() float64 {
+	if u.Total <= 0 {
+		return -1
+	}
+	return float64(u.Bytes) / float64(u.Total) * 100
+}
+
+// Rate returns the average throughput in bytes per second.
+func (u Update) Rate() float64 {
+	if u.Elapsed <= 0 {
+		return 0
+	}
+	return float64(u.Bytes) / u.Elapsed.Seconds()
+}
+
+// String renders u as a one-line status, e.g.
+// "upload: 1.20 GB of 3.40 GB (35%), 45.60 MB/s".
+func (u Update) String() string {
+	s := u.Op + ": " + FormatBytes(u.Bytes)
+	if pct := u.Percent(); pct >= 0 {
+		s += fmt.Sprintf(" of %s (%.0f%%)", FormatBytes(u.Total), pct)
+	}
+	return s + ", " + FormatBytes(int64(u.Rate())) + "/s"
+}
+
+// Func receives progress updates. It is called from the goroutine doing the
+// copy and must not block for long.
+type Func func(Update)
+
+// Join returns a Func calling each non-nil fn in turn, or nil if there are
+// none.
+func Join(fns ...Func) Func {
+	var set []Func
+	for _, fn := range fns {
+		if fn != nil {
+			set = append(set, fn)
+		}
+	}
+	switch len(set) {
+	case 0:
+		return nil
+	case 1:
+		return set[0]
+	}
+	return func(u Update) {
+		for _, fn := range set {
+			fn(u)
+		}
+	}
+}
+
+// Log returns a Func that logs updates at most once per interval. Short
+// operations that finish within the first interval log nothing, since the
+// completion message already covers them.
+func Log(logger *slog.Logger, interval time.Duration) Func {
+	var last time.Duration
+	return func(u Update) {
+		if u.Elapsed-last < interval && !(u.Done && last > 0) {
+			return
+		}
+		last = u.Elapsed
+		attrs := []any{"op", u.Op, "bytes", u.Bytes, "rate_bytes_per_sec", int64(u.Rate())}
+		if pct := u.Percent(); pct >= 0 {
+			attrs = append(attrs, "total", u.Total, "percent", int(pct))
+		}
+		if u.Done {
+			attrs = append(attrs, "done", true)
+		}
+		logger.Info("progress", attrs...)
+	}
+}
+
+type contextKey struct{}
+
+// WithFunc returns a context whose backups and restores report progress to
+// fn, in addition to logging it.
+func WithFunc(ctx context.Context, fn Func) context.Context {
+	return context.WithValue(ctx, contextKey{}, fn)
+}
+
+// FromContext returns the Func set by WithFunc, or nil.
+func FromContext(ctx context.Context) Func {
+	fn, _ := ctx.Value(contextKey{}).(Func)
+	return fn
+}
+
+// Track returns a Counter for op that logs to logger every LogInterval and
+// reports to the Func in ctx, if any. total is 0 when unknown.
+func Track(ctx context.Context, logger *slog.Logger, op string, total int64) *Counter {
+	return New(op, total, Join(Log(logger, LogInterval), FromContext(ctx)))
+}
+
+// Counter counts the bytes passing through the readers and writers it
+// wraps and reports them every Interval. A nil Counter counts nothing.
+type Counter struct {
+	op    string
+	total int64
+	fn    Func
+
+	mu    sync.Mutex
+	n     int64
+	start time.Time
+	last  time.Time
+	done  bool
+}
+
+// New returns a Counter reporting to fn, or nil when fn is nil.
+func New(op string, total int64, fn Func) *Counter {
+	if fn == nil {
+		return nil
+	}
+	now := time.Now()
+	return &Counter{op: op, total: total, fn: fn, start: now, last: now}
+}
+
+// Reader returns r, counting what is read from it.
+func (c *Counter) Reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &reader{r: r, c: c}
+}
+
+// Writer returns w, counting what is written to it.
+func (c *Counter) Writer(w io.Writer) io.Writer {
+	if c == nil {
+		return w
+	}
+	return &writer{w: w, c: c}
+}
+
+// Done sends the final update. Later calls do nothing.
+func (c *Counter) Done() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		return
+	}
+	c.done = true
+	u := c.update(time.Now())
+	c.mu.Unlock()
+
+	u.Done = true
+	c.fn(u)
+}
+
+func (c *Counter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.n += int64(n)
+	now := time.Now()
+	if c.done || now.Sub(c.last) < Interval {
+		c.mu.Unlock()
+		return
+	}
+	c.last = now
+	u := c.update(now)
+	c.mu.Unlock()
+
+	c.fn(u)
+}
+
+func (c *Counter) update(now time.Time) Update {
+	return Update{Op: c.op, Bytes: c.n, Total: c.total, Elapsed: now.Sub(c.start)}
+}
+
+type reader struct {
+	r io.Reader
+	c *Counter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.c.add(n)
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	c *Counter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.c.add(n)
+	return n, err
+}
+
+// FormatBytes renders a size with a binary unit, e.g. "1.50 GB".
+func FormatBytes(bytes int64) string {
+	const (
+		KB = 1024
+		MB = KB * 1024
+		GB = MB * 1024
+	)
+
+	switch {
+	case bytes >= GB:
+		return fmt.Sprintf("%.2f GB", float64(bytes)/float64(GB))
+	case bytes >= MB:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/float64(MB))
+	case bytes >= KB:
+		return fmt.Sprintf("%.2f KB", float64(bytes)/float64(KB))
+	default:
+		return fmt.Sprintf("%d B", bytes)
+	}
+}
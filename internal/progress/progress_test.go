@@ -0,0 +1,108 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounter_ReaderAndWriter(t *testing.T) {
+	var updates []Update
+	c := New("upload", 6, func(u Update) { updates = append(updates, u) })
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(c.Writer(&buf), c.Reader(strings.NewReader("abc"))); err != nil {
+		t.Fatalf("io.Copy() error = %v", err)
+	}
+	c.Done()
+	c.Done()
+
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates, want only the final one within Interval", len(updates))
+	}
+	u := updates[0]
+	if !u.Done || u.Bytes != 6 || u.Op != "upload" {
+		t.Errorf("final update = %+v, want 6 bytes of upload, done", u)
+	}
+	if u.Percent() != 100 {
+		t.Errorf("Percent() = %v, want 100", u.Percent())
+	}
+}
+
+func TestCounter_Nil(t *testing.T) {
+	c := New("dump", 0, nil)
+	if c != nil {
+		t.Fatal("New() with a nil Func should return nil")
+	}
+
+	r := strings.NewReader("abc")
+	if c.Reader(r) != io.Reader(r) {
+		t.Error("nil Counter should return the reader unchanged")
+	}
+	c.Done()
+}
+
+func TestUpdate_String(t *testing.T) {
+	tests := []struct {
+		u    Update
+		want string
+	}{
+		{
+			u:    Update{Op: "upload", Bytes: 512 << 20, Total: 2 << 30, Elapsed: 4 * time.Second},
+			want: "upload: 512.00 MB of 2.00 GB (25%), 128.00 MB/s",
+		},
+		{
+			u:    Update{Op: "dump", Bytes: 3 << 10, Elapsed: time.Second},
+			want: "dump: 3.00 KB, 3.00 KB/s",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.u.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	fn := Log(slog.New(slog.NewTextHandler(&buf, nil)), 10*time.Second)
+
+	fn(Update{Op: "dump", Bytes: 10, Elapsed: time.Second, Done: true})
+	if buf.Len() != 0 {
+		t.Errorf("Log() wrote %q for an operation shorter than the interval", buf.String())
+	}
+
+	fn(Update{Op: "dump", Bytes: 100, Elapsed: 5 * time.Second})
+	fn(Update{Op: "dump", Bytes: 200, Elapsed: 11 * time.Second})
+	fn(Update{Op: "dump", Bytes: 300, Elapsed: 12 * time.Second})
+	fn(Update{Op: "dump", Bytes: 400, Elapsed: 13 * time.Second, Done: true})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Log() wrote %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "bytes=200") || !strings.Contains(lines[1], "done=true") {
+		t.Errorf("Log() lines = %q", lines)
+	}
+}
+
+func TestTrack_ReportsToContext(t *testing.T) {
+	var got []Update
+	ctx := WithFunc(context.Background(), func(u Update) { got = append(got, u) })
+
+	c := Track(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), "download", 0)
+	io.Copy(io.Discard, c.Reader(strings.NewReader("hello")))
+	c.Done()
+
+	if len(got) != 1 || got[0].Bytes != 5 || got[0].Percent() != -1 {
+		t.Errorf("updates = %+v, want one final update of 5 bytes with unknown total", got)
+	}
+	if FromContext(context.Background()) != nil {
+		t.Error("FromContext() without WithFunc should return nil")
+	}
+}
@@ -1,33 +1,61 @@
 package restore
 
 import (
-	"compress/gzip"
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/localrivet/datasaver/internal/config"
+	"github.com/localrivet/datasaver/internal/hooks"
+	"github.com/localrivet/datasaver/internal/metrics"
+	"github.com/localrivet/datasaver/internal/notify"
+	"github.com/localrivet/datasaver/internal/oplock"
+	"github.com/localrivet/datasaver/internal/progress"
 	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/internal/transform"
+	"github.com/localrivet/datasaver/pkg/checksum"
+	"github.com/localrivet/datasaver/pkg/database"
 	"github.com/localrivet/datasaver/pkg/postgres"
 )
 
 type Engine struct {
-	cfg     *config.Config
-	storage storage.Backend
-	logger  *slog.Logger
+	cfg      *config.Config
+	storage  storage.Backend
+	notifier notify.Sender
+	metrics  *metrics.Metrics
+	hooks    *hooks.Runner
+	logger   *slog.Logger
+
+	// cache holds local copies of recent backups; nil when none is
+	// configured.
+	cache *storage.Cache
+
+	// transforms undoes the compression and encryption named by a stored
+	// file's suffixes.
+	transforms *transform.Registry
 }
 
-func NewEngine(cfg *config.Config, store storage.Backend, logger *slog.Logger) *Engine {
+// NewEngine creates a restore engine. notifier and m may be nil.
+func NewEngine(cfg *config.Config, store storage.Backend, notifier notify.Sender, m *metrics.Metrics, logger *slog.Logger) *Engine {
 	return &Engine{
-		cfg:     cfg,
-		storage: store,
-		logger:  logger,
+		cfg:      cfg,
+		storage:  store,
+		notifier: notifier,
+		metrics:  m,
+		hooks:    hooks.NewRunner(cfg.HookTimeout(), logger),
+		logger:   logger,
+
+		cache:      storage.NewCache(cfg.Storage.Cache.Path, cfg.Storage.Cache.Backups(), cfg.Storage.Cache.MaxBytes),
+		transforms: transform.NewRegistry(cfg.EncryptionKey()),
 	}
 }
 
@@ -35,16 +63,93 @@ type RestoreOptions struct {
 	BackupID       string
 	TargetDB       string
 	DryRun         bool
-	Force          bool
+	Force          bool // Drop and recreate the target database (PostgreSQL) or delete the target file (SQLite) first
 	VerifyChecksum bool // Verify checksum before restoring
+
+	// SQLite backups are restored into the file TargetDB, which must be set:
+	// the configured database is never replaced by default. Missing parent
+	// directories are created. An existing file is refused unless Overwrite
+	// is set, which keeps it as a timestamped copy, or Force deletes it.
+	Overwrite bool
+
+	// ExtractTo writes the verified, decrypted and decompressed dump to this
+	// file instead of restoring it: the archive or SQL script pg_dump wrote
+	// (a tar of a directory format dump), the SQLite file, or the base
+	// backup tar. ExtractSQL turns a custom or directory format archive into
+	// a SQL script with pg_restore -f instead. No database is touched;
+	// Overwrite lets an existing file be replaced.
+	ExtractTo  string
+	ExtractSQL bool
+
+	// Physical backups are unpacked into TargetDir, an empty PostgreSQL data
+	// directory, and recover when the server starts there: up to TargetTime,
+	// or to the end of the WAL archive when it is zero. RestoreCommand is
+	// how the server fetches archived WAL; it defaults to DefaultRestoreCommand.
+	TargetDir      string
+	TargetTime     time.Time
+	RestoreCommand string
+
+	// TargetHost, TargetPort, TargetUser and TargetPassword restore into a
+	// different PostgreSQL server than the configured source, such as an
+	// isolated staging server. Each one left unset falls back to the
+	// configured connection.
+	TargetHost     string
+	TargetPort     int
+	TargetUser     string
+	TargetPassword string
+
+	// Table restores only this table of a PostgreSQL custom or directory
+	// format dump, as "name" or "schema.name", with pg_restore -t. The rest
+	// of the target database is left alone.
+	Table string
+}
+
+// retargeted reports whether any of the target server overrides is set.
+func (o RestoreOptions) retargeted() bool {
+	return o.TargetHost != "" || o.TargetPort != 0 || o.TargetUser != "" || o.TargetPassword != ""
 }
 
+// DefaultRestoreCommand is the restore_command written for physical
+// restores; PostgreSQL substitutes %f and %p.
+const DefaultRestoreCommand = "datasaver wal-fetch %f %p"
+
 type RestoreResult struct {
 	BackupID       string
 	TargetDB       string
 	Success        bool
 	ChecksumValid  bool
+	ContentValid   bool // The decompressed dump matched its content checksum
+	Mode           string // Backup mode; "schema" restores no rows, "data" no DDL
 	Error          error
+
+	// Tables and Objects are what a dry run found in the backup: tables,
+	// and every schema object (SQLite), archive entry (PostgreSQL dump) or
+	// file (base backup).
+	Tables  int
+	Objects int
+
+	// RecoveryTarget is the time a physical restore was set to recover to,
+	// and RecoveryEndLSN the end of the archived WAL replay reaches it by.
+	RecoveryTarget time.Time
+	RecoveryEndLSN string
+
+	// Suspect is set when the restored database's sentinel tables could
+	// not be counted or hold different row counts than when the backup was
+	// taken; RowCountMismatches lists the tables that differ.
+	Suspect            bool
+	RowCountMismatches []RowCountMismatch
+
+	// Warnings are caveats about what was restored, such as foreign keys a
+	// single-table restore may leave unsatisfied.
+	Warnings []string
+}
+
+// RowCountMismatch is a sentinel table whose restored row count differs
+// from the count recorded with the backup.
+type RowCountMismatch struct {
+	Table    string
+	Expected int64
+	Actual   int64
 }
 
 func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResult, error) {
@@ -55,7 +160,26 @@ func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResu
 
 	e.logger.Info("starting restore", "backup_id", opts.BackupID, "target_db", opts.TargetDB)
 
-	metaPath := opts.BackupID + ".meta.json"
+	if !opts.DryRun && opts.ExtractTo == "" {
+		// Dry runs and extracts leave every database alone, so only real
+		// restores exclude each other, across processes on this host too,
+		// and run hooks and report their outcome.
+		release, err := oplock.Acquire("", "restore")
+		if err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+		defer release()
+
+		defer e.runPostRestoreHooks(ctx, result)
+		defer e.reportRestore(result, time.Now())
+	}
+
+	metaPath, err := storage.Locate(ctx, e.storage, opts.BackupID+postgres.MetadataExtJSON, append(postgres.MetadataIndexPaths(opts.BackupID), opts.BackupID+postgres.MetadataExtGzip)...)
+	if err != nil {
+		result.Error = fmt.Errorf("backup not found: %s", opts.BackupID)
+		return result, result.Error
+	}
 	metaReader, err := e.storage.Read(ctx, metaPath)
 	if err != nil {
 		result.Error = fmt.Errorf("backup not found: %s", opts.BackupID)
@@ -74,10 +198,16 @@ func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResu
 		result.Error = fmt.Errorf("failed to parse metadata: %w", err)
 		return result, result.Error
 	}
+	if key := e.cfg.MetadataSigningKey(); key != nil {
+		if err := metadata.VerifySignature(key); err != nil {
+			result.Error = fmt.Errorf("refusing to restore %s: %w", opts.BackupID, err)
+			return result, result.Error
+		}
+	}
 
 	var backupFile string
 	for _, f := range metadata.Files {
-		if !strings.HasSuffix(f, ".meta.json") {
+		if !postgres.IsMetadataPath(f) {
 			backupFile = f
 			break
 		}
@@ -88,85 +218,116 @@ func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResu
 		return result, result.Error
 	}
 
-	if opts.DryRun {
-		e.logger.Info("dry run: would restore from", "file", backupFile)
-		result.Success = true
-		return result, nil
+	physical := metadata.Backup.Kind == postgres.KindBase
+	sqlite := e.isSQLiteBackup(metadata)
+	if opts.retargeted() && (physical || sqlite) {
+		result.Error = fmt.Errorf("a target server only applies to PostgreSQL dumps; %s is not one", opts.BackupID)
+		return result, result.Error
 	}
-
-	tmpDir, err := os.MkdirTemp("", "datasaver-restore-*")
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create temp directory: %w", err)
+	if opts.ExtractTo != "" || opts.ExtractSQL {
+		if err := e.checkExtract(metadata, opts); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+	} else if opts.Overwrite && !sqlite {
+		result.Error = fmt.Errorf("overwriting a target file only applies to SQLite backups; %s is not one", opts.BackupID)
 		return result, result.Error
 	}
-	defer os.RemoveAll(tmpDir)
+	if !physical && !opts.TargetTime.IsZero() {
+		result.Error = fmt.Errorf("point-in-time recovery needs a physical backup; %s is a dump", opts.BackupID)
+		return result, result.Error
+	}
+	if physical && opts.ExtractTo == "" {
+		if err := checkDataDir(opts.TargetDir); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+		result.TargetDB = opts.TargetDir
+	}
+	if physical && !opts.TargetTime.IsZero() {
+		endLSN, err := e.checkWALCoverage(ctx, metadata, opts.TargetTime)
+		if err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+		result.RecoveryTarget = opts.TargetTime
+		result.RecoveryEndLSN = endLSN
+	}
 
-	reader, err := e.storage.Read(ctx, backupFile)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to read backup file: %w", err)
+	result.Mode = metadata.BackupMode()
+	if opts.Force && result.Mode == "data" {
+		result.Error = fmt.Errorf("cannot drop and recreate the target for a data-only backup: it has no schema to restore")
 		return result, result.Error
 	}
-	defer reader.Close()
+	if result.Mode != "full" {
+		e.logger.Warn("backup is not a full dump", "mode", result.Mode)
+	}
 
-	localPath := filepath.Join(tmpDir, backupFile)
+	if opts.Table != "" {
+		if err := e.checkTableRestore(metadata, opts); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+		warning := fmt.Sprintf("only table %s is restored; rows in other tables that it references or that reference it are not, so foreign keys may be unsatisfied", opts.Table)
+		e.logger.Warn("single-table restore", "table", opts.Table, "detail", warning)
+		result.Warnings = append(result.Warnings, warning)
+	}
 
-	var finalReader io.Reader = reader
+	if metadata.IsPartial() {
+		e.logger.Warn("backup does not contain the full database",
+			"include_tables", metadata.Backup.IncludeTables,
+			"exclude_tables", metadata.Backup.ExcludeTables,
+		)
+	}
 
-	if strings.HasSuffix(backupFile, ".gz") {
-		gzReader, err := gzip.NewReader(reader)
-		if err != nil {
-			result.Error = fmt.Errorf("failed to create gzip reader: %w", err)
+	switch {
+	case opts.ExtractTo != "":
+		if err := checkTargetFile(opts.ExtractTo, opts.Overwrite); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+	case sqlite && !opts.DryRun:
+		if opts.TargetDB == "" {
+			result.Error = fmt.Errorf("SQLite backups are restored into a file; set a target path")
+			return result, result.Error
+		}
+		if err := checkTargetFile(opts.TargetDB, opts.Overwrite || opts.Force); err != nil {
+			result.Error = err
 			return result, result.Error
 		}
-		defer gzReader.Close()
-		finalReader = gzReader
-		localPath = strings.TrimSuffix(localPath, ".gz")
 	}
 
-	localFile, err := os.Create(localPath)
+	tmpDir, err := os.MkdirTemp(e.cfg.Backup.TempDir, "datasaver-restore-*")
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create local file: %w", err)
+		result.Error = fmt.Errorf("failed to create temp directory: %w", err)
 		return result, result.Error
 	}
+	defer os.RemoveAll(tmpDir)
 
-	if _, err := io.Copy(localFile, finalReader); err != nil {
-		localFile.Close()
-		result.Error = fmt.Errorf("failed to write local file: %w", err)
-		return result, result.Error
+	// Download the stored (possibly compressed) file once, unless the local
+	// cache has a copy with the right checksum. The checksum is taken from
+	// this copy and the dump is decompressed on the fly from it, so the
+	// uncompressed form never touches disk.
+	localPath := filepath.Join(tmpDir, filepath.Base(backupFile))
+	cached := e.fromCache(backupFile, metadata.Backup.Checksum, localPath)
+	if !cached {
+		if err := e.download(ctx, backupFile, localPath); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
 	}
-	localFile.Close()
 
-	// Verify checksum before restoring if enabled or configured
-	if opts.VerifyChecksum || e.cfg.Backup.VerifyChecksum {
-		if metadata.Backup.Checksum != "" {
+	// Verify checksum before restoring if enabled or configured; a dry run
+	// and an extract always check it.
+	verify := opts.VerifyChecksum || e.cfg.Backup.VerifyChecksum || opts.DryRun || opts.ExtractTo != ""
+	if verify {
+		if cached {
+			// The cache only hands out copies matching the checksum.
+			result.ChecksumValid = true
+		} else if metadata.Backup.Checksum != "" {
 			e.logger.Info("verifying backup checksum", "expected", metadata.Backup.Checksum)
 
-			// For compressed files, we need to verify the original compressed file checksum
-			// So we read it again and calculate
-			compressedPath := filepath.Join(tmpDir, backupFile)
-			compReader, err := e.storage.Read(ctx, backupFile)
-			if err != nil {
-				result.Error = fmt.Errorf("failed to read backup for checksum: %w", err)
-				return result, result.Error
-			}
-
-			compFile, err := os.Create(compressedPath)
-			if err != nil {
-				compReader.Close()
-				result.Error = fmt.Errorf("failed to create temp file for checksum: %w", err)
-				return result, result.Error
-			}
-
-			if _, err := io.Copy(compFile, compReader); err != nil {
-				compReader.Close()
-				compFile.Close()
-				result.Error = fmt.Errorf("failed to write temp file for checksum: %w", err)
-				return result, result.Error
-			}
-			compReader.Close()
-			compFile.Close()
-
-			actualChecksum, err := postgres.CalculateChecksum(compressedPath)
+			actualChecksum, err := checksum.FileFor(localPath, metadata.Backup.Checksum)
 			if err != nil {
 				result.Error = fmt.Errorf("failed to calculate checksum: %w", err)
 				return result, result.Error
@@ -188,25 +349,114 @@ func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResu
 		}
 	}
 
-	targetDB := opts.TargetDB
-	if targetDB == "" {
-		targetDB = metadata.Database.Name
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to open local file: %w", err)
+		return result, result.Error
 	}
+	defer localFile.Close()
 
-	host, port, _, user, password := e.parseConnectionInfo()
+	// Progress is counted on the stored bytes, whose total is known, as the
+	// dump is decompressed from them.
+	op := "restore"
+	if opts.DryRun {
+		op = "validate"
+	} else if opts.ExtractTo != "" {
+		op = "extract"
+	}
+	var localSize int64
+	if info, err := localFile.Stat(); err == nil {
+		localSize = info.Size()
+	}
+	counter := progress.Track(ctx, e.logger, op, localSize)
+	defer counter.Done()
 
-	restoreOpts := postgres.DumpOptions{
-		Database: targetDB,
-		Host:     host,
-		Port:     port,
-		User:     user,
-		Password: password,
+	// A dump pg_dump compressed itself is read by pg_restore as it is.
+	chain, _ := e.transforms.Parse(backupFile)
+	if metadata.Backup.CompressedInDB {
+		chain = chain.WithoutCompression()
+	}
+	dumpReader, err := chain.Unwrap(counter.Reader(localFile))
+	if err != nil {
+		result.Error = err
+		return result, result.Error
+	}
+	defer dumpReader.Close()
+
+	var check *contentCheck
+	if verify && metadata.Backup.ContentChecksum != "" {
+		check, err = newContentCheck(metadata.Backup.ContentChecksum)
+		if err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+	}
+	src := check.reader(dumpReader)
+
+	if opts.ExtractTo != "" {
+		if err := e.extract(ctx, src, check, metadata.Backup.Format, opts, tmpDir, result); err != nil {
+			return result, err
+		}
+		result.Success = true
+		result.TargetDB = opts.ExtractTo
+		e.logger.Info("backup extracted",
+			"backup_id", opts.BackupID,
+			"path", opts.ExtractTo,
+			"sql", opts.ExtractSQL,
+		)
+		return result, nil
+	}
+
+	if opts.DryRun {
+		if err := e.validateArchive(ctx, src, metadata, opts.Table, tmpDir, result); err != nil {
+			result.Error = fmt.Errorf("backup cannot be restored: %w", err)
+			return result, result.Error
+		}
+		if err := e.verifyContent(check, src, result); err != nil {
+			return result, err
+		}
+		result.Success = true
+		e.logger.Info("dry run: backup can be restored",
+			"file", backupFile,
+			"tables", result.Tables,
+			"objects", result.Objects,
+		)
+		return result, nil
+	}
+
+	if physical {
+		if err := e.restorePhysical(src, opts); err != nil {
+			result.Error = err
+			return result, result.Error
+		}
+		if err := e.verifyContent(check, src, result); err != nil {
+			return result, err
+		}
+		result.Success = true
+		e.logger.Info("base backup restored; start PostgreSQL on the data directory to recover",
+			"backup_id", opts.BackupID,
+			"data_dir", opts.TargetDir,
+		)
+		return result, nil
+	}
+
+	targetDB := opts.TargetDB
+	if targetDB == "" && !sqlite {
+		targetDB = metadata.Database.Name
 	}
 
-	if err := postgres.Restore(ctx, localPath, restoreOpts); err != nil {
-		result.Error = fmt.Errorf("pg_restore failed: %w", err)
+	if sqlite {
+		err = e.restoreSQLite(ctx, src, targetDB, opts.Force)
+	} else {
+		err = e.restorePostgres(ctx, src, metadata.Backup.Format, targetDB, tmpDir, opts)
+	}
+	if err != nil {
+		result.Error = err
 		return result, result.Error
 	}
+	if err := e.verifyContent(check, src, result); err != nil {
+		return result, err
+	}
 
 	result.Success = true
 	result.TargetDB = targetDB
@@ -216,9 +466,715 @@ func (e *Engine) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResu
 		"target_db", targetDB,
 	)
 
+	counts := metadata.Backup.TableCounts
+	if opts.Table != "" {
+		counts = tableCounts(counts, opts.Table)
+	}
+	if len(counts) > 0 {
+		e.checkRowCounts(ctx, counts, sqlite, targetDB, opts, result)
+	}
+
 	return result, nil
 }
 
+// contentCheck checksums the decompressed dump as it is restored, for
+// comparison with the checksum taken when it was dumped. A nil contentCheck
+// checks nothing.
+type contentCheck struct {
+	want string
+	sum  *checksum.Writer
+}
+
+// newContentCheck checks against want with the algorithm want was taken
+// with.
+func newContentCheck(want string) (*contentCheck, error) {
+	sum, err := checksum.NewWriterFor(want)
+	if err != nil {
+		return nil, err
+	}
+	return &contentCheck{want: want, sum: sum}, nil
+}
+
+// reader returns r, checksumming what is read from it.
+func (c *contentCheck) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return io.TeeReader(r, c.sum)
+}
+
+// verifyContent reads what the restore left unread of src, which came from
+// check.reader, and compares the checksum of the whole dump. The restore has
+// already been applied by then, so a mismatch reports the data as suspect
+// rather than undoing it.
+func (e *Engine) verifyContent(check *contentCheck, src io.Reader, result *RestoreResult) error {
+	if check == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		result.Error = fmt.Errorf("failed to read backup: %w", err)
+		return result.Error
+	}
+	if got := check.sum.Sum(); got != check.want {
+		result.Error = fmt.Errorf("content checksum mismatch: expected %s, got %s - restored data may be corrupted", check.want, got)
+		e.logger.Error("CRITICAL: content checksum verification failed", "expected", check.want, "actual", got)
+		return result.Error
+	}
+	result.ContentValid = true
+	return nil
+}
+
+// validateArchive checks that the dump read from r would restore, without
+// touching the target: base backups are read through as a tar, PostgreSQL
+// dumps are listed by pg_restore, and must contain table when it is set,
+// and SQLite backups are restored into a file in tmpDir and
+// integrity-checked. Plain SQL dumps are scanned for the objects they
+// create. What it finds is recorded in result.
+func (e *Engine) validateArchive(ctx context.Context, r io.Reader, metadata *postgres.BackupMetadata, table, tmpDir string, result *RestoreResult) error {
+	if metadata.Backup.Kind == postgres.KindBase {
+		files, err := database.CheckBaseBackup(r)
+		if err != nil {
+			return err
+		}
+		result.Objects = files
+		return nil
+	}
+
+	if e.isSQLiteBackup(metadata) {
+		dbPath := filepath.Join(tmpDir, "dry-run.db")
+		if err := e.restoreSQLite(ctx, r, dbPath, false); err != nil {
+			return err
+		}
+		return checkSQLite(ctx, dbPath, result)
+	}
+
+	if metadata.Backup.Format == database.FormatSQL {
+		tables, objects, err := postgres.ScanSQL(r)
+		if err != nil {
+			return err
+		}
+		if objects == 0 {
+			return fmt.Errorf("SQL dump creates no objects")
+		}
+		result.Tables = tables
+		result.Objects = objects
+		return nil
+	}
+
+	archive := ""
+	if metadata.Backup.Format == database.FormatDirectory {
+		archive = filepath.Join(tmpDir, "dump")
+		if err := database.UntarDirectory(r, archive); err != nil {
+			return err
+		}
+		r = nil
+	}
+	entries, err := postgres.ListArchive(ctx, e.cfg.Database.PGRestorePath, archive, r)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("archive is empty")
+	}
+	if table != "" && !postgres.ArchiveHasTable(entries, table) {
+		return fmt.Errorf("table %s is not in the backup", table)
+	}
+	result.Tables = postgres.ArchiveTables(entries)
+	result.Objects = len(entries)
+	return nil
+}
+
+// checkSQLite runs an integrity check on the database at path and counts
+// its tables and schema objects into result.
+func checkSQLite(ctx context.Context, path string, result *RestoreResult) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open restored database: %w", err)
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return fmt.Errorf("integrity check query failed: %w", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("integrity check failed: %s", integrity)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT type FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+		if kind == "table" {
+			result.Tables++
+		}
+		result.Objects++
+	}
+	return rows.Err()
+}
+
+// checkTargetFile checks, before anything is downloaded, that a backup can
+// be written to the file at path: an existing file there may be replaced,
+// and its directory exists, creating it if needed, and accepts new files.
+func checkTargetFile(path string, replace bool) error {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("target %s is a directory", path)
+	case err == nil && !replace:
+		return fmt.Errorf("target %s already exists; force the restore to replace it", path)
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("failed to check target: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".datasaver-restore-*")
+	if err != nil {
+		return fmt.Errorf("target directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// checkDataDir rejects a target for a physical restore that is unset or
+// already holds files; a base backup is never unpacked over a cluster.
+func checkDataDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("physical backups are restored into a data directory; set a target directory")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read target directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("target directory %s is not empty", dir)
+	}
+	return nil
+}
+
+// restorePhysical unpacks a base backup into opts.TargetDir and sets it up
+// to recover from the WAL archive when PostgreSQL starts.
+func (e *Engine) restorePhysical(r io.Reader, opts RestoreOptions) error {
+	if err := database.ExtractBaseBackup(r, opts.TargetDir); err != nil {
+		return err
+	}
+
+	restoreCommand := opts.RestoreCommand
+	if restoreCommand == "" {
+		restoreCommand = DefaultRestoreCommand
+	}
+
+	settings := "\n# Added by datasaver restore\n"
+	settings += "restore_command = " + quoteSetting(restoreCommand) + "\n"
+	if !opts.TargetTime.IsZero() {
+		settings += "recovery_target_time = " + quoteSetting(opts.TargetTime.UTC().Format("2006-01-02 15:04:05.999999-07")) + "\n"
+		settings += "recovery_target_action = 'promote'\n"
+	}
+
+	conf, err := os.OpenFile(filepath.Join(opts.TargetDir, "postgresql.auto.conf"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open postgresql.auto.conf: %w", err)
+	}
+	if _, err := conf.WriteString(settings); err != nil {
+		conf.Close()
+		return fmt.Errorf("failed to write recovery settings: %w", err)
+	}
+	if err := conf.Close(); err != nil {
+		return fmt.Errorf("failed to write recovery settings: %w", err)
+	}
+
+	// recovery.signal makes the server replay archived WAL before opening.
+	if err := os.WriteFile(filepath.Join(opts.TargetDir, "recovery.signal"), nil, 0600); err != nil {
+		return fmt.Errorf("failed to write recovery.signal: %w", err)
+	}
+	return nil
+}
+
+// quoteSetting quotes s as a postgresql.conf string value.
+func quoteSetting(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// reportRestore sends the restore outcome to the webhook and metrics, so a
+// failed restore during a DR drill can be alerted on.
+func (e *Engine) reportRestore(result *RestoreResult, start time.Time) {
+	if e.metrics != nil {
+		e.metrics.RecordRestore(result.Success, time.Since(start))
+	}
+	if e.notifier != nil {
+		e.notifier.NotifyRestore(result.BackupID, result.TargetDB, result.Success, result.Error)
+	}
+}
+
+// runPostRestoreHooks passes the outcome of a restore to the post_restore
+// hooks. Hook failures are logged and do not change the restore's result.
+func (e *Engine) runPostRestoreHooks(ctx context.Context, result *RestoreResult) {
+	status := "success"
+	if !result.Success {
+		status = "failed"
+	}
+
+	env := []string{
+		"DATASAVER_BACKUP_ID=" + result.BackupID,
+		"DATASAVER_TARGET_DB=" + result.TargetDB,
+		"DATASAVER_RESTORE_STATUS=" + status,
+	}
+	if result.Error != nil {
+		env = append(env, "DATASAVER_RESTORE_ERROR="+result.Error.Error())
+	}
+
+	if err := e.hooks.Run(ctx, hooks.PostRestore, e.cfg.Hooks.PostRestore, env); err != nil {
+		e.logger.Error("post-restore hook failed", "backup_id", result.BackupID, "error", err)
+	}
+}
+
+// fromCache links the cached copy of backupFile to localPath when the cache
+// holds one matching checksum, and reports whether it did.
+func (e *Engine) fromCache(backupFile, checksum, localPath string) bool {
+	ok, err := e.cache.Fetch(backupFile, checksum, localPath)
+	if err != nil {
+		e.logger.Warn("not using cached backup", "file", backupFile, "error", err)
+	}
+	if ok {
+		e.logger.Info("using cached backup", "file", backupFile)
+	}
+	return ok
+}
+
+// download copies backupFile from storage to localPath, tracking progress
+// against the stored size when the backend can report it.
+func (e *Engine) download(ctx context.Context, backupFile, localPath string) error {
+	reader, err := e.storage.Read(ctx, backupFile)
+	if err != nil {
+		return fmt.Errorf("failed to read backup file: %w", err)
+	}
+	defer reader.Close()
+
+	size, err := e.storage.Size(ctx, backupFile)
+	if err != nil {
+		size = 0
+	}
+	counter := progress.Track(ctx, e.logger, "download", size)
+
+	localFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer localFile.Close()
+
+	if _, err := io.Copy(localFile, counter.Reader(reader)); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	counter.Done()
+
+	return nil
+}
+
+// isSQLiteBackup reports whether the backup was taken from SQLite. Backups
+// record the driver that made them; metadata without a known driver falls
+// back to the configured database type.
+func (e *Engine) isSQLiteBackup(metadata *postgres.BackupMetadata) bool {
+	switch metadata.Backup.Method {
+	case "sqlite":
+		return true
+	case "postgres":
+		return false
+	default:
+		return e.cfg.IsSQLite()
+	}
+}
+
+// restoreSQLite writes the backup to targetDB. An existing file is kept as a
+// timestamped .bak copy, unless dropExisting removes it, and its WAL files,
+// first.
+func (e *Engine) restoreSQLite(ctx context.Context, r io.Reader, targetDB string, dropExisting bool) error {
+	if dropExisting {
+		for _, path := range []string{targetDB, targetDB + "-wal", targetDB + "-shm"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove existing database: %w", err)
+			}
+		}
+	}
+
+	driver, err := database.NewSQLiteDriver(database.Config{
+		Path:          targetDB,
+		RestoreCopies: e.cfg.Database.SQLiteRestoreCopies,
+		SQLite3Path:   e.cfg.Database.SQLite3Path,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create database driver: %w", err)
+	}
+
+	if err := driver.Restore(ctx, r, targetDB); err != nil {
+		return fmt.Errorf("sqlite restore failed: %w", err)
+	}
+
+	return nil
+}
+
+func (e *Engine) restorePostgres(ctx context.Context, r io.Reader, format, targetDB, tmpDir string, opts RestoreOptions) error {
+	if opts.Force {
+		if err := e.recreateDatabase(ctx, targetDB, opts); err != nil {
+			return err
+		}
+	}
+
+	host, port, user, password := e.targetConnection(opts)
+	if opts.retargeted() {
+		e.logger.Info("restoring to target server", "host", host, "port", port, "user", user, "database", targetDB)
+	}
+
+	restoreOpts := postgres.DumpOptions{
+		Database: targetDB,
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: password,
+		Jobs:     e.cfg.Database.DumpJobs,
+
+		ConnectTimeout: e.cfg.Database.ConnectTimeoutSeconds,
+
+		PGRestorePath: e.cfg.Database.PGRestorePath,
+		PSQLPath:      e.cfg.Database.PSQLPath,
+	}
+	e.applySSL(&restoreOpts)
+
+	// Directory-format dumps are stored as a tar; pg_restore needs the
+	// directory itself, so this format is unpacked to disk. A single-table
+	// restore lists the dump before restoring from it, so it needs the dump
+	// on disk too.
+	if format == database.FormatDirectory || opts.Table != "" {
+		dumpPath := filepath.Join(tmpDir, "dump")
+		if err := stageArchive(r, format, dumpPath); err != nil {
+			return err
+		}
+
+		if opts.Table != "" {
+			entries, err := postgres.ListArchive(ctx, e.cfg.Database.PGRestorePath, dumpPath, nil)
+			if err != nil {
+				return err
+			}
+			if !postgres.ArchiveHasTable(entries, opts.Table) {
+				return fmt.Errorf("table %s is not in the backup", opts.Table)
+			}
+			restoreOpts.Table = opts.Table
+		}
+
+		if err := postgres.Restore(ctx, dumpPath, restoreOpts); err != nil {
+			return fmt.Errorf("pg_restore failed: %w", err)
+		}
+		return nil
+	}
+
+	// Plain SQL dumps are replayed by psql, which needs no archive format
+	// support from the target server's version.
+	if format == database.FormatSQL {
+		if err := postgres.RestoreSQL(ctx, r, restoreOpts); err != nil {
+			return fmt.Errorf("psql restore failed: %w", err)
+		}
+		return nil
+	}
+
+	if err := postgres.RestoreReader(ctx, r, restoreOpts); err != nil {
+		return fmt.Errorf("pg_restore failed: %w", err)
+	}
+
+	return nil
+}
+
+// stageArchive writes the dump read from r to path: a directory for
+// directory-format dumps, otherwise a single archive file.
+func stageArchive(r io.Reader, format, path string) error {
+	if format == database.FormatDirectory {
+		return database.UntarDirectory(r, path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to stage dump: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stage dump: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to stage dump: %w", err)
+	}
+	return nil
+}
+
+// checkExtract rejects extract options that do not fit together or with
+// the backup: an extract restores nothing, so no restore target applies,
+// and only custom and directory format archives convert to SQL.
+func (e *Engine) checkExtract(metadata *postgres.BackupMetadata, opts RestoreOptions) error {
+	if opts.ExtractTo == "" {
+		return fmt.Errorf("converting to SQL needs a file to extract to")
+	}
+	if opts.DryRun || opts.Force || opts.TargetDB != "" || opts.TargetDir != "" || !opts.TargetTime.IsZero() || opts.Table != "" || opts.retargeted() {
+		return fmt.Errorf("an extract writes the dump to a file and restores nothing; it takes no restore target or options")
+	}
+	if opts.ExtractSQL {
+		format := metadata.Backup.Format
+		if metadata.Backup.Kind == postgres.KindBase || e.isSQLiteBackup(metadata) || format == database.FormatSQL {
+			return fmt.Errorf("only PostgreSQL custom and directory format dumps convert to SQL; %s is not one", opts.BackupID)
+		}
+	}
+	return nil
+}
+
+// extract writes the dump read from r to opts.ExtractTo, or with
+// opts.ExtractSQL the SQL script pg_restore makes of it. The output goes to
+// a temporary file next to it and is renamed into place once the content
+// checksum matched, so a failed extract leaves nothing behind.
+func (e *Engine) extract(ctx context.Context, r io.Reader, check *contentCheck, format string, opts RestoreOptions, tmpDir string, result *RestoreResult) error {
+	out, err := os.CreateTemp(filepath.Dir(opts.ExtractTo), "."+filepath.Base(opts.ExtractTo)+".*.tmp")
+	if err != nil {
+		result.Error = fmt.Errorf("failed to create output file: %w", err)
+		return result.Error
+	}
+	defer os.Remove(out.Name())
+
+	if opts.ExtractSQL {
+		out.Close()
+		dumpPath := filepath.Join(tmpDir, "dump")
+		if err := stageArchive(r, format, dumpPath); err != nil {
+			result.Error = err
+			return result.Error
+		}
+		if err := e.verifyContent(check, r, result); err != nil {
+			return err
+		}
+		if err := postgres.ArchiveToSQL(ctx, e.cfg.Database.PGRestorePath, dumpPath, out.Name()); err != nil {
+			result.Error = err
+			return result.Error
+		}
+	} else {
+		_, err := io.Copy(out, r)
+		if err == nil {
+			err = out.Sync()
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("failed to write %s: %w", opts.ExtractTo, err)
+			return result.Error
+		}
+		if err := e.verifyContent(check, r, result); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(out.Name(), opts.ExtractTo); err != nil {
+		result.Error = fmt.Errorf("failed to write %s: %w", opts.ExtractTo, err)
+		return result.Error
+	}
+	return nil
+}
+
+// checkTableRestore rejects a single-table restore of a backup pg_restore
+// cannot select tables from, and one that would drop the target first.
+func (e *Engine) checkTableRestore(metadata *postgres.BackupMetadata, opts RestoreOptions) error {
+	if metadata.Backup.Kind == postgres.KindBase || e.isSQLiteBackup(metadata) || metadata.Backup.Format == database.FormatSQL {
+		return fmt.Errorf("restoring a single table needs a PostgreSQL custom or directory format dump; %s is not one", opts.BackupID)
+	}
+	if opts.Force {
+		return fmt.Errorf("cannot drop and recreate the target for a single-table restore: every other table in it would be lost")
+	}
+	return nil
+}
+
+// tableCounts returns the entries of counts for table, "name" or
+// "schema.name"; an unqualified name on either side matches any schema.
+func tableCounts(counts map[string]int64, table string) map[string]int64 {
+	schema, name := postgres.SplitTableName(table)
+	kept := make(map[string]int64)
+	for key, count := range counts {
+		keySchema, keyName := postgres.SplitTableName(key)
+		if keyName == name && (schema == "" || keySchema == "" || keySchema == schema) {
+			kept[key] = count
+		}
+	}
+	return kept
+}
+
+// checkRowCounts counts the rows of the sentinel tables in the restored
+// database and marks result as suspect, and sends an alert, when they could
+// not be counted or differ from want, the counts taken at backup time.
+func (e *Engine) checkRowCounts(ctx context.Context, want map[string]int64, sqlite bool, targetDB string, opts RestoreOptions, result *RestoreResult) {
+	tables := make([]string, 0, len(want))
+	for table := range want {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	got, err := e.countRows(ctx, sqlite, targetDB, opts, tables)
+	if err != nil {
+		result.Suspect = true
+		e.logger.Error("restore is suspect: failed to count sentinel table rows", "target_db", targetDB, "error", err)
+		e.alert(fmt.Sprintf("Restore of %s into %s is suspect: %v", result.BackupID, targetDB, err))
+		return
+	}
+
+	for _, table := range tables {
+		if got[table] != want[table] {
+			result.RowCountMismatches = append(result.RowCountMismatches, RowCountMismatch{
+				Table:    table,
+				Expected: want[table],
+				Actual:   got[table],
+			})
+		}
+	}
+	if len(result.RowCountMismatches) == 0 {
+		e.logger.Info("sentinel table row counts match", "target_db", targetDB, "tables", len(tables))
+		return
+	}
+
+	result.Suspect = true
+	diffs := make([]string, len(result.RowCountMismatches))
+	for i, m := range result.RowCountMismatches {
+		diffs[i] = fmt.Sprintf("%s has %d rows, expected %d", m.Table, m.Actual, m.Expected)
+	}
+	e.logger.Error("restore is suspect: sentinel table row counts differ", "target_db", targetDB, "mismatches", diffs)
+	e.alert(fmt.Sprintf("Restore of %s into %s is suspect: %s", result.BackupID, targetDB, strings.Join(diffs, "; ")))
+}
+
+// countRows counts the rows of tables in the restored database targetDB.
+func (e *Engine) countRows(ctx context.Context, sqlite bool, targetDB string, opts RestoreOptions, tables []string) (map[string]int64, error) {
+	var driver interface {
+		database.Driver
+		database.RowCounter
+	}
+	var err error
+	if sqlite {
+		driver, err = database.NewSQLiteDriver(database.Config{Path: targetDB})
+	} else {
+		driver, err = e.targetDriver(targetDB, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database driver: %w", err)
+	}
+	if err := driver.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to restored database: %w", err)
+	}
+	defer driver.Close()
+
+	return driver.CountRows(ctx, tables)
+}
+
+func (e *Engine) alert(message string) {
+	if e.notifier != nil {
+		e.notifier.NotifyAlert(message)
+	}
+}
+
+// maintenanceDB is the database connected to while the restore target is
+// dropped and recreated.
+const maintenanceDB = "postgres"
+
+// recreateDatabase drops and recreates name through maintenanceDB on the
+// target server, so pg_restore starts from an empty database.
+func (e *Engine) recreateDatabase(ctx context.Context, name string, opts RestoreOptions) error {
+	driver, err := e.targetDriver(maintenanceDB, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create database driver: %w", err)
+	}
+	if err := driver.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to %s database: %w", maintenanceDB, err)
+	}
+	defer driver.Close()
+
+	host, _, _, _ := e.targetConnection(opts)
+	e.logger.Warn("dropping and recreating target database", "database", name, "host", host)
+	return driver.RecreateDatabase(ctx, name)
+}
+
+// targetDriver returns a driver for database name on the server a
+// PostgreSQL restore connects to.
+func (e *Engine) targetDriver(name string, opts RestoreOptions) (*database.PostgresDriver, error) {
+	host, port, user, password := e.targetConnection(opts)
+	var ssl postgres.DumpOptions
+	e.applySSL(&ssl)
+
+	return database.NewPostgresDriver(database.Config{
+		Type:     "postgres",
+		Host:     host,
+		Port:     port,
+		Name:     name,
+		User:     user,
+		Password: password,
+
+		SSLMode:     ssl.SSLMode,
+		SSLRootCert: ssl.SSLRootCert,
+		SSLCert:     ssl.SSLCert,
+		SSLKey:      ssl.SSLKey,
+
+		ConnectTimeout: e.cfg.Database.ConnectTimeoutSeconds,
+	})
+}
+
+// applySSL copies the TLS settings into opts, matching the backup driver's
+// connection string: parameters in the database URL win over the ssl_*
+// fields, and host/port settings without an ssl_mode keep sslmode=disable.
+func (e *Engine) applySSL(opts *postgres.DumpOptions) {
+	opts.SSLMode = e.cfg.Database.SSLMode
+	opts.SSLRootCert = e.cfg.Database.SSLRootCert
+	opts.SSLCert = e.cfg.Database.SSLCert
+	opts.SSLKey = e.cfg.Database.SSLKey
+
+	if e.cfg.Database.URL == "" {
+		if opts.SSLMode == "" {
+			opts.SSLMode = "disable"
+		}
+		return
+	}
+
+	u, err := url.Parse(e.cfg.Database.URL)
+	if err != nil {
+		return
+	}
+	query := u.Query()
+	for key, field := range map[string]*string{
+		"sslmode":     &opts.SSLMode,
+		"sslrootcert": &opts.SSLRootCert,
+		"sslcert":     &opts.SSLCert,
+		"sslkey":      &opts.SSLKey,
+	} {
+		if v := query.Get(key); v != "" {
+			*field = v
+		}
+	}
+}
+
+// targetConnection returns the server a PostgreSQL restore connects to: the
+// configured connection with opts' target overrides applied.
+func (e *Engine) targetConnection(opts RestoreOptions) (host string, port int, user, password string) {
+	host, port, _, user, password = e.parseConnectionInfo()
+	if opts.TargetHost != "" {
+		host = opts.TargetHost
+	}
+	if opts.TargetPort != 0 {
+		port = opts.TargetPort
+	}
+	if opts.TargetUser != "" {
+		user = opts.TargetUser
+	}
+	if opts.TargetPassword != "" {
+		password = opts.TargetPassword
+	}
+	return
+}
+
 func (e *Engine) parseConnectionInfo() (host string, port int, dbName, user, password string) {
 	if e.cfg.Database.URL != "" {
 		u, err := url.Parse(e.cfg.Database.URL)
@@ -0,0 +1,249 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/localrivet/datasaver/internal/storage"
+	"github.com/localrivet/datasaver/pkg/checksum"
+	"github.com/localrivet/datasaver/pkg/postgres"
+)
+
+// ErrWALNotFound means the archive has no file of the requested name.
+// PostgreSQL asks for files past the end of the archive during recovery, so
+// this is expected once replay catches up.
+var ErrWALNotFound = errors.New("WAL file not found in archive")
+
+// FetchWAL copies archived WAL file name to dest. It is meant to run as
+// PostgreSQL's restore_command. The stored file's checksum is verified, and
+// dest only appears once it is complete.
+func (e *Engine) FetchWAL(ctx context.Context, name, dest string) error {
+	if !postgres.IsWALFileName(name) {
+		return fmt.Errorf("not a WAL file: %s", name)
+	}
+
+	metaPath := postgres.WALMetadataPath(name)
+	exists, err := e.storage.Exists(ctx, metaPath)
+	if err != nil {
+		return fmt.Errorf("failed to check WAL archive: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrWALNotFound, name)
+	}
+
+	metaReader, err := e.storage.Read(ctx, metaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL metadata: %w", err)
+	}
+	metaData, err := io.ReadAll(metaReader)
+	metaReader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read WAL metadata: %w", err)
+	}
+	metadata, err := postgres.ParseMetadata(metaData)
+	if err != nil {
+		return err
+	}
+	if key := e.cfg.MetadataSigningKey(); key != nil {
+		if err := metadata.VerifySignature(key); err != nil {
+			return fmt.Errorf("WAL %s: %w", name, err)
+		}
+	}
+	if len(metadata.Files) == 0 {
+		return fmt.Errorf("no file recorded for WAL %s", name)
+	}
+	storedFile := metadata.Files[0]
+
+	tmpDir, err := os.MkdirTemp(e.cfg.Backup.TempDir, "datasaver-wal-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	localPath := filepath.Join(tmpDir, filepath.Base(storedFile))
+	if err := e.download(ctx, storedFile, localPath); err != nil {
+		return err
+	}
+
+	if want := metadata.Backup.Checksum; want != "" {
+		got, err := checksum.FileFor(localPath, want)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("checksum mismatch for WAL %s: expected %s, got %s", name, want, got)
+		}
+	}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer localFile.Close()
+
+	walReader, err := e.transforms.Reader(localFile, storedFile)
+	if err != nil {
+		return err
+	}
+	defer walReader.Close()
+
+	tmpPath := dest + ".datasaver"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(out, walReader); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write WAL file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write WAL file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		return fmt.Errorf("failed to move WAL file into place: %w", err)
+	}
+	return nil
+}
+
+// checkWALCoverage checks that the WAL archive can carry base backup base
+// forward to target: it must hold every segment on the backup's timeline
+// from where the backup ends, the WAL before that being in the backup
+// itself, up to one archived at or after target. It returns the end LSN of
+// that last segment.
+func (e *Engine) checkWALCoverage(ctx context.Context, base *postgres.BackupMetadata, target time.Time) (string, error) {
+	if target.Before(base.Timestamp) {
+		return "", fmt.Errorf("base backup %s finished at %s, after the requested time %s",
+			base.ID, base.Timestamp.UTC().Format(time.RFC3339), target.UTC().Format(time.RFC3339))
+	}
+	from := base.Backup.EndLSN
+	if from == "" {
+		from = base.Backup.StartLSN
+	}
+	if from == "" {
+		return "", fmt.Errorf("base backup %s does not record its WAL range; cannot check WAL coverage", base.ID)
+	}
+	next, err := postgres.ParseLSN(from)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := e.walEntries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	type segment struct {
+		start, end uint64
+		archivedAt time.Time
+	}
+	var segments []segment
+	for _, entry := range entries {
+		if entry.Timeline != base.Backup.Timeline || entry.StartLSN == "" || entry.EndLSN == "" {
+			continue
+		}
+		start, err := postgres.ParseLSN(entry.StartLSN)
+		if err != nil {
+			continue
+		}
+		end, err := postgres.ParseLSN(entry.EndLSN)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{start, end, entry.ArchivedAt})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start < segments[j].start })
+
+	var covered time.Time
+	for _, s := range segments {
+		if s.end <= next {
+			continue
+		}
+		if s.start > next {
+			break // A gap: replay stops at next.
+		}
+		next = s.end
+		covered = s.archivedAt
+		if !s.archivedAt.Before(target) {
+			return postgres.FormatLSN(next), nil
+		}
+	}
+
+	if covered.IsZero() {
+		return "", fmt.Errorf("WAL archive has no segment with %s on timeline %d, where base backup %s ends",
+			from, base.Backup.Timeline, base.ID)
+	}
+	return "", fmt.Errorf("WAL archive after base backup %s only reaches %s, archived at %s; cannot recover to %s",
+		base.ID, postgres.FormatLSN(next), covered.UTC().Format(time.RFC3339), target.UTC().Format(time.RFC3339))
+}
+
+// walEntries describes every archived WAL file. Files listed in a WAL index
+// are taken from it; the metadata of the rest, archived with wal-push, is
+// read one by one.
+func (e *Engine) walEntries(ctx context.Context) ([]postgres.WALIndexEntry, error) {
+	var indexes, metas []string
+	err := storage.Walk(ctx, e.storage, postgres.WALPrefix, func(f storage.FileInfo) error {
+		switch {
+		case strings.HasSuffix(f.Path, "/index.json"):
+			indexes = append(indexes, f.Path)
+		case strings.HasSuffix(f.Path, ".wal.json"):
+			metas = append(metas, f.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL archive: %w", err)
+	}
+
+	all := &postgres.WALIndex{}
+	for _, p := range indexes {
+		data, err := e.readAll(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL index: %w", err)
+		}
+		index, err := postgres.ParseWALIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		all.Files = append(all.Files, index.Files...)
+	}
+
+	indexed := make(map[string]bool, len(all.Files))
+	for _, f := range all.Files {
+		indexed[f.Name] = true
+	}
+	for _, p := range metas {
+		if indexed[strings.TrimSuffix(path.Base(p), ".wal.json")] {
+			continue
+		}
+		data, err := e.readAll(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL metadata: %w", err)
+		}
+		meta, err := postgres.ParseMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		all.Add(meta)
+	}
+	return all.Files, nil
+}
+
+func (e *Engine) readAll(ctx context.Context, p string) ([]byte, error) {
+	reader, err := e.storage.Read(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
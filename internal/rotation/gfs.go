@@ -1,6 +1,7 @@
 package rotation
 
 import (
+	"fmt"
 	"sort"
 	"time"
 
@@ -22,7 +23,31 @@ type BackupEntry struct {
 	Types    []BackupType
 }
 
+// Decision records whether the rotator keeps a backup and why.
+type Decision struct {
+	Metadata *postgres.BackupMetadata
+	Type     BackupType // Primary GFS type of the backup
+	Keep     bool
+	Reason   string
+
+	// ruled is set when a label rule decided, which the size cap leaves
+	// alone.
+	ruled bool
+}
+
 func (g *GFSRotator) DetermineBackupsToDelete(backups []*postgres.BackupMetadata) []*postgres.BackupMetadata {
+	var toDelete []*postgres.BackupMetadata
+	for _, d := range g.Plan(backups) {
+		if !d.Keep {
+			toDelete = append(toDelete, d.Metadata)
+		}
+	}
+	return toDelete
+}
+
+// Plan classifies every backup, newest first, and decides whether the policy
+// keeps it. It does not touch storage, so it can be used to preview a cleanup.
+func (g *GFSRotator) Plan(backups []*postgres.BackupMetadata) []Decision {
 	if len(backups) == 0 {
 		return nil
 	}
@@ -31,67 +56,113 @@ func (g *GFSRotator) DetermineBackupsToDelete(backups []*postgres.BackupMetadata
 		return backups[i].Timestamp.After(backups[j].Timestamp)
 	})
 
-	entries := make([]BackupEntry, len(backups))
+	now := time.Now()
+	maxAge := time.Duration(g.policy.MaxAgeDays) * 24 * time.Hour
+
+	limits := map[BackupType]int{
+		BackupTypeDaily:   g.policy.KeepDaily,
+		BackupTypeWeekly:  g.policy.KeepWeekly,
+		BackupTypeMonthly: g.policy.KeepMonthly,
+		BackupTypeYearly:  g.policy.KeepYearly,
+	}
+	counts := make(map[BackupType]int)
+
+	decisions := make([]Decision, len(backups))
 	for i, b := range backups {
-		entries[i] = BackupEntry{
+		if rule, ok := g.policy.RuleFor(b); ok {
+			d := Decision{Metadata: b, Type: GetPrimaryType(b.Timestamp), ruled: true}
+			d.Keep, d.Reason = rule.decide(b, now)
+			decisions[i] = d
+			continue
+		}
+
+		var keptBy string
+		if tier, ok := countingTier(b.Timestamp, limits); ok && counts[tier] < limits[tier] {
+			counts[tier]++
+			keptBy = fmt.Sprintf("%s %d/%d", tier, counts[tier], limits[tier])
+		}
+
+		d := Decision{
 			Metadata: b,
-			Types:    ClassifyBackup(b.Timestamp),
+			Type:     GetPrimaryType(b.Timestamp),
+		}
+		switch {
+		case keptBy == "":
+			d.Reason = "beyond the daily/weekly/monthly/yearly limits"
+		case g.policy.MaxAgeDays > 0 && now.Sub(b.Timestamp) > maxAge:
+			d.Reason = fmt.Sprintf("older than max age of %d days", g.policy.MaxAgeDays)
+		default:
+			d.Keep = true
+			d.Reason = "kept as " + keptBy
 		}
+		decisions[i] = d
 	}
 
-	keep := make(map[string]bool)
-
-	dailyCount := 0
-	weeklyCount := 0
-	monthlyCount := 0
-
-	for _, entry := range entries {
-		shouldKeep := false
-
-		for _, t := range entry.Types {
-			switch t {
-			case BackupTypeMonthly:
-				if monthlyCount < g.policy.KeepMonthly {
-					monthlyCount++
-					shouldKeep = true
-				}
-			case BackupTypeWeekly:
-				if weeklyCount < g.policy.KeepWeekly {
-					weeklyCount++
-					shouldKeep = true
-				}
-			case BackupTypeDaily:
-				if dailyCount < g.policy.KeepDaily {
-					dailyCount++
-					shouldKeep = true
-				}
-			}
-		}
+	if g.policy.MaxTotalBytes > 0 {
+		g.applySizeCap(decisions)
+	}
+	g.protectNewest(decisions)
 
-		if shouldKeep {
-			keep[entry.Metadata.ID] = true
+	return decisions
+}
+
+// countingTier returns the single tier a backup counts against: its primary
+// type, or the next lower tier when that one keeps nothing, so a Sunday
+// backup fills a weekly slot rather than a daily one as well.
+func countingTier(t time.Time, limits map[BackupType]int) (BackupType, bool) {
+	types := ClassifyBackup(t)
+	for i := len(types) - 1; i >= 0; i-- {
+		if limits[types[i]] > 0 {
+			return types[i], true
 		}
 	}
+	return "", false
+}
 
-	now := time.Now()
-	maxAge := time.Duration(g.policy.MaxAgeDays) * 24 * time.Hour
+// applySizeCap deletes the oldest kept backups until the kept total fits
+// under MaxTotalBytes. The newest backup is always kept, even if it alone
+// exceeds the cap, and so are backups a label rule keeps, though their
+// size counts toward the total.
+func (g *GFSRotator) applySizeCap(decisions []Decision) {
+	var total int64
+	for _, d := range decisions {
+		if d.Keep {
+			total += d.Metadata.Backup.CompressedSize
+		}
+	}
 
-	var toDelete []*postgres.BackupMetadata
-	for _, entry := range entries {
-		if !keep[entry.Metadata.ID] {
-			toDelete = append(toDelete, entry.Metadata)
+	for i := len(decisions) - 1; i > 0 && total > g.policy.MaxTotalBytes; i-- {
+		if !decisions[i].Keep || decisions[i].ruled {
 			continue
 		}
+		decisions[i].Keep = false
+		decisions[i].Reason = fmt.Sprintf("over storage cap of %d bytes", g.policy.MaxTotalBytes)
+		total -= decisions[i].Metadata.Backup.CompressedSize
+	}
+}
 
-		if g.policy.MaxAgeDays > 0 {
-			age := now.Sub(entry.Metadata.Timestamp)
-			if age > maxAge {
-				toDelete = append(toDelete, entry.Metadata)
-			}
+// protectNewest keeps the KeepNewest most recent backups, and always the
+// newest one, whatever the tiers, max age or size cap decided. Without it a
+// backup that ages out while no new backup succeeds leaves nothing to
+// restore.
+func (g *GFSRotator) protectNewest(decisions []Decision) {
+	n := max(g.policy.KeepNewest, 1)
+	for i := 0; i < n && i < len(decisions); i++ {
+		if decisions[i].Keep {
+			continue
+		}
+		decisions[i].Keep = true
+		if n == 1 {
+			decisions[i].Reason = "kept as the newest backup"
+		} else {
+			decisions[i].Reason = fmt.Sprintf("kept as one of the %d newest backups", n)
 		}
 	}
+}
 
-	return toDelete
+// Policy returns the retention policy the rotator applies.
+func (g *GFSRotator) Policy() *Policy {
+	return g.policy
 }
 
 func (g *GFSRotator) GetRetentionInfo(backupTime time.Time) (time.Time, string) {
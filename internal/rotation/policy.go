@@ -1,14 +1,74 @@
 package rotation
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/localrivet/datasaver/pkg/postgres"
 )
 
 type Policy struct {
 	KeepDaily   int
 	KeepWeekly  int
 	KeepMonthly int
+	KeepYearly  int // Jan 1 backups; not set by NewPolicy
 	MaxAgeDays  int
+
+	// MaxTotalBytes caps the compressed size of all kept backups; 0 means
+	// no cap. Not set by NewPolicy.
+	MaxTotalBytes int64
+
+	// KeepNewest is how many of the most recent backups are kept whatever
+	// their tier, age or size, so a run of failed backups cannot age out
+	// the last good ones. At least one is always kept. Not set by NewPolicy.
+	KeepNewest int
+
+	// Rules keep backups by label instead of by tier; the first rule whose
+	// labels a backup carries decides it. Not set by NewPolicy.
+	Rules []LabelRule
+}
+
+// LabelRule keeps backups carrying every label in Match for KeepDays days
+// after they were taken and deletes them after, whatever the GFS tiers,
+// max age and size cap would decide. Such backups do not take a tier slot.
+type LabelRule struct {
+	Match    map[string]string
+	KeepDays int
+}
+
+// String describes the rule's labels, e.g. "reason=pre-upgrade".
+func (r LabelRule) String() string {
+	pairs := make([]string, 0, len(r.Match))
+	for k, v := range r.Match {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// KeepUntil returns when a backup taken at t stops being kept by the rule.
+func (r LabelRule) KeepUntil(t time.Time) time.Time {
+	return t.AddDate(0, 0, r.KeepDays)
+}
+
+// RuleFor returns the first rule matching the labels of b.
+func (p *Policy) RuleFor(b *postgres.BackupMetadata) (LabelRule, bool) {
+	for _, r := range p.Rules {
+		if len(r.Match) > 0 && b.HasLabels(r.Match) {
+			return r, true
+		}
+	}
+	return LabelRule{}, false
+}
+
+func (r LabelRule) decide(b *postgres.BackupMetadata, now time.Time) (bool, string) {
+	until := r.KeepUntil(b.Timestamp)
+	if now.Before(until) {
+		return true, fmt.Sprintf("kept by label rule %s until %s", r, until.Format("2006-01-02"))
+	}
+	return false, fmt.Sprintf("older than the %d days label rule %s keeps it", r.KeepDays, r)
 }
 
 func NewPolicy(daily, weekly, monthly, maxAgeDays int) *Policy {
@@ -26,6 +86,7 @@ const (
 	BackupTypeDaily   BackupType = "daily"
 	BackupTypeWeekly  BackupType = "weekly"
 	BackupTypeMonthly BackupType = "monthly"
+	BackupTypeYearly  BackupType = "yearly"
 )
 
 func ClassifyBackup(t time.Time) []BackupType {
@@ -39,10 +100,17 @@ func ClassifyBackup(t time.Time) []BackupType {
 		types = append(types, BackupTypeMonthly)
 	}
 
+	if t.Day() == 1 && t.Month() == time.January {
+		types = append(types, BackupTypeYearly)
+	}
+
 	return types
 }
 
 func GetPrimaryType(t time.Time) BackupType {
+	if t.Day() == 1 && t.Month() == time.January {
+		return BackupTypeYearly
+	}
 	if t.Day() == 1 {
 		return BackupTypeMonthly
 	}
@@ -56,6 +124,8 @@ func (p *Policy) CalculateRetentionDate(backupTime time.Time, backupType BackupT
 	var retentionDays int
 
 	switch backupType {
+	case BackupTypeYearly:
+		retentionDays = p.KeepYearly * 365
 	case BackupTypeMonthly:
 		retentionDays = p.KeepMonthly * 30
 	case BackupTypeWeekly:
@@ -1,6 +1,9 @@
 package rotation
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,6 +37,9 @@ func TestBackupType_Constants(t *testing.T) {
 	if BackupTypeMonthly != "monthly" {
 		t.Errorf("BackupTypeMonthly = %v, want monthly", BackupTypeMonthly)
 	}
+	if BackupTypeYearly != "yearly" {
+		t.Errorf("BackupTypeYearly = %v, want yearly", BackupTypeYearly)
+	}
 }
 
 func TestClassifyBackup(t *testing.T) {
@@ -95,6 +101,18 @@ func TestClassifyBackup(t *testing.T) {
 	}
 }
 
+func TestClassifyBackup_Yearly(t *testing.T) {
+	types := ClassifyBackup(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) // Monday, Jan 1
+	if !containsType(types, BackupTypeDaily) || !containsType(types, BackupTypeMonthly) || !containsType(types, BackupTypeYearly) {
+		t.Errorf("ClassifyBackup(Jan 1) = %v, want daily, monthly and yearly", types)
+	}
+
+	types = ClassifyBackup(time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)) // Feb 1
+	if containsType(types, BackupTypeYearly) {
+		t.Errorf("ClassifyBackup(Feb 1) = %v, should not be yearly", types)
+	}
+}
+
 func TestGetPrimaryType(t *testing.T) {
 	tests := []struct {
 		name string
@@ -121,6 +139,16 @@ func TestGetPrimaryType(t *testing.T) {
 			time: time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC), // Sunday, Sep 1
 			want: BackupTypeMonthly,
 		},
+		{
+			name: "jan 1 is yearly",
+			time: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), // Monday, Jan 1
+			want: BackupTypeYearly,
+		},
+		{
+			name: "jan 1 on sunday is yearly (yearly takes precedence)",
+			time: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), // Sunday, Jan 1
+			want: BackupTypeYearly,
+		},
 	}
 
 	for _, tt := range tests {
@@ -171,6 +199,18 @@ func TestPolicy_CalculateRetentionDate(t *testing.T) {
 	}
 }
 
+func TestPolicy_CalculateRetentionDate_Yearly(t *testing.T) {
+	policy := NewPolicy(7, 4, 12, 0)
+	policy.KeepYearly = 7
+	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	result := policy.CalculateRetentionDate(baseTime, BackupTypeYearly)
+	expectedDate := baseTime.AddDate(0, 0, 7*365)
+	if !result.Equal(expectedDate) {
+		t.Errorf("CalculateRetentionDate() = %v, want %v", result, expectedDate)
+	}
+}
+
 func TestPolicy_CalculateRetentionDate_MaxAgeLimit(t *testing.T) {
 	// Policy with short max age
 	policy := NewPolicy(7, 4, 12, 30) // Max 30 days
@@ -288,6 +328,29 @@ func TestGFSRotator_DetermineBackupsToDelete_KeepMonthly(t *testing.T) {
 	}
 }
 
+func TestGFSRotator_DetermineBackupsToDelete_KeepYearly(t *testing.T) {
+	policy := NewPolicy(0, 0, 0, 0)
+	policy.KeepYearly = 2 // Keep only 2 yearly
+	rotator := NewGFSRotator(policy)
+
+	// Create backups on Jan 1 (yearly backups)
+	backups := []*postgres.BackupMetadata{
+		{ID: "backup-1", Timestamp: time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{ID: "backup-2", Timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{ID: "backup-3", Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+	}
+
+	toDelete := rotator.DetermineBackupsToDelete(backups)
+
+	// Should keep 2 most recent, delete 1
+	if len(toDelete) != 1 {
+		t.Fatalf("DetermineBackupsToDelete() deleted %d, want 1", len(toDelete))
+	}
+	if toDelete[0].ID != "backup-1" {
+		t.Errorf("DetermineBackupsToDelete() deleted %s, want backup-1", toDelete[0].ID)
+	}
+}
+
 func TestGFSRotator_DetermineBackupsToDelete_MaxAge(t *testing.T) {
 	policy := NewPolicy(100, 100, 100, 7) // Keep lots, but max 7 days
 	rotator := NewGFSRotator(policy)
@@ -403,6 +466,252 @@ func TestGFSRotator_DetermineBackupsToDelete_MixedTypes(t *testing.T) {
 }
 
 // Helper function
+func TestGFSRotator_Plan(t *testing.T) {
+	policy := NewPolicy(1, 1, 0, 0) // Keep 1 daily, 1 weekly
+	rotator := NewGFSRotator(policy)
+
+	backups := []*postgres.BackupMetadata{
+		{ID: "sunday-old", Timestamp: time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},  // Sunday
+		{ID: "monday", Timestamp: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},     // Monday
+		{ID: "sunday", Timestamp: time.Date(2024, 1, 14, 12, 0, 0, 0, time.UTC)},     // Sunday
+		{ID: "tuesday-old", Timestamp: time.Date(2024, 1, 9, 12, 0, 0, 0, time.UTC)}, // Tuesday
+	}
+
+	decisions := rotator.Plan(backups)
+	if len(decisions) != len(backups) {
+		t.Fatalf("Plan() returned %d decisions, want %d", len(decisions), len(backups))
+	}
+
+	want := map[string]struct {
+		keep   bool
+		typ    BackupType
+		reason string
+	}{
+		"monday":      {true, BackupTypeDaily, "kept as daily 1/1"},
+		"sunday":      {true, BackupTypeWeekly, "kept as weekly 1/1"},
+		"tuesday-old": {false, BackupTypeDaily, "beyond the daily/weekly/monthly/yearly limits"},
+		"sunday-old":  {false, BackupTypeWeekly, "beyond the daily/weekly/monthly/yearly limits"},
+	}
+
+	for i, d := range decisions {
+		if i > 0 && d.Metadata.Timestamp.After(decisions[i-1].Metadata.Timestamp) {
+			t.Error("Plan() decisions are not sorted newest first")
+		}
+		w := want[d.Metadata.ID]
+		if d.Keep != w.keep || d.Type != w.typ || d.Reason != w.reason {
+			t.Errorf("%s: got keep=%v type=%v reason=%q, want keep=%v type=%v reason=%q",
+				d.Metadata.ID, d.Keep, d.Type, d.Reason, w.keep, w.typ, w.reason)
+		}
+	}
+}
+
+func TestGFSRotator_Plan_MaxAge(t *testing.T) {
+	policy := NewPolicy(7, 0, 0, 30)
+	rotator := NewGFSRotator(policy)
+
+	backups := []*postgres.BackupMetadata{
+		{ID: "recent", Timestamp: time.Now().AddDate(0, 0, -1)},
+		{ID: "old", Timestamp: time.Now().AddDate(0, 0, -60)},
+	}
+
+	decisions := rotator.Plan(backups)
+	if decisions[1].Keep {
+		t.Error("Plan() kept a backup older than max age")
+	}
+	if decisions[1].Reason != "older than max age of 30 days" {
+		t.Errorf("Reason = %q", decisions[1].Reason)
+	}
+}
+
+func TestGFSRotator_Plan_AllOlderThanMaxAge(t *testing.T) {
+	policy := NewPolicy(7, 4, 6, 30)
+	rotator := NewGFSRotator(policy)
+
+	// Every backup has aged out, as after a month of failed runs.
+	now := time.Now()
+	backups := []*postgres.BackupMetadata{
+		{ID: "backup-1", Timestamp: now.AddDate(0, 0, -40)},
+		{ID: "backup-2", Timestamp: now.AddDate(0, 0, -41)},
+		{ID: "backup-3", Timestamp: now.AddDate(0, 0, -42)},
+	}
+
+	decisions := rotator.Plan(backups)
+	if !decisions[0].Keep || decisions[0].Metadata.ID != "backup-1" {
+		t.Fatalf("Plan() did not keep the newest backup: %+v", decisions[0])
+	}
+	if decisions[0].Reason != "kept as the newest backup" {
+		t.Errorf("Reason = %q", decisions[0].Reason)
+	}
+
+	toDelete := rotator.DetermineBackupsToDelete(backups)
+	if len(toDelete) != 2 {
+		t.Errorf("DetermineBackupsToDelete() deleted %d, want 2", len(toDelete))
+	}
+	for _, b := range toDelete {
+		if b.ID == "backup-1" {
+			t.Error("DetermineBackupsToDelete() deleted the only remaining recent backup")
+		}
+	}
+
+	// A single backup is never deleted.
+	if toDelete := rotator.DetermineBackupsToDelete(backups[:1]); len(toDelete) != 0 {
+		t.Errorf("DetermineBackupsToDelete() of the last backup = %d deleted, want 0", len(toDelete))
+	}
+}
+
+func TestGFSRotator_Plan_KeepNewest(t *testing.T) {
+	policy := NewPolicy(1, 0, 0, 30)
+	policy.KeepNewest = 3
+	policy.MaxTotalBytes = 100
+	rotator := NewGFSRotator(policy)
+
+	now := time.Now()
+	var backups []*postgres.BackupMetadata
+	for i := 1; i <= 5; i++ {
+		meta := &postgres.BackupMetadata{ID: fmt.Sprintf("backup-%d", i), Timestamp: now.AddDate(0, 0, -30-i)}
+		meta.Backup.CompressedSize = 80
+		backups = append(backups, meta)
+	}
+
+	decisions := rotator.Plan(backups)
+	for i, d := range decisions {
+		if want := i < 3; d.Keep != want {
+			t.Errorf("%s: Keep = %v, want %v (%s)", d.Metadata.ID, d.Keep, want, d.Reason)
+		}
+	}
+	if decisions[2].Reason != "kept as one of the 3 newest backups" {
+		t.Errorf("Reason = %q", decisions[2].Reason)
+	}
+}
+
+func TestGFSRotator_DetermineBackupsToDelete_MaxTotalBytes(t *testing.T) {
+	newBackup := func(id string, daysAgo int, size int64) *postgres.BackupMetadata {
+		b := &postgres.BackupMetadata{ID: id, Timestamp: time.Now().AddDate(0, 0, -daysAgo)}
+		b.Backup.CompressedSize = size
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		cap        int64
+		backups    []*postgres.BackupMetadata
+		wantDelete []string
+	}{
+		{
+			name:       "no cap",
+			backups:    []*postgres.BackupMetadata{newBackup("new", 0, 100), newBackup("mid", 1, 100), newBackup("old", 2, 100)},
+			wantDelete: nil,
+		},
+		{
+			name:       "under cap",
+			cap:        300,
+			backups:    []*postgres.BackupMetadata{newBackup("new", 0, 100), newBackup("mid", 1, 100), newBackup("old", 2, 100)},
+			wantDelete: nil,
+		},
+		{
+			name:       "deletes oldest first",
+			cap:        250,
+			backups:    []*postgres.BackupMetadata{newBackup("new", 0, 100), newBackup("mid", 1, 100), newBackup("old", 2, 100)},
+			wantDelete: []string{"old"},
+		},
+		{
+			name:       "keeps newest over cap",
+			cap:        50,
+			backups:    []*postgres.BackupMetadata{newBackup("new", 0, 100), newBackup("mid", 1, 100), newBackup("old", 2, 100)},
+			wantDelete: []string{"mid", "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := NewPolicy(7, 0, 0, 0)
+			policy.MaxTotalBytes = tt.cap
+			rotator := NewGFSRotator(policy)
+
+			var got []string
+			for _, b := range rotator.DetermineBackupsToDelete(tt.backups) {
+				got = append(got, b.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantDelete, ",") {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestGFSRotator_Plan_MaxTotalBytesReason(t *testing.T) {
+	policy := NewPolicy(7, 0, 0, 0)
+	policy.MaxTotalBytes = 100
+	rotator := NewGFSRotator(policy)
+
+	newest := &postgres.BackupMetadata{ID: "new", Timestamp: time.Now()}
+	newest.Backup.CompressedSize = 80
+	oldest := &postgres.BackupMetadata{ID: "old", Timestamp: time.Now().AddDate(0, 0, -1)}
+	oldest.Backup.CompressedSize = 80
+
+	decisions := rotator.Plan([]*postgres.BackupMetadata{oldest, newest})
+	if !decisions[0].Keep || decisions[1].Keep {
+		t.Fatalf("Plan() keep = %v, %v, want true, false", decisions[0].Keep, decisions[1].Keep)
+	}
+	if decisions[1].Reason != "over storage cap of 100 bytes" {
+		t.Errorf("Reason = %q", decisions[1].Reason)
+	}
+}
+
+func TestGFSRotator_DetermineBackupsToDelete_MonthOfDailies(t *testing.T) {
+	// April 2024: the 1st is a Monday, Sundays are the 7th, 14th, 21st and 28th.
+	var backups []*postgres.BackupMetadata
+	for day := 1; day <= 30; day++ {
+		backups = append(backups, &postgres.BackupMetadata{
+			ID:        fmt.Sprintf("%02d", day),
+			Timestamp: time.Date(2024, 4, day, 2, 0, 0, 0, time.UTC),
+		})
+	}
+
+	rotator := NewGFSRotator(NewPolicy(7, 4, 1, 0))
+
+	var kept []string
+	for _, d := range rotator.Plan(backups) {
+		if d.Keep {
+			kept = append(kept, d.Metadata.ID)
+		}
+	}
+
+	// Seven weekday dailies, four Sundays and the 1st, with no backup
+	// filling a slot in two tiers.
+	want := "30,29,28,27,26,25,24,23,21,14,07,01"
+	if got := strings.Join(kept, ","); got != want {
+		t.Errorf("kept = %s, want %s", got, want)
+	}
+
+	if deleted := rotator.DetermineBackupsToDelete(backups); len(deleted) != 30-12 {
+		t.Errorf("DetermineBackupsToDelete() deleted %d, want 18", len(deleted))
+	}
+}
+
+func TestGFSRotator_Plan_DisabledTierFallsBack(t *testing.T) {
+	// With no monthly tier, a backup on the 1st counts as a daily.
+	rotator := NewGFSRotator(NewPolicy(2, 0, 0, 0))
+
+	backups := []*postgres.BackupMetadata{
+		{ID: "first", Timestamp: time.Date(2024, 4, 1, 2, 0, 0, 0, time.UTC)},     // Monday the 1st
+		{ID: "sunday", Timestamp: time.Date(2024, 3, 31, 2, 0, 0, 0, time.UTC)},   // Sunday
+		{ID: "saturday", Timestamp: time.Date(2024, 3, 30, 2, 0, 0, 0, time.UTC)}, // Saturday
+	}
+
+	decisions := rotator.Plan(backups)
+	want := map[string]string{
+		"first":    "kept as daily 1/2",
+		"sunday":   "kept as daily 2/2",
+		"saturday": "beyond the daily/weekly/monthly/yearly limits",
+	}
+	for _, d := range decisions {
+		if d.Reason != want[d.Metadata.ID] {
+			t.Errorf("%s: Reason = %q, want %q", d.Metadata.ID, d.Reason, want[d.Metadata.ID])
+		}
+	}
+}
+
 func containsType(types []BackupType, target BackupType) bool {
 	for _, t := range types {
 		if t == target {
@@ -411,3 +720,52 @@ func containsType(types []BackupType, target BackupType) bool {
 	}
 	return false
 }
+
+func TestGFSRotator_Plan_LabelRules(t *testing.T) {
+	policy := NewPolicy(1, 0, 0, 0)
+	policy.MaxTotalBytes = 100
+	policy.Rules = []LabelRule{{Match: map[string]string{"reason": "pre-upgrade"}, KeepDays: 30}}
+	rotator := NewGFSRotator(policy)
+
+	newBackup := func(id string, daysAgo int, labels map[string]string) *postgres.BackupMetadata {
+		b := &postgres.BackupMetadata{ID: id, Timestamp: time.Now().AddDate(0, 0, -daysAgo), Labels: labels}
+		b.Backup.CompressedSize = 40
+		return b
+	}
+	preUpgrade := map[string]string{"reason": "pre-upgrade", "by": "ops"}
+	backups := []*postgres.BackupMetadata{
+		newBackup("daily-new", 0, nil),
+		newBackup("upgrade-recent", 1, preUpgrade),
+		newBackup("daily-old", 2, nil),
+		newBackup("upgrade-mid", 20, preUpgrade),
+		newBackup("upgrade-expired", 31, preUpgrade),
+		newBackup("other-label", 3, map[string]string{"reason": "manual"}),
+	}
+
+	keep := make(map[string]bool)
+	reasons := make(map[string]string)
+	for _, d := range rotator.Plan(backups) {
+		keep[d.Metadata.ID] = d.Keep
+		reasons[d.Metadata.ID] = d.Reason
+	}
+
+	// The rule keeps labeled backups for 30 days without taking the single
+	// daily slot, and the size cap leaves them alone.
+	want := map[string]bool{
+		"daily-new":       true,
+		"upgrade-recent":  true,
+		"daily-old":       false,
+		"upgrade-mid":     true,
+		"upgrade-expired": false,
+		"other-label":     false,
+	}
+	if !reflect.DeepEqual(keep, want) {
+		t.Errorf("keep = %v, want %v", keep, want)
+	}
+	if !strings.HasPrefix(reasons["upgrade-mid"], "kept by label rule reason=pre-upgrade until ") {
+		t.Errorf("Reason = %q", reasons["upgrade-mid"])
+	}
+	if reasons["upgrade-expired"] != "older than the 30 days label rule reason=pre-upgrade keeps it" {
+		t.Errorf("Reason = %q", reasons["upgrade-expired"])
+	}
+}
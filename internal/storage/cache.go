@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/localrivet/datasaver/pkg/checksum"
+)
+
+// cacheTempPrefix names files being copied into the cache; eviction skips
+// them.
+const cacheTempPrefix = ".datasaver-cache-"
+
+// Cache keeps local copies of the most recent backup files, so restores and
+// verification can skip downloading them from remote storage. Files are
+// stored under their storage path and evicted oldest first once there are
+// more than maxFiles of them or they take more than maxBytes; a limit of 0
+// is no limit. Eviction is independent of the retention policy.
+//
+// The methods of a nil *Cache do nothing, so callers need not check whether
+// a cache is configured.
+type Cache struct {
+	dir      string
+	maxFiles int
+	maxBytes int64
+
+	mu sync.Mutex
+}
+
+// NewCache returns a cache in dir, or nil when dir is empty. The directory
+// is created on the first Put.
+func NewCache(dir string, maxFiles int, maxBytes int64) *Cache {
+	if dir == "" {
+		return nil
+	}
+	return &Cache{dir: dir, maxFiles: maxFiles, maxBytes: maxBytes}
+}
+
+func (c *Cache) localPath(path string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(path))
+}
+
+// Put stores a copy of the file at src as path, hard-linking it when src
+// is on the same filesystem, then evicts files beyond the limits.
+func (c *Cache) Put(path, src string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp := filepath.Join(c.dir, fmt.Sprintf("%s%d", cacheTempPrefix, os.Getpid()))
+	os.Remove(tmp)
+	if err := linkOrCopy(src, tmp); err != nil {
+		return fmt.Errorf("failed to copy %s into cache: %w", path, err)
+	}
+
+	dst := c.localPath(path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy %s into cache: %w", path, err)
+	}
+	return c.evict()
+}
+
+// Fetch links or copies the cached copy of path to dst and reports whether
+// it did. A copy that does not match checksum, in the "<algorithm>:<hex>"
+// form backup metadata uses, is removed rather than used, and nothing is
+// used without a checksum to check against.
+func (c *Cache) Fetch(path, checksum, dst string) (bool, error) {
+	if c == nil || checksum == "" {
+		return false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	local := c.localPath(path)
+	actual, err := fileChecksum(local, checksum)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if actual != checksum {
+		os.Remove(local)
+		return false, fmt.Errorf("cached copy of %s has checksum %s, want %s; removed it", path, actual, checksum)
+	}
+
+	if err := linkOrCopy(local, dst); err != nil {
+		return false, fmt.Errorf("failed to copy %s from cache: %w", path, err)
+	}
+	return true, nil
+}
+
+// evict removes the oldest files until the cache is within its limits.
+func (c *Cache) evict() error {
+	type entry struct {
+		path string
+		info fs.FileInfo
+	}
+	var entries []entry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), cacheTempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path, info})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan cache: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().After(entries[j].info.ModTime())
+	})
+
+	var total int64
+	for i, e := range entries {
+		total += e.info.Size()
+		overCount := c.maxFiles > 0 && i >= c.maxFiles
+		overSize := c.maxBytes > 0 && total > c.maxBytes
+		if !overCount && !overSize {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to evict %s from cache: %w", e.path, err)
+		}
+		total -= e.info.Size()
+	}
+	return nil
+}
+
+// linkOrCopy hard-links src to dst, falling back to a copy across
+// filesystems.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// fileChecksum returns the checksum of the file at path with the
+// algorithm want was taken with.
+func fileChecksum(path, want string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w, err := checksum.NewWriterFor(want)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return "", err
+	}
+	return w.Sum(), nil
+}
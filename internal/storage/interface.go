@@ -2,7 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,6 +18,69 @@ type Backend interface {
 	Size(ctx context.Context, path string) (int64, error)
 }
 
+// Locate returns the path of the file named name: the first of candidates
+// or name itself that exists, otherwise the first listed file with that base
+// name. This finds backups stored under a key template without knowing the
+// template.
+func Locate(ctx context.Context, b Backend, name string, candidates ...string) (string, error) {
+	for _, candidate := range append(candidates, name) {
+		exists, err := b.Exists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return candidate, nil
+		}
+	}
+
+	var found string
+	err := Walk(ctx, b, "", func(f FileInfo) error {
+		if strings.HasSuffix(f.Path, "/"+name) {
+			found = f.Path
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrStopWalk) {
+		return "", err
+	}
+	if found == "" {
+		return "", &StorageError{Op: "locate", Path: name, Err: ErrNotFound}
+	}
+	return found, nil
+}
+
+// Walker is implemented by backends that can stream a listing rather than
+// build it in memory, which matters for buckets with many objects.
+type Walker interface {
+	// Walk calls fn for every file under prefix, in no particular order,
+	// and returns the first error fn returns.
+	Walk(ctx context.Context, prefix string, fn func(FileInfo) error) error
+}
+
+// ErrStopWalk can be returned by a Walk callback to stop early. Walk returns
+// it unchanged.
+var ErrStopWalk = errors.New("stop walk")
+
+// Walk calls fn for every file under prefix, streaming the listing when b
+// is a Walker and falling back to List otherwise.
+func Walk(ctx context.Context, b Backend, prefix string, fn func(FileInfo) error) error {
+	if w, ok := b.(Walker); ok {
+		return w.Walk(ctx, prefix, fn)
+	}
+
+	files, err := b.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type FileInfo struct {
 	Path         string
 	Size         int64
@@ -22,24 +88,114 @@ type FileInfo struct {
 	IsDir        bool
 }
 
-type Factory struct{}
+// Constructor builds a backend from the configured storage path and
+// backend-specific settings. raw is nil when there are none.
+type Constructor func(path string, raw map[string]any) (Backend, error)
 
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Constructor{
+		"local": newLocalFromRaw,
+		"s3":    newS3FromRaw,
+	}
+)
+
+// Register makes a backend available to every Factory created afterwards,
+// typically from the init function of the package providing it.
+func Register(name string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = constructor
+}
+
+type Factory struct {
+	mu           sync.RWMutex
+	constructors map[string]Constructor
+}
+
+// NewFactory returns a factory for the built-in backends and any added with
+// the package-level Register.
 func NewFactory() *Factory {
-	return &Factory{}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	f := &Factory{constructors: make(map[string]Constructor, len(registry))}
+	for name, constructor := range registry {
+		f.constructors[name] = constructor
+	}
+	return f
 }
 
+// Register adds or replaces a backend on this factory only.
+func (f *Factory) Register(name string, constructor Constructor) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.constructors[name] = constructor
+}
+
+// Open creates the named backend from its raw settings.
+func (f *Factory) Open(backend, path string, raw map[string]any) (Backend, error) {
+	f.mu.RLock()
+	constructor, ok := f.constructors[backend]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownBackend
+	}
+	return constructor(path, raw)
+}
+
+// Create opens a built-in backend, passing s3Config to the S3 backend.
 func (f *Factory) Create(backend, path string, s3Config *S3Config) (Backend, error) {
-	switch backend {
-	case "local":
-		return NewLocalStorage(path)
-	case "s3":
-		if s3Config == nil {
+	var raw map[string]any
+	if s3Config != nil {
+		raw = map[string]any{"s3": *s3Config}
+	}
+	return f.Open(backend, path, raw)
+}
+
+func newLocalFromRaw(path string, _ map[string]any) (Backend, error) {
+	local, err := NewLocalStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	return local, nil
+}
+
+// newS3FromRaw accepts either an S3Config under "s3", as passed by Create,
+// or the settings as individual keys named like the YAML config.
+func newS3FromRaw(_ string, raw map[string]any) (Backend, error) {
+	cfg, ok := raw["s3"].(S3Config)
+	if !ok {
+		if _, ok := raw["bucket"]; !ok {
 			return nil, ErrS3ConfigRequired
 		}
-		return NewS3Storage(*s3Config)
-	default:
-		return nil, ErrUnknownBackend
+		cfg = s3ConfigFromRaw(raw)
+	}
+
+	s3, err := NewS3Storage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s3, nil
+}
+
+func s3ConfigFromRaw(raw map[string]any) S3Config {
+	str := func(key string) string {
+		v, _ := raw[key].(string)
+		return v
+	}
+	cfg := S3Config{
+		Bucket:    str("bucket"),
+		Endpoint:  str("endpoint"),
+		Region:    str("region"),
+		AccessKey: str("access_key"),
+		SecretKey: str("secret_key"),
 	}
+	cfg.UseSSL, _ = raw["use_ssl"].(bool)
+	cfg.MaxAttempts, _ = raw["max_attempts"].(int)
+	cfg.ObjectLockMode = str("object_lock_mode")
+	cfg.ObjectLockDays, _ = raw["object_lock_days"].(int)
+	return cfg
 }
 
 type S3Config struct {
@@ -49,6 +205,14 @@ type S3Config struct {
 	AccessKey string
 	SecretKey string
 	UseSSL    bool
+
+	MaxAttempts int // Per-operation attempts on transient errors; 0 uses the default of 3
+
+	// ObjectLockDays retains every object written for this many days with
+	// S3 object lock, in ObjectLockMode (GOVERNANCE, the default, or
+	// COMPLIANCE). The bucket must have object lock enabled. 0 disables it.
+	ObjectLockDays int
+	ObjectLockMode string
 }
 
 type StorageError struct {
@@ -65,6 +229,10 @@ func (e *StorageError) Unwrap() error {
 	return e.Err
 }
 
+// ErrObjectLocked is returned, wrapped in a StorageError, when deleting an
+// object whose S3 object lock retention has not expired.
+var ErrObjectLocked = errors.New("object is locked by its retention period")
+
 var (
 	ErrNotFound         = &StorageError{Op: "storage", Err: io.EOF}
 	ErrS3ConfigRequired = &StorageError{Op: "storage", Err: io.EOF}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 )
 
 type LocalStorage struct {
@@ -76,14 +77,42 @@ func (l *LocalStorage) Delete(ctx context.Context, path string) error {
 }
 
 func (l *LocalStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+	err := l.Walk(ctx, prefix, func(f FileInfo) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].LastModified.After(files[j].LastModified)
+	})
+
+	return files, nil
+}
+
+// Walk calls fn for every file under prefix as the directory tree is read,
+// without sorting.
+func (l *LocalStorage) Walk(ctx context.Context, prefix string, fn func(FileInfo) error) error {
 	searchPath := l.fullPath(prefix)
 
-	var files []FileInfo
+	// Only walk the directory the prefix points into, so listing meta/
+	// does not stat every backup file.
+	root := l.basePath
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = l.fullPath(prefix[:i])
+	}
 
-	err := filepath.Walk(l.basePath, func(path string, info os.FileInfo, err error) error {
+	var fnErr error
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		if info.IsDir() {
 			return nil
@@ -98,28 +127,26 @@ func (l *LocalStorage) List(ctx context.Context, prefix string) ([]FileInfo, err
 			return nil
 		}
 
-		files = append(files, FileInfo{
+		fnErr = fn(FileInfo{
 			Path:         relPath,
 			Size:         info.Size(),
 			LastModified: info.ModTime(),
 			IsDir:        info.IsDir(),
 		})
-
-		return nil
+		return fnErr
 	})
 
+	if fnErr != nil {
+		return fnErr
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			return files, nil
+			return nil
 		}
-		return nil, &StorageError{Op: "list", Path: searchPath, Err: err}
+		return &StorageError{Op: "list", Path: searchPath, Err: err}
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].LastModified.After(files[j].LastModified)
-	})
-
-	return files, nil
+	return nil
 }
 
 func (l *LocalStorage) Exists(ctx context.Context, path string) (bool, error) {
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// errStoppedReading is recorded for a secondary whose Write returned before
+// the primary finished sending it the object.
+var errStoppedReading = errors.New("secondary stopped reading before the end of the object")
+
+// Mirror is a Backend that writes every object to a primary backend and
+// copies it to secondaries, e.g. local disk and S3, so an outage of one
+// does not lose the backup. The primary decides whether a write or delete
+// succeeds; secondary failures are logged as warnings. Reads, listings and
+// existence checks use the primary and fall back to the secondaries, in the
+// order they were added, when it fails.
+type Mirror struct {
+	primary     Backend
+	secondaries []mirrorSecondary
+	logger      *slog.Logger
+}
+
+type mirrorSecondary struct {
+	name    string
+	backend Backend
+}
+
+// NewMirror returns a Mirror of primary with no secondaries yet.
+func NewMirror(primary Backend, logger *slog.Logger) *Mirror {
+	return &Mirror{primary: primary, logger: logger}
+}
+
+// AddSecondary adds a backend every write is copied to. name identifies it
+// in log messages.
+func (m *Mirror) AddSecondary(name string, backend Backend) {
+	m.secondaries = append(m.secondaries, mirrorSecondary{name: name, backend: backend})
+}
+
+// Write streams reader to the primary and every secondary at once, so the
+// object is read only once. When the primary fails, partial copies on the
+// secondaries are removed.
+func (m *Mirror) Write(ctx context.Context, path string, reader io.Reader) error {
+	if len(m.secondaries) == 0 {
+		return m.primary.Write(ctx, path, reader)
+	}
+
+	tee := &teeWriter{
+		pipes:  make([]*io.PipeWriter, len(m.secondaries)),
+		failed: make([]error, len(m.secondaries)),
+	}
+	errs := make([]error, len(m.secondaries))
+
+	var wg sync.WaitGroup
+	for i, s := range m.secondaries {
+		pr, pw := io.Pipe()
+		tee.pipes[i] = pw
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.backend.Write(ctx, path, pr)
+			errs[i] = err
+			if err == nil {
+				err = errStoppedReading
+			}
+			pr.CloseWithError(err)
+		}()
+	}
+
+	err := m.primary.Write(ctx, path, io.TeeReader(reader, tee))
+	for _, pw := range tee.pipes {
+		if err != nil {
+			pw.CloseWithError(err)
+		} else {
+			pw.Close()
+		}
+	}
+	wg.Wait()
+
+	if err != nil {
+		for _, s := range m.secondaries {
+			_ = s.backend.Delete(ctx, path)
+		}
+		return err
+	}
+
+	for i, s := range m.secondaries {
+		secondaryErr := errs[i]
+		if secondaryErr == nil {
+			secondaryErr = tee.failed[i]
+		}
+		if secondaryErr != nil {
+			m.logger.Warn("failed to write to secondary storage", "secondary", s.name, "path", path, "error", secondaryErr)
+		}
+	}
+	return nil
+}
+
+// teeWriter copies writes to every pipe still being read. A pipe whose
+// secondary gave up is dropped rather than failing the primary's write.
+type teeWriter struct {
+	pipes  []*io.PipeWriter
+	failed []error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	for i, pw := range t.pipes {
+		if t.failed[i] != nil {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			t.failed[i] = err
+		}
+	}
+	return len(p), nil
+}
+
+// Read returns the object from the primary or, when that fails, from the
+// first secondary that has it. The primary's error is returned when none
+// does.
+func (m *Mirror) Read(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := m.primary.Read(ctx, path)
+	if err == nil {
+		return reader, nil
+	}
+	for _, s := range m.secondaries {
+		if reader, secondaryErr := s.backend.Read(ctx, path); secondaryErr == nil {
+			m.logger.Warn("read from secondary storage", "secondary", s.name, "path", path, "error", err)
+			return reader, nil
+		}
+	}
+	return nil, err
+}
+
+// Delete removes the object from every backend and returns the primary's
+// error.
+func (m *Mirror) Delete(ctx context.Context, path string) error {
+	err := m.primary.Delete(ctx, path)
+	for _, s := range m.secondaries {
+		if secondaryErr := s.backend.Delete(ctx, path); secondaryErr != nil && !errors.Is(secondaryErr, ErrNotFound) {
+			m.logger.Warn("failed to delete from secondary storage", "secondary", s.name, "path", path, "error", secondaryErr)
+		}
+	}
+	return err
+}
+
+// Checksum returns the checksum the primary recorded for the object, or ""
+// when the primary records none.
+func (m *Mirror) Checksum(ctx context.Context, path string) (string, error) {
+	c, ok := m.primary.(interface {
+		Checksum(ctx context.Context, path string) (string, error)
+	})
+	if !ok {
+		return "", nil
+	}
+	return c.Checksum(ctx, path)
+}
+
+func (m *Mirror) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	return fallback(m, "list", prefix, func(b Backend) ([]FileInfo, error) {
+		return b.List(ctx, prefix)
+	})
+}
+
+func (m *Mirror) Exists(ctx context.Context, path string) (bool, error) {
+	return fallback(m, "exists", path, func(b Backend) (bool, error) {
+		return b.Exists(ctx, path)
+	})
+}
+
+func (m *Mirror) Size(ctx context.Context, path string) (int64, error) {
+	return fallback(m, "size", path, func(b Backend) (int64, error) {
+		return b.Size(ctx, path)
+	})
+}
+
+// Walk walks the primary, falling back to a secondary only when the
+// primary fails before reporting any file, so fn never sees a file twice.
+func (m *Mirror) Walk(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	called := false
+	err := Walk(ctx, m.primary, prefix, func(f FileInfo) error {
+		called = true
+		return fn(f)
+	})
+	if err == nil || called || errors.Is(err, ErrStopWalk) {
+		return err
+	}
+	for _, s := range m.secondaries {
+		called = false
+		secondaryErr := Walk(ctx, s.backend, prefix, func(f FileInfo) error {
+			called = true
+			return fn(f)
+		})
+		if secondaryErr == nil || called {
+			m.logger.Warn("listed secondary storage", "secondary", s.name, "prefix", prefix, "error", err)
+			return secondaryErr
+		}
+	}
+	return err
+}
+
+// fallback calls op on the primary and, when it fails, on each secondary in
+// turn, returning the first success or the primary's error.
+func fallback[T any](m *Mirror, opName, path string, op func(Backend) (T, error)) (T, error) {
+	result, err := op(m.primary)
+	if err == nil {
+		return result, nil
+	}
+	for _, s := range m.secondaries {
+		if result, secondaryErr := op(s.backend); secondaryErr == nil {
+			m.logger.Warn("used secondary storage", "op", opName, "secondary", s.name, "path", path, "error", err)
+			return result, nil
+		}
+	}
+	return result, err
+}
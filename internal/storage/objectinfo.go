@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+)
+
+// ObjectInfo describes the backup an object belongs to. Backends that can
+// keep it with the object, such as S3 as user metadata, do so on Write, so
+// bucket tooling and lifecycle rules can select objects by it.
+type ObjectInfo struct {
+	BackupID string
+	Database string
+	Created  time.Time
+}
+
+type objectInfoKey struct{}
+
+// WithObjectInfo returns a context whose writes are tagged with info.
+func WithObjectInfo(ctx context.Context, info ObjectInfo) context.Context {
+	return context.WithValue(ctx, objectInfoKey{}, info)
+}
+
+// ObjectInfoFromContext returns the ObjectInfo set by WithObjectInfo.
+func ObjectInfoFromContext(ctx context.Context) (ObjectInfo, bool) {
+	info, ok := ctx.Value(objectInfoKey{}).(ObjectInfo)
+	return info, ok
+}
+
+type noRetentionKey struct{}
+
+// WithoutRetention returns a context whose writes are not placed under
+// object lock retention, for objects that are rewritten and deleted while
+// datasaver runs, such as the daemon's instance lock.
+func WithoutRetention(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetentionKey{}, true)
+}
+
+// RetentionSkipped reports whether ctx was returned by WithoutRetention.
+func RetentionSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(noRetentionKey{}).(bool)
+	return skip
+}
+
+// contentTypes maps the last extension of a stored file to its MIME type.
+var contentTypes = map[string]string{
+	".json": "application/json",
+	".gz":   "application/gzip",
+	".zst":  "application/zstd",
+	".sql":  "application/sql",
+	".tar":  "application/x-tar",
+	".db":   "application/vnd.sqlite3",
+}
+
+// ContentType returns the MIME type of the object at p by its last
+// extension: application/gzip for a gzipped dump, application/json for
+// metadata, and application/octet-stream for encrypted files, custom-format
+// dumps and anything else.
+func ContentType(p string) string {
+	if t, ok := contentTypes[strings.ToLower(path.Ext(p))]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
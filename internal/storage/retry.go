@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const defaultS3MaxAttempts = 3
+
+// retryPolicy controls how S3Storage retries transient failures such as
+// throttling (503 SlowDown) or dropped connections.
+type retryPolicy struct {
+	maxAttempts int
+	initialWait time.Duration
+	maxWait     time.Duration
+}
+
+func newRetryPolicy(maxAttempts int) retryPolicy {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultS3MaxAttempts
+	}
+	return retryPolicy{
+		maxAttempts: maxAttempts,
+		initialWait: 1 * time.Second,
+		maxWait:     30 * time.Second,
+	}
+}
+
+func withRetry[T any](ctx context.Context, p retryPolicy, fn func() (T, error)) (T, error) {
+	var lastErr error
+	var zero T
+	wait := p.initialWait
+
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		result, err := fn()
+		if err == nil {
+			return result, nil
+		}
+
+		lastErr = err
+
+		if !isRetryableS3(err) {
+			return zero, err
+		}
+
+		if attempt < p.maxAttempts {
+			select {
+			case <-ctx.Done():
+				return zero, ctx.Err()
+			case <-time.After(wait):
+			}
+
+			wait *= 2
+			if wait > p.maxWait {
+				wait = p.maxWait
+			}
+		}
+	}
+
+	return zero, lastErr
+}
+
+// isRetryableS3 reports whether err is worth retrying. Missing objects and
+// credential problems won't fix themselves; throttling, server errors and
+// network failures usually do.
+func isRetryableS3(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "NoSuchKey", "NoSuchBucket", "AccessDenied", "InvalidAccessKeyId",
+		"SignatureDoesNotMatch", "InvalidBucketName", "AllAccessDisabled":
+		return false
+	}
+
+	if resp.StatusCode != 0 {
+		return resp.StatusCode >= http.StatusInternalServerError ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusRequestTimeout
+	}
+
+	// Not an S3 response at all: connection resets, timeouts, DNS hiccups.
+	return true
+}
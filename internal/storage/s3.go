@@ -3,18 +3,38 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+// checksumMetaKey is the user metadata key holding an object's SHA-256.
+const checksumMetaKey = "Sha256"
+
+// User metadata keys describing the backup an object belongs to, set from
+// the ObjectInfo in the context of Write.
+const (
+	backupIDMetaKey = "Backup-Id"
+	databaseMetaKey = "Db-Name"
+	createdMetaKey  = "Created-At"
+)
+
 type S3Storage struct {
 	client *minio.Client
 	bucket string
+	retry  retryPolicy
+
+	// lockMode and lockDays set S3 object lock retention on every object
+	// written; lockDays is 0 when locking is off.
+	lockMode minio.RetentionMode
+	lockDays int
 }
 
 func NewS3Storage(cfg S3Config) (*S3Storage, error) {
@@ -30,14 +50,25 @@ func NewS3Storage(cfg S3Config) (*S3Storage, error) {
 		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
 		Secure: cfg.UseSSL,
 		Region: cfg.Region,
+		// Retries are handled per operation with our own backoff, so
+		// MaxAttempts means what it says.
+		MaxRetries: 1,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create S3 client: %w", err)
 	}
 
+	lockMode := minio.Governance
+	if strings.EqualFold(cfg.ObjectLockMode, string(minio.Compliance)) {
+		lockMode = minio.Compliance
+	}
+
 	return &S3Storage{
-		client: client,
-		bucket: cfg.Bucket,
+		client:   client,
+		bucket:   cfg.Bucket,
+		retry:    newRetryPolicy(cfg.MaxAttempts),
+		lockMode: lockMode,
+		lockDays: cfg.ObjectLockDays,
 	}, nil
 }
 
@@ -47,21 +78,91 @@ func (s *S3Storage) Write(ctx context.Context, path string, reader io.Reader) er
 		return &StorageError{Op: "write", Path: path, Err: err}
 	}
 
-	_, err = s.client.PutObject(ctx, s.bucket, path, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	sum := sha256.Sum256(data)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	// Content-MD5 makes S3 reject a body corrupted in transit; the SHA-256
+	// is kept as user metadata so integrity can be checked without a download.
+	opts := minio.PutObjectOptions{
+		SendContentMd5: true,
+		ContentType:    ContentType(path),
+		UserMetadata:   objectMetadata(ctx, checksum),
+	}
+	if s.lockDays > 0 && !RetentionSkipped(ctx) {
+		opts.Mode = s.lockMode
+		opts.RetainUntilDate = time.Now().UTC().AddDate(0, 0, s.lockDays)
+	}
+	_, err = withRetry(ctx, s.retry, func() (minio.UploadInfo, error) {
+		return s.client.PutObject(ctx, s.bucket, path, bytes.NewReader(data), int64(len(data)), opts)
+	})
 	if err != nil {
 		return &StorageError{Op: "write", Path: path, Err: err}
 	}
 
+	info, err := s.stat(ctx, path)
+	if err != nil {
+		return &StorageError{Op: "write", Path: path, Err: fmt.Errorf("failed to stat uploaded object: %w", err)}
+	}
+	if info.Size != int64(len(data)) {
+		return &StorageError{Op: "write", Path: path, Err: fmt.Errorf("stored object is %d bytes, uploaded %d", info.Size, len(data))}
+	}
+	if stored := info.UserMetadata[checksumMetaKey]; stored != "" && stored != checksum {
+		return &StorageError{Op: "write", Path: path, Err: fmt.Errorf("stored checksum %s does not match uploaded %s", stored, checksum)}
+	}
+
 	return nil
 }
 
-func (s *S3Storage) Read(ctx context.Context, path string) (io.ReadCloser, error) {
-	obj, err := s.client.GetObject(ctx, s.bucket, path, minio.GetObjectOptions{})
+// objectMetadata returns the user metadata for an object with checksum,
+// including the backup it belongs to when ctx carries an ObjectInfo.
+func objectMetadata(ctx context.Context, checksum string) map[string]string {
+	meta := map[string]string{checksumMetaKey: checksum}
+	info, ok := ObjectInfoFromContext(ctx)
+	if !ok {
+		return meta
+	}
+	if info.BackupID != "" {
+		meta[backupIDMetaKey] = info.BackupID
+	}
+	if info.Database != "" {
+		meta[databaseMetaKey] = info.Database
+	}
+	if !info.Created.IsZero() {
+		meta[createdMetaKey] = info.Created.UTC().Format(time.RFC3339)
+	}
+	return meta
+}
+
+// Checksum returns the SHA-256 recorded when the object was written, in the
+// same "sha256:<hex>" form as backup metadata, or "" for objects written
+// before checksums were recorded.
+func (s *S3Storage) Checksum(ctx context.Context, path string) (string, error) {
+	info, err := s.stat(ctx, path)
 	if err != nil {
-		return nil, &StorageError{Op: "read", Path: path, Err: err}
+		errResp := minio.ToErrorResponse(err)
+		if errResp.Code == "NoSuchKey" {
+			return "", ErrNotFound
+		}
+		return "", &StorageError{Op: "checksum", Path: path, Err: err}
 	}
 
-	_, err = obj.Stat()
+	return info.UserMetadata[checksumMetaKey], nil
+}
+
+func (s *S3Storage) Read(ctx context.Context, path string) (io.ReadCloser, error) {
+	obj, err := withRetry(ctx, s.retry, func() (*minio.Object, error) {
+		obj, err := s.client.GetObject(ctx, s.bucket, path, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		// GetObject is lazy; Stat issues the request.
+		if _, err := obj.Stat(); err != nil {
+			obj.Close()
+			return nil, err
+		}
+		return obj, nil
+	})
 	if err != nil {
 		errResp := minio.ToErrorResponse(err)
 		if errResp.Code == "NoSuchKey" {
@@ -73,45 +174,138 @@ func (s *S3Storage) Read(ctx context.Context, path string) (io.ReadCloser, error
 	return obj, nil
 }
 
+// Delete removes the object. With object lock on, it removes the current
+// version rather than hiding it behind a delete marker, and returns
+// ErrObjectLocked while that version's retention has not expired.
 func (s *S3Storage) Delete(ctx context.Context, path string) error {
-	err := s.client.RemoveObject(ctx, s.bucket, path, minio.RemoveObjectOptions{})
+	var opts minio.RemoveObjectOptions
+	if s.lockDays > 0 {
+		info, err := s.stat(ctx, path)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil
+			}
+			return &StorageError{Op: "delete", Path: path, Err: err}
+		}
+		until, err := withRetry(ctx, s.retry, func() (*time.Time, error) {
+			_, until, err := s.client.GetObjectRetention(ctx, s.bucket, path, info.VersionID)
+			return until, err
+		})
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchObjectLockConfiguration" {
+			return &StorageError{Op: "delete", Path: path, Err: fmt.Errorf("failed to read retention: %w", err)}
+		}
+		if until != nil && until.After(time.Now()) {
+			return &StorageError{Op: "delete", Path: path, Err: fmt.Errorf("%w until %s", ErrObjectLocked, until.UTC().Format(time.RFC3339))}
+		}
+		opts.VersionID = info.VersionID
+	}
+
+	_, err := withRetry(ctx, s.retry, func() (struct{}, error) {
+		return struct{}{}, s.client.RemoveObject(ctx, s.bucket, path, opts)
+	})
 	if err != nil {
+		if isObjectLockDenial(err) {
+			err = ErrObjectLocked
+		}
 		return &StorageError{Op: "delete", Path: path, Err: err}
 	}
 
 	return nil
 }
 
+// isObjectLockDenial reports whether S3 refused a delete because of object
+// lock retention or a legal hold. S3 and MinIO word the AccessDenied
+// message differently.
+func isObjectLockDenial(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code != "AccessDenied" {
+		return false
+	}
+	msg := strings.ToLower(resp.Message)
+	return strings.Contains(msg, "object lock") || strings.Contains(msg, "worm")
+}
+
 func (s *S3Storage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
 	var files []FileInfo
+	err := s.Walk(ctx, prefix, func(f FileInfo) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].LastModified.After(files[j].LastModified)
+	})
+
+	return files, nil
+}
+
+// Walk calls fn for every object under prefix as the listing pages arrive.
+// S3 lists keys in order, so a retry after a transient failure resumes
+// after the last key fn saw instead of repeating it.
+func (s *S3Storage) Walk(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	var last string
+	var fnErr error
+	_, err := withRetry(ctx, s.retry, func() (struct{}, error) {
+		err := s.walk(ctx, prefix, last, func(f FileInfo) error {
+			if fnErr = fn(f); fnErr != nil {
+				return fnErr
+			}
+			last = f.Path
+			return nil
+		})
+		if fnErr != nil {
+			// Not a listing failure, so not retried.
+			return struct{}{}, nil
+		}
+		return struct{}{}, err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return &StorageError{Op: "list", Path: prefix, Err: err}
+	}
+
+	return nil
+}
+
+// walk makes a single pass over the bucket listing, starting after the key
+// startAfter when it is set.
+func (s *S3Storage) walk(ctx context.Context, prefix, startAfter string, fn func(FileInfo) error) error {
+	// Cancelling stops the listing goroutine if we bail out early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	objectCh := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
+		Prefix:     prefix,
+		Recursive:  true,
+		StartAfter: startAfter,
 	})
 
 	for object := range objectCh {
 		if object.Err != nil {
-			return nil, &StorageError{Op: "list", Path: prefix, Err: object.Err}
+			return object.Err
 		}
 
-		files = append(files, FileInfo{
+		err := fn(FileInfo{
 			Path:         object.Key,
 			Size:         object.Size,
 			LastModified: object.LastModified,
 			IsDir:        false,
 		})
+		if err != nil {
+			return err
+		}
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].LastModified.After(files[j].LastModified)
-	})
-
-	return files, nil
+	return nil
 }
 
 func (s *S3Storage) Exists(ctx context.Context, path string) (bool, error) {
-	_, err := s.client.StatObject(ctx, s.bucket, path, minio.StatObjectOptions{})
+	_, err := s.stat(ctx, path)
 	if err != nil {
 		errResp := minio.ToErrorResponse(err)
 		if errResp.Code == "NoSuchKey" {
@@ -124,7 +318,7 @@ func (s *S3Storage) Exists(ctx context.Context, path string) (bool, error) {
 }
 
 func (s *S3Storage) Size(ctx context.Context, path string) (int64, error) {
-	info, err := s.client.StatObject(ctx, s.bucket, path, minio.StatObjectOptions{})
+	info, err := s.stat(ctx, path)
 	if err != nil {
 		errResp := minio.ToErrorResponse(err)
 		if errResp.Code == "NoSuchKey" {
@@ -135,3 +329,9 @@ func (s *S3Storage) Size(ctx context.Context, path string) (int64, error) {
 
 	return info.Size, nil
 }
+
+func (s *S3Storage) stat(ctx context.Context, path string) (minio.ObjectInfo, error) {
+	return withRetry(ctx, s.retry, func() (minio.ObjectInfo, error) {
+		return s.client.StatObject(ctx, s.bucket, path, minio.StatObjectOptions{})
+	})
+}
@@ -3,12 +3,21 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/minio/minio-go/v7"
 )
 
 func TestNewFactory(t *testing.T) {
@@ -87,6 +96,55 @@ func TestFactory_Create(t *testing.T) {
 	}
 }
 
+func TestFactory_Register(t *testing.T) {
+	var gotPath string
+	var gotRaw map[string]any
+	custom := func(path string, raw map[string]any) (Backend, error) {
+		gotPath, gotRaw = path, raw
+		return NewLocalStorage(t.TempDir())
+	}
+
+	f := NewFactory()
+	f.Register("custom", custom)
+
+	backend, err := f.Open("custom", "bucket/prefix", map[string]any{"region": "eu"})
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if backend == nil || gotPath != "bucket/prefix" || gotRaw["region"] != "eu" {
+		t.Errorf("constructor got path %q raw %v", gotPath, gotRaw)
+	}
+
+	if _, err := NewFactory().Open("custom", "", nil); !errors.Is(err, ErrUnknownBackend) {
+		t.Errorf("Factory.Register leaked to a new factory: error = %v", err)
+	}
+
+	Register("custom-global", custom)
+	if _, err := NewFactory().Create("custom-global", "p", nil); err != nil {
+		t.Errorf("Create() after package Register error: %v", err)
+	}
+}
+
+func TestFactory_Open_S3FromRaw(t *testing.T) {
+	backend, err := NewFactory().Open("s3", "", map[string]any{
+		"bucket":       "test-bucket",
+		"endpoint":     "localhost:9000",
+		"access_key":   "access",
+		"secret_key":   "secret",
+		"max_attempts": 5,
+	})
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	s3, ok := backend.(*S3Storage)
+	if !ok {
+		t.Fatalf("Open() = %T, want *S3Storage", backend)
+	}
+	if s3.bucket != "test-bucket" || s3.retry.maxAttempts != 5 {
+		t.Errorf("bucket = %q, maxAttempts = %d", s3.bucket, s3.retry.maxAttempts)
+	}
+}
+
 func TestStorageError(t *testing.T) {
 	err := &StorageError{
 		Op:   "write",
@@ -530,6 +588,71 @@ func TestLocalStorage_List(t *testing.T) {
 	}
 }
 
+func TestLocate(t *testing.T) {
+	storage, _ := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	for _, path := range []string{"root.meta.json", "prod/app/2024/01/nested.meta.json", "prod/app/2024/01/not-nested.meta.json"} {
+		if err := storage.Write(ctx, path, strings.NewReader("{}")); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"root.meta.json", "root.meta.json"},
+		{"nested.meta.json", "prod/app/2024/01/nested.meta.json"},
+	}
+	for _, tt := range tests {
+		got, err := Locate(ctx, storage, tt.name)
+		if err != nil {
+			t.Errorf("Locate(%q) error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Locate(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Locate(ctx, storage, "missing.meta.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Locate() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalStorage_Walk(t *testing.T) {
+	storage, _ := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	for _, path := range []string{"a.dump", "meta/a.meta.json", "meta/b.meta.json", "metadata.txt"} {
+		if err := storage.Write(ctx, path, strings.NewReader("x")); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	var seen []string
+	err := Walk(ctx, storage, "meta/", func(f FileInfo) error {
+		seen = append(seen, f.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("Walk() visited %v, want the two files under meta/", seen)
+	}
+
+	calls := 0
+	err = Walk(ctx, storage, "", func(FileInfo) error {
+		calls++
+		return ErrStopWalk
+	})
+	if !errors.Is(err, ErrStopWalk) || calls != 1 {
+		t.Errorf("Walk() = %v after %d calls, want ErrStopWalk after 1", err, calls)
+	}
+}
+
 func TestLocalStorage_WriteReadRoundTrip(t *testing.T) {
 	tmpDir := t.TempDir()
 	storage, _ := NewLocalStorage(tmpDir)
@@ -707,3 +830,663 @@ func TestFileInfo(t *testing.T) {
 		t.Error("IsDir = true, want false")
 	}
 }
+
+// newFakeS3 starts a server that answers HEAD requests with the given status
+// codes in turn (the last one repeating) and returns a client for it along
+// with the request counter.
+func newFakeS3(t *testing.T, maxAttempts int, statuses ...int) (*S3Storage, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status == http.StatusOK {
+			w.Header().Set("Content-Length", "42")
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"abc"`)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(S3Config{
+		Bucket:      "test-bucket",
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		AccessKey:   "access",
+		SecretKey:   "secret",
+		MaxAttempts: maxAttempts,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage() error: %v", err)
+	}
+	store.retry.initialWait = time.Millisecond
+
+	return store, &requests
+}
+
+func TestS3Storage_RetriesTransientErrors(t *testing.T) {
+	store, requests := newFakeS3(t, 3, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+
+	size, err := store.Size(context.Background(), "backup.dump")
+	if err != nil {
+		t.Fatalf("Size() error: %v", err)
+	}
+	if size != 42 {
+		t.Errorf("Size() = %d, want 42", size)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
+
+func TestS3Storage_GivesUpAfterMaxAttempts(t *testing.T) {
+	store, requests := newFakeS3(t, 2, http.StatusServiceUnavailable)
+
+	if _, err := store.Size(context.Background(), "backup.dump"); err == nil {
+		t.Fatal("Size() should fail when every attempt is throttled")
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestS3Storage_DoesNotRetryPermanentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"not found", http.StatusNotFound},
+		{"access denied", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, requests := newFakeS3(t, 5, tt.status)
+
+			_, _ = store.Exists(context.Background(), "backup.dump")
+			if got := atomic.LoadInt32(requests); got != 1 {
+				t.Errorf("requests = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestIsRetryableS3(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"slow down", minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, true},
+		{"throttled", minio.ErrorResponse{StatusCode: http.StatusTooManyRequests}, true},
+		{"no such key", minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, false},
+		{"access denied", minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+		{"bad request", minio.ErrorResponse{Code: "InvalidArgument", StatusCode: http.StatusBadRequest}, false},
+		{"connection reset", errors.New("read tcp: connection reset by peer"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableS3(tt.err); got != tt.want {
+				t.Errorf("isRetryableS3() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newFakeS3Objects starts a server that accepts PUTs and answers HEADs for
+// the last uploaded object, shrinking its reported size by truncate bytes to
+// simulate a corrupted upload. It returns the headers of the last PUT.
+func newFakeS3Objects(t *testing.T, truncate int64) (*S3Storage, *http.Header) {
+	t.Helper()
+
+	var put http.Header
+	var size int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			put = r.Header.Clone()
+			size = r.ContentLength
+			if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+				size, _ = strconv.ParseInt(decoded, 10, 64)
+			}
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.FormatInt(size-truncate, 10))
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("X-Amz-Meta-Sha256", put.Get("X-Amz-Meta-Sha256"))
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(S3Config{
+		Bucket:    "test-bucket",
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage() error: %v", err)
+	}
+
+	return store, &put
+}
+
+func TestS3Storage_Write_VerifiesUpload(t *testing.T) {
+	store, put := newFakeS3Objects(t, 0)
+	ctx := context.Background()
+
+	if err := store.Write(ctx, "backup.dump", strings.NewReader("backup data")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	if put.Get("Content-Md5") == "" {
+		t.Error("Write() should send Content-MD5")
+	}
+	sum, err := store.Checksum(ctx, "backup.dump")
+	if err != nil {
+		t.Fatalf("Checksum() error: %v", err)
+	}
+	want := "sha256:d9c38b4a49e99d9a64a34bfec2d42ee152283003487e13460a1d6de6fb853473"
+	if sum != want {
+		t.Errorf("Checksum() = %q, want %q", sum, want)
+	}
+}
+
+func TestS3Storage_Write_SizeMismatch(t *testing.T) {
+	store, _ := newFakeS3Objects(t, 1)
+
+	err := store.Write(context.Background(), "backup.dump", strings.NewReader("backup data"))
+	if err == nil {
+		t.Fatal("Write() should fail when the stored object is truncated")
+	}
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) || storageErr.Op != "write" {
+		t.Errorf("Write() error = %v, want a write StorageError", err)
+	}
+}
+
+func TestS3Storage_Write_ObjectLock(t *testing.T) {
+	store, put := newFakeS3Objects(t, 0)
+	store.lockMode = minio.Compliance
+	store.lockDays = 30
+
+	if err := store.Write(context.Background(), "backup.dump", strings.NewReader("backup data")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	if got := put.Get("X-Amz-Object-Lock-Mode"); got != "COMPLIANCE" {
+		t.Errorf("X-Amz-Object-Lock-Mode = %q, want COMPLIANCE", got)
+	}
+	until, err := time.Parse(time.RFC3339, put.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil {
+		t.Fatalf("X-Amz-Object-Lock-Retain-Until-Date: %v", err)
+	}
+	if want := time.Now().AddDate(0, 0, 30); until.Before(want.Add(-time.Minute)) || until.After(want.Add(time.Minute)) {
+		t.Errorf("retain until = %v, want about %v", until, want)
+	}
+
+	// Objects rewritten and deleted while running are not retained.
+	if err := store.Write(WithoutRetention(context.Background()), "lock", strings.NewReader("lease")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if got := put.Get("X-Amz-Object-Lock-Mode"); got != "" {
+		t.Errorf("X-Amz-Object-Lock-Mode = %q with WithoutRetention, want none", got)
+	}
+}
+
+func TestS3Storage_Write_ObjectMetadata(t *testing.T) {
+	store, put := newFakeS3Objects(t, 0)
+	created := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	ctx := WithObjectInfo(context.Background(), ObjectInfo{
+		BackupID: "backup_20240115_020000",
+		Database: "orders",
+		Created:  created,
+	})
+
+	if err := store.Write(ctx, "backup_20240115_020000.dump.gz", strings.NewReader("backup data")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	for header, want := range map[string]string{
+		"Content-Type":          "application/gzip",
+		"X-Amz-Meta-Backup-Id":  "backup_20240115_020000",
+		"X-Amz-Meta-Db-Name":    "orders",
+		"X-Amz-Meta-Created-At": "2024-01-15T02:00:00Z",
+	} {
+		if got := put.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// Without an ObjectInfo only the checksum is attached.
+	if err := store.Write(context.Background(), "backup.meta.json", strings.NewReader("{}")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if got := put.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := put.Get("X-Amz-Meta-Backup-Id"); got != "" {
+		t.Errorf("X-Amz-Meta-Backup-Id = %q, want none", got)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		"backup_20240115_020000.dump.gz":     "application/gzip",
+		"backup_20240115_020000.sql.zst":     "application/zstd",
+		"backup_20240115_020000.meta.json":   "application/json",
+		"backup_20240115_020000.DB":          "application/vnd.sqlite3",
+		"backup_20240115_020000.dump.gz.enc": "application/octet-stream",
+		"backup_20240115_020000.dump":        "application/octet-stream",
+		"wal/000000010000000000000001":       "application/octet-stream",
+	}
+	for path, want := range tests {
+		if got := ContentType(path); got != want {
+			t.Errorf("ContentType(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// newFakeS3Locked starts a server holding one object, version v1, retained
+// until until. It returns the version ID deleted, if any.
+func newFakeS3Locked(t *testing.T, until time.Time) (*S3Storage, *string) {
+	t.Helper()
+
+	var deleted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "42")
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("X-Amz-Version-Id", "v1")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Query().Has("retention"):
+			fmt.Fprintf(w, `<Retention><Mode>GOVERNANCE</Mode><RetainUntilDate>%s</RetainUntilDate></Retention>`, until.UTC().Format(time.RFC3339))
+		case r.Method == http.MethodDelete:
+			deleted = r.URL.Query().Get("versionId")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(S3Config{
+		Bucket:         "test-bucket",
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		AccessKey:      "access",
+		SecretKey:      "secret",
+		ObjectLockDays: 30,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage() error: %v", err)
+	}
+
+	return store, &deleted
+}
+
+func TestS3Storage_Delete_ObjectLock(t *testing.T) {
+	store, deleted := newFakeS3Locked(t, time.Now().Add(24*time.Hour))
+	err := store.Delete(context.Background(), "backup.dump")
+	if !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Delete() of a locked object error = %v, want ErrObjectLocked", err)
+	}
+	if *deleted != "" {
+		t.Error("Delete() sent a DELETE for a locked object")
+	}
+
+	store, deleted = newFakeS3Locked(t, time.Now().Add(-time.Hour))
+	if err := store.Delete(context.Background(), "backup.dump"); err != nil {
+		t.Fatalf("Delete() after retention expired error = %v", err)
+	}
+	if *deleted != "v1" {
+		t.Errorf("Delete() removed version %q, want v1 rather than adding a delete marker", *deleted)
+	}
+}
+
+// newFakeS3Listing starts a server that lists keys a and b on its first
+// page, fails the request for the next page with 503, and lists c to a
+// request that starts after b.
+func newFakeS3Listing(t *testing.T) *S3Storage {
+	t.Helper()
+
+	object := func(key string) string {
+		return "<Contents><Key>" + key + "</Key><LastModified>2024-01-01T00:00:00.000Z</LastModified>" +
+			`<ETag>"abc"</ETag><Size>1</Size><StorageClass>STANDARD</StorageClass></Contents>`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("continuation-token") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body := `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>test-bucket</Name>`
+		if query.Get("start-after") == "b" {
+			body += "<IsTruncated>false</IsTruncated>" + object("c")
+		} else {
+			body += "<IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken>" + object("a") + object("b")
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, body+"</ListBucketResult>")
+	}))
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(S3Config{
+		Bucket:    "test-bucket",
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage() error: %v", err)
+	}
+	store.retry.initialWait = time.Millisecond
+
+	return store
+}
+
+func TestS3Storage_Walk_ResumesAfterTransientError(t *testing.T) {
+	store := newFakeS3Listing(t)
+
+	var seen []string
+	err := store.Walk(context.Background(), "", func(f FileInfo) error {
+		seen = append(seen, f.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+	if strings.Join(seen, ",") != "a,b,c" {
+		t.Errorf("Walk() visited %v, want each of a, b and c once", seen)
+	}
+}
+
+func TestS3Storage_Walk_CallbackErrorNotRetried(t *testing.T) {
+	store := newFakeS3Listing(t)
+	errCallback := errors.New("callback failed")
+
+	calls := 0
+	err := store.Walk(context.Background(), "", func(FileInfo) error {
+		calls++
+		return errCallback
+	})
+	if !errors.Is(err, errCallback) {
+		t.Errorf("Walk() error = %v, want the callback's error", err)
+	}
+	if calls != 1 {
+		t.Errorf("callback called %d times, want 1", calls)
+	}
+}
+
+func TestIsObjectLockDenial(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"aws", minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied because object protected by object lock."}, true},
+		{"minio", minio.ErrorResponse{Code: "AccessDenied", Message: "Object is WORM protected and cannot be overwritten"}, true},
+		{"plain access denied", minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied"}, false},
+		{"other error", errors.New("connection reset"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isObjectLockDenial(tt.err); got != tt.want {
+				t.Errorf("isObjectLockDenial() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// brokenBackend fails every operation, like a bucket that is down.
+type brokenBackend struct{}
+
+var errBroken = errors.New("backend unavailable")
+
+func (brokenBackend) Write(ctx context.Context, path string, reader io.Reader) error {
+	return errBroken
+}
+func (brokenBackend) Read(ctx context.Context, path string) (io.ReadCloser, error) {
+	return nil, errBroken
+}
+func (brokenBackend) Delete(ctx context.Context, path string) error { return errBroken }
+func (brokenBackend) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	return nil, errBroken
+}
+func (brokenBackend) Exists(ctx context.Context, path string) (bool, error) { return false, errBroken }
+func (brokenBackend) Size(ctx context.Context, path string) (int64, error)  { return 0, errBroken }
+
+func newTestMirror(t *testing.T, primary Backend, secondaries ...Backend) *Mirror {
+	t.Helper()
+	m := NewMirror(primary, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for i, s := range secondaries {
+		m.AddSecondary(fmt.Sprintf("secondary-%d", i), s)
+	}
+	return m
+}
+
+func newTestLocal(t *testing.T) *LocalStorage {
+	t.Helper()
+	local, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error = %v", err)
+	}
+	return local
+}
+
+func readAll(t *testing.T, b Backend, path string) string {
+	t.Helper()
+	reader, err := b.Read(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll(%s) error = %v", path, err)
+	}
+	return string(data)
+}
+
+func TestMirror_Write(t *testing.T) {
+	ctx := context.Background()
+	primary, first, second := newTestLocal(t), newTestLocal(t), newTestLocal(t)
+	m := newTestMirror(t, primary, first, second)
+
+	content := strings.Repeat("backup data ", 10000)
+	if err := m.Write(ctx, "dir/backup.dump", strings.NewReader(content)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for i, b := range []Backend{primary, first, second} {
+		if got := readAll(t, b, "dir/backup.dump"); got != content {
+			t.Errorf("backend %d holds %d bytes, want %d", i, len(got), len(content))
+		}
+	}
+
+	if err := m.Delete(ctx, "dir/backup.dump"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	for i, b := range []Backend{primary, first, second} {
+		if exists, _ := b.Exists(ctx, "dir/backup.dump"); exists {
+			t.Errorf("backend %d still holds the object after Delete()", i)
+		}
+	}
+}
+
+func TestMirror_Write_SecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+	primary, other := newTestLocal(t), newTestLocal(t)
+	m := newTestMirror(t, primary, brokenBackend{}, other)
+
+	if err := m.Write(ctx, "backup.dump", strings.NewReader("data")); err != nil {
+		t.Fatalf("Write() error = %v, want a secondary failure to be ignored", err)
+	}
+	if got := readAll(t, primary, "backup.dump"); got != "data" {
+		t.Errorf("primary holds %q, want %q", got, "data")
+	}
+	if got := readAll(t, other, "backup.dump"); got != "data" {
+		t.Errorf("working secondary holds %q, want %q", got, "data")
+	}
+}
+
+func TestMirror_Write_PrimaryFailure(t *testing.T) {
+	ctx := context.Background()
+	secondary := newTestLocal(t)
+	m := newTestMirror(t, brokenBackend{}, secondary)
+
+	if err := m.Write(ctx, "backup.dump", strings.NewReader("data")); !errors.Is(err, errBroken) {
+		t.Fatalf("Write() error = %v, want the primary's error", err)
+	}
+	if exists, _ := secondary.Exists(ctx, "backup.dump"); exists {
+		t.Error("secondary kept a copy of a write the primary failed")
+	}
+}
+
+func TestMirror_ReadFallback(t *testing.T) {
+	ctx := context.Background()
+	secondary := newTestLocal(t)
+	if err := secondary.Write(ctx, "meta/backup.meta.json", strings.NewReader("{}")); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMirror(t, brokenBackend{}, secondary)
+
+	if got := readAll(t, m, "meta/backup.meta.json"); got != "{}" {
+		t.Errorf("Read() = %q, want the secondary's copy", got)
+	}
+	exists, err := m.Exists(ctx, "meta/backup.meta.json")
+	if err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true from the secondary", exists, err)
+	}
+	files, err := m.List(ctx, "meta/")
+	if err != nil || len(files) != 1 {
+		t.Errorf("List() = %v, %v, want the secondary's listing", files, err)
+	}
+
+	var walked []string
+	err = Walk(ctx, m, "meta/", func(f FileInfo) error {
+		walked = append(walked, f.Path)
+		return nil
+	})
+	if err != nil || len(walked) != 1 {
+		t.Errorf("Walk() = %v, %v, want the secondary's listing", walked, err)
+	}
+
+	if _, err := m.Read(ctx, "missing"); !errors.Is(err, errBroken) {
+		t.Errorf("Read() of a missing object error = %v, want the primary's error", err)
+	}
+}
+
+func TestCache_PutFetch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(t.TempDir(), "backup.dump.gz")
+	if err := os.WriteFile(src, []byte("backup data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := fileChecksum(src, "sha256:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(dir, 0, 0)
+	if err := cache.Put("prod/backup.dump.gz", src); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "restore.dump.gz")
+	ok, err := cache.Fetch("prod/backup.dump.gz", checksum, dst)
+	if err != nil || !ok {
+		t.Fatalf("Fetch() = %v, %v, want a hit", ok, err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "backup data" {
+		t.Errorf("fetched %q, want %q", data, "backup data")
+	}
+
+	if ok, _ := cache.Fetch("prod/other.dump.gz", checksum, filepath.Join(t.TempDir(), "x")); ok {
+		t.Error("Fetch() of an uncached file should miss")
+	}
+	if ok, _ := cache.Fetch("prod/backup.dump.gz", "", filepath.Join(t.TempDir(), "x")); ok {
+		t.Error("Fetch() without a checksum should miss")
+	}
+
+	ok, err = cache.Fetch("prod/backup.dump.gz", "sha256:0000", filepath.Join(t.TempDir(), "x"))
+	if ok || err == nil {
+		t.Errorf("Fetch() with a wrong checksum = %v, %v, want a miss with an error", ok, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prod", "backup.dump.gz")); !os.IsNotExist(err) {
+		t.Error("a cached copy with the wrong checksum should be removed")
+	}
+
+	var none *Cache
+	if err := none.Put("backup.dump.gz", src); err != nil {
+		t.Errorf("nil Cache Put() error = %v", err)
+	}
+	if ok, err := none.Fetch("backup.dump.gz", checksum, dst); ok || err != nil {
+		t.Errorf("nil Cache Fetch() = %v, %v, want a miss", ok, err)
+	}
+}
+
+func TestCache_Evict(t *testing.T) {
+	put := func(cache *Cache, name string, size int, age time.Duration) {
+		t.Helper()
+		src := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(src, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(src, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Put(name, src); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+	}
+	cached := func(dir string) []string {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	dir := t.TempDir()
+	byCount := NewCache(dir, 2, 0)
+	put(byCount, "old", 10, 3*time.Hour)
+	put(byCount, "mid", 10, 2*time.Hour)
+	put(byCount, "new", 10, time.Hour)
+	if got := strings.Join(cached(dir), ","); got != "mid,new" {
+		t.Errorf("count-limited cache holds %s, want mid,new", got)
+	}
+
+	dir = t.TempDir()
+	bySize := NewCache(dir, 0, 25)
+	put(bySize, "old", 10, 3*time.Hour)
+	put(bySize, "mid", 10, 2*time.Hour)
+	put(bySize, "new", 10, time.Hour)
+	if got := strings.Join(cached(dir), ","); got != "mid,new" {
+		t.Errorf("size-limited cache holds %s, want mid,new", got)
+	}
+}
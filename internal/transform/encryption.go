@@ -0,0 +1,198 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeySize is the length of an encryption key in bytes (AES-256).
+const KeySize = 32
+
+// ErrNoKey means an encrypted file was read without an encryption key.
+var ErrNoKey = errors.New("backup is encrypted but no encryption key is configured")
+
+const (
+	chunkSize   = 64 * 1024
+	prefixSize  = 7
+	encMagic    = "DSE1"
+	encOverhead = 16 // GCM tag
+)
+
+// Encryption encrypts with AES-256-GCM. The stream is sealed in chunks so it
+// never has to fit in memory; each chunk's nonce carries its index and
+// whether it is the last, so reordered, dropped or truncated chunks fail to
+// decrypt.
+type Encryption struct {
+	Key []byte
+}
+
+func (*Encryption) Suffix() string { return ".enc" }
+
+func (e *Encryption) aead() (cipher.AEAD, error) {
+	if len(e.Key) == 0 {
+		return nil, ErrNoKey
+	}
+	if len(e.Key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(e.Key))
+	}
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *Encryption) Wrap(w io.Writer) (io.WriteCloser, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encMagic)+prefixSize)
+	copy(header, encMagic)
+	if _, err := rand.Read(header[len(encMagic):]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: header[len(encMagic):],
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (e *Encryption) Unwrap(r io.Reader) (io.ReadCloser, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encMagic)+prefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encMagic)]) != encMagic {
+		return nil, fmt.Errorf("not an encrypted backup")
+	}
+
+	return io.NopCloser(&decryptReader{
+		r:      r,
+		aead:   aead,
+		prefix: header[len(encMagic):],
+		chunk:  make([]byte, chunkSize+encOverhead),
+	}), nil
+}
+
+// nonce returns the nonce for chunk index of the stream with prefix.
+func nonce(prefix []byte, index uint32, last bool) []byte {
+	n := make([]byte, prefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], index)
+	if last {
+		n[prefixSize+4] = 1
+	}
+	return n
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	index  uint32
+	closed bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encryption stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		// The final chunk is always shorter than chunkSize, which is how
+		// the reader recognizes it.
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.index == ^uint32(0) {
+		return errors.New("encryption stream too long")
+	}
+	sealed := e.aead.Seal(nil, nonce(e.prefix, e.index, last), e.buf, nil)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	chunk  []byte
+	plain  bytes.Reader
+	index  uint32
+	done   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for d.plain.Len() == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	return d.plain.Read(p)
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case err == io.EOF:
+		return fmt.Errorf("encrypted backup is truncated")
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+
+	plain, err := d.aead.Open(nil, nonce(d.prefix, d.index, last), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup (wrong key or corrupted file): %w", err)
+	}
+	d.index++
+	d.done = last
+	d.plain.Reset(plain)
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package transform provides the reversible steps, such as compression and
+// encryption, that a backup file passes through before it is stored. Each
+// step adds a suffix to the file name, so the steps applied to a stored file
+// can be recovered from its name alone.
+package transform
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Transform is one reversible step applied to a backup stream.
+type Transform interface {
+	// Suffix is appended to the file name, e.g. ".gz".
+	Suffix() string
+	// Wrap returns a writer that transforms what is written to it into w.
+	// Closing it flushes the transform but does not close w.
+	Wrap(w io.Writer) (io.WriteCloser, error)
+	// Unwrap returns a reader that reverses the transform on r.
+	Unwrap(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip compresses with gzip. A Level of 0 uses gzip's default.
+type Gzip struct {
+	Level int
+}
+
+func (Gzip) Suffix() string { return ".gz" }
+
+func (g Gzip) Wrap(w io.Writer) (io.WriteCloser, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	return gw, nil
+}
+
+func (Gzip) Unwrap(r io.Reader) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	return gr, nil
+}
+
+// Zstd compresses with zstd. Levels follow the zstd CLI (1-19); 0 uses the
+// encoder's default.
+type Zstd struct {
+	Level int
+}
+
+func (Zstd) Suffix() string { return ".zst" }
+
+func (z Zstd) Wrap(w io.Writer) (io.WriteCloser, error) {
+	level := zstd.SpeedDefault
+	if z.Level != 0 {
+		level = zstd.EncoderLevelFromZstd(z.Level)
+	}
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	return zw, nil
+}
+
+func (Zstd) Unwrap(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// None passes data through unchanged.
+type None struct{}
+
+func (None) Suffix() string { return "" }
+
+func (None) Wrap(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+
+func (None) Unwrap(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ForCompression returns the transform for a compression setting ("gzip",
+// "zstd", or "none"/"" for none).
+func ForCompression(name string, level int) (Transform, error) {
+	switch name {
+	case "gzip":
+		return Gzip{Level: level}, nil
+	case "zstd":
+		return Zstd{Level: level}, nil
+	case "none", "":
+		return None{}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression: %s", name)
+	}
+}
+
+// Chain applies its transforms in order when writing, and undoes them in
+// reverse order when reading.
+type Chain []Transform
+
+// Suffix returns the suffixes of all transforms, in the order applied.
+func (c Chain) Suffix() string {
+	var b strings.Builder
+	for _, t := range c {
+		b.WriteString(t.Suffix())
+	}
+	return b.String()
+}
+
+// WithoutCompression returns c without its compression steps, for files
+// whose content is compressed in a format only the database tools read.
+func (c Chain) WithoutCompression() Chain {
+	var out Chain
+	for _, t := range c {
+		switch t.(type) {
+		case Gzip, Zstd:
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Compressed reports whether c has a compression step.
+func (c Chain) Compressed() bool {
+	return len(c.WithoutCompression()) < len(c)
+}
+
+// Wrap returns a writer that applies every transform in c before writing to
+// w. Closing it flushes each transform in turn but does not close w.
+func (c Chain) Wrap(w io.Writer) (io.WriteCloser, error) {
+	writers := make([]io.WriteCloser, len(c))
+	next := w
+	for i := len(c) - 1; i >= 0; i-- {
+		wc, err := c[i].Wrap(next)
+		if err != nil {
+			for _, opened := range writers[i+1:] {
+				opened.Close()
+			}
+			return nil, err
+		}
+		writers[i] = wc
+		next = wc
+	}
+	if len(writers) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	return &chainWriter{Writer: writers[0], writers: writers}, nil
+}
+
+// Unwrap returns a reader that undoes every transform in c on r.
+func (c Chain) Unwrap(r io.Reader) (io.ReadCloser, error) {
+	var readers []io.ReadCloser
+	next := r
+	for i := len(c) - 1; i >= 0; i-- {
+		rc, err := c[i].Unwrap(next)
+		if err != nil {
+			closeAll(readers)
+			return nil, err
+		}
+		readers = append(readers, rc)
+		next = rc
+	}
+	if len(readers) == 0 {
+		return io.NopCloser(r), nil
+	}
+	return &chainReader{Reader: next, readers: readers}, nil
+}
+
+type chainWriter struct {
+	io.Writer
+	writers []io.WriteCloser
+}
+
+// Close closes the outermost writer first so each flush reaches the next.
+func (c *chainWriter) Close() error {
+	var firstErr error
+	for _, w := range c.writers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type chainReader struct {
+	io.Reader
+	readers []io.ReadCloser
+}
+
+func (c *chainReader) Close() error {
+	return closeAll(c.readers)
+}
+
+func closeAll(readers []io.ReadCloser) error {
+	var firstErr error
+	for i := len(readers) - 1; i >= 0; i-- {
+		if err := readers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Registry finds the transforms applied to a stored file from its suffixes.
+type Registry struct {
+	bySuffix map[string]Transform
+}
+
+// NewRegistry returns a registry of the built-in transforms. key is the
+// encryption key; without one, encrypted files are recognized but cannot be
+// read.
+func NewRegistry(key []byte) *Registry {
+	r := &Registry{bySuffix: make(map[string]Transform)}
+	r.Register(Gzip{})
+	r.Register(Zstd{})
+	r.Register(&Encryption{Key: key})
+	return r
+}
+
+// Register adds t, replacing any transform with the same suffix.
+func (r *Registry) Register(t Transform) {
+	r.bySuffix[t.Suffix()] = t
+}
+
+// Parse strips the known suffixes from name and returns the chain that
+// undoes them, and the name without them.
+func (r *Registry) Parse(name string) (Chain, string) {
+	var chain Chain
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return chain, name
+		}
+		t, ok := r.bySuffix[name[i:]]
+		if !ok {
+			return chain, name
+		}
+		chain = append(Chain{t}, chain...)
+		name = name[:i]
+	}
+}
+
+// Reader undoes the transforms named by name's suffixes on src.
+func (r *Registry) Reader(src io.Reader, name string) (io.ReadCloser, error) {
+	chain, _ := r.Parse(name)
+	return chain.Unwrap(src)
+}
@@ -0,0 +1,230 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func roundTrip(t *testing.T, chain Chain, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := chain.Wrap(&buf)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := chain.Unwrap(&buf)
+	if err != nil {
+		t.Fatalf("Unwrap failed: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	return got
+}
+
+func testKey() []byte {
+	return bytes.Repeat([]byte{0x42}, KeySize)
+}
+
+func TestChain_RoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("datasaver backup contents\n", 10000))
+	key := testKey()
+
+	tests := []struct {
+		name  string
+		chain Chain
+	}{
+		{"empty", nil},
+		{"none", Chain{None{}}},
+		{"gzip default", Chain{Gzip{}}},
+		{"gzip 9", Chain{Gzip{Level: 9}}},
+		{"zstd default", Chain{Zstd{}}},
+		{"zstd 19", Chain{Zstd{Level: 19}}},
+		{"encryption", Chain{&Encryption{Key: key}}},
+		{"gzip then encryption", Chain{Gzip{}, &Encryption{Key: key}}},
+		{"zstd then encryption", Chain{Zstd{Level: 3}, &Encryption{Key: key}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roundTrip(t, tt.chain, data); !bytes.Equal(got, data) {
+				t.Errorf("round trip changed data: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestEncryption_ChunkBoundaries(t *testing.T) {
+	chain := Chain{&Encryption{Key: testKey()}}
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		data := bytes.Repeat([]byte{0x5a}, size)
+		if got := roundTrip(t, chain, data); !bytes.Equal(got, data) {
+			t.Errorf("size %d: round trip changed data", size)
+		}
+	}
+}
+
+func encrypt(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := (&Encryption{Key: key}).Wrap(&buf)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	r, err := (&Encryption{Key: key}).Unwrap(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestEncryption_RejectsBadInput(t *testing.T) {
+	data := bytes.Repeat([]byte("secret"), chunkSize/3)
+	sealed := encrypt(t, testKey(), data)
+
+	wrongKey := bytes.Repeat([]byte{0x01}, KeySize)
+	if _, err := decrypt(wrongKey, sealed); err == nil {
+		t.Error("expected error decrypting with the wrong key")
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)/2] ^= 0xff
+	if _, err := decrypt(testKey(), tampered); err == nil {
+		t.Error("expected error decrypting a tampered file")
+	}
+
+	// Cut at the boundary after the first full chunk.
+	truncated := sealed[:len(encMagic)+prefixSize+chunkSize+encOverhead]
+	if _, err := decrypt(testKey(), truncated); err == nil {
+		t.Error("expected error decrypting a truncated file")
+	}
+
+	if _, err := decrypt(nil, sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey without a key, got %v", err)
+	}
+}
+
+func TestRegistry_Parse(t *testing.T) {
+	reg := NewRegistry(nil)
+
+	tests := []struct {
+		name     string
+		suffixes string
+		base     string
+	}{
+		{"backups/db.sql", "", "backups/db.sql"},
+		{"backups/db.sql.gz", ".gz", "backups/db.sql"},
+		{"backups/db.dump.zst", ".zst", "backups/db.dump"},
+		{"backups/db.dump.zst.enc", ".zst.enc", "backups/db.dump"},
+		{"backups/db.tar.gz.enc", ".gz.enc", "backups/db.tar"},
+		{"backups/db.sqlite.enc", ".enc", "backups/db.sqlite"},
+		{"wal/000000010000000000000001.gz", ".gz", "wal/000000010000000000000001"},
+		{"noext", "", "noext"},
+	}
+
+	for _, tt := range tests {
+		chain, base := reg.Parse(tt.name)
+		if got := chain.Suffix(); got != tt.suffixes {
+			t.Errorf("Parse(%q) suffixes = %q, want %q", tt.name, got, tt.suffixes)
+		}
+		if base != tt.base {
+			t.Errorf("Parse(%q) base = %q, want %q", tt.name, base, tt.base)
+		}
+	}
+}
+
+func TestChain_WithoutCompression(t *testing.T) {
+	reg := NewRegistry(nil)
+	for name, want := range map[string]string{
+		"db.dump":         "",
+		"db.dump.gz":      "",
+		"db.dump.enc":     ".enc",
+		"db.dump.zst.enc": ".enc",
+	} {
+		chain, _ := reg.Parse(name)
+		if got := chain.WithoutCompression().Suffix(); got != want {
+			t.Errorf("WithoutCompression() of %q = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestChain_Compressed(t *testing.T) {
+	reg := NewRegistry(nil)
+	for name, want := range map[string]bool{
+		"db.dump":         false,
+		"db.dump.gz":      true,
+		"db.dump.enc":     false,
+		"db.dump.zst.enc": true,
+	} {
+		chain, _ := reg.Parse(name)
+		if got := chain.Compressed(); got != want {
+			t.Errorf("Compressed() of %q = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRegistry_Reader(t *testing.T) {
+	key := testKey()
+	data := []byte("hello from a compressed, encrypted backup")
+
+	var buf bytes.Buffer
+	w, err := Chain{Zstd{}, &Encryption{Key: key}}.Wrap(&buf)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	w.Write(data)
+	w.Close()
+
+	r, err := NewRegistry(key).Reader(bytes.NewReader(buf.Bytes()), "db.sql.zst.enc")
+	if err != nil {
+		t.Fatalf("Reader failed: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestForCompression(t *testing.T) {
+	for name, suffix := range map[string]string{"gzip": ".gz", "zstd": ".zst", "none": "", "": ""} {
+		tr, err := ForCompression(name, 0)
+		if err != nil {
+			t.Fatalf("ForCompression(%q) failed: %v", name, err)
+		}
+		if tr.Suffix() != suffix {
+			t.Errorf("ForCompression(%q).Suffix() = %q, want %q", name, tr.Suffix(), suffix)
+		}
+	}
+
+	if _, err := ForCompression("lz4", 0); err == nil {
+		t.Error("expected error for unknown compression")
+	}
+}
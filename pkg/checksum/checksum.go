@@ -0,0 +1,123 @@
+// Package checksum computes the checksums recorded with backups. A checksum
+// is written as "<algorithm>:<hex>", so every checksum names the algorithm
+// that verifies it, and backups taken with different algorithms can be
+// verified side by side.
+package checksum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Supported algorithms. SHA256 is the default and the algorithm of every
+// checksum recorded before the algorithm was configurable. XXHash (64-bit
+// xxHash) is much faster on large files but only detects corruption, not
+// tampering.
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	XXHash = "xxhash"
+)
+
+// Default is the algorithm used when none is configured.
+const Default = SHA256
+
+var algorithms = map[string]func() hash.Hash{
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+	XXHash: func() hash.Hash { return xxhash.New() },
+}
+
+// Valid reports whether algorithm is supported.
+func Valid(algorithm string) bool {
+	_, ok := algorithms[algorithm]
+	return ok
+}
+
+// Algorithms returns the supported algorithms in order.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Algorithm returns the algorithm named by sum's prefix, or SHA256 for a
+// sum without one.
+func Algorithm(sum string) string {
+	if algorithm, _, ok := strings.Cut(sum, ":"); ok {
+		return algorithm
+	}
+	return SHA256
+}
+
+// Writer checksums what is written to it, for streams that are not files
+// of their own such as a dump on its way to disk.
+type Writer struct {
+	algorithm string
+	h         hash.Hash
+}
+
+// NewWriter returns a Writer for algorithm, or Default when it is empty.
+func NewWriter(algorithm string) (*Writer, error) {
+	if algorithm == "" {
+		algorithm = Default
+	}
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	return &Writer{algorithm: algorithm, h: newHash()}, nil
+}
+
+// NewWriterFor returns a Writer for the algorithm sum was computed with, to
+// compute a checksum comparable with it.
+func NewWriterFor(sum string) (*Writer, error) {
+	return NewWriter(Algorithm(sum))
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.h.Write(p)
+}
+
+// Sum returns the checksum of everything written as "<algorithm>:<hex>".
+func (w *Writer) Sum() string {
+	return w.algorithm + ":" + hex.EncodeToString(w.h.Sum(nil))
+}
+
+// File returns the checksum of the file at path with algorithm, or Default
+// when it is empty.
+func File(path, algorithm string) (string, error) {
+	w, err := NewWriter(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for checksum: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return "", fmt.Errorf("failed to calculate checksum: %w", err)
+	}
+	return w.Sum(), nil
+}
+
+// FileFor returns the checksum of the file at path with the algorithm sum
+// was computed with, so the two can be compared.
+func FileFor(path, sum string) (string, error) {
+	return File(path, Algorithm(sum))
+}
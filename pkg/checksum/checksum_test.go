@@ -0,0 +1,83 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.dump")
+	if err := os.WriteFile(path, []byte("backup data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range Algorithms() {
+		sum, err := File(path, alg)
+		if err != nil {
+			t.Fatalf("File(%s) error = %v", alg, err)
+		}
+		if !strings.HasPrefix(sum, alg+":") {
+			t.Errorf("File(%s) = %q, want an %s: prefix", alg, sum, alg)
+		}
+		if Algorithm(sum) != alg {
+			t.Errorf("Algorithm(%q) = %q, want %q", sum, Algorithm(sum), alg)
+		}
+		again, err := FileFor(path, sum)
+		if err != nil || again != sum {
+			t.Errorf("FileFor() = %q, %v, want %q", again, err, sum)
+		}
+	}
+
+	if sum, _ := File(path, ""); !strings.HasPrefix(sum, "sha256:") || len(sum) != len("sha256:")+64 {
+		t.Errorf("File() with no algorithm = %q, want a sha256 checksum", sum)
+	}
+
+	if _, err := File(path, "md5"); err == nil {
+		t.Error("File() should reject an unsupported algorithm")
+	}
+	if _, err := File(filepath.Join(t.TempDir(), "missing"), SHA256); err == nil {
+		t.Error("File() should fail for a missing file")
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	tests := map[string]string{
+		"sha256:abc": SHA256,
+		"sha512:abc": SHA512,
+		"xxhash:abc": XXHash,
+		"abc":        SHA256,
+		"":           SHA256,
+	}
+	for sum, want := range tests {
+		if got := Algorithm(sum); got != want {
+			t.Errorf("Algorithm(%q) = %q, want %q", sum, got, want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.dump")
+	if err := os.WriteFile(path, []byte("backup data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := File(path, SHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriterFor(want)
+	if err != nil {
+		t.Fatalf("NewWriterFor() error = %v", err)
+	}
+	w.Write([]byte("backup "))
+	w.Write([]byte("data"))
+	if got := w.Sum(); got != want {
+		t.Errorf("Sum() = %q, want %q", got, want)
+	}
+
+	if _, err := NewWriter("crc32"); err == nil {
+		t.Error("NewWriter() should reject an unsupported algorithm")
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FormatCustom     = "custom"     // pg_dump -F c, a single archive file
+	FormatDirectory  = "directory"  // pg_dump -F d, stored as a tar of the directory
+	FormatSQL        = "sql"        // Plain SQL text
+	FormatSQLite     = "sqlite"     // SQLite database file from the online backup API
+	FormatBaseBackup = "basebackup" // pg_basebackup -F t, a tar of the whole cluster
+)
+
+// TarDirectory writes the regular files under dir to w as a tar archive,
+// with paths relative to dir.
+func TarDirectory(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive dump directory: %w", err)
+	}
+
+	return tw.Close()
+}
+
+// UntarDirectory extracts a tar archive written by TarDirectory into dir,
+// creating it if needed.
+func UntarDirectory(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create extract directory: %w", err)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in dump archive: %s", header.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create extract directory: %w", err)
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create extracted file: %w", err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+		f.Close()
+	}
+}
+
+// ExtractBaseBackup unpacks a pg_basebackup tar into dir as a PostgreSQL
+// data directory. Unlike UntarDirectory it keeps empty directories, such as
+// pg_wal, and file modes, both of which the server needs to start.
+func ExtractBaseBackup(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("failed to set data directory permissions: %w", err)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read base backup: %w", err)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in base backup: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create extracted file: %w", err)
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+			f.Close()
+		}
+	}
+}
+
+// CheckBaseBackup reads a pg_basebackup tar from r without extracting it
+// and checks that it holds the files every PostgreSQL data directory starts
+// from. It returns the number of files in the archive.
+func CheckBaseBackup(r io.Reader) (int, error) {
+	missing := map[string]bool{"PG_VERSION": true, "global/pg_control": true}
+	files := 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("base backup is not a readable tar: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			files++
+		}
+		delete(missing, strings.TrimPrefix(header.Name, "./"))
+	}
+
+	for name := range missing {
+		return files, fmt.Errorf("base backup is missing %s", name)
+	}
+	return files, nil
+}
+
+// tarHeaderSize is enough of a stream to recognise a tar archive by its
+// "ustar" magic at offset 257.
+const tarHeaderSize = 262
+
+// isTarHeader reports whether header, the first bytes of a dump, is a POSIX
+// tar header, which is how directory-format dumps are stored.
+func isTarHeader(header []byte) bool {
+	return len(header) >= tarHeaderSize && string(header[257:262]) == "ustar"
+}
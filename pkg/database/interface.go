@@ -7,6 +7,7 @@ import (
 
 type Driver interface {
 	Type() string
+	Format() string
 	Connect(ctx context.Context) error
 	Close() error
 	Version(ctx context.Context) (string, error)
@@ -14,6 +15,53 @@ type Driver interface {
 	Restore(ctx context.Context, r io.Reader, targetDB string) error
 }
 
+// ErrorClassifier is implemented by drivers that recognize errors retrying
+// cannot fix, such as rejected credentials or a missing database, including
+// ones reported by their command-line tools.
+type ErrorClassifier interface {
+	IsPermanentError(err error) bool
+}
+
+// Sizer is implemented by drivers that can tell how large the database is
+// before dumping it, so a backup can check it has room first.
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// RowCounter is implemented by drivers that can count the rows of tables,
+// so a restore can be compared with the database it was backed up from.
+type RowCounter interface {
+	CountRows(ctx context.Context, tables []string) (map[string]int64, error)
+}
+
+// DumpCompressor is implemented by drivers whose dumps can come out already
+// compressed, so a backup does not compress them again.
+type DumpCompressor interface {
+	CompressesDump() bool
+}
+
+// StatsCollector is implemented by drivers that can count the rows of every
+// user table, so backups can record what they hold.
+type StatsCollector interface {
+	TableStats(ctx context.Context) (map[string]int64, error)
+}
+
+const (
+	ModeFull   = "full"   // Schema and data
+	ModeSchema = "schema" // Object definitions only
+	ModeData   = "data"   // Table contents only
+)
+
+const (
+	SQLiteMethodDump   = "dump"   // sqlite3 CLI .dump, portable SQL text
+	SQLiteMethodBackup = "backup" // Online backup API, binary copy without the CLI
+)
+
+const (
+	MethodLogical  = "logical"  // pg_dump of one database
+	MethodPhysical = "physical" // pg_basebackup of the whole cluster
+)
+
 type Config struct {
 	Type     string
 	Host     string
@@ -23,4 +71,40 @@ type Config struct {
 	Password string
 	URL      string
 	Path     string // For SQLite file path
+	DumpJobs int    // Parallel pg_dump jobs; more than 1 switches to directory format
+
+	IncludeTables []string // Only dump tables matching these patterns
+	ExcludeTables []string // Skip tables matching these patterns
+	Mode          string   // ModeFull (default), ModeSchema or ModeData
+	SQLiteMethod  string   // SQLiteMethodDump (default) or SQLiteMethodBackup
+	Method        string   // MethodLogical (default) or MethodPhysical, PostgreSQL only
+	DumpFormat    string   // FormatCustom (default) or FormatSQL for a plain pg_dump
+	CompressLevel int      // When positive, pg_dump compresses custom and directory dumps at this -Z level
+
+	RestoreCopies int // SQLite files replaced by Restore to keep; 0 keeps none once a restore succeeds
+
+	TempDir string // Where dumps are staged; empty uses the system default
+
+	SSLMode     string // libpq sslmode; defaults to disable for host/port configs
+	SSLRootCert string // CA certificate path
+	SSLCert     string // Client certificate path
+	SSLKey      string // Client key path
+
+	ConnectTimeout int // Seconds pg_dump/pg_restore wait to connect; 0 waits forever
+
+	// Client binaries to run, for hosts with version-suffixed tools or
+	// several PostgreSQL versions installed. Empty finds the tool on PATH.
+	PGDumpPath       string
+	PGRestorePath    string
+	PSQLPath         string
+	PGBasebackupPath string
+	SQLite3Path      string
+}
+
+// binary returns path, or tool to find it on PATH when path is empty.
+func binary(path, tool string) string {
+	if path != "" {
+		return path
+	}
+	return tool
 }
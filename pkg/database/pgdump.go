@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PGDumpVersionError is returned before a dump when pg_dump is older than
+// the server, which pg_dump refuses to dump with an error that does not say
+// what to do about it.
+type PGDumpVersionError struct {
+	PGDump string // pg_dump's major version, e.g. "14"
+	Server string // The server's major version, e.g. "16"
+}
+
+func (e *PGDumpVersionError) Error() string {
+	return fmt.Sprintf("pg_dump %s cannot dump server %s; install PostgreSQL %s client tools or set database.pg_dump_path to a pg_dump of version %s or later",
+		e.PGDump, e.Server, e.Server, e.Server)
+}
+
+// pgDump returns the pg_dump binary to run.
+func (p *PostgresDriver) pgDump() string {
+	return binary(p.cfg.PGDumpPath, "pg_dump")
+}
+
+// CheckPGDump runs pg_dump --version and compares it with the connected
+// server, so a missing or too old pg_dump fails with an actionable error
+// before the dump starts. Versions it cannot parse, or a server it cannot
+// query, are left for the dump itself to report. Dump calls it first.
+func (p *PostgresDriver) CheckPGDump(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, p.pgDump(), "--version").Output()
+	if err != nil {
+		return fmt.Errorf("failed to run %s --version: %w; install the PostgreSQL client tools or set database.pg_dump_path", p.pgDump(), err)
+	}
+	if p.db == nil {
+		return nil
+	}
+	server, err := p.Version(ctx)
+	if err != nil {
+		return nil
+	}
+	return checkPGDumpVersion(string(out), server)
+}
+
+// checkPGDumpVersion returns a PGDumpVersionError when the pg_dump whose
+// --version output is pgDumpOutput has an older major version than server.
+func checkPGDumpVersion(pgDumpOutput, server string) error {
+	var client string
+	for _, field := range strings.Fields(pgDumpOutput) {
+		if field[0] >= '0' && field[0] <= '9' {
+			client = field
+			break
+		}
+	}
+
+	clientMajor, clientNum, ok := pgMajorVersion(client)
+	if !ok {
+		return nil
+	}
+	serverMajor, serverNum, ok := pgMajorVersion(server)
+	if !ok {
+		return nil
+	}
+	if clientNum < serverNum {
+		return &PGDumpVersionError{PGDump: clientMajor, Server: serverMajor}
+	}
+	return nil
+}
+
+var pgVersionPattern = regexp.MustCompile(`^(\d+)(?:\.(\d+))?`)
+
+// pgMajorVersion returns the major version of a PostgreSQL version such as
+// "16.2", "16beta1" or "9.6.24", as displayed ("16", "9.6") and as a number
+// that orders both numbering schemes (160000, 90600).
+func pgMajorVersion(version string) (string, int, bool) {
+	m := pgVersionPattern.FindStringSubmatch(version)
+	if m == nil {
+		return "", 0, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	if major >= 10 {
+		return m[1], major * 10000, true
+	}
+	if m[2] == "" {
+		return "", 0, false
+	}
+	minor, _ := strconv.Atoi(m[2])
+	return m[1] + "." + m[2], major*10000 + minor*100, true
+}
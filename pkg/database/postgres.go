@@ -1,16 +1,23 @@
 package database
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDriver struct {
@@ -28,6 +35,20 @@ func (p *PostgresDriver) Type() string {
 	return "postgres"
 }
 
+// Format reports the archive format Dump produces.
+func (p *PostgresDriver) Format() string {
+	if p.cfg.Method == MethodPhysical {
+		return FormatBaseBackup
+	}
+	if p.cfg.DumpJobs > 1 {
+		return FormatDirectory
+	}
+	if p.cfg.DumpFormat == FormatSQL {
+		return FormatSQL
+	}
+	return FormatCustom
+}
+
 func (p *PostgresDriver) ConnectionString() string {
 	return p.connString("")
 }
@@ -37,22 +58,74 @@ func (p *PostgresDriver) ConnectionString() string {
 // the discrete Host/Port/User/Name fields are empty, so building CLI args
 // from them silently targets nothing.
 func (p *PostgresDriver) connString(dbName string) string {
+	ssl := p.sslParams()
 	if p.cfg.URL != "" {
-		if dbName == "" {
+		if dbName == "" && len(ssl) == 0 {
 			return p.cfg.URL
 		}
 		u, err := url.Parse(p.cfg.URL)
 		if err != nil {
 			return p.cfg.URL
 		}
-		u.Path = "/" + dbName
+		if dbName != "" {
+			u.Path = "/" + dbName
+		}
+		// Settings already in the URL win over the separate ssl fields.
+		query := u.Query()
+		for key, values := range ssl {
+			if !query.Has(key) {
+				query[key] = values
+			}
+		}
+		u.RawQuery = query.Encode()
 		return u.String()
 	}
 	if dbName == "" {
 		dbName = p.cfg.Name
 	}
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		p.cfg.User, url.QueryEscape(p.cfg.Password), p.cfg.Host, p.cfg.Port, dbName)
+	if !ssl.Has("sslmode") {
+		ssl.Set("sslmode", "disable")
+	}
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?%s",
+		p.cfg.User, url.QueryEscape(p.cfg.Password), p.cfg.Host, p.cfg.Port, dbName, ssl.Encode())
+}
+
+// toolEnv returns the environment for pg_dump and pg_restore. Without
+// PGCONNECT_TIMEOUT libpq waits forever on an unreachable host, and nothing
+// cancels a scheduled backup's context. The TLS variables repeat what
+// connString puts in the URL, where the URL's values take precedence.
+func (p *PostgresDriver) toolEnv() []string {
+	env := os.Environ()
+	if p.cfg.ConnectTimeout > 0 {
+		env = append(env, "PGCONNECT_TIMEOUT="+strconv.Itoa(p.cfg.ConnectTimeout))
+	}
+	for name, value := range map[string]string{
+		"PGSSLMODE":     p.cfg.SSLMode,
+		"PGSSLROOTCERT": p.cfg.SSLRootCert,
+		"PGSSLCERT":     p.cfg.SSLCert,
+		"PGSSLKEY":      p.cfg.SSLKey,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// sslParams returns the configured TLS settings as libpq URL parameters.
+func (p *PostgresDriver) sslParams() url.Values {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"sslmode":     p.cfg.SSLMode,
+		"sslrootcert": p.cfg.SSLRootCert,
+		"sslcert":     p.cfg.SSLCert,
+		"sslkey":      p.cfg.SSLKey,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	return params
 }
 
 func (p *PostgresDriver) Connect(ctx context.Context) error {
@@ -95,13 +168,62 @@ func (p *PostgresDriver) Version(ctx context.Context) (string, error) {
 	return version, nil
 }
 
+// Size returns the on-disk size of the database, or of every database on
+// the server for physical backups, which copy the whole cluster.
+func (p *PostgresDriver) Size(ctx context.Context) (int64, error) {
+	if p.db == nil {
+		return 0, fmt.Errorf("database not connected")
+	}
+
+	query := "SELECT pg_database_size(current_database())"
+	if p.Format() == FormatBaseBackup {
+		query = "SELECT sum(pg_database_size(datname))::bigint FROM pg_database"
+	}
+	var size int64
+	if err := p.db.QueryRowContext(ctx, query).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to get database size: %w", err)
+	}
+	return size, nil
+}
+
+// CountRows counts the rows of each of tables in the connected database.
+func (p *PostgresDriver) CountRows(ctx context.Context, tables []string) (map[string]int64, error) {
+	return countRows(ctx, p.db, tables)
+}
+
+// TableStats counts the rows of every user table, keyed schema.table.
+func (p *PostgresDriver) TableStats(ctx context.Context) (map[string]int64, error) {
+	return countAllRows(ctx, p.db, "SELECT schemaname || '.' || relname FROM pg_stat_user_tables ORDER BY 1")
+}
+
 func (p *PostgresDriver) Dump(ctx context.Context, w io.Writer) error {
+	switch p.Format() {
+	case FormatBaseBackup:
+		_, err := p.BaseBackup(ctx, w)
+		return err
+	}
+	if err := p.CheckPGDump(ctx); err != nil {
+		return err
+	}
+	if p.Format() == FormatDirectory {
+		return p.dumpDirectory(ctx, w)
+	}
+
+	format := "c"
+	if p.Format() == FormatSQL {
+		format = "p"
+	}
 	args := []string{
 		"-d", p.connString(""),
-		"-F", "c",
+		"-F", format,
+	}
+	if format == "c" {
+		args = append(args, p.compressArgs()...)
 	}
+	args = append(args, p.selectionArgs()...)
 
-	cmd := exec.CommandContext(ctx, "pg_dump", args...)
+	cmd := exec.CommandContext(ctx, p.pgDump(), args...)
+	cmd.Env = p.toolEnv()
 	cmd.Stdout = w
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
@@ -113,14 +235,153 @@ func (p *PostgresDriver) Dump(ctx context.Context, w io.Writer) error {
 	return nil
 }
 
+// CompressesDump reports whether pg_dump compresses what Dump writes, so
+// it needs no further compression.
+func (p *PostgresDriver) CompressesDump() bool {
+	if p.cfg.CompressLevel <= 0 {
+		return false
+	}
+	format := p.Format()
+	return format == FormatCustom || format == FormatDirectory
+}
+
+// compressArgs returns the pg_dump flag setting the configured compression
+// level, if any.
+func (p *PostgresDriver) compressArgs() []string {
+	if p.cfg.CompressLevel <= 0 {
+		return nil
+	}
+	return []string{"-Z", strconv.Itoa(p.cfg.CompressLevel)}
+}
+
+// selectionArgs returns the pg_dump flags that narrow what gets dumped.
+// Patterns are passed through as-is, so pg_dump's glob syntax applies.
+func (p *PostgresDriver) selectionArgs() []string {
+	var args []string
+	for _, pattern := range p.cfg.IncludeTables {
+		args = append(args, "--table", pattern)
+	}
+	for _, pattern := range p.cfg.ExcludeTables {
+		args = append(args, "--exclude-table", pattern)
+	}
+	switch p.cfg.Mode {
+	case ModeSchema:
+		args = append(args, "--schema-only")
+	case ModeData:
+		args = append(args, "--data-only")
+	}
+	return args
+}
+
+// dumpDirectory runs a parallel directory-format pg_dump and streams the
+// result to w as a tar, so callers still deal with a single file.
+func (p *PostgresDriver) dumpDirectory(ctx context.Context, w io.Writer) error {
+	tmpDir, err := os.MkdirTemp(p.cfg.TempDir, "pg-dump-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// pg_dump -F d refuses to write into an existing non-empty directory
+	// and creates the target itself.
+	outputDir := filepath.Join(tmpDir, "dump")
+
+	args := []string{
+		"-d", p.connString(""),
+		"-F", "d",
+		"-j", strconv.Itoa(p.cfg.DumpJobs),
+		"-f", outputDir,
+	}
+	args = append(args, p.compressArgs()...)
+	args = append(args, p.selectionArgs()...)
+
+	cmd := exec.CommandContext(ctx, p.pgDump(), args...)
+	cmd.Env = p.toolEnv()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_dump failed: %w, output: %s", err, stderr.String())
+	}
+
+	return TarDirectory(outputDir, w)
+}
+
+// BaseBackupInfo is the WAL range a base backup needs to be consistent.
+type BaseBackupInfo struct {
+	StartLSN string
+	EndLSN   string
+	Timeline int
+}
+
+// BaseBackup streams a pg_basebackup of the whole cluster to w as a tar.
+// The WAL written while it runs is fetched into the tar, so the backup
+// starts on its own; archived WAL only carries it further forward.
+func (p *PostgresDriver) BaseBackup(ctx context.Context, w io.Writer) (*BaseBackupInfo, error) {
+	args := []string{
+		"-d", p.connString(""),
+		"-D", "-",
+		"-F", "t",
+		"-X", "fetch",
+		"--checkpoint", "fast",
+		"--verbose", // Reports the WAL start and end points on stderr
+	}
+
+	cmd := exec.CommandContext(ctx, binary(p.cfg.PGBasebackupPath, "pg_basebackup"), args...)
+	cmd.Env = p.toolEnv()
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pg_basebackup failed: %w, output: %s", err, stderr.String())
+	}
+
+	return parseBaseBackupLog(stderr.String()), nil
+}
+
+var (
+	walStartPoint = regexp.MustCompile(`write-ahead log start point: ([0-9A-F]+/[0-9A-F]+) on timeline (\d+)`)
+	walEndPoint   = regexp.MustCompile(`write-ahead log end point: ([0-9A-F]+/[0-9A-F]+)`)
+)
+
+// parseBaseBackupLog reads the WAL range from pg_basebackup's verbose
+// output. Fields it cannot find are left empty.
+func parseBaseBackupLog(log string) *BaseBackupInfo {
+	info := &BaseBackupInfo{}
+	if m := walStartPoint.FindStringSubmatch(log); m != nil {
+		info.StartLSN = m[1]
+		info.Timeline, _ = strconv.Atoi(m[2])
+	}
+	if m := walEndPoint.FindStringSubmatch(log); m != nil {
+		info.EndLSN = m[1]
+	}
+	return info
+}
+
 func (p *PostgresDriver) DumpToFile(ctx context.Context, outputPath string) error {
+	if p.Format() != FormatCustom {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+
+		return p.Dump(ctx, f)
+	}
+
 	args := []string{
 		"-d", p.connString(""),
 		"-F", "c",
 		"-f", outputPath,
 	}
+	args = append(args, p.selectionArgs()...)
 
-	cmd := exec.CommandContext(ctx, "pg_dump", args...)
+	if err := p.CheckPGDump(ctx); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, p.pgDump(), args...)
+	cmd.Env = p.toolEnv()
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -130,23 +391,20 @@ func (p *PostgresDriver) DumpToFile(ctx context.Context, outputPath string) erro
 	return nil
 }
 
+// Restore streams r into pg_restore. Custom-format archives are piped to
+// its stdin; directory-format tars are unpacked first since pg_restore
+// needs the directory itself. Plain SQL dumps are piped into psql.
 func (p *PostgresDriver) Restore(ctx context.Context, r io.Reader, targetDB string) error {
 	dbName := targetDB
 	if dbName == "" {
 		dbName = p.cfg.Name
 	}
 
-	tmpFile, err := os.CreateTemp("", "pg-restore-*.dump")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	if _, err := io.Copy(tmpFile, r); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("failed to write restore data: %w", err)
+	br := bufio.NewReaderSize(r, tarHeaderSize)
+	header, _ := br.Peek(tarHeaderSize)
+	if !isTarHeader(header) && !bytes.HasPrefix(header, customArchiveMagic) {
+		return p.restoreSQL(ctx, br, dbName)
 	}
-	tmpFile.Close()
 
 	args := []string{
 		"-d", p.connString(dbName),
@@ -154,10 +412,33 @@ func (p *PostgresDriver) Restore(ctx context.Context, r io.Reader, targetDB stri
 		"--if-exists",      // Don't error if objects don't exist
 		"--no-owner",       // Don't restore ownership
 		"--no-privileges",  // Don't restore privileges
-		tmpFile.Name(),
 	}
 
-	cmd := exec.CommandContext(ctx, "pg_restore", args...)
+	var stdin io.Reader
+	if isTarHeader(header) {
+		dumpDir, err := os.MkdirTemp(p.cfg.TempDir, "pg-restore-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		defer os.RemoveAll(dumpDir)
+
+		if err := UntarDirectory(br, dumpDir); err != nil {
+			return err
+		}
+
+		if p.cfg.DumpJobs > 1 {
+			args = append(args, "-j", strconv.Itoa(p.cfg.DumpJobs))
+		}
+		args = append(args, dumpDir)
+	} else {
+		// Parallel restore needs a seekable archive, so a piped custom-format
+		// dump is always restored with a single job.
+		stdin = br
+	}
+
+	cmd := exec.CommandContext(ctx, binary(p.cfg.PGRestorePath, "pg_restore"), args...)
+	cmd.Env = p.toolEnv()
+	cmd.Stdin = stdin
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -167,6 +448,134 @@ func (p *PostgresDriver) Restore(ctx context.Context, r io.Reader, targetDB stri
 	return nil
 }
 
+// customArchiveMagic starts every custom-format pg_dump archive.
+var customArchiveMagic = []byte("PGDMP")
+
+// restoreSQL pipes a plain SQL dump from r into psql, stopping at the first
+// failed statement so a broken dump is not reported as restored.
+func (p *PostgresDriver) restoreSQL(ctx context.Context, r io.Reader, dbName string) error {
+	cmd := exec.CommandContext(ctx, binary(p.cfg.PSQLPath, "psql"),
+		"-d", p.connString(dbName),
+		"-X", // Ignore ~/.psqlrc
+		"-q",
+		"-v", "ON_ERROR_STOP=1",
+	)
+	cmd.Env = p.toolEnv()
+	cmd.Stdin = r
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("psql failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// ScratchRestore restores the dump in r into a new, uniquely named database
+// on the connected server, counts the user tables it ends up with, and drops
+// the database again. It proves a dump restores without touching any
+// existing database.
+func (p *PostgresDriver) ScratchRestore(ctx context.Context, r io.Reader) (tables int, err error) {
+	if p.db == nil {
+		return 0, fmt.Errorf("database not connected")
+	}
+
+	name := fmt.Sprintf("datasaver_verify_%d", time.Now().UnixNano())
+	if _, err := p.db.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(name)); err != nil {
+		return 0, fmt.Errorf("failed to create scratch database: %w", err)
+	}
+	defer func() {
+		// Drop even if ctx was canceled so verification never leaks databases.
+		dropCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if _, dropErr := p.db.ExecContext(dropCtx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)); dropErr != nil && err == nil {
+			err = fmt.Errorf("failed to drop scratch database %s: %w", name, dropErr)
+		}
+	}()
+
+	if err := p.Restore(ctx, r, name); err != nil {
+		return 0, err
+	}
+
+	scratch, err := sql.Open("postgres", p.connString(name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open scratch database: %w", err)
+	}
+	defer scratch.Close()
+
+	err = scratch.QueryRowContext(ctx, `SELECT count(*) FROM pg_catalog.pg_tables
+		WHERE schemaname NOT IN ('pg_catalog', 'information_schema')`).Scan(&tables)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count restored tables: %w", err)
+	}
+
+	return tables, nil
+}
+
+// RecreateDatabase drops database name, disconnecting its sessions first,
+// and creates it again empty. The driver must be connected to another
+// database on the same server, such as postgres.
+func (p *PostgresDriver) RecreateDatabase(ctx context.Context, name string) error {
+	if p.db == nil {
+		return fmt.Errorf("database not connected")
+	}
+
+	var current string
+	if err := p.db.QueryRowContext(ctx, "SELECT current_database()").Scan(&current); err != nil {
+		return fmt.Errorf("failed to get current database: %w", err)
+	}
+	if current == name {
+		return fmt.Errorf("cannot recreate %s while connected to it", name)
+	}
+
+	// DROP DATABASE fails while anyone is connected. WITH (FORCE) does this
+	// too, but only from PostgreSQL 13.
+	if _, err := p.db.ExecContext(ctx,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", name); err != nil {
+		return fmt.Errorf("failed to disconnect sessions from %s: %w", name, err)
+	}
+	if _, err := p.db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", name, err)
+	}
+	if _, err := p.db.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return nil
+}
+
+// permanentSQLStates are the SQLSTATE codes and classes, by prefix, of
+// connection errors that retrying cannot fix.
+var permanentSQLStates = []string{
+	"28",    // invalid_authorization_specification, incl. 28P01 invalid_password
+	"3D000", // invalid_catalog_name: the database does not exist
+	"42501", // insufficient_privilege
+	"3F000", // invalid_schema_name
+}
+
+// permanentToolError matches the same failures as reported on stderr by
+// pg_dump and pg_basebackup, which print the message but not its SQLSTATE.
+var permanentToolError = regexp.MustCompile(`password authentication failed|no pg_hba\.conf entry|(database|role) "[^"]*" does not exist|permission denied|no matching (tables|schemas) were found`)
+
+// IsPermanentError reports whether err, from Connect or a dump, is an
+// authentication, authorization or missing-object error, or a missing or
+// too old pg_dump.
+func (p *PostgresDriver) IsPermanentError(err error) bool {
+	var versionErr *PGDumpVersionError
+	if errors.As(err, &versionErr) || errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		for _, state := range permanentSQLStates {
+			if strings.HasPrefix(string(pqErr.Code), state) {
+				return true
+			}
+		}
+		return false
+	}
+	return permanentToolError.MatchString(err.Error())
+}
+
 func (p *PostgresDriver) Config() Config {
 	return p.cfg
 }
@@ -226,6 +226,54 @@ func TestPostgresDriver_Integration_DumpAndRestore(t *testing.T) {
 	t.Log("Dump and restore completed successfully with data integrity verified")
 }
 
+func TestPostgresDriver_Integration_ScratchRestore(t *testing.T) {
+	if err := waitForPostgres(t, testPostgresConfig, 30*time.Second); err != nil {
+		t.Skipf("PostgreSQL not available: %v", err)
+	}
+
+	ctx := context.Background()
+
+	driver, err := NewPostgresDriver(testPostgresConfig)
+	if err != nil {
+		t.Fatalf("NewPostgresDriver() error: %v", err)
+	}
+	if err := driver.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	defer driver.Close()
+
+	if _, err := driver.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS scratch_test (id SERIAL PRIMARY KEY, name TEXT);
+		INSERT INTO scratch_test (name) VALUES ('a'), ('b');
+	`); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer driver.db.ExecContext(ctx, "DROP TABLE IF EXISTS scratch_test")
+
+	var dump bytes.Buffer
+	if err := driver.Dump(ctx, &dump); err != nil {
+		t.Fatalf("Dump() error: %v", err)
+	}
+
+	tables, err := driver.ScratchRestore(ctx, &dump)
+	if err != nil {
+		t.Fatalf("ScratchRestore() error: %v", err)
+	}
+	if tables == 0 {
+		t.Error("ScratchRestore() restored no tables")
+	}
+
+	var leftover int
+	err = driver.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM pg_database WHERE datname LIKE 'datasaver_verify_%'").Scan(&leftover)
+	if err != nil {
+		t.Fatalf("Failed to list databases: %v", err)
+	}
+	if leftover != 0 {
+		t.Errorf("%d scratch databases left behind", leftover)
+	}
+}
+
 func TestPostgresDriver_Integration_DumpToFile(t *testing.T) {
 	if err := waitForPostgres(t, testPostgresConfig, 30*time.Second); err != nil {
 		t.Skipf("PostgreSQL not available: %v", err)
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// countRows counts the rows of each of tables through db. Table names may
+// be schema-qualified, as in public.users.
+func countRows(ctx context.Context, db *sql.DB, tables []string) (map[string]int64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not connected")
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var n int64
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+quoteTable(table)).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s: %w", table, err)
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
+
+// countAllRows counts the rows of every table the query lists by name.
+func countAllRows(ctx context.Context, db *sql.DB, query string) (map[string]int64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not connected")
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	return countRows(ctx, db, tables)
+}
+
+// quoteTable quotes each dot-separated part of a table name as an SQL
+// identifier, which both PostgreSQL and SQLite accept.
+func quoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
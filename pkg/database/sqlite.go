@@ -1,20 +1,35 @@
 package database
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// SQLiteHeader is the magic string every SQLite database file starts with.
+const SQLiteHeader = "SQLite format 3\x00"
+
 type SQLiteDriver struct {
-	path string
-	db   *sql.DB
+	path          string
+	mode          string
+	method        string
+	restoreCopies int
+	tempDir       string
+	sqlite3       string // sqlite3 binary to run
+	db            *sql.DB
 }
 
 func NewSQLiteDriver(cfg Config) (*SQLiteDriver, error) {
@@ -27,8 +42,31 @@ func NewSQLiteDriver(cfg Config) (*SQLiteDriver, error) {
 		return nil, fmt.Errorf("sqlite database path is required")
 	}
 
+	if cfg.Mode == ModeData {
+		return nil, fmt.Errorf("data-only backups are not supported for sqlite")
+	}
+
+	method := cfg.SQLiteMethod
+	if method == "" {
+		method = SQLiteMethodDump
+	}
+	switch method {
+	case SQLiteMethodDump:
+	case SQLiteMethodBackup:
+		if cfg.Mode == ModeSchema {
+			return nil, fmt.Errorf("schema-only sqlite backups require the dump method")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sqlite backup method: %s", method)
+	}
+
 	return &SQLiteDriver{
-		path: path,
+		path:          path,
+		mode:          cfg.Mode,
+		method:        method,
+		restoreCopies: cfg.RestoreCopies,
+		tempDir:       cfg.TempDir,
+		sqlite3:       binary(cfg.SQLite3Path, "sqlite3"),
 	}, nil
 }
 
@@ -36,12 +74,19 @@ func (s *SQLiteDriver) Type() string {
 	return "sqlite"
 }
 
+func (s *SQLiteDriver) Format() string {
+	if s.method == SQLiteMethodBackup {
+		return FormatSQLite
+	}
+	return FormatSQL
+}
+
 func (s *SQLiteDriver) Connect(ctx context.Context) error {
 	if _, err := os.Stat(s.path); os.IsNotExist(err) {
 		return fmt.Errorf("sqlite database file not found: %s", s.path)
 	}
 
-	db, err := sql.Open("sqlite", s.path+"?mode=ro")
+	db, err := sql.Open("sqlite", readOnlyDSN(s.path))
 	if err != nil {
 		return fmt.Errorf("failed to open sqlite database: %w", err)
 	}
@@ -76,8 +121,41 @@ func (s *SQLiteDriver) Version(ctx context.Context) (string, error) {
 	return version, nil
 }
 
+// Size returns the size of the database file plus its write-ahead log,
+// whose pages a backup includes. It needs no connection.
+func (s *SQLiteDriver) Size(ctx context.Context) (int64, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat sqlite database: %w", err)
+	}
+	size := info.Size()
+	if wal, err := os.Stat(s.path + "-wal"); err == nil {
+		size += wal.Size()
+	}
+	return size, nil
+}
+
+// CountRows counts the rows of each of tables in the database file.
+func (s *SQLiteDriver) CountRows(ctx context.Context, tables []string) (map[string]int64, error) {
+	return countRows(ctx, s.db, tables)
+}
+
+// TableStats counts the rows of every table, leaving out SQLite's own.
+func (s *SQLiteDriver) TableStats(ctx context.Context) (map[string]int64, error) {
+	return countAllRows(ctx, s.db, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+}
+
 func (s *SQLiteDriver) Dump(ctx context.Context, w io.Writer) error {
-	cmd := exec.CommandContext(ctx, "sqlite3", s.path, ".dump")
+	if s.method == SQLiteMethodBackup {
+		return s.dumpBackup(ctx, w)
+	}
+
+	command := ".dump"
+	if s.mode == ModeSchema {
+		command = ".schema"
+	}
+
+	cmd := exec.CommandContext(ctx, s.sqlite3, s.path, command)
 	cmd.Stdout = w
 	cmd.Stderr = os.Stderr
 
@@ -88,7 +166,91 @@ func (s *SQLiteDriver) Dump(ctx context.Context, w io.Writer) error {
 	return nil
 }
 
+// dumpBackup streams an online-backup copy of the database to w.
+func (s *SQLiteDriver) dumpBackup(ctx context.Context, w io.Writer) error {
+	tmpDir, err := os.MkdirTemp(s.tempDir, "sqlite-backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "backup.db")
+	if err := s.backupToFile(ctx, tmpPath); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup copy: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write backup copy: %w", err)
+	}
+
+	return nil
+}
+
+// readOnlyDSN returns a file: URI that opens path read-only. SQLite only
+// reads mode=ro from a URI; after a plain path it is ignored, and a missing
+// database is created instead of reported.
+func readOnlyDSN(path string) string {
+	return "file:" + uriPathEscaper.Replace(filepath.ToSlash(path)) + "?mode=ro"
+}
+
+// uriPathEscaper escapes the characters that end or escape the path of a
+// SQLite URI.
+var uriPathEscaper = strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23")
+
+// backupToFile copies the database to destPath with SQLite's online backup
+// API. Unlike copying the file, this yields a consistent snapshot even while
+// other connections are writing, and it needs no sqlite3 binary.
+func (s *SQLiteDriver) backupToFile(ctx context.Context, destPath string) error {
+	db, err := sql.Open("sqlite", readOnlyDSN(s.path))
+	if err != nil {
+		return fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to sqlite database: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		backuper, ok := driverConn.(interface {
+			NewBackup(dstUri string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return fmt.Errorf("sqlite driver does not support online backup")
+		}
+
+		bck, err := backuper.NewBackup(destPath)
+		if err != nil {
+			return fmt.Errorf("failed to start sqlite backup: %w", err)
+		}
+
+		// A single step copies every page under one read transaction, so
+		// concurrent writers cannot restart the backup halfway through.
+		if _, err := bck.Step(-1); err != nil {
+			bck.Finish()
+			return fmt.Errorf("sqlite backup failed: %w", err)
+		}
+
+		if err := bck.Finish(); err != nil {
+			return fmt.Errorf("failed to finish sqlite backup: %w", err)
+		}
+		return nil
+	})
+}
+
 func (s *SQLiteDriver) DumpToFile(ctx context.Context, outputPath string) error {
+	if s.method == SQLiteMethodBackup {
+		return s.backupToFile(ctx, outputPath)
+	}
+
 	f, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
@@ -122,39 +284,49 @@ func (s *SQLiteDriver) CopyDatabase(ctx context.Context, destPath string) error
 	return destFile.Sync()
 }
 
-func (s *SQLiteDriver) Restore(ctx context.Context, r io.Reader, targetDB string) error {
-	targetPath := targetDB
-	if targetPath == "" {
-		targetPath = s.path
-	}
-
-	tmpFile, err := os.CreateTemp("", "sqlite-restore-*.sql")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+// Restore writes the backup in r to the file targetDB, which must be set
+// even when it is the driver's own database, so a restore never replaces
+// the source by accident. Online-backup copies are written out as-is; SQL
+// dumps are piped into sqlite3. An existing file is first moved aside to a
+// timestamped copy, which is moved back if the restore fails; after a
+// successful restore only the newest restoreCopies copies are kept.
+func (s *SQLiteDriver) Restore(ctx context.Context, r io.Reader, targetDB string) (err error) {
+	if targetDB == "" {
+		return fmt.Errorf("no restore target given; name %s explicitly to replace it", s.path)
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
+	targetPath := targetDB
 
-	if _, err := io.Copy(tmpFile, r); err != nil {
-		return fmt.Errorf("failed to write restore data: %w", err)
-	}
-	tmpFile.Close()
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(SQLiteHeader))
 
-	if _, err := os.Stat(targetPath); err == nil {
-		backupPath := targetPath + ".bak"
-		if err := os.Rename(targetPath, backupPath); err != nil {
+	var copyPath string
+	if _, statErr := os.Stat(targetPath); statErr == nil {
+		copyPath = preRestorePath(targetPath, time.Now())
+		if err := os.Rename(targetPath, copyPath); err != nil {
 			return fmt.Errorf("failed to backup existing database: %w", err)
 		}
 	}
 
-	cmd := exec.CommandContext(ctx, "sqlite3", targetPath)
-	sqlFile, err := os.Open(tmpFile.Name())
-	if err != nil {
-		return fmt.Errorf("failed to open sql file: %w", err)
+	defer func() {
+		if err == nil {
+			prunePreRestoreCopies(targetPath, s.restoreCopies)
+			return
+		}
+		// Never leave a half-restored database in place of the old one.
+		os.Remove(targetPath)
+		if copyPath != "" {
+			if rbErr := os.Rename(copyPath, targetPath); rbErr != nil {
+				err = fmt.Errorf("%w; rollback failed, previous database is at %s: %v", err, copyPath, rbErr)
+			}
+		}
+	}()
+
+	if string(header) == SQLiteHeader {
+		return writeDatabaseFile(br, targetPath)
 	}
-	defer sqlFile.Close()
 
-	cmd.Stdin = sqlFile
+	cmd := exec.CommandContext(ctx, s.sqlite3, targetPath)
+	cmd.Stdin = br
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -164,6 +336,98 @@ func (s *SQLiteDriver) Restore(ctx context.Context, r io.Reader, targetDB string
 	return nil
 }
 
+// restoreStampLayout timestamps the copies Restore keeps of replaced files.
+const restoreStampLayout = "2006-01-02T15:04:05"
+
+// preRestorePath returns an unused name for the copy of targetPath taken
+// before a restore, such as app.db.2024-01-15T12:00:00.bak.
+func preRestorePath(targetPath string, now time.Time) string {
+	stamp := now.UTC().Format(restoreStampLayout)
+	path := targetPath + "." + stamp + ".bak"
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s.%s-%d.bak", targetPath, stamp, i)
+	}
+}
+
+// prunePreRestoreCopies deletes all but the newest keep pre-restore copies
+// of targetPath. It is best effort: the restore itself already succeeded.
+func prunePreRestoreCopies(targetPath string, keep int) {
+	entries, err := os.ReadDir(filepath.Dir(targetPath))
+	if err != nil {
+		return
+	}
+
+	prefix := filepath.Base(targetPath) + "."
+	var stamps []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak")
+		if isRestoreStamp(stamp) {
+			stamps = append(stamps, stamp)
+		}
+	}
+
+	// Stamps sort oldest first, with a -N suffix after the plain stamp.
+	sort.Strings(stamps)
+	for len(stamps) > keep {
+		os.Remove(targetPath + "." + stamps[0] + ".bak")
+		stamps = stamps[1:]
+	}
+}
+
+// isRestoreStamp reports whether s is a stamp preRestorePath puts in a
+// copy's name, so unrelated .bak files are never pruned.
+func isRestoreStamp(s string) bool {
+	if len(s) < len(restoreStampLayout) {
+		return false
+	}
+	if _, err := time.Parse(restoreStampLayout, s[:len(restoreStampLayout)]); err != nil {
+		return false
+	}
+	suffix := s[len(restoreStampLayout):]
+	if suffix == "" {
+		return true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+	return strings.HasPrefix(suffix, "-") && err == nil && n > 0
+}
+
+// IsPermanentError reports whether err means the file is not a usable
+// database, such as a corrupt file or one that is not SQLite at all.
+func (s *SQLiteDriver) IsPermanentError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff { // Primary result code
+		case sqlite3.SQLITE_NOTADB, sqlite3.SQLITE_CORRUPT, sqlite3.SQLITE_AUTH, sqlite3.SQLITE_PERM:
+			return true
+		}
+		return false
+	}
+	// The sqlite3 CLI only reports the message.
+	msg := err.Error()
+	return strings.Contains(msg, "file is not a database") || strings.Contains(msg, "database disk image is malformed")
+}
+
 func (s *SQLiteDriver) Path() string {
 	return s.path
 }
+
+func writeDatabaseFile(r io.Reader, destPath string) error {
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create database file: %w", err)
+	}
+	defer dest.Close()
+
+	if _, err := io.Copy(dest, r); err != nil {
+		return fmt.Errorf("failed to restore database file: %w", err)
+	}
+
+	return dest.Sync()
+}
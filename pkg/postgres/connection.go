@@ -1,9 +1,12 @@
 package postgres
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 
@@ -76,6 +79,56 @@ type DumpOptions struct {
 	Port        int
 	User        string
 	Password    string
+	Jobs        int // Parallel pg_restore jobs
+
+	IncludeTables []string
+	ExcludeTables []string
+
+	// TLS settings, passed as PGSSLMODE etc. Empty values leave libpq's
+	// defaults in place.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	ConnectTimeout int // Seconds to wait for a connection; 0 waits forever
+
+	// Table limits Restore to one table's definition and data, as
+	// "name" or "schema.name".
+	Table string
+
+	// Client binaries to run; empty finds the tool on PATH.
+	PGDumpPath    string
+	PGRestorePath string
+	PSQLPath      string
+}
+
+// binary returns path, or tool to find it on PATH when path is empty.
+func binary(path, tool string) string {
+	if path != "" {
+		return path
+	}
+	return tool
+}
+
+// connEnv returns the libpq environment for the password, connect timeout
+// and TLS settings in opts.
+func connEnv(opts DumpOptions) []string {
+	env := []string{fmt.Sprintf("PGPASSWORD=%s", opts.Password)}
+	if opts.ConnectTimeout > 0 {
+		env = append(env, fmt.Sprintf("PGCONNECT_TIMEOUT=%d", opts.ConnectTimeout))
+	}
+	for name, value := range map[string]string{
+		"PGSSLMODE":     opts.SSLMode,
+		"PGSSLROOTCERT": opts.SSLRootCert,
+		"PGSSLCERT":     opts.SSLCert,
+		"PGSSLKEY":      opts.SSLKey,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
 }
 
 func Dump(ctx context.Context, opts DumpOptions) error {
@@ -87,9 +140,15 @@ func Dump(ctx context.Context, opts DumpOptions) error {
 		"-F", opts.Format,
 		"-f", opts.OutputPath,
 	}
+	for _, pattern := range opts.IncludeTables {
+		args = append(args, "--table", pattern)
+	}
+	for _, pattern := range opts.ExcludeTables {
+		args = append(args, "--exclude-table", pattern)
+	}
 
-	cmd := exec.CommandContext(ctx, "pg_dump", args...)
-	cmd.Env = append(cmd.Environ(), fmt.Sprintf("PGPASSWORD=%s", opts.Password))
+	cmd := exec.CommandContext(ctx, binary(opts.PGDumpPath, "pg_dump"), args...)
+	cmd.Env = append(cmd.Environ(), connEnv(opts)...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -100,16 +159,201 @@ func Dump(ctx context.Context, opts DumpOptions) error {
 }
 
 func Restore(ctx context.Context, backupPath string, opts DumpOptions) error {
-	args := []string{
+	args := restoreArgs(opts)
+	if opts.Jobs > 1 {
+		args = append(args, "-j", fmt.Sprintf("%d", opts.Jobs))
+	}
+	if opts.Table != "" {
+		schema, table := SplitTableName(opts.Table)
+		if schema != "" {
+			args = append(args, "-n", schema)
+		}
+		args = append(args, "-t", table)
+	}
+	args = append(args, backupPath)
+
+	return runRestore(ctx, args, nil, opts)
+}
+
+// RestoreReader pipes a custom-format archive from r into pg_restore, so a
+// compressed backup can be restored without writing the decompressed dump to
+// disk. pg_restore cannot run parallel jobs on stdin, so Jobs is ignored.
+func RestoreReader(ctx context.Context, r io.Reader, opts DumpOptions) error {
+	return runRestore(ctx, restoreArgs(opts), r, opts)
+}
+
+// RestoreSQL pipes a plain SQL dump from r into psql, stopping at the first
+// statement that fails.
+func RestoreSQL(ctx context.Context, r io.Reader, opts DumpOptions) error {
+	args := append(restoreArgs(opts), "-X", "-q", "-v", "ON_ERROR_STOP=1")
+
+	cmd := exec.CommandContext(ctx, binary(opts.PSQLPath, "psql"), args...)
+	cmd.Env = append(cmd.Environ(), connEnv(opts)...)
+	cmd.Stdin = r
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("psql failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// plainDumpHeader opens every plain SQL dump written by pg_dump.
+const plainDumpHeader = "-- PostgreSQL database dump"
+
+// ScanSQL reads a plain SQL dump from r and counts the tables it creates and
+// its objects, each CREATE statement and each table's COPY of data. It fails if r does not start like a pg_dump plain
+// dump, which needs no database connection to tell.
+func ScanSQL(r io.Reader) (tables, objects int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // COPY rows can be long
+	header, copying := false, false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !header {
+			if strings.TrimSpace(line) == "" || line == "--" {
+				continue
+			}
+			if !strings.HasPrefix(line, plainDumpHeader) {
+				return 0, 0, fmt.Errorf("not a plain pg_dump file")
+			}
+			header = true
+			continue
+		}
+		// Rows between COPY and \. are table data, not statements.
+		switch {
+		case copying:
+			copying = line != `\.`
+		case strings.HasPrefix(line, "COPY "):
+			copying = true
+			objects++
+		case strings.HasPrefix(line, "CREATE TABLE "):
+			tables++
+			objects++
+		case strings.HasPrefix(line, "CREATE "):
+			objects++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read SQL dump: %w", err)
+	}
+	if !header {
+		return 0, 0, fmt.Errorf("SQL dump is empty")
+	}
+	return tables, objects, nil
+}
+
+// ListArchive returns the table of contents of a custom or directory format
+// dump, one entry per line of pg_restore --list without its comments. The
+// dump is read from archive, a file or directory, or from r when archive is
+// empty. pgRestore is the binary to run, or empty for pg_restore on PATH.
+// No database connection is needed.
+func ListArchive(ctx context.Context, pgRestore, archive string, r io.Reader) ([]string, error) {
+	args := []string{"--list"}
+	if archive != "" {
+		args = append(args, archive)
+	}
+
+	cmd := exec.CommandContext(ctx, binary(pgRestore, "pg_restore"), args...)
+	cmd.Stdin = r
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pg_restore --list failed: %w, output: %s", err, stderr.String())
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, ";") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// ArchiveToSQL writes the SQL script pg_restore would run for a custom or
+// directory format dump, a file or directory at archive, to outputPath.
+// pgRestore is the binary to run, or empty for pg_restore on PATH. No
+// database connection is needed.
+func ArchiveToSQL(ctx context.Context, pgRestore, archive, outputPath string) error {
+	cmd := exec.CommandContext(ctx, binary(pgRestore, "pg_restore"), "-f", outputPath, archive)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pg_restore -f failed: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// ArchiveTables counts the tables among entries from ListArchive. Entries
+// read "<id>; <catalog oid> <oid> <type> <schema> <name> <owner>", and a
+// table's data is a separate TABLE DATA entry.
+func ArchiveTables(entries []string) int {
+	tables := 0
+	for _, entry := range entries {
+		_, desc, ok := strings.Cut(entry, "; ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(desc)
+		if len(fields) > 3 && fields[2] == "TABLE" && fields[3] != "DATA" {
+			tables++
+		}
+	}
+	return tables
+}
+
+// SplitTableName splits "schema.name" into its parts; schema is empty for
+// an unqualified name.
+func SplitTableName(name string) (schema, table string) {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return schema, table
+	}
+	return "", name
+}
+
+// ArchiveHasTable reports whether entries from ListArchive include the
+// definition or data of the table name, "name" or "schema.name"; an
+// unqualified name matches in any schema.
+func ArchiveHasTable(entries []string, name string) bool {
+	schema, table := SplitTableName(name)
+	for _, entry := range entries {
+		_, desc, ok := strings.Cut(entry, "; ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(desc)
+		if len(fields) < 3 || fields[2] != "TABLE" {
+			continue
+		}
+		fields = fields[3:]
+		if len(fields) > 0 && fields[0] == "DATA" {
+			fields = fields[1:]
+		}
+		if len(fields) > 1 && fields[1] == table && (schema == "" || fields[0] == schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func restoreArgs(opts DumpOptions) []string {
+	return []string{
 		"-h", opts.Host,
 		"-p", fmt.Sprintf("%d", opts.Port),
 		"-U", opts.User,
 		"-d", opts.Database,
-		backupPath,
 	}
+}
 
-	cmd := exec.CommandContext(ctx, "pg_restore", args...)
-	cmd.Env = append(cmd.Environ(), fmt.Sprintf("PGPASSWORD=%s", opts.Password))
+func runRestore(ctx context.Context, args []string, stdin io.Reader, opts DumpOptions) error {
+	cmd := exec.CommandContext(ctx, binary(opts.PGRestorePath, "pg_restore"), args...)
+	cmd.Env = append(cmd.Environ(), connEnv(opts)...)
+	cmd.Stdin = stdin
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
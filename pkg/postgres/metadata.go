@@ -1,13 +1,20 @@
 package postgres
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/localrivet/datasaver/pkg/checksum"
 )
 
 type BackupMetadata struct {
@@ -18,6 +25,24 @@ type BackupMetadata struct {
 	Backup    BackupInfo       `json:"backup"`
 	Files     []string         `json:"files"`
 	Retention RetentionInfo    `json:"retention"`
+
+	// Schedule names the schedules entry that took the backup. It is empty
+	// for the default schedule and for backups taken by hand.
+	Schedule string `json:"schedule,omitempty"`
+
+	// OnDemand is set for backups taken by hand, with the backup command
+	// or the backup_now tool, and counts them against
+	// backup.max_on_demand_per_hour.
+	OnDemand bool `json:"on_demand,omitempty"`
+
+	// Labels are free-form tags such as reason=pre-upgrade, set when the
+	// backup is taken and used to filter listings.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Signature is an HMAC of the rest of the metadata, set by Sign when a
+	// metadata signing key is configured, so sizes and checksums cannot be
+	// altered to pass off a corrupted backup as valid.
+	Signature string `json:"signature,omitempty"`
 }
 
 type DatabaseMetadata struct {
@@ -30,10 +55,52 @@ type BackupInfo struct {
 	Method           string  `json:"method"`
 	Format           string  `json:"format"`
 	Compression      string  `json:"compression"`
+	CompressionLevel int     `json:"compression_level,omitempty"` // 0 means the algorithm's default
+	Encrypted        bool    `json:"encrypted,omitempty"`
 	SizeBytes        int64   `json:"size_bytes"`
 	CompressedSize   int64   `json:"compressed_size_bytes"`
 	DurationSeconds  float64 `json:"duration_seconds"`
-	Checksum         string  `json:"checksum"`
+	Checksum         string  `json:"checksum"` // Of the stored file, for storage integrity
+
+	// CompressedInDB is set when pg_dump compressed the dump itself, at
+	// CompressionLevel, and Compression is "pg_dump". The file has no
+	// compression suffix, and restoring it must not decompress it first:
+	// pg_restore reads the compressed archive directly.
+	CompressedInDB bool `json:"compressed_in_db,omitempty"`
+
+	// CompressionRatio is SizeBytes over CompressedSize and
+	// ThroughputBytesPerSec is SizeBytes over the duration. Metadata
+	// written before they were recorded leaves them 0.
+	CompressionRatio      float64 `json:"compression_ratio,omitempty"`
+	ThroughputBytesPerSec float64 `json:"throughput_bytes_per_sec,omitempty"`
+
+	// ContentChecksum is taken of the dump before compression and
+	// encryption, so backups of the same data match whatever the settings,
+	// and a restore can check what it decompressed.
+	ContentChecksum string `json:"content_checksum,omitempty"`
+
+	// TableCounts are the row counts of the configured sentinel tables
+	// when the dump was taken, for comparison after a restore.
+	TableCounts map[string]int64 `json:"table_counts,omitempty"`
+
+	// TableStats are the row counts of every table in the database when
+	// the dump was taken, recorded when backup.collect_stats is set.
+	TableStats map[string]int64 `json:"table_stats,omitempty"`
+
+	// Table filters applied at dump time. When set, the backup is not a
+	// full copy of the database.
+	IncludeTables []string `json:"include_tables,omitempty"`
+	ExcludeTables []string `json:"exclude_tables,omitempty"`
+
+	// Mode is "schema" or "data" for partial dumps; empty or "full" otherwise.
+	Mode string `json:"mode,omitempty"`
+
+	// Kind is KindBase or KindWAL for physical backups and empty for dumps.
+	// StartLSN and EndLSN bound the WAL the file covers on Timeline.
+	Kind     string `json:"kind,omitempty"`
+	StartLSN string `json:"start_lsn,omitempty"`
+	EndLSN   string `json:"end_lsn,omitempty"`
+	Timeline int    `json:"timeline,omitempty"`
 }
 
 type RetentionInfo struct {
@@ -65,6 +132,26 @@ func (m *BackupMetadata) SetBackupInfo(sizeBytes, compressedSize int64, duration
 	m.Backup.CompressedSize = compressedSize
 	m.Backup.DurationSeconds = duration.Seconds()
 	m.Backup.Checksum = checksum
+	m.Backup.CompressionRatio = CompressionRatio(sizeBytes, compressedSize)
+	m.Backup.ThroughputBytesPerSec = Throughput(sizeBytes, duration)
+}
+
+// CompressionRatio returns size over compressedSize, or 0 when nothing was
+// stored.
+func CompressionRatio(size, compressedSize int64) float64 {
+	if compressedSize <= 0 {
+		return 0
+	}
+	return float64(size) / float64(compressedSize)
+}
+
+// Throughput returns size bytes over duration in bytes per second, or 0
+// for a zero duration.
+func Throughput(size int64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(size) / duration.Seconds()
 }
 
 func (m *BackupMetadata) SetRetention(keepUntil time.Time, policy string) {
@@ -72,6 +159,104 @@ func (m *BackupMetadata) SetRetention(keepUntil time.Time, policy string) {
 	m.Retention.Policy = policy
 }
 
+// IsPartial reports whether table filters limited what was dumped.
+func (m *BackupMetadata) IsPartial() bool {
+	return len(m.Backup.IncludeTables) > 0 || len(m.Backup.ExcludeTables) > 0
+}
+
+// BackupMode returns the dump mode, treating backups from before modes
+// existed as full.
+func (m *BackupMetadata) BackupMode() string {
+	if m.Backup.Mode == "" {
+		return "full"
+	}
+	return m.Backup.Mode
+}
+
+// HasLabels reports whether every label in want is set to the same value on
+// the backup. An empty want matches every backup.
+func (m *BackupMetadata) HasLabels(want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels turns "key=value" pairs into a label map.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label %q: must be key=value", pair)
+		}
+		labels[strings.TrimSpace(k)] = v
+	}
+	return labels, nil
+}
+
+// TimeRange selects backups by when they were taken: at or after Since
+// and before Until. A zero bound leaves that end open.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Contains reports whether t falls within r.
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.Since.IsZero() || !t.Before(r.Since)) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// ParseTimeRange parses since and until, either of which may be empty.
+// Each is an RFC 3339 time, a date, or an age before now such as 7d, 2w or
+// 12h. A date as until includes that whole day.
+func ParseTimeRange(since, until string, now time.Time) (TimeRange, error) {
+	var r TimeRange
+	var err error
+	if r.Since, err = parseTimeBound(since, now, false); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid since: %w", err)
+	}
+	if r.Until, err = parseTimeBound(until, now, true); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid until: %w", err)
+	}
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return TimeRange{}, fmt.Errorf("since (%s) must be before until (%s)", since, until)
+	}
+	return r, nil
+}
+
+var ageUnits = map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+
+func parseTimeBound(s string, now time.Time, end bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if unit, ok := ageUnits[s[len(s)-1]]; ok {
+		if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time (2024-01-15T02:00:00Z), a date (2024-01-15) or an age (7d, 2w, 12h)", s)
+}
+
 func (m *BackupMetadata) AddFile(filename string) {
 	m.Files = append(m.Files, filename)
 }
@@ -80,7 +265,84 @@ func (m *BackupMetadata) ToJSON() ([]byte, error) {
 	return json.MarshalIndent(m, "", "  ")
 }
 
+// Encode returns the metadata as stored: ToJSON, gzipped when compressed
+// is set, for a file named with MetadataExt(compressed).
+func (m *BackupMetadata) Encode(compressed bool) ([]byte, error) {
+	data, err := m.ToJSON()
+	if err != nil || !compressed {
+		return data, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrMetadataTampered is returned by VerifySignature when metadata has been
+// altered since it was signed, or carries no signature.
+var ErrMetadataTampered = errors.New("metadata tampered")
+
+// Sign sets Signature to an HMAC-SHA256 of the metadata under key.
+func (m *BackupMetadata) Sign(key []byte) error {
+	sig, err := m.signature(key)
+	if err != nil {
+		return err
+	}
+	m.Signature = sig
+	return nil
+}
+
+// VerifySignature checks Signature against the metadata under key. Unsigned
+// metadata fails too: stripping the signature must not bypass the check.
+func (m *BackupMetadata) VerifySignature(key []byte) error {
+	if m.Signature == "" {
+		return fmt.Errorf("%w: no signature", ErrMetadataTampered)
+	}
+	want, err := m.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(m.Signature), []byte(want)) {
+		return fmt.Errorf("%w: signature does not match", ErrMetadataTampered)
+	}
+	return nil
+}
+
+// signature computes the HMAC over the compact JSON of m without its
+// signature. encoding/json writes struct fields in order and map keys
+// sorted, so the same metadata always encodes the same way.
+func (m *BackupMetadata) signature(key []byte) (string, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata for signing: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ParseMetadata parses metadata as Encode stores it, gzipped or not; gzip
+// is recognized by its magic bytes, which JSON never starts with.
 func ParseMetadata(data []byte) (*BackupMetadata, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress metadata: %w", err)
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress metadata: %w", err)
+		}
+	}
+
 	var meta BackupMetadata
 	if err := json.Unmarshal(data, &meta); err != nil {
 		return nil, fmt.Errorf("failed to parse metadata: %w", err)
@@ -88,21 +350,62 @@ func ParseMetadata(data []byte) (*BackupMetadata, error) {
 	return &meta, nil
 }
 
+// CalculateChecksum returns the SHA-256 checksum of the file at filepath.
+// Use checksum.File for the configured algorithm.
 func CalculateChecksum(filepath string) (string, error) {
-	f, err := os.Open(filepath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file for checksum: %w", err)
-	}
-	defer f.Close()
+	return checksum.File(filepath, checksum.SHA256)
+}
 
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", fmt.Errorf("failed to calculate checksum: %w", err)
+// ChecksumWriter checksums what is written to it, for streams that are not
+// files of their own such as a dump on its way to disk.
+type ChecksumWriter = checksum.Writer
+
+// NewChecksumWriter returns a SHA-256 ChecksumWriter.
+func NewChecksumWriter() *ChecksumWriter {
+	w, _ := checksum.NewWriter(checksum.SHA256)
+	return w
+}
+
+// MetadataIndexPrefix holds a copy of every backup's metadata, so listing
+// backups reads only metadata instead of every object in storage.
+const MetadataIndexPrefix = "meta/"
+
+// Metadata is stored as <key>.meta.json, or gzipped as <key>.meta.json.gz
+// with backup.compress_metadata. Readers accept both, so the setting can be
+// changed at any time.
+const (
+	MetadataExtJSON = ".meta.json"
+	MetadataExtGzip = ".meta.json.gz"
+)
+
+// MetadataExt returns the extension of metadata stored compressed or not.
+func MetadataExt(compressed bool) string {
+	if compressed {
+		return MetadataExtGzip
 	}
+	return MetadataExtJSON
+}
 
-	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+// IsMetadataPath reports whether p is backup metadata, compressed or not.
+func IsMetadataPath(p string) bool {
+	return strings.HasSuffix(p, MetadataExtJSON) || strings.HasSuffix(p, MetadataExtGzip)
 }
 
+// MetadataIndexPath returns the index copy of backup id's metadata.
+func MetadataIndexPath(id string) string {
+	return MetadataIndexPrefix + id + MetadataExtJSON
+}
+
+// MetadataIndexPaths returns both places the index copy of backup id's
+// metadata may be, compressed first.
+func MetadataIndexPaths(id string) []string {
+	return []string{MetadataIndexPrefix + id + MetadataExtGzip, MetadataIndexPath(id)}
+}
+
+// HistoryPath holds one line of metadata for every backup ever completed.
+// Cleanup never deletes it, so it outlives the backups it lists.
+const HistoryPath = "history.jsonl"
+
 func GenerateBackupID(timestamp time.Time) string {
 	return fmt.Sprintf("backup_%s", timestamp.Format("20060102_150405"))
 }
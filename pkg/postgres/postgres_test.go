@@ -1,8 +1,10 @@
 package postgres
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -57,6 +59,17 @@ func TestBackupMetadata_SetBackupInfo(t *testing.T) {
 	if meta.Backup.Checksum != "sha256:abc123" {
 		t.Errorf("Checksum = %v, want sha256:abc123", meta.Backup.Checksum)
 	}
+	if meta.Backup.CompressionRatio != 2 {
+		t.Errorf("CompressionRatio = %v, want 2", meta.Backup.CompressionRatio)
+	}
+	if meta.Backup.ThroughputBytesPerSec != 204.8 {
+		t.Errorf("ThroughputBytesPerSec = %v, want 204.8", meta.Backup.ThroughputBytesPerSec)
+	}
+
+	meta.SetBackupInfo(0, 0, 0, "")
+	if meta.Backup.CompressionRatio != 0 || meta.Backup.ThroughputBytesPerSec != 0 {
+		t.Errorf("ratio/throughput of an empty backup = %v/%v, want 0/0", meta.Backup.CompressionRatio, meta.Backup.ThroughputBytesPerSec)
+	}
 }
 
 func TestBackupMetadata_SetRetention(t *testing.T) {
@@ -178,6 +191,89 @@ func TestParseMetadata_InvalidJSON(t *testing.T) {
 	}
 }
 
+func TestBackupMetadata_EncodeCompressed(t *testing.T) {
+	meta := NewBackupMetadata("backup_20240115_120000", "testdb", "local", "16.1")
+	meta.AddFile("backup_20240115_120000.dump.gz")
+
+	plain, err := meta.Encode(false)
+	if err != nil {
+		t.Fatalf("Encode(false) error = %v", err)
+	}
+	compressed, err := meta.Encode(true)
+	if err != nil {
+		t.Fatalf("Encode(true) error = %v", err)
+	}
+	if plain[0] != '{' {
+		t.Errorf("Encode(false) = %q, want JSON", plain[:10])
+	}
+	if compressed[0] != 0x1f || compressed[1] != 0x8b {
+		t.Errorf("Encode(true) does not start with the gzip magic bytes")
+	}
+
+	for name, data := range map[string][]byte{"plain": plain, "compressed": compressed} {
+		parsed, err := ParseMetadata(data)
+		if err != nil {
+			t.Fatalf("ParseMetadata(%s) error = %v", name, err)
+		}
+		if parsed.ID != meta.ID || len(parsed.Files) != 1 {
+			t.Errorf("ParseMetadata(%s) = %+v, want the encoded metadata", name, parsed)
+		}
+	}
+
+	if _, err := ParseMetadata(compressed[:len(compressed)/2]); err == nil {
+		t.Error("ParseMetadata() of truncated gzip should fail")
+	}
+}
+
+func TestIsMetadataPath(t *testing.T) {
+	tests := map[string]bool{
+		"backup_20240115_120000.meta.json":         true,
+		"meta/backup_20240115_120000.meta.json.gz": true,
+		"backup_20240115_120000.dump.gz":           false,
+		"backup_20240115_120000.meta.json.bak":     false,
+	}
+	for p, want := range tests {
+		if got := IsMetadataPath(p); got != want {
+			t.Errorf("IsMetadataPath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestBackupMetadata_Signature(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	meta := NewBackupMetadata("backup-001", "testdb", "localhost", "16")
+	meta.SetBackupInfo(1000, 400, time.Second, "sha256:abc")
+	meta.Labels = map[string]string{"b": "2", "a": "1"}
+	meta.AddFile("backup-001.dump.gz")
+
+	if err := meta.VerifySignature(key); !errors.Is(err, ErrMetadataTampered) {
+		t.Errorf("VerifySignature() on unsigned metadata = %v, want ErrMetadataTampered", err)
+	}
+	if err := meta.Sign(key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	data, err := meta.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	parsed, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if err := parsed.VerifySignature(key); err != nil {
+		t.Errorf("VerifySignature() after a round trip error = %v", err)
+	}
+	if err := parsed.VerifySignature([]byte("another-key-0000")); !errors.Is(err, ErrMetadataTampered) {
+		t.Errorf("VerifySignature() with the wrong key = %v, want ErrMetadataTampered", err)
+	}
+
+	parsed.Backup.CompressedSize = 999
+	if err := parsed.VerifySignature(key); !errors.Is(err, ErrMetadataTampered) {
+		t.Errorf("VerifySignature() after editing the size = %v, want ErrMetadataTampered", err)
+	}
+}
+
 func TestCalculateChecksum(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.txt")
@@ -259,6 +355,30 @@ func TestGenerateBackupID_DifferentTimes(t *testing.T) {
 	}
 }
 
+func TestBackupMetadata_IsPartial(t *testing.T) {
+	meta := NewBackupMetadata("backup_001", "testdb", "localhost", "16.0")
+	if meta.IsPartial() {
+		t.Error("IsPartial() = true for a backup without table filters")
+	}
+
+	meta.Backup.ExcludeTables = []string{"public.audit_log"}
+	if !meta.IsPartial() {
+		t.Error("IsPartial() = false with exclude_tables set")
+	}
+
+	data, err := meta.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error: %v", err)
+	}
+	parsed, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatalf("ParseMetadata() error: %v", err)
+	}
+	if len(parsed.Backup.ExcludeTables) != 1 || parsed.Backup.ExcludeTables[0] != "public.audit_log" {
+		t.Errorf("ExcludeTables after round trip = %v", parsed.Backup.ExcludeTables)
+	}
+}
+
 func TestDumpOptions(t *testing.T) {
 	opts := DumpOptions{
 		Format:      "custom",
@@ -282,6 +402,34 @@ func TestDumpOptions(t *testing.T) {
 	}
 }
 
+func TestConnEnv(t *testing.T) {
+	env := connEnv(DumpOptions{Password: "secret"})
+	if len(env) != 1 || env[0] != "PGPASSWORD=secret" {
+		t.Errorf("connEnv() without TLS = %v, want only PGPASSWORD", env)
+	}
+
+	env = connEnv(DumpOptions{
+		Password:       "secret",
+		SSLMode:        "verify-ca",
+		SSLRootCert:    "/certs/ca.pem",
+		ConnectTimeout: 10,
+	})
+	want := map[string]bool{
+		"PGPASSWORD=secret":           true,
+		"PGCONNECT_TIMEOUT=10":        true,
+		"PGSSLMODE=verify-ca":         true,
+		"PGSSLROOTCERT=/certs/ca.pem": true,
+	}
+	if len(env) != len(want) {
+		t.Fatalf("connEnv() = %v, want %d entries", env, len(want))
+	}
+	for _, e := range env {
+		if !want[e] {
+			t.Errorf("connEnv() has unexpected %q", e)
+		}
+	}
+}
+
 func TestDatabaseMetadata(t *testing.T) {
 	dbMeta := DatabaseMetadata{
 		Name:    "testdb",
@@ -345,6 +493,7 @@ func TestBackupMetadata_RoundTrip(t *testing.T) {
 	original.SetRetention(time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), "weekly")
 	original.AddFile("backup-test.dump.gz")
 	original.AddFile("backup-test.meta.json")
+	original.Labels = map[string]string{"reason": "pre-upgrade"}
 
 	// Serialize
 	jsonData, err := original.ToJSON()
@@ -383,4 +532,238 @@ func TestBackupMetadata_RoundTrip(t *testing.T) {
 	if parsed.Retention.Policy != original.Retention.Policy {
 		t.Errorf("Retention.Policy mismatch")
 	}
+	if parsed.Labels["reason"] != "pre-upgrade" {
+		t.Errorf("Labels = %v, want reason=pre-upgrade", parsed.Labels)
+	}
+}
+
+func TestBackupMetadata_HasLabels(t *testing.T) {
+	meta := NewBackupMetadata("backup_001", "testdb", "localhost", "16.0")
+	meta.Labels = map[string]string{"reason": "pre-upgrade", "ticket": "42"}
+
+	tests := []struct {
+		name string
+		want map[string]string
+		ok   bool
+	}{
+		{"no filter", nil, true},
+		{"one match", map[string]string{"reason": "pre-upgrade"}, true},
+		{"all match", map[string]string{"reason": "pre-upgrade", "ticket": "42"}, true},
+		{"wrong value", map[string]string{"reason": "nightly"}, false},
+		{"missing key", map[string]string{"env": "prod"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := meta.HasLabels(tt.want); got != tt.ok {
+				t.Errorf("HasLabels(%v) = %v, want %v", tt.want, got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"reason=pre-upgrade", "note=a=b", "empty="})
+	if err != nil {
+		t.Fatalf("ParseLabels() error: %v", err)
+	}
+	if labels["reason"] != "pre-upgrade" || labels["note"] != "a=b" || labels["empty"] != "" || len(labels) != 3 {
+		t.Errorf("ParseLabels() = %v", labels)
+	}
+
+	for _, bad := range []string{"reason", "=value"} {
+		if _, err := ParseLabels([]string{bad}); err == nil {
+			t.Errorf("ParseLabels(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2024, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		since, until string
+		wantSince    time.Time
+		wantUntil    time.Time
+	}{
+		{"", "", time.Time{}, time.Time{}},
+		{"7d", "", time.Date(2024, 2, 3, 12, 0, 0, 0, time.UTC), time.Time{}},
+		{"2w", "12h", time.Date(2024, 1, 27, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01", "2024-01-31", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-15T02:00:00Z", "", time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		r, err := ParseTimeRange(tt.since, tt.until, now)
+		if err != nil {
+			t.Errorf("ParseTimeRange(%q, %q) error: %v", tt.since, tt.until, err)
+			continue
+		}
+		if !r.Since.Equal(tt.wantSince) || !r.Until.Equal(tt.wantUntil) {
+			t.Errorf("ParseTimeRange(%q, %q) = %v to %v, want %v to %v", tt.since, tt.until, r.Since, r.Until, tt.wantSince, tt.wantUntil)
+		}
+	}
+
+	for _, bad := range [][2]string{{"last week", ""}, {"", "-3d"}, {"2024-13-01", ""}, {"1d", "7d"}} {
+		if _, err := ParseTimeRange(bad[0], bad[1], now); err == nil {
+			t.Errorf("ParseTimeRange(%q, %q) should fail", bad[0], bad[1])
+		}
+	}
+
+	r, _ := ParseTimeRange("2024-01-01", "2024-01-31", now)
+	if !r.Contains(time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)) || r.Contains(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("a date as until should include that whole day and nothing after")
+	}
+}
+
+func TestBackupMetadata_BackupMode(t *testing.T) {
+	meta := NewBackupMetadata("backup_001", "testdb", "localhost", "16.0")
+	if meta.BackupMode() != "full" {
+		t.Errorf("BackupMode() = %v, want full for metadata without a mode", meta.BackupMode())
+	}
+
+	meta.Backup.Mode = "schema"
+	if meta.BackupMode() != "schema" {
+		t.Errorf("BackupMode() = %v, want schema", meta.BackupMode())
+	}
+}
+
+func TestIsWALFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"000000010000000000000002", true},
+		{"000000010000000000000002.partial", true},
+		{"000000010000000000000002.00000028.backup", true},
+		{"00000002.history", true},
+		{"00000001000000000000000g", false},
+		{"../etc/passwd", false},
+		{"000000010000000000000002.gz", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsWALFileName(tt.name); got != tt.want {
+			t.Errorf("IsWALFileName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLSN_RoundTrip(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	if err != nil {
+		t.Fatalf("ParseLSN() error: %v", err)
+	}
+	if lsn != 0x16B374D848 {
+		t.Errorf("ParseLSN() = %X, want 16B374D848", lsn)
+	}
+	if got := FormatLSN(lsn); got != "16/B374D848" {
+		t.Errorf("FormatLSN() = %q, want 16/B374D848", got)
+	}
+
+	if _, err := ParseLSN("not-an-lsn"); err == nil {
+		t.Error("ParseLSN() should reject malformed input")
+	}
+}
+
+func TestWALSegmentRange(t *testing.T) {
+	const segmentSize = 16 << 20
+
+	timeline, start, end, ok := WALSegmentRange("000000020000000100000003", segmentSize)
+	if !ok {
+		t.Fatal("WALSegmentRange() ok = false for a segment")
+	}
+	if timeline != 2 {
+		t.Errorf("timeline = %d, want 2", timeline)
+	}
+	if FormatLSN(start) != "1/3000000" || FormatLSN(end) != "1/4000000" {
+		t.Errorf("range = %s-%s, want 1/3000000-1/4000000", FormatLSN(start), FormatLSN(end))
+	}
+
+	if _, _, _, ok := WALSegmentRange("00000002.history", segmentSize); ok {
+		t.Error("WALSegmentRange() ok = true for a history file")
+	}
+}
+
+func TestArchiveTables(t *testing.T) {
+	entries := []string{
+		"3; 2615 2200 SCHEMA - public postgres",
+		"215; 1259 16386 TABLE public users postgres",
+		"216; 1259 16390 TABLE public orders postgres",
+		"217; 1259 16384 SEQUENCE public users_id_seq postgres",
+		"3345; 0 16386 TABLE DATA public users postgres",
+		"3346; 0 16390 TABLE DATA public orders postgres",
+		"3190; 2606 16395 CONSTRAINT public users users_pkey postgres",
+	}
+	if got := ArchiveTables(entries); got != 2 {
+		t.Errorf("ArchiveTables() = %d, want 2", got)
+	}
+	if got := ArchiveTables(nil); got != 0 {
+		t.Errorf("ArchiveTables(nil) = %d, want 0", got)
+	}
+}
+
+func TestArchiveHasTable(t *testing.T) {
+	entries := []string{
+		"215; 1259 16386 TABLE public users postgres",
+		"216; 1259 16390 TABLE billing invoices postgres",
+		"3346; 0 16390 TABLE DATA public orders postgres",
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"users", true},
+		{"public.users", true},
+		{"billing.invoices", true},
+		{"invoices", true},
+		{"public.invoices", false},
+		{"orders", true}, // Data-only dumps have no definitions
+		{"public.orders", true},
+		{"missing", false},
+	}
+	for _, tt := range tests {
+		if got := ArchiveHasTable(entries, tt.name); got != tt.want {
+			t.Errorf("ArchiveHasTable(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanSQL(t *testing.T) {
+	dump := `--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text
+);
+
+CREATE SEQUENCE public.users_id_seq
+    AS integer;
+
+COPY public.users (id, name) FROM stdin;
+1	CREATE TABLE looks like a statement but is data
+\.
+
+CREATE INDEX users_name_idx ON public.users USING btree (name);
+
+--
+-- PostgreSQL database dump complete
+--
+`
+	tables, objects, err := ScanSQL(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("ScanSQL() error = %v", err)
+	}
+	if tables != 1 || objects != 4 {
+		t.Errorf("ScanSQL() = %d tables, %d objects, want 1 and 4", tables, objects)
+	}
+
+	if _, _, err := ScanSQL(strings.NewReader("PGDMP\x01\x0e")); err == nil {
+		t.Error("ScanSQL() should reject a custom-format archive")
+	}
+	if _, _, err := ScanSQL(strings.NewReader("")); err == nil {
+		t.Error("ScanSQL() should reject an empty dump")
+	}
 }
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	KindBase = "base" // pg_basebackup of the whole cluster
+	KindWAL  = "wal"  // One file from the WAL archive
+)
+
+// WALPrefix holds archived WAL files and their metadata. It is kept apart
+// from backups, whose listings and retention never see WAL files.
+const WALPrefix = "wal/"
+
+// WALMetadataPath returns the metadata path of archived WAL file name.
+func WALMetadataPath(name string) string {
+	return WALPrefix + name + ".wal.json"
+}
+
+// WALBasePrefix returns where WAL shipped after base backup id is stored,
+// or WALPrefix itself when there is no base backup yet. Metadata always
+// stays at WALMetadataPath, so fetching a file does not need to know it.
+func WALBasePrefix(id string) string {
+	if id == "" {
+		return WALPrefix
+	}
+	return WALPrefix + id + "/"
+}
+
+// WALIndexPath returns the index of the WAL shipped after base backup id.
+func WALIndexPath(id string) string {
+	return WALBasePrefix(id) + "index.json"
+}
+
+// WALIndex lists the WAL files shipped after one base backup, in the order
+// they were shipped, so the range available for point-in-time recovery can
+// be read without fetching every file's metadata.
+type WALIndex struct {
+	BaseBackup string          `json:"base_backup,omitempty"`
+	Files      []WALIndexEntry `json:"files"`
+}
+
+type WALIndexEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Timeline   int       `json:"timeline,omitempty"`
+	StartLSN   string    `json:"start_lsn,omitempty"`
+	EndLSN     string    `json:"end_lsn,omitempty"`
+	SizeBytes  int64     `json:"size_bytes"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Add records the archived WAL file described by meta, replacing an
+// earlier entry of the same name.
+func (x *WALIndex) Add(meta *BackupMetadata) {
+	var path string
+	if len(meta.Files) > 0 {
+		path = meta.Files[0]
+	}
+	entry := WALIndexEntry{
+		Name:       meta.ID,
+		Path:       path,
+		Timeline:   meta.Backup.Timeline,
+		StartLSN:   meta.Backup.StartLSN,
+		EndLSN:     meta.Backup.EndLSN,
+		SizeBytes:  meta.Backup.SizeBytes,
+		ArchivedAt: meta.Timestamp,
+	}
+	for i := range x.Files {
+		if x.Files[i].Name == entry.Name {
+			x.Files[i] = entry
+			return
+		}
+	}
+	x.Files = append(x.Files, entry)
+}
+
+func (x *WALIndex) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(x, "", "  ")
+}
+
+func ParseWALIndex(data []byte) (*WALIndex, error) {
+	var index WALIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse WAL index: %w", err)
+	}
+	return &index, nil
+}
+
+// walFileName matches the files PostgreSQL hands to archive_command:
+// segments, partial segments, backup history and timeline history files.
+var walFileName = regexp.MustCompile(`^[0-9A-F]{8}(\.history|[0-9A-F]{16}(\.partial|\.[0-9A-F]{8}\.backup)?)$`)
+
+// IsWALFileName reports whether name is a WAL archive file name.
+func IsWALFileName(name string) bool {
+	return walFileName.MatchString(name)
+}
+
+// ParseLSN parses a log sequence number written as "16/B374D848".
+func ParseLSN(s string) (uint64, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", s, err)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+// FormatLSN writes lsn the way PostgreSQL does.
+func FormatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// WALSegmentRange returns the timeline and the LSN range covered by WAL
+// segment name, given the server's segment size. ok is false for files
+// that are not segments, such as history files.
+func WALSegmentRange(name string, segmentSize int64) (timeline int, start, end uint64, ok bool) {
+	if !IsWALFileName(name) || len(name) < 24 || segmentSize <= 0 || name[8:] == ".history" {
+		return 0, 0, 0, false
+	}
+	if len(name) > 24 && name[24:] != ".partial" {
+		return 0, 0, 0, false
+	}
+
+	tli, _ := strconv.ParseUint(name[0:8], 16, 32)
+	log, _ := strconv.ParseUint(name[8:16], 16, 32)
+	seg, _ := strconv.ParseUint(name[16:24], 16, 32)
+
+	segmentsPerLog := uint64(0x100000000) / uint64(segmentSize)
+	start = (log*segmentsPerLog + seg) * uint64(segmentSize)
+	return int(tli), start, start + uint64(segmentSize), true
+}